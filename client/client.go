@@ -0,0 +1,545 @@
+// Package client is a go client for the kdb3 http api.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Client struct {
+	url        string
+	HTTPClient *http.Client
+
+	name     string
+	password string
+	token    string
+}
+
+// New returns a client for the server at url, e.g. http://localhost:8001.
+func New(url string) *Client {
+	return &Client{url: strings.TrimRight(url, "/"), HTTPClient: http.DefaultClient}
+}
+
+// SetBasicAuth makes the client send name and password with every request.
+func (c *Client) SetBasicAuth(name, password string) {
+	c.name, c.password = name, password
+}
+
+// SetBearerToken makes the client send a JWT with every request instead.
+func (c *Client) SetBearerToken(token string) {
+	c.token = token
+}
+
+type DatabaseInfo struct {
+	DBName          string `json:"db_name"`
+	UpdateSeq       string `json:"update_seq"`
+	DocCount        int    `json:"doc_count"`
+	DeletedDocCount int    `json:"deleted_doc_count"`
+	Storage         string `json:"storage"`
+}
+
+// DocumentMeta is the id and version of a written document.
+type DocumentMeta struct {
+	ID      string `json:"_id"`
+	Version int    `json:"_version,omitempty"`
+	Deleted bool   `json:"_deleted,omitempty"`
+}
+
+// BulkResult is the outcome of one document of a bulk write, Err is set
+// when that document failed.
+type BulkResult struct {
+	DocumentMeta
+	Err *Error `json:"-"`
+}
+
+type Change struct {
+	Seq     string `json:"seq"`
+	ID      string `json:"id"`
+	Version int    `json:"version"`
+	Deleted bool   `json:"deleted,omitempty"`
+	Hash    string `json:"hash,omitempty"`
+}
+
+type ReplicationResult struct {
+	ReplicationID    string `json:"replication_id"`
+	SourceLastSeq    string `json:"source_last_seq"`
+	DocsRead         int    `json:"docs_read"`
+	DocsWritten      int    `json:"docs_written"`
+	DocWriteFailures int    `json:"doc_write_failures"`
+}
+
+// BackupResult describes a backup, Kind is "full" or "incremental". An
+// incremental backup without changes writes no File.
+type BackupResult struct {
+	Kind string `json:"kind"`
+	File string `json:"file"`
+	Seq  string `json:"seq"`
+	Docs int    `json:"docs"`
+}
+
+type RestoreResult struct {
+	Seq          string `json:"seq"`
+	Incrementals int    `json:"incrementals"`
+	Docs         int    `json:"docs"`
+}
+
+// ImportResult summarizes an import, documents which were already there
+// are Skipped, conflicting versions are stored as conflicts.
+type ImportResult struct {
+	Read        int           `json:"read"`
+	Written     int           `json:"written"`
+	Skipped     int           `json:"skipped"`
+	Conflicts   int           `json:"conflicts"`
+	ConflictIDs []string      `json:"conflict_ids"`
+	Failures    int           `json:"failures"`
+	Errors      []ImportError `json:"errors"`
+}
+
+type ImportError struct {
+	Line   int    `json:"line"`
+	ID     string `json:"id,omitempty"`
+	Error  string `json:"error"`
+	Reason string `json:"reason"`
+}
+
+type ViewOptions struct {
+	// Select is the name of the select script, "default" when empty.
+	Select string
+	Params url.Values
+	// Stale returns the view without building it first.
+	Stale bool
+}
+
+func (c *Client) AllDatabases(ctx context.Context) ([]string, error) {
+	var list []string
+	err := c.do(ctx, http.MethodGet, "/_all_dbs", nil, &list)
+	return list, err
+}
+
+func (c *Client) CreateDatabase(ctx context.Context, db string) error {
+	return c.do(ctx, http.MethodPut, "/"+url.PathEscape(db), nil, nil)
+}
+
+// CreateDatabaseWithStorage creates a database in the named storage, "file"
+// or "memory".
+func (c *Client) CreateDatabaseWithStorage(ctx context.Context, db, storage string) error {
+	return c.do(ctx, http.MethodPut, "/"+url.PathEscape(db)+"?storage="+url.QueryEscape(storage), nil, nil)
+}
+
+func (c *Client) DeleteDatabase(ctx context.Context, db string) error {
+	return c.do(ctx, http.MethodDelete, "/"+url.PathEscape(db), nil, nil)
+}
+
+func (c *Client) DatabaseInfo(ctx context.Context, db string) (*DatabaseInfo, error) {
+	info := &DatabaseInfo{}
+	if err := c.do(ctx, http.MethodGet, "/"+url.PathEscape(db), nil, info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (c *Client) Compact(ctx context.Context, db string) error {
+	return c.do(ctx, http.MethodPost, "/"+url.PathEscape(db)+"/_compact", nil, nil)
+}
+
+// CopyDatabase clones db into target, with its view files when views.
+func (c *Client) CopyDatabase(ctx context.Context, db, target string, views bool) error {
+	body := map[string]interface{}{"target": target, "views": views}
+	return c.do(ctx, http.MethodPost, "/"+url.PathEscape(db)+"/_copy", body, nil)
+}
+
+func (c *Client) RenameDatabase(ctx context.Context, db, target string) error {
+	return c.do(ctx, http.MethodPost, "/"+url.PathEscape(db)+"/_rename", map[string]string{"target": target}, nil)
+}
+
+// BackupDatabase writes a backup of db on the server, incremental unless
+// full is set or db has no backup yet.
+func (c *Client) BackupDatabase(ctx context.Context, db string, full bool) (*BackupResult, error) {
+	result := &BackupResult{}
+	if err := c.do(ctx, http.MethodPost, "/"+url.PathEscape(db)+"/_backup", map[string]bool{"full": full}, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// RestoreDatabase creates the database target from the backups of backup.
+func (c *Client) RestoreDatabase(ctx context.Context, backup, target string) (*RestoreResult, error) {
+	result := &RestoreResult{}
+	if err := c.do(ctx, http.MethodPost, "/_restore", map[string]string{"backup": backup, "target": target}, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ExportDocuments writes the documents of db to w as lines of json, with
+// their _id, _version, _hash and _history.
+func (c *Client) ExportDocuments(ctx context.Context, db string, w io.Writer) error {
+	resp, err := c.send(ctx, http.MethodGet, "/"+url.PathEscape(db)+"/_export", "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// ImportDocuments imports the documents read from r as lines of json into
+// db. With keepVersions they keep their _version like replicated documents,
+// otherwise each becomes the next version of the current one.
+func (c *Client) ImportDocuments(ctx context.Context, db string, r io.Reader, keepVersions bool) (*ImportResult, error) {
+	path := "/" + url.PathEscape(db) + "/_import"
+	if !keepVersions {
+		path += "?versions=ignore"
+	}
+	resp, err := c.send(ctx, http.MethodPost, path, "application/x-ndjson", r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	result := &ImportResult{}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return nil, fmt.Errorf("%s: %w", err, ErrBadJSON)
+	}
+	return result, nil
+}
+
+// PutDocument creates or updates a document. doc is marshaled to json and
+// carries its own _id and, for updates, the _version it replaces.
+func (c *Client) PutDocument(ctx context.Context, db string, doc interface{}) (*DocumentMeta, error) {
+	meta := &DocumentMeta{}
+	if err := c.do(ctx, http.MethodPost, "/"+url.PathEscape(db), doc, meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// GetDocument reads the latest version of a document into v.
+func (c *Client) GetDocument(ctx context.Context, db, id string, v interface{}) error {
+	return c.do(ctx, http.MethodGet, documentPath(db, id), nil, v)
+}
+
+func (c *Client) DeleteDocument(ctx context.Context, db, id string, version int) (*DocumentMeta, error) {
+	meta := &DocumentMeta{}
+	path := documentPath(db, id) + "?version=" + strconv.Itoa(version)
+	if err := c.do(ctx, http.MethodDelete, path, nil, meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// BulkDocuments writes several documents, each one succeeds or fails on its
+// own.
+func (c *Client) BulkDocuments(ctx context.Context, db string, docs []interface{}) ([]BulkResult, error) {
+	return c.bulkDocuments(ctx, db, map[string]interface{}{"_docs": docs})
+}
+
+func (c *Client) bulkDocuments(ctx context.Context, db string, body interface{}) ([]BulkResult, error) {
+	var items []json.RawMessage
+	if err := c.do(ctx, http.MethodPost, "/"+url.PathEscape(db)+"/_bulk_docs", body, &items); err != nil {
+		return nil, err
+	}
+
+	results := make([]BulkResult, len(items))
+	for i, item := range items {
+		if err := json.Unmarshal(item, &results[i].DocumentMeta); err != nil {
+			return nil, err
+		}
+		if e := parseError(item, 0); e != nil {
+			results[i].Err = e
+		}
+	}
+	return results, nil
+}
+
+// ReplicateDocuments writes documents of another database with their
+// _version, _hash, _history and _deleted, versions the database has already
+// are skipped and conflicting ones are stored as conflicts.
+func (c *Client) ReplicateDocuments(ctx context.Context, db string, docs []json.RawMessage) ([]BulkResult, error) {
+	return c.bulkDocuments(ctx, db, map[string]interface{}{"_docs": docs, "new_edits": false})
+}
+
+// BulkGetDocuments reads several documents, a missing document is returned
+// as {"error":"doc_not_found",...} in its place.
+func (c *Client) BulkGetDocuments(ctx context.Context, db string, ids []string) ([]json.RawMessage, error) {
+	docs := make([]DocumentMeta, len(ids))
+	for i, id := range ids {
+		docs[i].ID = id
+	}
+	var items []json.RawMessage
+	err := c.do(ctx, http.MethodPost, "/"+url.PathEscape(db)+"/_bulk_gets", map[string]interface{}{"_docs": docs}, &items)
+	return items, err
+}
+
+// BulkGetHistory reads the current versions of several documents with their
+// _hash and _history, deleted ones included. Replication reads documents with
+// it.
+func (c *Client) BulkGetHistory(ctx context.Context, db string, ids []string) ([]json.RawMessage, error) {
+	docs := make([]DocumentMeta, len(ids))
+	for i, id := range ids {
+		docs[i].ID = id
+	}
+	var items []json.RawMessage
+	err := c.do(ctx, http.MethodPost, "/"+url.PathEscape(db)+"/_bulk_gets", map[string]interface{}{"_docs": docs, "history": true}, &items)
+	return items, err
+}
+
+// GetConflicts returns the losing versions of a conflicted document as
+// "N-hash", none when it has no conflicts.
+func (c *Client) GetConflicts(ctx context.Context, db, id string) ([]string, error) {
+	doc := struct {
+		Conflicts []string `json:"_conflicts"`
+	}{}
+	if err := c.do(ctx, http.MethodGet, documentPath(db, id)+"?conflicts=true", nil, &doc); err != nil {
+		return nil, err
+	}
+	return doc.Conflicts, nil
+}
+
+// GetConflict reads a losing version of a document, given as "N-hash", into
+// v.
+func (c *Client) GetConflict(ctx context.Context, db, id, version string, v interface{}) error {
+	return c.do(ctx, http.MethodGet, documentPath(db, id)+"?conflict="+url.QueryEscape(version), nil, v)
+}
+
+// DeleteConflict resolves a conflict by removing the losing version, given as
+// "N-hash".
+func (c *Client) DeleteConflict(ctx context.Context, db, id, version string) error {
+	return c.do(ctx, http.MethodDelete, documentPath(db, id)+"?conflict="+url.QueryEscape(version), nil, nil)
+}
+
+// GetLocalDocument reads a local document into v, local documents aren't
+// versioned or replicated.
+func (c *Client) GetLocalDocument(ctx context.Context, db, id string, v interface{}) error {
+	return c.do(ctx, http.MethodGet, "/"+url.PathEscape(db)+"/_local/"+url.PathEscape(id), nil, v)
+}
+
+func (c *Client) PutLocalDocument(ctx context.Context, db, id string, doc interface{}) error {
+	return c.do(ctx, http.MethodPut, "/"+url.PathEscape(db)+"/_local/"+url.PathEscape(id), doc, nil)
+}
+
+// Replicate copies the changed documents of source to target, each a
+// database name on this server or a database url on another one.
+func (c *Client) Replicate(ctx context.Context, source, target string, createTarget bool) (*ReplicationResult, error) {
+	body := map[string]interface{}{"source": source, "target": target, "create_target": createTarget}
+	result := &ReplicationResult{}
+	if err := c.do(ctx, http.MethodPost, "/_replicate", body, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Changes returns up to limit changes after since, newest first. A limit of
+// 0 uses the server default.
+func (c *Client) Changes(ctx context.Context, db, since string, limit int) ([]Change, error) {
+	query := url.Values{}
+	if since != "" {
+		query.Set("since", since)
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	result := struct {
+		Results []Change `json:"results"`
+	}{}
+	if err := c.do(ctx, http.MethodGet, "/"+url.PathEscape(db)+"/_changes?"+query.Encode(), nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Results, nil
+}
+
+// StreamChanges polls the changes feed every interval and calls fn for each
+// change after since, oldest first, until ctx is done or fn returns an error.
+func (c *Client) StreamChanges(ctx context.Context, db, since string, interval time.Duration, fn func(Change) error) error {
+	for {
+		changes, err := c.Changes(ctx, db, since, 0)
+		if err != nil {
+			return err
+		}
+		for i := len(changes) - 1; i >= 0; i-- {
+			if err := fn(changes[i]); err != nil {
+				return err
+			}
+			if changes[i].Seq > since {
+				since = changes[i].Seq
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// SelectView runs a view select and decodes its result into v.
+func (c *Client) SelectView(ctx context.Context, db, ddocID, view string, options *ViewOptions, v interface{}) error {
+	path := documentPath(db, ddocID) + "/" + url.PathEscape(view)
+	query := url.Values{}
+	if options != nil {
+		if options.Select != "" {
+			path += "/" + url.PathEscape(options.Select)
+		}
+		for k, values := range options.Params {
+			query[k] = values
+		}
+		if options.Stale {
+			query.Set("stale", "true")
+		}
+	}
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	return c.do(ctx, http.MethodGet, path, nil, v)
+}
+
+// AllDocs returns the _all_docs view, with the documents when includeDocs.
+func (c *Client) AllDocs(ctx context.Context, db string, includeDocs bool, v interface{}) error {
+	path := "/" + url.PathEscape(db) + "/_all_docs"
+	if includeDocs {
+		path += "?include_docs=true"
+	}
+	return c.do(ctx, http.MethodGet, path, nil, v)
+}
+
+func (c *Client) UUIDs(ctx context.Context, count int) ([]string, error) {
+	var list []string
+	err := c.do(ctx, http.MethodGet, "/_uuids?count="+strconv.Itoa(count), nil, &list)
+	return list, err
+}
+
+// RetryOnConflict calls fn until it doesn't fail with ErrDocConflict, at
+// most attempts times.
+func RetryOnConflict(ctx context.Context, attempts int, fn func() error) error {
+	var err error
+	for i := 0; i < attempts; i++ {
+		if err = fn(); !errors.Is(err, ErrDocConflict) {
+			return err
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+	}
+	return err
+}
+
+// UpdateDocument reads a document, applies fn and writes it back, reading
+// it again when someone else updated it in between. A missing document is
+// passed to fn with only its _id, so it is created.
+func (c *Client) UpdateDocument(ctx context.Context, db, id string, attempts int, fn func(doc map[string]interface{}) error) (*DocumentMeta, error) {
+	var meta *DocumentMeta
+	err := RetryOnConflict(ctx, attempts, func() error {
+		doc := make(map[string]interface{})
+		if err := c.GetDocument(ctx, db, id, &doc); err != nil {
+			if !errors.Is(err, ErrDocNotFound) {
+				return err
+			}
+			doc = map[string]interface{}{"_id": id}
+		}
+		if err := fn(doc); err != nil {
+			return err
+		}
+		doc["_id"] = id
+
+		var err error
+		meta, err = c.PutDocument(ctx, db, doc)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+func documentPath(db, id string) string {
+	if strings.HasPrefix(id, "_design/") {
+		return "/" + url.PathEscape(db) + "/_design/" + url.PathEscape(strings.TrimPrefix(id, "_design/"))
+	}
+	return "/" + url.PathEscape(db) + "/" + url.PathEscape(id)
+}
+
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	contentType := ""
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+		contentType = "application/json"
+	}
+
+	resp, err := c.send(ctx, method, path, contentType, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if out == nil || len(b) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(b, out); err != nil {
+		return fmt.Errorf("%s: %w", err, ErrBadJSON)
+	}
+	return nil
+}
+
+// send sends a request and returns the response unless it's an error
+// response, the caller closes its body.
+func (c *Client) send(ctx context.Context, method, path, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.url+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	} else if c.name != "" {
+		req.SetBasicAuth(c.name, c.password)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if e := parseError(b, resp.StatusCode); e != nil {
+		return nil, e
+	}
+	return nil, &Error{StatusCode: resp.StatusCode, Code: http.StatusText(resp.StatusCode)}
+}
+
+func parseError(b []byte, statusCode int) *Error {
+	e := &Error{StatusCode: statusCode}
+	if err := json.Unmarshal(b, e); err != nil || e.Code == "" {
+		return nil
+	}
+	return e
+}
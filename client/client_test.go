@@ -0,0 +1,260 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/clementmac/kdb3/client"
+	"github.com/clementmac/kdb3/kdb"
+	"github.com/clementmac/kdb3/server"
+)
+
+func newTestClient(t *testing.T) (*client.Client, func()) {
+	serverURL, done := newTestServer(t, nil)
+	return client.New(serverURL), done
+}
+
+func newTestServer(t *testing.T, config *kdb.Config) (string, func()) {
+	engine, err := kdb.New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(server.NewHandler(engine))
+	return ts.URL, func() {
+		ts.Close()
+		engine.Close()
+	}
+}
+
+func TestClientDocuments(t *testing.T) {
+	c, done := newTestClient(t)
+	defer done()
+	ctx := context.Background()
+
+	if err := c.CreateDatabase(ctx, "testclient"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CreateDatabase(ctx, "testclient"); !errors.Is(err, client.ErrDBExists) {
+		t.Errorf("expected %s, got %v", client.ErrDBExists, err)
+	}
+	if _, err := c.DatabaseInfo(ctx, "testclientmissing"); !errors.Is(err, client.ErrDBNotFound) {
+		t.Errorf("expected %s, got %v", client.ErrDBNotFound, err)
+	}
+
+	meta, err := c.PutDocument(ctx, "testclient", map[string]interface{}{"_id": "1", "count": 1})
+	if err != nil || meta.ID != "1" || meta.Version != 1 {
+		t.Errorf("unexpected put result %+v %v", meta, err)
+	}
+	_, err = c.PutDocument(ctx, "testclient", map[string]interface{}{"_id": "1", "count": 2})
+	var e *client.Error
+	if !errors.Is(err, client.ErrDocConflict) || !errors.As(err, &e) || e.StatusCode != 409 {
+		t.Errorf("expected %s, got %v", client.ErrDocConflict, err)
+	}
+
+	meta, err = c.UpdateDocument(ctx, "testclient", "1", 3, func(doc map[string]interface{}) error {
+		doc["count"] = doc["count"].(float64) + 1
+		return nil
+	})
+	if err != nil || meta.Version != 2 {
+		t.Errorf("unexpected update result %+v %v", meta, err)
+	}
+
+	doc := struct {
+		ID      string `json:"_id"`
+		Version int    `json:"_version"`
+		Count   int    `json:"count"`
+	}{}
+	if err := c.GetDocument(ctx, "testclient", "1", &doc); err != nil || doc.Count != 2 || doc.Version != 2 {
+		t.Errorf("unexpected doc %+v %v", doc, err)
+	}
+
+	attempts := 0
+	err = client.RetryOnConflict(ctx, 3, func() error {
+		attempts++
+		_, err := c.PutDocument(ctx, "testclient", map[string]interface{}{"_id": "1", "_version": 1})
+		return err
+	})
+	if !errors.Is(err, client.ErrDocConflict) || attempts != 3 {
+		t.Errorf("expected 3 conflicting attempts, got %d %v", attempts, err)
+	}
+
+	results, err := c.BulkDocuments(ctx, "testclient", []interface{}{
+		map[string]interface{}{"_id": "2"},
+		map[string]interface{}{"_id": "1"},
+	})
+	if err != nil || len(results) != 2 || results[0].ID != "2" || results[0].Err != nil || !errors.Is(results[1].Err, client.ErrDocConflict) {
+		t.Errorf("unexpected bulk result %+v %v", results, err)
+	}
+
+	items, err := c.BulkGetDocuments(ctx, "testclient", []string{"1", "missing"})
+	if err != nil || len(items) != 2 {
+		t.Errorf("unexpected bulk get result %s %v", items, err)
+	}
+
+	if _, err := c.DeleteDocument(ctx, "testclient", "2", 1); err != nil {
+		t.Error(err)
+	}
+	if err := c.GetDocument(ctx, "testclient", "2", &doc); !errors.Is(err, client.ErrDocNotFound) {
+		t.Errorf("expected %s, got %v", client.ErrDocNotFound, err)
+	}
+
+	if err := c.DeleteDatabase(ctx, "testclient"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestClientChangesAndViews(t *testing.T) {
+	c, done := newTestClient(t)
+	defer done()
+	ctx := context.Background()
+
+	c.CreateDatabase(ctx, "testclientviews")
+	defer c.DeleteDatabase(ctx, "testclientviews")
+
+	ddoc := map[string]interface{}{
+		"_id": "_design/counts",
+		"views": map[string]interface{}{
+			"by_id": map[string]interface{}{
+				"setup":  []string{"CREATE TABLE IF NOT EXISTS counts (doc_id, count, PRIMARY KEY(doc_id))"},
+				"run":    []string{"INSERT OR REPLACE INTO counts SELECT doc_id, json_extract(data, '$.count') FROM latest_documents WHERE deleted = 0 AND doc_id NOT LIKE '_design/%'"},
+				"select": map[string]string{"default": "SELECT IFNULL(SUM(count), 0) FROM counts WHERE ${min:int} IS NULL OR count >= ${min:int}"},
+			},
+		},
+	}
+	if _, err := c.PutDocument(ctx, "testclientviews", ddoc); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		c.PutDocument(ctx, "testclientviews", map[string]interface{}{"_id": string(rune('a' + i)), "count": i})
+	}
+
+	var sum int
+	if err := c.SelectView(ctx, "testclientviews", "_design/counts", "by_id", &client.ViewOptions{Params: url.Values{"min": {"2"}}}, &sum); err != nil || sum != 5 {
+		t.Errorf("expected 5, got %d %v", sum, err)
+	}
+	if err := c.SelectView(ctx, "testclientviews", "_design/counts", "missing", nil, &sum); !errors.Is(err, client.ErrViewNotFound) {
+		t.Errorf("expected %s, got %v", client.ErrViewNotFound, err)
+	}
+
+	uuids, err := c.UUIDs(ctx, 3)
+	if err != nil || len(uuids) != 3 {
+		t.Errorf("unexpected uuids %v %v", uuids, err)
+	}
+
+	changes, err := c.Changes(ctx, "testclientviews", "", 0)
+	if err != nil || len(changes) != 5 {
+		t.Errorf("expected 5 changes, got %d %v", len(changes), err)
+	}
+
+	streamCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	var ids []string
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		c.PutDocument(ctx, "testclientviews", map[string]interface{}{"_id": "e", "count": 4})
+	}()
+	err = c.StreamChanges(streamCtx, "testclientviews", changes[0].Seq, 10*time.Millisecond, func(change client.Change) error {
+		ids = append(ids, change.ID)
+		cancel()
+		return nil
+	})
+	if err != context.Canceled || len(ids) != 1 || ids[0] != "e" {
+		t.Errorf("expected change for e, got %v %v", ids, err)
+	}
+}
+
+func TestClientReplicate(t *testing.T) {
+	centralURL, centralDone := newTestServer(t, nil)
+	defer centralDone()
+	central := client.New(centralURL)
+	config := kdb.DefaultConfig()
+	config.DBPath = "./data/edge/dbs"
+	config.ViewPath = "./data/edge/mrviews"
+	edgeURL, edgeDone := newTestServer(t, config)
+	defer edgeDone()
+	edge := client.New(edgeURL)
+	ctx := context.Background()
+
+	central.CreateDatabase(ctx, "testreplicate")
+	defer central.DeleteDatabase(ctx, "testreplicate")
+	defer edge.DeleteDatabase(ctx, "testreplicate")
+
+	central.PutDocument(ctx, "testreplicate", map[string]interface{}{"_id": "1", "name": "alice"})
+	meta, _ := central.PutDocument(ctx, "testreplicate", map[string]interface{}{"_id": "2", "name": "bob"})
+	central.DeleteDocument(ctx, "testreplicate", "2", meta.Version)
+	central.UpdateDocument(ctx, "testreplicate", "1", 1, func(doc map[string]interface{}) error {
+		doc["name"] = "alice smith"
+		return nil
+	})
+
+	// the edge pulls from central
+	source := centralURL + "/testreplicate"
+	result, err := edge.Replicate(ctx, source, "testreplicate", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.DocsRead != 3 || result.DocWriteFailures != 0 {
+		t.Errorf("unexpected result %+v", result)
+	}
+	doc := map[string]interface{}{}
+	if err := edge.GetDocument(ctx, "testreplicate", "1", &doc); err != nil || doc["_version"] != float64(2) || doc["name"] != "alice smith" {
+		t.Errorf("unexpected doc %v %v", doc, err)
+	}
+	if err := edge.GetDocument(ctx, "testreplicate", "2", &doc); !errors.Is(err, client.ErrDocNotFound) {
+		t.Errorf("expected %s, got %v", client.ErrDocNotFound, err)
+	}
+
+	// replication resumes from its checkpoint
+	central.PutDocument(ctx, "testreplicate", map[string]interface{}{"_id": "3"})
+	result, err = edge.Replicate(ctx, source, "testreplicate", false)
+	if err != nil || result.DocsRead != 1 {
+		t.Errorf("expected 1 document read, got %+v %v", result, err)
+	}
+
+	// and pushes back
+	edge.PutDocument(ctx, "testreplicate", map[string]interface{}{"_id": "4"})
+	if _, err := edge.Replicate(ctx, "testreplicate", source, false); err != nil {
+		t.Error(err)
+	}
+	if err := central.GetDocument(ctx, "testreplicate", "4", &doc); err != nil || doc["_version"] != float64(1) {
+		t.Errorf("unexpected doc %v %v", doc, err)
+	}
+
+	if _, err := edge.Replicate(ctx, "", "testreplicate", false); !errors.Is(err, client.ErrInvalidReplication) {
+		t.Errorf("expected %s, got %v", client.ErrInvalidReplication, err)
+	}
+}
+
+func TestClientExportAndImport(t *testing.T) {
+	c, done := newTestClient(t)
+	defer done()
+	ctx := context.Background()
+
+	c.CreateDatabase(ctx, "testclientexport")
+	defer c.DeleteDatabase(ctx, "testclientexport")
+	c.CreateDatabase(ctx, "testclientimport")
+	defer c.DeleteDatabase(ctx, "testclientimport")
+	c.PutDocument(ctx, "testclientexport", map[string]interface{}{"_id": "1", "name": "alice"})
+
+	var export bytes.Buffer
+	if err := c.ExportDocuments(ctx, "testclientexport", &export); err != nil {
+		t.Fatal(err)
+	}
+	result, err := c.ImportDocuments(ctx, "testclientimport", &export, true)
+	if err != nil || result.Written != 1 || result.Failures != 0 {
+		t.Errorf("expected 1 document written, got %+v %v", result, err)
+	}
+	doc := map[string]interface{}{}
+	if err := c.GetDocument(ctx, "testclientimport", "1", &doc); err != nil || doc["name"] != "alice" {
+		t.Errorf("unexpected doc %v %v", doc, err)
+	}
+
+	if err := c.ExportDocuments(ctx, "testclientmissing", &export); !errors.Is(err, client.ErrDBNotFound) {
+		t.Errorf("expected %s, got %v", client.ErrDBNotFound, err)
+	}
+}
@@ -0,0 +1,68 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Error codes returned by the server, errors.Is matches an *Error against
+// them.
+var (
+	ErrBadJSON               = errors.New("bad_json")
+	ErrDBExists              = errors.New("db_exists")
+	ErrDBNotFound            = errors.New("db_not_found")
+	ErrDBInvalidName         = errors.New("invalid_db_name")
+	ErrDocInvalidID          = errors.New("invalid_doc_id")
+	ErrDocConflict           = errors.New("doc_conflict")
+	ErrDocNotFound           = errors.New("doc_not_found")
+	ErrViewNotFound          = errors.New("view_not_found")
+	ErrViewResult            = errors.New("view_result_error")
+	ErrViewInvalidParam      = errors.New("invalid_view_param")
+	ErrViewInvalidSource     = errors.New("invalid_view_source")
+	ErrViewInvalidDependency = errors.New("invalid_view_dependency")
+	ErrViewInvalidExternal   = errors.New("invalid_view_external")
+	ErrExternalView          = errors.New("external_view_error")
+	ErrInvalidStorage        = errors.New("invalid_storage")
+	ErrInvalidSQLStmt        = errors.New("invalid_sql_stmt")
+	ErrInvalidReplication    = errors.New("invalid_replication")
+	ErrBackupNotFound        = errors.New("backup_not_found")
+	ErrUnauthorized          = errors.New("unauthorized")
+	ErrForbidden             = errors.New("forbidden")
+	ErrQuotaExceeded         = errors.New("quota_exceeded")
+	ErrTooManyRequests       = errors.New("too_many_requests")
+	ErrAuditDisabled         = errors.New("audit_disabled")
+	ErrInternalError         = errors.New("internal_error")
+	ErrTimeout               = errors.New("timeout")
+	ErrCanceled              = errors.New("canceled")
+)
+
+var errorCodes = map[string]error{}
+
+func init() {
+	for _, err := range []error{
+		ErrBadJSON, ErrDBExists, ErrDBNotFound, ErrDBInvalidName, ErrDocInvalidID,
+		ErrDocConflict, ErrDocNotFound, ErrViewNotFound, ErrViewResult, ErrViewInvalidParam,
+		ErrViewInvalidSource, ErrViewInvalidDependency, ErrViewInvalidExternal, ErrExternalView,
+		ErrInvalidStorage, ErrInvalidSQLStmt, ErrInvalidReplication, ErrBackupNotFound, ErrUnauthorized, ErrForbidden, ErrQuotaExceeded, ErrTooManyRequests, ErrAuditDisabled, ErrInternalError, ErrTimeout, ErrCanceled,
+	} {
+		errorCodes[err.Error()] = err
+	}
+}
+
+// Error is an error response, {"error": code, "reason": reason}.
+type Error struct {
+	StatusCode int
+	Code       string `json:"error"`
+	Reason     string `json:"reason"`
+}
+
+func (e *Error) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("%d %s", e.StatusCode, e.Code)
+	}
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, e.Code, e.Reason)
+}
+
+func (e *Error) Unwrap() error {
+	return errorCodes[e.Code]
+}
@@ -0,0 +1,246 @@
+package kdb
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// actions of the audit records
+const (
+	AuditCreateDatabase = "create_db"
+	AuditDeleteDatabase = "delete_db"
+	AuditCopyDatabase   = "copy_db"
+	AuditRenameDatabase = "rename_db"
+	AuditRestore        = "restore_db"
+	AuditPutDocument    = "put_doc"
+	AuditDeleteDocument = "delete_doc"
+	AuditPutDesign      = "put_design"
+	AuditDeleteDesign   = "delete_design"
+	AuditPutSecurity    = "put_security"
+)
+
+// AuditRecord is a write or administrative operation of the audit log. User
+// and Roles are the ones of the request, both empty for the engine's own
+// writes, Roles empty for anonymous requests.
+type AuditRecord struct {
+	Time    time.Time `json:"time"`
+	User    string    `json:"user"`
+	Roles   []string  `json:"roles"`
+	Action  string    `json:"action"`
+	DB      string    `json:"db"`
+	DocID   string    `json:"doc_id,omitempty"`
+	Version int       `json:"version,omitempty"`
+	Target  string    `json:"target,omitempty"`
+}
+
+// AuditQuery selects audit records, empty fields match every record.
+type AuditQuery struct {
+	Since  time.Time
+	Until  time.Time
+	User   string
+	DB     string
+	Action string
+	Limit  int
+}
+
+func (query *AuditQuery) matches(record *AuditRecord) bool {
+	return (query.Since.IsZero() || !record.Time.Before(query.Since)) &&
+		(query.Until.IsZero() || record.Time.Before(query.Until)) &&
+		(query.User == "" || record.User == query.User) &&
+		(query.DB == "" || record.DB == query.DB) &&
+		(query.Action == "" || record.Action == query.Action)
+}
+
+// AuditLog stores the audit records, records are only ever appended.
+type AuditLog interface {
+	Write(record *AuditRecord) error
+	// Query returns the records matching query, oldest first.
+	Query(ctx context.Context, query AuditQuery) ([]*AuditRecord, error)
+	Close() error
+}
+
+// FileAuditLog writes the audit records to a file as lines of json. The file
+// is rotated once it's larger than maxSize bytes, path.1 is the newest of
+// the maxFiles rotated files.
+type FileAuditLog struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	mux  sync.Mutex
+	file *os.File
+	size int64
+}
+
+func NewFileAuditLog(path string, maxSize int64, maxFiles int) *FileAuditLog {
+	return &FileAuditLog{path: path, maxSize: maxSize, maxFiles: maxFiles}
+}
+
+func (auditLog *FileAuditLog) Write(record *AuditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	auditLog.mux.Lock()
+	defer auditLog.mux.Unlock()
+
+	if auditLog.file == nil {
+		if err := auditLog.open(); err != nil {
+			return err
+		}
+	}
+	if auditLog.maxSize > 0 && auditLog.size > 0 && auditLog.size+int64(len(line)) > auditLog.maxSize {
+		if err := auditLog.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := auditLog.file.Write(line)
+	auditLog.size += int64(n)
+	return err
+}
+
+func (auditLog *FileAuditLog) open() error {
+	file, err := os.OpenFile(auditLog.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	auditLog.file, auditLog.size = file, info.Size()
+	return nil
+}
+
+// rotate renames the file to path.1, shifting the older files up and
+// removing the oldest one. Caller holds the lock.
+func (auditLog *FileAuditLog) rotate() error {
+	if err := auditLog.file.Close(); err != nil {
+		return err
+	}
+	auditLog.file = nil
+	os.Remove(auditLog.rotatedPath(auditLog.maxFiles))
+	for i := auditLog.maxFiles - 1; i >= 1; i-- {
+		os.Rename(auditLog.rotatedPath(i), auditLog.rotatedPath(i+1))
+	}
+	if auditLog.maxFiles > 0 {
+		if err := os.Rename(auditLog.path, auditLog.rotatedPath(1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(auditLog.path); err != nil {
+		return err
+	}
+	return auditLog.open()
+}
+
+func (auditLog *FileAuditLog) rotatedPath(i int) string {
+	return fmt.Sprintf("%s.%d", auditLog.path, i)
+}
+
+func (auditLog *FileAuditLog) Query(ctx context.Context, query AuditQuery) ([]*AuditRecord, error) {
+	// rotating while reading would skip a file
+	auditLog.mux.Lock()
+	defer auditLog.mux.Unlock()
+
+	records := []*AuditRecord{}
+	paths := []string{}
+	for i := auditLog.maxFiles; i >= 1; i-- {
+		paths = append(paths, auditLog.rotatedPath(i))
+	}
+	paths = append(paths, auditLog.path)
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			if err := ctx.Err(); err != nil {
+				file.Close()
+				return nil, err
+			}
+			record := &AuditRecord{}
+			if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+				continue
+			}
+			if query.matches(record) {
+				records = append(records, record)
+				if query.Limit > 0 && len(records) >= query.Limit {
+					file.Close()
+					return records, nil
+				}
+			}
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+func (auditLog *FileAuditLog) Close() error {
+	auditLog.mux.Lock()
+	defer auditLog.mux.Unlock()
+	if auditLog.file == nil {
+		return nil
+	}
+	err := auditLog.file.Close()
+	auditLog.file = nil
+	return err
+}
+
+// audit records an operation done for the user of ctx. The operation is
+// done already, a record which can't be written is logged.
+func (kdb *Engine) audit(ctx context.Context, record *AuditRecord) {
+	if kdb.auditLog == nil {
+		return
+	}
+	record.Time = time.Now().UTC()
+	if user := UserFromContext(ctx); user != nil {
+		record.User, record.Roles = user.Name, user.Roles
+	}
+	if err := kdb.auditLog.Write(record); err != nil {
+		log.Printf("audit: %s %s %s: %s", record.Action, record.DB, record.DocID, err)
+	}
+}
+
+// auditDocument records a document write, design documents have their own
+// actions.
+func (kdb *Engine) auditDocument(ctx context.Context, name string, doc *Document) {
+	action := AuditPutDocument
+	switch {
+	case strings.HasPrefix(doc.ID, "_design/") && doc.Deleted:
+		action = AuditDeleteDesign
+	case strings.HasPrefix(doc.ID, "_design/"):
+		action = AuditPutDesign
+	case doc.Deleted:
+		action = AuditDeleteDocument
+	}
+	kdb.audit(ctx, &AuditRecord{Action: action, DB: name, DocID: doc.ID, Version: doc.Version})
+}
+
+// QueryAudit returns the audit records matching query, ErrAuditDisabled
+// without an audit log.
+func (kdb *Engine) QueryAudit(ctx context.Context, query AuditQuery) ([]*AuditRecord, error) {
+	if kdb.auditLog == nil {
+		return nil, ErrAuditDisabled
+	}
+	records, err := kdb.auditLog.Query(ctx, query)
+	return records, contextError(ctx, err)
+}
@@ -0,0 +1,119 @@
+package kdb
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestFileAuditLogRotation(t *testing.T) {
+	os.MkdirAll("./data/audit", 0755)
+	defer os.RemoveAll("./data/audit")
+	auditLog := NewFileAuditLog("./data/audit/audit.log", 200, 2)
+	defer auditLog.Close()
+	ctx := context.Background()
+
+	start := time.Now().UTC()
+	for i := 0; i < 10; i++ {
+		record := &AuditRecord{Time: start.Add(time.Duration(i) * time.Second), User: "testalice", Action: AuditPutDocument, DB: "testaudit", DocID: "1", Version: i + 1}
+		if err := auditLog.Write(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat("./data/audit/audit.log.2"); err != nil {
+		t.Errorf("expected rotated files, got %v", err)
+	}
+	if _, err := os.Stat("./data/audit/audit.log.3"); !os.IsNotExist(err) {
+		t.Errorf("expected at most 2 rotated files, got %v", err)
+	}
+
+	records, err := auditLog.Query(ctx, AuditQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) == 0 || len(records) >= 10 || records[len(records)-1].Version != 10 {
+		t.Fatalf("expected the newest records, got %d", len(records))
+	}
+	for i := 1; i < len(records); i++ {
+		if records[i].Version != records[i-1].Version+1 {
+			t.Errorf("expected the records in order, got %d after %d", records[i].Version, records[i-1].Version)
+		}
+	}
+
+	records, _ = auditLog.Query(ctx, AuditQuery{Since: start.Add(8 * time.Second)})
+	if len(records) != 2 || records[0].Version != 9 {
+		t.Errorf("expected the records since 8s, got %+v", records)
+	}
+	records, _ = auditLog.Query(ctx, AuditQuery{Limit: 1, User: "testalice"})
+	if len(records) != 1 {
+		t.Errorf("expected one record, got %d", len(records))
+	}
+	records, _ = auditLog.Query(ctx, AuditQuery{Action: AuditDeleteDocument})
+	if len(records) != 0 {
+		t.Errorf("expected no records, got %d", len(records))
+	}
+}
+
+func TestAudit(t *testing.T) {
+	config := DefaultConfig()
+	config.DBPath = "./data/audit/dbs"
+	config.ViewPath = "./data/audit/mrviews"
+	config.AuditPath = "./data/audit/audit.log"
+	kdb, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll("./data/audit")
+	defer kdb.Close()
+	ctx := NewUserContext(context.Background(), &User{Name: "testalice", Roles: []string{"_admin"}})
+
+	if err := kdb.CreateDatabase(ctx, "testaudit", ""); err != nil {
+		t.Fatal(err)
+	}
+	inputDoc, _ := ParseDocument([]byte(`{"_id":"1","name":"one"}`))
+	doc, err := kdb.PutDocument(ctx, "testaudit", inputDoc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kdb.BulkDocuments(ctx, "testaudit", []byte(`{"_docs":[{"_id":"2"},{"_id":"_design/names","views":{}}]}`))
+	kdb.DeleteDocument(ctx, "testaudit", &Document{ID: "1", Version: doc.Version})
+	kdb.PutSecurity(ctx, "testaudit", &Security{})
+	if err := kdb.DeleteDatabase(ctx, "testaudit"); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := kdb.QueryAudit(context.Background(), AuditQuery{User: "testalice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{AuditCreateDatabase, AuditPutDocument, AuditPutDocument, AuditPutDesign, AuditDeleteDocument, AuditPutSecurity, AuditDeleteDatabase}
+	if len(records) != len(expected) {
+		t.Fatalf("expected %d records, got %d", len(expected), len(records))
+	}
+	for i, record := range records {
+		if record.Action != expected[i] || record.DB != "testaudit" || record.User != "testalice" || len(record.Roles) != 1 {
+			t.Errorf("record %d: expected %s, got %+v", i, expected[i], record)
+		}
+	}
+	if records[1].DocID != "1" || records[1].Version != doc.Version || records[4].Version != doc.Version+1 {
+		t.Errorf("expected the document versions, got %+v %+v", records[1], records[4])
+	}
+
+	// the engine's own writes have no user
+	kdb.Open("testauditsystem", true)
+	defer kdb.Delete("testauditsystem")
+	records, _ = kdb.QueryAudit(context.Background(), AuditQuery{DB: "testauditsystem"})
+	if len(records) != 1 || records[0].User != "" || records[0].Roles != nil {
+		t.Errorf("expected a record without user, got %+v", records)
+	}
+}
+
+func TestAuditDisabled(t *testing.T) {
+	kdb, _ := New(DefaultConfig())
+	defer kdb.Close()
+	if _, err := kdb.QueryAudit(context.Background(), AuditQuery{}); !errors.Is(err, ErrAuditDisabled) {
+		t.Errorf("expected %s, got %v", ErrAuditDisabled, err)
+	}
+}
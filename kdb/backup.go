@@ -0,0 +1,382 @@
+package kdb
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// backupPages is the number of pages copied per backup step, ctx is checked
+// between steps.
+var backupPages = 1024
+
+// rawSQLiteConn runs fn with the driver connection of con, the online
+// backup api works on driver connections.
+func rawSQLiteConn(ctx context.Context, con *sql.DB, fn func(*sqlite3.SQLiteConn) error) error {
+	conn, err := con.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Raw(func(driverConn interface{}) error {
+		sqliteConn, ok := driverConn.(*sqlite3.SQLiteConn)
+		if !ok {
+			return fmt.Errorf("unexpected driver connection %T", driverConn)
+		}
+		return fn(sqliteConn)
+	})
+}
+
+// backupDatabase copies the database src into dest with sqlite's online
+// backup, src can be written meanwhile.
+func backupDatabase(ctx context.Context, src, dest string) error {
+	srcCon, err := sql.Open("sqlite3", withMode(src, "ro"))
+	if err != nil {
+		return err
+	}
+	defer srcCon.Close()
+
+	destCon, err := sql.Open("sqlite3", withMode(dest, "rwc"))
+	if err != nil {
+		return err
+	}
+	defer destCon.Close()
+
+	return rawSQLiteConn(ctx, srcCon, func(srcConn *sqlite3.SQLiteConn) error {
+		return rawSQLiteConn(ctx, destCon, func(destConn *sqlite3.SQLiteConn) error {
+			backup, err := destConn.Backup("main", srcConn, "main")
+			if err != nil {
+				return err
+			}
+			for {
+				if err := ctx.Err(); err != nil {
+					backup.Finish()
+					return err
+				}
+				done, err := backup.Step(backupPages)
+				if err != nil {
+					backup.Finish()
+					return err
+				}
+				if done {
+					break
+				}
+			}
+			return backup.Finish()
+		})
+	})
+}
+
+// A database's backups are a full backup and the incremental backups written
+// after it, the manifest of the backup directory lists them in order.
+const (
+	backupManifestFile = "manifest.json"
+	backupFullFile     = "full.db"
+)
+
+const (
+	BackupFull        = "full"
+	BackupIncremental = "incremental"
+)
+
+type backupManifest struct {
+	Database     string            `json:"db"`
+	Full         string            `json:"full"`
+	Seq          string            `json:"seq"`
+	Incrementals []backupIncrement `json:"incrementals"`
+	// Security is the security object of the database at the last backup.
+	Security json.RawMessage `json:"security,omitempty"`
+}
+
+type backupIncrement struct {
+	File  string `json:"file"`
+	Since string `json:"since"`
+	Seq   string `json:"seq"`
+	Docs  int    `json:"docs"`
+}
+
+type BackupResult struct {
+	OK   bool   `json:"ok"`
+	Kind string `json:"kind"`
+	File string `json:"file,omitempty"`
+	Seq  string `json:"seq"`
+	Docs int    `json:"docs"`
+}
+
+type RestoreResult struct {
+	OK           bool   `json:"ok"`
+	Seq          string `json:"seq"`
+	Incrementals int    `json:"incrementals"`
+	Docs         int    `json:"docs"`
+}
+
+// Backup backs up a database into its backup directory. The first backup,
+// and every one with full set, is a full online backup of the database file,
+// it replaces the previous backups. The ones after it are incremental, they
+// hold the documents changed since the last backup as lines of json.
+func (kdb *Engine) Backup(ctx context.Context, name string, full bool) (*BackupResult, error) {
+	dir, err := kdb.backupDir(name)
+	if err != nil {
+		return nil, err
+	}
+
+	kdb.backupMux.Lock()
+	defer kdb.backupMux.Unlock()
+
+	manifest, err := readBackupManifest(dir)
+	if err != nil && !errors.Is(err, ErrBackupNotFound) {
+		return nil, err
+	}
+	if full || manifest == nil {
+		return kdb.fullBackup(ctx, name, dir)
+	}
+	return kdb.incrementalBackup(ctx, name, dir, manifest)
+}
+
+func (kdb *Engine) fullBackup(ctx context.Context, name, dir string) (*BackupResult, error) {
+	kdb.rwmux.RLock()
+	db, err := kdb.database(name)
+	if err != nil {
+		kdb.rwmux.RUnlock()
+		return nil, err
+	}
+	// documents written during the backup are in the next increment again
+	seq := db.GetLastUpdateSequence()
+	src := db.Storage().ConnectionString(db.DBPath, "_journal=WAL")
+	kdb.rwmux.RUnlock()
+
+	if err := kdb.serviceLocator.GetFileHandler().MkdirAll(dir); err != nil {
+		return nil, err
+	}
+	tmp := filepath.Join(dir, backupFullFile+".tmp")
+	os.Remove(tmp)
+	if err := backupDatabase(ctx, src, tmp+"?_journal=WAL"); err != nil {
+		os.Remove(tmp)
+		return nil, contextError(ctx, err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, backupFullFile)); err != nil {
+		return nil, err
+	}
+
+	// increments of the previous full backup are replayed over a newer one
+	// harmlessly, so the manifest goes last
+	previous, _ := readBackupManifest(dir)
+	manifest := &backupManifest{Database: name, Full: backupFullFile, Seq: seq}
+	if security, _ := kdb.localDB.LookupSecurity(name); security != "" {
+		manifest.Security = json.RawMessage(security)
+	}
+	if err := writeBackupManifest(dir, manifest); err != nil {
+		return nil, err
+	}
+	if previous != nil {
+		for _, increment := range previous.Incrementals {
+			os.Remove(filepath.Join(dir, increment.File))
+		}
+	}
+
+	return &BackupResult{OK: true, Kind: BackupFull, File: backupFullFile, Seq: seq}, nil
+}
+
+func (kdb *Engine) incrementalBackup(ctx context.Context, name, dir string, manifest *backupManifest) (*BackupResult, error) {
+	increment := backupIncrement{
+		File:  fmt.Sprintf("incremental-%04d.ndjson", len(manifest.Incrementals)+1),
+		Since: manifest.Seq,
+		Seq:   manifest.Seq,
+	}
+	tmp := filepath.Join(dir, increment.File+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp)
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	increment.Seq, increment.Docs, err = kdb.Export(ctx, name, increment.Since, true, w)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &BackupResult{OK: true, Kind: BackupIncremental, Seq: increment.Seq, Docs: increment.Docs}
+	if increment.Docs == 0 {
+		return result, nil
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, increment.File)); err != nil {
+		return nil, err
+	}
+
+	manifest.Seq = increment.Seq
+	manifest.Incrementals = append(manifest.Incrementals, increment)
+	if security, _ := kdb.localDB.LookupSecurity(name); security != "" {
+		manifest.Security = json.RawMessage(security)
+	}
+	if err := writeBackupManifest(dir, manifest); err != nil {
+		return nil, err
+	}
+	result.File = increment.File
+	return result, nil
+}
+
+// Restore creates the database target from the backups of a database: its
+// full backup with the incremental backups replayed over it. The views are
+// built once the database is restored.
+func (kdb *Engine) Restore(ctx context.Context, backup, target string) (*RestoreResult, error) {
+	if !validateDBName(target) {
+		return nil, ErrDBInvalidName
+	}
+	if !kdb.sameEncryption(backup, target) {
+		return nil, fmt.Errorf("%s: %w", target+" doesn't encrypt the fields of "+backup, ErrDBInvalidName)
+	}
+	dir, err := kdb.backupDir(backup)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := readBackupManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	storage, _ := kdb.serviceLocator.GetStorage(StorageFile)
+
+	kdb.rwmux.Lock()
+	kdb.localDB.Begin()
+	fileName, err := kdb.reserveName(target)
+	kdb.localDB.Rollback()
+	kdb.rwmux.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		kdb.rwmux.Lock()
+		delete(kdb.copying, target)
+		kdb.rwmux.Unlock()
+	}()
+
+	path := filepath.Join(kdb.dbPath, fileName+dbExt)
+	result, err := kdb.restoreFiles(ctx, dir, manifest, target, fileName, storage)
+	if err == nil {
+		err = kdb.endCopy(target, fileName, StorageFile, string(manifest.Security))
+	}
+	if err != nil {
+		storage.Remove(path)
+		return nil, contextError(ctx, err)
+	}
+	kdb.audit(ctx, &AuditRecord{Action: AuditRestore, DB: backup, Target: target})
+
+	kdb.rwmux.RLock()
+	defer kdb.rwmux.RUnlock()
+	db, err := kdb.database(target)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.BuildViews(ctx); err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return result, nil
+}
+
+// restoreFiles copies the full backup to the file of target and replays the
+// incremental backups over it, before target is visible.
+func (kdb *Engine) restoreFiles(ctx context.Context, dir string, manifest *backupManifest, target, fileName string, storage Storage) (*RestoreResult, error) {
+	path := filepath.Join(kdb.dbPath, fileName+dbExt)
+	if err := backupDatabase(ctx, filepath.Join(dir, manifest.Full)+"?_journal=WAL", storage.ConnectionString(path, "_journal=WAL")); err != nil {
+		return nil, err
+	}
+
+	db, err := NewDatabaseWithStorage(target, fileName, kdb.dbPath, kdb.viewPath, false, kdb.serviceLocator, storage)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	result := &RestoreResult{OK: true, Seq: manifest.Seq}
+	for _, increment := range manifest.Incrementals {
+		docs, err := replayIncrement(ctx, db, filepath.Join(dir, increment.File))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", increment.File, err)
+		}
+		result.Incrementals++
+		result.Docs += docs
+	}
+	return result, nil
+}
+
+func replayIncrement(ctx context.Context, db *Database, path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	docs := 0
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 1 {
+			doc, err := ParseDocument(line)
+			if err != nil {
+				return docs, err
+			}
+			if _, err := db.PutReplicatedDocument(ctx, doc); err != nil {
+				return docs, err
+			}
+			docs++
+		}
+		if err == io.EOF {
+			return docs, nil
+		}
+		if err != nil {
+			return docs, err
+		}
+	}
+}
+
+// backupDir is the backup directory of a database, its name is a single
+// path element.
+func (kdb *Engine) backupDir(name string) (string, error) {
+	if !validateDBName(name) || name != filepath.Base(name) || name == "." || name == ".." {
+		return "", ErrDBInvalidName
+	}
+	return filepath.Join(kdb.config.BackupPath, name), nil
+}
+
+func readBackupManifest(dir string) (*backupManifest, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, backupManifestFile))
+	if os.IsNotExist(err) {
+		return nil, ErrBackupNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	manifest := &backupManifest{}
+	if err := json.Unmarshal(b, manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+func writeBackupManifest(dir string, manifest *backupManifest) error {
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, backupManifestFile+".tmp")
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, backupManifestFile))
+}
@@ -0,0 +1,153 @@
+package kdb
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+)
+
+type Config struct {
+	Addr     string `json:"addr"`
+	DBPath   string `json:"db_path"`
+	ViewPath string `json:"view_path"`
+
+	// Databases and views are opened on first access. They are closed after
+	// IdleTimeout seconds without use, or least recently used first when
+	// there are more than MaxOpenDatabases or MaxOpenViews. 0 disables.
+	IdleTimeout      int `json:"idle_timeout"`
+	MaxOpenDatabases int `json:"max_open_databases"`
+	MaxOpenViews     int `json:"max_open_views"`
+
+	// ExternalViews maps an external view server name to the command that
+	// starts it. Design documents can only reference servers listed here.
+	ExternalViews map[string][]string `json:"external_views,omitempty"`
+
+	// ReplicatorInterval is how often, in seconds, the _replicator database
+	// is checked for new replications and continuous ones run again. 0
+	// disables the replication manager.
+	ReplicatorInterval int `json:"replicator_interval"`
+
+	// BackupPath is where _backup writes a directory of backups for each
+	// database.
+	BackupPath string `json:"backup_path"`
+
+	// Admins maps the names of the server admins to their passwords. Without
+	// admins every request is treated as coming from an admin.
+	Admins map[string]string `json:"admins,omitempty"`
+	// RequireValidUser rejects requests without valid credentials.
+	RequireValidUser bool `json:"require_valid_user"`
+	// Secret signs the session cookies, a random secret is used when it's
+	// empty and sessions end with a restart. SessionTimeout is the lifetime
+	// of a session in seconds.
+	Secret         string `json:"secret,omitempty"`
+	SessionTimeout int    `json:"session_timeout"`
+	// JWT enables authentication with Bearer tokens, see JWTConfig.
+	JWT *JWTConfig `json:"jwt,omitempty"`
+
+	// RateLimits limits the requests of each client, a user or else an ip
+	// address, per class of routes, "read", "write" and "view".
+	RateLimits map[string]RateLimit `json:"rate_limits,omitempty"`
+	// Quotas limits databases by name, "*" applies to those without their
+	// own quota, see Quota.
+	Quotas map[string]Quota `json:"quotas,omitempty"`
+
+	// AuditPath is the file of the audit log, empty disables it. It's
+	// rotated once it's larger than AuditMaxSize bytes, keeping AuditMaxFiles
+	// rotated files.
+	AuditPath     string `json:"audit_path"`
+	AuditMaxSize  int64  `json:"audit_max_size"`
+	AuditMaxFiles int    `json:"audit_max_files"`
+
+	// Encryption lists the fields encrypted at rest by database, see
+	// EncryptedFields. EncryptionKeyPath is the directory of the keys, see
+	// LocalKeyProvider.
+	Encryption        map[string]EncryptedFields `json:"encryption,omitempty"`
+	EncryptionKeyPath string                     `json:"encryption_key_path"`
+
+	// Debug is how the pprof and expvar endpoints under /_debug are served,
+	// DebugAdmin or DebugOff. Without Admins they're off on Addr. With a
+	// DebugAddr they're served without authentication on a listener of
+	// their own instead of Addr, bind it to localhost.
+	Debug     string `json:"debug"`
+	DebugAddr string `json:"debug_addr"`
+
+	// CORS lets browser apps of other origins call the server, see
+	// CORSConfig.
+	CORS *CORSConfig `json:"cors,omitempty"`
+}
+
+// CORSConfig are the cross-origin requests allowed. Origins are the allowed
+// origins, "https://app.example.com", "*" allows every origin. Methods and
+// Headers are the methods and request headers allowed, common ones when
+// empty. Credentials allows cookies and Authorization headers, not with "*".
+// MaxAge is how long in seconds browsers can cache a preflight response.
+type CORSConfig struct {
+	Origins     []string `json:"origins"`
+	Methods     []string `json:"methods,omitempty"`
+	Headers     []string `json:"headers,omitempty"`
+	Credentials bool     `json:"credentials"`
+	MaxAge      int      `json:"max_age"`
+}
+
+// validate rejects credentials with "*", browsers don't send them to every
+// origin and echoing the origin instead would.
+func (config *CORSConfig) validate() error {
+	if config == nil || !config.Credentials {
+		return nil
+	}
+	for _, origin := range config.Origins {
+		if origin == "*" {
+			return errors.New(`cors: credentials can't be allowed for "*"`)
+		}
+	}
+	return nil
+}
+
+// modes of the debug endpoints
+const (
+	DebugAdmin = "admin"
+	DebugOff   = "off"
+)
+
+// RateLimit is a token bucket, Rate requests per second with bursts of up to
+// Burst requests, Rate rounded up when it's 0.
+type RateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Addr:     "0.0.0.0:8001",
+		DBPath:   "./data/dbs",
+		ViewPath: "./data/mrviews",
+
+		BackupPath: "./data/backups",
+
+		IdleTimeout:      600,
+		MaxOpenDatabases: 256,
+		MaxOpenViews:     512,
+
+		ReplicatorInterval: 5,
+
+		SessionTimeout: 600,
+
+		AuditMaxSize:  100 * 1024 * 1024,
+		AuditMaxFiles: 10,
+
+		Debug: DebugAdmin,
+	}
+}
+
+// LoadConfig reads a json config file, missing keys keep their defaults.
+func LoadConfig(path string) (*Config, error) {
+	config := DefaultConfig()
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
@@ -0,0 +1,712 @@
+package kdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type DatabaseLocator interface {
+	GetDatabaseURI(name string) (string, error)
+	DatabaseAccess(name string, user *User) (Access, error)
+}
+
+type Database struct {
+	Name            string
+	UpdateSeq       string
+	DocCount        int
+	DeletedDocCount int
+
+	DBPath      string
+	ViewDirPath string
+
+	storage Storage
+
+	mux sync.Mutex
+
+	readers     DatabaseReaderPool
+	writer      DatabaseWriter
+	changeSeq   *ChangeSequenceGenarator
+	idSeq       *SequenceUUIDGenarator
+	viewManager ViewManager
+
+	databaseLocator DatabaseLocator
+
+	// cipher encrypts the configured fields at rest, nil without any
+	cipher *fieldCipher
+	// size is the size of the database file as of the last commit, quotas
+	// check it without reading the database
+	size int64
+
+	lastAccess int64
+}
+
+func (db *Database) Open(connectionString string, createIfNotExists bool) error {
+
+	err := db.writer.Open(withMode(connectionString, "rwc"))
+	if err != nil {
+		panic(err)
+	}
+
+	err = db.readers.Open(withMode(connectionString, "ro"))
+	if err != nil {
+		panic(err)
+	}
+
+	// the build script also adds the tables of newer versions to existing
+	// databases
+	db.writer.Begin(context.Background())
+	if err := db.writer.ExecBuildScript(); err != nil {
+		return err
+	}
+	db.writer.Commit()
+
+	db.DocCount, db.DeletedDocCount = db.GetDocumentCount()
+	db.UpdateSeq = db.GetLastUpdateSequence()
+	db.size = db.GetSize()
+	db.changeSeq = NewChangeSequenceGenarator(138, db.UpdateSeq)
+
+	if createIfNotExists {
+		err := db.viewManager.SetupViews(db)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = db.viewManager.Initialize(db)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (db *Database) Close() error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	db.viewManager.Close()
+	db.writer.Close()
+	db.readers.Close()
+	return nil
+}
+
+func (db *Database) touch() {
+	atomic.StoreInt64(&db.lastAccess, time.Now().UnixNano())
+}
+
+func (db *Database) lastAccessTime() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&db.lastAccess))
+}
+
+// documentWrite is the outcome of a document written in a transaction, the
+// counters of the database are updated with it once it's committed.
+type documentWrite struct {
+	updateSeq       string
+	docCount        int
+	deletedDocCount int
+	// written is set when the document became the current version, conflict
+	// when its version conflicted with the current one.
+	written  bool
+	conflict bool
+}
+
+// commit commits the transaction of writer, and keeps the size of the
+// database it measured before.
+func (db *Database) commit(writer DatabaseWriter) error {
+	size := writer.GetSize()
+	if err := writer.Commit(); err != nil {
+		return err
+	}
+	db.size = size
+	return nil
+}
+
+func (db *Database) applyWrite(write *documentWrite) {
+	if write.updateSeq != "" {
+		db.UpdateSeq = write.updateSeq
+	}
+	db.DocCount += write.docCount
+	db.DeletedDocCount += write.deletedDocCount
+}
+
+func (db *Database) PutDocument(ctx context.Context, newDoc *Document) (*Document, error) {
+
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	writer := db.writer
+
+	err := writer.Begin(ctx)
+	defer writer.Rollback()
+	if err != nil {
+		return nil, err
+	}
+
+	write, err := db.putDocument(writer, newDoc, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.commit(writer); err != nil {
+		return nil, err
+	}
+
+	db.applyWrite(write)
+
+	return newDoc, nil
+}
+
+// putDocument writes the next version of newDoc in the transaction of
+// writer. newDoc has to carry the current version, unless ignoreVersion is
+// set and it replaces whatever version is current.
+func (db *Database) putDocument(writer DatabaseWriter, newDoc *Document, ignoreVersion bool) (*documentWrite, error) {
+	if newDoc.ID == "" {
+		newDoc.ID = db.idSeq.Next()
+	}
+
+	currentDoc, err := writer.GetDocumentRevisionByID(newDoc.ID)
+	if err != nil && err != ErrDocNotFound {
+		return nil, fmt.Errorf("%s: %w", err.Error(), ErrInternalError)
+	}
+
+	if currentDoc != nil {
+		if ignoreVersion {
+			newDoc.Version = currentDoc.Version
+		} else if currentDoc.Deleted {
+			if newDoc.Version > 0 && currentDoc.Version > newDoc.Version {
+				return nil, ErrDocConflict
+			}
+			newDoc.Version = currentDoc.Version
+		} else {
+			if currentDoc.Version != newDoc.Version {
+				return nil, ErrDocConflict
+			}
+		}
+	} else if ignoreVersion {
+		newDoc.Version = 0
+	}
+
+	newDoc.CalculateNextVersion()
+	newDoc.CalculateHash(currentDoc)
+
+	// the hash is the one of the plaintext, the same on every replica
+	sealedDoc, err := db.cipher.encrypt(newDoc, false)
+	if err != nil {
+		return nil, err
+	}
+
+	updateSeq := db.changeSeq.Next()
+
+	err = writer.PutDocument(updateSeq, sealedDoc, currentDoc)
+	if err != nil {
+		return nil, err
+	}
+
+	write := &documentWrite{updateSeq: updateSeq, written: true}
+	if currentDoc == nil || currentDoc.Deleted {
+		write.docCount++
+	}
+	if currentDoc != nil && currentDoc.Deleted {
+		write.deletedDocCount--
+	}
+	if newDoc.Deleted {
+		write.docCount--
+		write.deletedDocCount++
+	}
+	return write, nil
+}
+
+// PutReplicatedDocument writes a document of another database with the
+// version, hash and history it has there. It's skipped when the database has
+// that version already and replaces the current version when it's based on
+// it. Otherwise the versions conflict, the winner becomes the current version
+// and the loser is stored as a conflict. It returns whether the document
+// became the current version.
+//
+// Versions written before versions had hashes have no history, of those the
+// higher version wins.
+func (db *Database) PutReplicatedDocument(ctx context.Context, newDoc *Document) (bool, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	writer := db.writer
+
+	err := writer.Begin(ctx)
+	defer writer.Rollback()
+	if err != nil {
+		return false, err
+	}
+
+	write, err := db.putReplicatedDocument(writer, newDoc)
+	if err != nil {
+		return false, err
+	}
+
+	if err := db.commit(writer); err != nil {
+		return false, err
+	}
+
+	db.applyWrite(write)
+
+	return write.written, nil
+}
+
+func (db *Database) putReplicatedDocument(writer DatabaseWriter, newDoc *Document) (*documentWrite, error) {
+	currentDoc, err := writer.GetDocumentRevisionByID(newDoc.ID)
+	if err != nil && err != ErrDocNotFound {
+		return nil, fmt.Errorf("%s: %w", err.Error(), ErrInternalError)
+	}
+
+	conflict := false
+	if currentDoc != nil {
+		if currentDoc.Hash == "" || newDoc.Hash == "" {
+			if currentDoc.Version >= newDoc.Version {
+				return &documentWrite{}, nil
+			}
+		} else if currentDoc.hasVersion(newDoc.Version, newDoc.Hash) {
+			return &documentWrite{}, nil
+		} else if !newDoc.hasVersion(currentDoc.Version, currentDoc.Hash) {
+			winner, known, err := db.putConflict(writer, currentDoc, newDoc)
+			if err != nil {
+				return nil, err
+			}
+			if known {
+				return &documentWrite{}, nil
+			}
+			if !winner {
+				return &documentWrite{conflict: true}, nil
+			}
+			conflict = true
+		}
+	}
+
+	sealedDoc, err := db.cipher.encrypt(newDoc, true)
+	if err != nil {
+		return nil, err
+	}
+
+	updateSeq := db.changeSeq.Next()
+
+	if err := writer.PutDocument(updateSeq, sealedDoc, currentDoc); err != nil {
+		return nil, err
+	}
+
+	write := &documentWrite{updateSeq: updateSeq, written: true, conflict: conflict}
+	if currentDoc == nil || currentDoc.Deleted {
+		write.docCount++
+	}
+	if currentDoc != nil && currentDoc.Deleted {
+		write.deletedDocCount--
+	}
+	if newDoc.Deleted {
+		write.docCount--
+		write.deletedDocCount++
+	}
+	return write, nil
+}
+
+// putConflict stores the loser of the conflicting versions currentDoc and
+// newDoc, conflicts newDoc is based on are replaced by it. It returns whether
+// newDoc won and has to become the current version, and whether newDoc is a
+// conflict stored already.
+func (db *Database) putConflict(writer DatabaseWriter, currentDoc, newDoc *Document) (bool, bool, error) {
+	conflicts, err := writer.GetConflicts(newDoc.ID)
+	if err != nil {
+		return false, false, err
+	}
+	// conflicts newDoc is based on are gone even when newDoc is known, the
+	// caller commits either way
+	known := false
+	for _, conflict := range conflicts {
+		if conflict.Version == newDoc.Version && conflict.Hash == newDoc.Hash {
+			known = true
+		} else if newDoc.hasVersion(conflict.Version, conflict.Hash) {
+			if _, err := writer.DeleteConflict(conflict.ID, conflict.Version, conflict.Hash); err != nil {
+				return false, false, err
+			}
+		}
+	}
+	if known {
+		return false, true, nil
+	}
+
+	if newDoc.winsOver(currentDoc) {
+		return true, false, writer.PutCurrentAsConflict(newDoc.ID)
+	}
+	sealedDoc, err := db.cipher.encrypt(newDoc, true)
+	if err != nil {
+		return false, false, err
+	}
+	return false, false, writer.PutConflict(sealedDoc)
+}
+
+// importDocuments writes docs in one transaction. With keepVersions they
+// are written like replicated documents, otherwise as the next version of
+// whatever version is current.
+func (db *Database) importDocuments(ctx context.Context, docs []*Document, keepVersions bool) ([]*documentWrite, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	writer := db.writer
+
+	err := writer.Begin(ctx)
+	defer writer.Rollback()
+	if err != nil {
+		return nil, err
+	}
+
+	writes := make([]*documentWrite, len(docs))
+	for i, doc := range docs {
+		if keepVersions {
+			writes[i], err = db.putReplicatedDocument(writer, doc)
+		} else {
+			writes[i], err = db.putDocument(writer, doc, true)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := db.commit(writer); err != nil {
+		return nil, err
+	}
+
+	for _, write := range writes {
+		db.applyWrite(write)
+	}
+	return writes, nil
+}
+
+// GetConflicts returns the losing versions of a document, without their data.
+func (db *Database) GetConflicts(ctx context.Context, id string) ([]*Document, error) {
+	reader := db.readers.Borrow()
+	defer db.readers.Return(reader)
+
+	if err := reader.Begin(ctx); err != nil {
+		return nil, err
+	}
+	defer reader.Commit()
+
+	return reader.GetConflicts(id)
+}
+
+func (db *Database) GetConflict(ctx context.Context, id string, version int, hash string) (*Document, error) {
+	reader := db.readers.Borrow()
+	defer db.readers.Return(reader)
+
+	if err := reader.Begin(ctx); err != nil {
+		return nil, err
+	}
+	defer reader.Commit()
+
+	doc, err := reader.GetConflict(id, version, hash)
+	if err != nil {
+		return nil, err
+	}
+	return doc, db.cipher.decrypt(doc)
+}
+
+// DeleteConflict removes a losing version of a document, which resolves the
+// conflict when it's the last one.
+func (db *Database) DeleteConflict(ctx context.Context, id string, version int, hash string) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	writer := db.writer
+
+	err := writer.Begin(ctx)
+	defer writer.Rollback()
+	if err != nil {
+		return err
+	}
+
+	deleted, err := writer.DeleteConflict(id, version, hash)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrDocNotFound
+	}
+	return db.commit(writer)
+}
+
+func (db *Database) DeleteDocument(ctx context.Context, doc *Document) (*Document, error) {
+	doc.Deleted = true
+	return db.PutDocument(ctx, doc)
+}
+
+func (db *Database) GetDocument(ctx context.Context, doc *Document, includeData bool) (*Document, error) {
+
+	reader := db.readers.Borrow()
+	defer db.readers.Return(reader)
+
+	if err := reader.Begin(ctx); err != nil {
+		return nil, err
+	}
+	defer reader.Commit()
+
+	if includeData {
+		var err error
+		if doc.Version > 0 {
+			doc, err = reader.GetDocumentByIDandVersion(doc.ID, doc.Version)
+		} else {
+			doc, err = reader.GetDocumentByID(doc.ID)
+		}
+		// deleted documents come with ErrDocNotFound
+		if err != nil {
+			return doc, err
+		}
+		return doc, db.cipher.decrypt(doc)
+	}
+
+	if doc.Version > 0 {
+		return reader.GetDocumentRevisionByIDandVersion(doc.ID, doc.Version)
+	}
+	return reader.GetDocumentRevisionByID(doc.ID)
+}
+
+func (db *Database) GetLocalDocument(ctx context.Context, id string) ([]byte, error) {
+	reader := db.readers.Borrow()
+	defer db.readers.Return(reader)
+
+	if err := reader.Begin(ctx); err != nil {
+		return nil, err
+	}
+	defer reader.Commit()
+
+	return reader.GetLocalDocument(id)
+}
+
+func (db *Database) PutLocalDocument(ctx context.Context, id string, data []byte) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	writer := db.writer
+
+	err := writer.Begin(ctx)
+	defer writer.Rollback()
+	if err != nil {
+		return err
+	}
+
+	if err := writer.PutLocalDocument(id, data); err != nil {
+		return err
+	}
+	return db.commit(writer)
+}
+
+func (db *Database) GetAllDesignDocuments() ([]*Document, error) {
+	reader := db.readers.Borrow()
+	defer db.readers.Return(reader)
+
+	reader.Begin(context.Background())
+	defer reader.Commit()
+
+	return reader.GetAllDesignDocuments()
+}
+
+func (db *Database) GetLastUpdateSequence() string {
+	reader := db.readers.Borrow()
+	defer db.readers.Return(reader)
+
+	reader.Begin(context.Background())
+	defer reader.Commit()
+
+	return reader.GetLastUpdateSequence()
+}
+
+func (db *Database) GetChanges(ctx context.Context, since string, limit int) ([]byte, error) {
+	reader := db.readers.Borrow()
+	defer db.readers.Return(reader)
+
+	if err := reader.Begin(ctx); err != nil {
+		return nil, err
+	}
+	defer reader.Commit()
+
+	return reader.GetChanges(since, limit)
+}
+
+func (db *Database) GetDocumentsSince(ctx context.Context, since string, limit int) ([]*Document, string, error) {
+	reader := db.readers.Borrow()
+	defer db.readers.Return(reader)
+
+	if err := reader.Begin(ctx); err != nil {
+		return nil, "", err
+	}
+	defer reader.Commit()
+
+	return reader.GetDocumentsSince(since, limit)
+}
+
+func (db *Database) GetDocumentCount() (int, int) {
+	reader := db.readers.Borrow()
+	defer db.readers.Return(reader)
+
+	reader.Begin(context.Background())
+	defer reader.Commit()
+
+	return reader.GetDocumentCount()
+}
+
+func (db *Database) GetSize() int64 {
+	reader := db.readers.Borrow()
+	defer db.readers.Return(reader)
+
+	reader.Begin(context.Background())
+	defer reader.Commit()
+
+	return reader.GetSize()
+}
+
+func (db *Database) GetStat() *DBStat {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	stat := &DBStat{}
+	stat.DBName = db.Name
+	stat.UpdateSeq = db.UpdateSeq
+	stat.DocCount = db.DocCount
+	stat.DeletedDocCount = db.DeletedDocCount
+	stat.Storage = db.Storage().Name()
+	return stat
+}
+
+func (db *Database) Vacuum(ctx context.Context) error {
+	if err := db.writer.Vacuum(ctx); err != nil {
+		return err
+	}
+	size := db.GetSize()
+	db.mux.Lock()
+	db.size = size
+	db.mux.Unlock()
+	return nil
+}
+
+func (db *Database) SelectView(ctx context.Context, ddocID, viewName, selectName string, values url.Values, stale bool) ([]byte, error) {
+	inputDoc := &Document{ID: ddocID}
+	outputDoc, err := db.GetDocument(ctx, inputDoc, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return db.viewManager.SelectView(ctx, db.UpdateSeq, outputDoc, viewName, selectName, values, stale)
+}
+
+// BuildViews opens and builds every view of the design documents, views are
+// otherwise built on first use.
+func (db *Database) BuildViews(ctx context.Context) error {
+	ddocs, err := db.GetAllDesignDocuments()
+	if err != nil {
+		return err
+	}
+	for _, doc := range ddocs {
+		ddoc := &DesignDocument{}
+		if err := json.Unmarshal(doc.Data, ddoc); err != nil {
+			return err
+		}
+		for viewName := range ddoc.Views {
+			if err := db.viewManager.OpenView(viewName, ddoc); err != nil {
+				return err
+			}
+			view, ok := db.viewManager.GetView(ddoc.ID + "$" + viewName)
+			if !ok {
+				continue
+			}
+			if err := view.Build(ctx, db.UpdateSeq); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// GetDatabaseURI resolves other databases used as view sources.
+func (db *Database) GetDatabaseURI(name string) (string, error) {
+	if db.databaseLocator == nil {
+		return "", ErrDBNotFound
+	}
+	return db.databaseLocator.GetDatabaseURI(name)
+}
+
+// authorizeSource checks the user of ctx can read a database a view uses as
+// source, views of the engine itself have no user.
+func (db *Database) authorizeSource(ctx context.Context, name string) error {
+	user := UserFromContext(ctx)
+	if user == nil {
+		return nil
+	}
+	if db.databaseLocator == nil {
+		return fmt.Errorf("%s: %w", fmt.Sprintf("source database %s not found", name), ErrViewInvalidSource)
+	}
+	access, err := db.databaseLocator.DatabaseAccess(name, user)
+	if errors.Is(err, ErrDBNotFound) {
+		return fmt.Errorf("%s: %w", fmt.Sprintf("source database %s not found", name), ErrViewInvalidSource)
+	}
+	if err != nil {
+		return err
+	}
+	if access < AccessReader {
+		return fmt.Errorf("%s: %w", fmt.Sprintf("can't read source database %s", name), ErrForbidden)
+	}
+	return nil
+}
+
+// Storage returns where the database lives, file storage unless it was
+// created with another one.
+func (db *Database) Storage() Storage {
+	if db.storage == nil {
+		return NewFileStorage(new(DefaultFileHandler))
+	}
+	return db.storage
+}
+
+func (db *Database) ValidateDesignDocument(ctx context.Context, doc *Document) error {
+	return db.viewManager.ValidateDesignDocument(ctx, doc)
+}
+
+func (db *Database) TestDesignDocument(ctx context.Context, doc *Document, docs []*Document, values url.Values) (*DesignDocumentTestResult, error) {
+	return db.viewManager.TestDesignDocument(ctx, doc, docs, values)
+}
+
+func NewDatabase(name, fileName, dbPath, defaultViewPath string, createIfNotExists bool, serviceLocator ServiceLocator) (*Database, error) {
+	storage, _ := serviceLocator.GetStorage(StorageFile)
+	return NewDatabaseWithStorage(name, fileName, dbPath, defaultViewPath, createIfNotExists, serviceLocator, storage)
+}
+
+func NewDatabaseWithStorage(name, fileName, dbPath, defaultViewPath string, createIfNotExists bool, serviceLocator ServiceLocator, storage Storage) (*Database, error) {
+	path := filepath.Join(dbPath, fileName+dbExt)
+	if !storage.Exists(path) {
+		if !createIfNotExists {
+			return nil, ErrDBNotFound
+		}
+		if err := storage.Create(path); err != nil {
+			return nil, err
+		}
+	} else {
+		if createIfNotExists {
+			return nil, ErrDBExists
+		}
+	}
+
+	db := &Database{Name: name, DBPath: path, ViewDirPath: defaultViewPath, storage: storage}
+	db.idSeq = NewSequenceUUIDGenarator()
+	connectionString := storage.ConnectionString(db.DBPath, "_journal=WAL&cache=shared&_mutex=no")
+	db.readers = NewDatabaseReaderPool(4, serviceLocator)
+	db.writer = serviceLocator.GetDatabaseWriter()
+	db.viewManager = serviceLocator.GetViewManager()
+
+	err := db.Open(connectionString, createIfNotExists)
+	if err != nil {
+		panic(err)
+	}
+
+	return db, nil
+}
@@ -1,14 +1,16 @@
-package main
+package kdb
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 )
 
 type DatabaseReader interface {
 	Open(connectionString string) error
 	Close() error
-	Begin() error
+	Begin(ctx context.Context) error
 	Commit() error
 
 	GetDocumentRevisionByIDandVersion(ID string, Version int) (*Document, error)
@@ -19,20 +21,27 @@ type DatabaseReader interface {
 
 	GetAllDesignDocuments() ([]*Document, error)
 	GetChanges(since string, limit int) ([]byte, error)
+	GetDocumentsSince(since string, limit int) ([]*Document, string, error)
 
 	GetLastUpdateSequence() string
 	GetDocumentCount() (int, int)
+	GetSize() int64
+
+	GetLocalDocument(ID string) ([]byte, error)
+	GetConflicts(ID string) ([]*Document, error)
+	GetConflict(ID string, Version int, Hash string) (*Document, error)
 }
 
 type DefaultDatabaseReader struct {
 	connectionString string
 	conn             *sql.DB
 	tx               *sql.Tx
+	ctx              context.Context
 }
 
 func (reader *DefaultDatabaseReader) Open(connectionString string) error {
 	reader.connectionString = connectionString
-	con, err := sql.Open("sqlite3", connectionString)
+	con, err := sql.Open(sqlDriver, connectionString)
 	if err != nil {
 		return err
 	}
@@ -40,9 +49,12 @@ func (reader *DefaultDatabaseReader) Open(connectionString string) error {
 	return nil
 }
 
-func (reader *DefaultDatabaseReader) Begin() error {
+// Begin starts a read transaction, its queries are interrupted once ctx is
+// done.
+func (reader *DefaultDatabaseReader) Begin(ctx context.Context) error {
 	var err error
-	reader.tx, err = reader.conn.Begin()
+	reader.tx, err = reader.conn.BeginTx(ctx, nil)
+	reader.ctx = ctx
 	return err
 }
 
@@ -53,8 +65,10 @@ func (reader *DefaultDatabaseReader) Commit() error {
 func (reader *DefaultDatabaseReader) GetDocumentRevisionByIDandVersion(ID string, Version int) (*Document, error) {
 	doc := &Document{}
 
-	row := reader.tx.QueryRow("SELECT doc_id, version, ifnull(kind, '') as kind, deleted FROM documents INDEXED BY idx_metadata WHERE doc_id = ? AND version = ? LIMIT 1", ID, Version)
-	err := row.Scan(&doc.ID, &doc.Version, &doc.Kind, &doc.Deleted)
+	row := reader.tx.QueryRowContext(reader.ctx, "SELECT doc_id, version, ifnull(kind, '') as kind, deleted, ifnull(hash, ''), history FROM documents WHERE doc_id = ? AND version = ? LIMIT 1", ID, Version)
+	var history sql.NullString
+	err := row.Scan(&doc.ID, &doc.Version, &doc.Kind, &doc.Deleted, &doc.Hash, &history)
+	doc.History = parseHistory(history)
 	if err != nil && err.Error() != "sql: no rows in result set" {
 		return nil, err
 	}
@@ -73,8 +87,10 @@ func (reader *DefaultDatabaseReader) GetDocumentRevisionByIDandVersion(ID string
 func (reader *DefaultDatabaseReader) GetDocumentRevisionByID(ID string) (*Document, error) {
 	doc := &Document{}
 
-	row := reader.tx.QueryRow("SELECT doc_id, version, ifnull(kind, '') as kind, deleted FROM documents INDEXED BY idx_metadata WHERE doc_id = ?", ID)
-	err := row.Scan(&doc.ID, &doc.Version, &doc.Kind, &doc.Deleted)
+	row := reader.tx.QueryRowContext(reader.ctx, "SELECT doc_id, version, ifnull(kind, '') as kind, deleted, ifnull(hash, ''), history FROM documents WHERE doc_id = ?", ID)
+	var history sql.NullString
+	err := row.Scan(&doc.ID, &doc.Version, &doc.Kind, &doc.Deleted, &doc.Hash, &history)
+	doc.History = parseHistory(history)
 	if err != nil && err.Error() != "sql: no rows in result set" {
 		return nil, err
 	}
@@ -93,8 +109,10 @@ func (reader *DefaultDatabaseReader) GetDocumentRevisionByID(ID string) (*Docume
 func (reader *DefaultDatabaseReader) GetDocumentByID(ID string) (*Document, error) {
 	doc := &Document{}
 
-	row := reader.tx.QueryRow("SELECT doc_id, version, ifnull(kind, '') as kind, deleted, data as data FROM documents WHERE doc_id = ?", ID, ID)
-	err := row.Scan(&doc.ID, &doc.Version, &doc.Kind, &doc.Deleted, &doc.Data)
+	row := reader.tx.QueryRowContext(reader.ctx, "SELECT doc_id, version, ifnull(kind, '') as kind, deleted, data as data, ifnull(hash, ''), history FROM documents WHERE doc_id = ?", ID)
+	var history sql.NullString
+	err := row.Scan(&doc.ID, &doc.Version, &doc.Kind, &doc.Deleted, &doc.Data, &doc.Hash, &history)
+	doc.History = parseHistory(history)
 	if err != nil && err.Error() != "sql: no rows in result set" {
 		return nil, err
 	}
@@ -127,8 +145,10 @@ func (reader *DefaultDatabaseReader) GetDocumentByID(ID string) (*Document, erro
 func (reader *DefaultDatabaseReader) GetDocumentByIDandVersion(ID string, Version int) (*Document, error) {
 	doc := &Document{}
 
-	row := reader.tx.QueryRow("SELECT doc_id, version, ifnull(kind, '') as kind, deleted, data FROM documents WHERE doc_id = ? AND version = ?", ID, Version)
-	err := row.Scan(&doc.ID, &doc.Version, &doc.Kind, &doc.Deleted, &doc.Data)
+	row := reader.tx.QueryRowContext(reader.ctx, "SELECT doc_id, version, ifnull(kind, '') as kind, deleted, data, ifnull(hash, ''), history FROM documents WHERE doc_id = ? AND version = ?", ID, Version)
+	var history sql.NullString
+	err := row.Scan(&doc.ID, &doc.Version, &doc.Kind, &doc.Deleted, &doc.Data, &doc.Hash, &history)
+	doc.History = parseHistory(history)
 	if err != nil && err.Error() != "sql: no rows in result set" {
 		return nil, err
 	}
@@ -160,7 +180,7 @@ func (reader *DefaultDatabaseReader) GetDocumentByIDandVersion(ID string, Versio
 func (reader *DefaultDatabaseReader) GetAllDesignDocuments() ([]*Document, error) {
 
 	var docs []*Document
-	rows, err := reader.tx.Query("SELECT doc_id FROM documents WHERE doc_id like '_design/%' AND deleted != 1")
+	rows, err := reader.tx.QueryContext(reader.ctx, "SELECT doc_id FROM documents WHERE doc_id like '_design/%' AND deleted != 1")
 	if err != nil {
 		return nil, err
 	}
@@ -190,16 +210,20 @@ func (db *DefaultDatabaseReader) GetChanges(since string, limit int) ([]byte, er
 	(
 		SELECT doc_id FROM documents INDEXED BY idx_changes WHERE (? IS NULL OR seq_id > ?) ORDER by seq_id ASC LIMIT ?
 	),
-	all_changes_metadata (seq, doc_id, version, deleted) AS 
+	all_changes_metadata (seq, doc_id, version, deleted, hash) AS 
+	(
+		SELECT d.seq_id, d.doc_id, d.version, d.deleted, d.hash FROM documents d INDEXED BY idx_metadata JOIN all_changes c USING (doc_id) ORDER BY d.seq_id DESC
+	),
+	changes_object (obj, hash) as
 	(
-		SELECT d.seq_id, d.doc_id, d.version, d.deleted FROM documents d INDEXED BY idx_metadata JOIN all_changes c USING (doc_id) ORDER BY d.seq_id DESC
+		SELECT (CASE WHEN deleted != 1 THEN JSON_OBJECT('seq', seq, 'version', version, 'id', doc_id) ELSE JSON_OBJECT('seq', seq, 'version', version, 'id', doc_id, 'deleted', JSON('true'))  END) as obj, hash FROM all_changes_metadata
 	),
-	changes_object (obj) as
+	changes_with_hash (obj) as
 	(
-		SELECT (CASE WHEN deleted != 1 THEN JSON_OBJECT('seq', seq, 'version', version, 'id', doc_id) ELSE JSON_OBJECT('seq', seq, 'version', version, 'id', doc_id, 'deleted', JSON('true'))  END) as obj FROM all_changes_metadata
+		SELECT (CASE WHEN hash IS NULL THEN obj ELSE JSON_SET(obj, '$.hash', hash) END) FROM changes_object
 	)
-	SELECT JSON_OBJECT('results',JSON_GROUP_ARRAY(obj)) FROM changes_object`
-	row := db.tx.QueryRow(sqlGetChanges, since, since, limit)
+	SELECT JSON_OBJECT('results',JSON_GROUP_ARRAY(obj)) FROM changes_with_hash`
+	row := db.tx.QueryRowContext(db.ctx, sqlGetChanges, since, since, limit)
 	var (
 		changes []byte
 	)
@@ -212,10 +236,33 @@ func (db *DefaultDatabaseReader) GetChanges(since string, limit int) ([]byte, er
 	return changes, nil
 }
 
+// GetDocumentsSince returns up to limit documents changed after since, in
+// the order they changed, with their data without meta fields. It returns
+// the seq of the last one too.
+func (reader *DefaultDatabaseReader) GetDocumentsSince(since string, limit int) ([]*Document, string, error) {
+	rows, err := reader.tx.QueryContext(reader.ctx, "SELECT doc_id, version, ifnull(kind, ''), deleted, ifnull(data, '{}'), ifnull(hash, ''), history, seq_id FROM documents INDEXED BY idx_seq WHERE seq_id > ? ORDER BY seq_id LIMIT ?", since, limit)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var docs []*Document
+	for rows.Next() {
+		doc := &Document{}
+		var history sql.NullString
+		if err := rows.Scan(&doc.ID, &doc.Version, &doc.Kind, &doc.Deleted, &doc.Data, &doc.Hash, &history, &since); err != nil {
+			return nil, "", err
+		}
+		doc.History = parseHistory(history)
+		docs = append(docs, doc)
+	}
+	return docs, since, rows.Err()
+}
+
 func (db *DefaultDatabaseReader) GetLastUpdateSequence() string {
 	var maxUpdateSeq string
 	sqlGetMaxSeq := "SELECT IFNULL(seq_id, '') FROM (SELECT MAX(seq_id) as seq_id FROM documents INDEXED BY idx_changes)"
-	row := db.tx.QueryRow(sqlGetMaxSeq)
+	row := db.tx.QueryRowContext(db.ctx, sqlGetMaxSeq)
 	err := row.Scan(&maxUpdateSeq)
 	if err != nil && err.Error() != "sql: no rows in result set" {
 		panic(err)
@@ -224,7 +271,7 @@ func (db *DefaultDatabaseReader) GetLastUpdateSequence() string {
 }
 
 func (db *DefaultDatabaseReader) GetDocumentCount() (int, int) {
-	rows, _ := db.tx.Query("SELECT deleted, COUNT(1) as count FROM documents GROUP BY deleted")
+	rows, _ := db.tx.QueryContext(db.ctx, "SELECT deleted, COUNT(1) as count FROM documents GROUP BY deleted")
 	deleted, count, docCount, deletedDocCount := 0, 0, 0, 0
 	for rows.Next() {
 		rows.Scan(&deleted, &count)
@@ -237,6 +284,79 @@ func (db *DefaultDatabaseReader) GetDocumentCount() (int, int) {
 	return docCount, deletedDocCount
 }
 
+// GetSize returns the size of the database in bytes, free pages included.
+func (db *DefaultDatabaseReader) GetSize() int64 {
+	var pageCount, pageSize int64
+	db.tx.QueryRowContext(db.ctx, "PRAGMA page_count").Scan(&pageCount)
+	db.tx.QueryRowContext(db.ctx, "PRAGMA page_size").Scan(&pageSize)
+	return pageCount * pageSize
+}
+
+// GetLocalDocument reads a local document, local documents aren't versioned
+// and don't show up in changes or views.
+func (reader *DefaultDatabaseReader) GetLocalDocument(ID string) ([]byte, error) {
+	var data []byte
+	row := reader.tx.QueryRowContext(reader.ctx, "SELECT data FROM local_documents WHERE doc_id = ?", ID)
+	if err := row.Scan(&data); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrDocNotFound
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+// GetConflicts returns the losing versions of a document, without their
+// data.
+func (reader *DefaultDatabaseReader) GetConflicts(ID string) ([]*Document, error) {
+	rows, err := reader.tx.QueryContext(reader.ctx, "SELECT version, hash, deleted FROM conflicts WHERE doc_id = ? ORDER BY version DESC, hash DESC", ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var docs []*Document
+	for rows.Next() {
+		doc := &Document{ID: ID}
+		if err := rows.Scan(&doc.Version, &doc.Hash, &doc.Deleted); err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	return docs, rows.Err()
+}
+
+// GetConflict reads a losing version of a document, with the meta fields in
+// its data.
+func (reader *DefaultDatabaseReader) GetConflict(ID string, Version int, Hash string) (*Document, error) {
+	doc := &Document{ID: ID, Version: Version, Hash: Hash}
+	var data []byte
+	row := reader.tx.QueryRowContext(reader.ctx, "SELECT deleted, data FROM conflicts WHERE doc_id = ? AND version = ? AND hash = ?", ID, Version, Hash)
+	if err := row.Scan(&doc.Deleted, &data); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrDocNotFound
+		}
+		return nil, err
+	}
+	meta := FormatDocString(ID, Version, doc.Deleted)
+	meta = fmt.Sprintf(`%s,"_hash":"%s"}`, meta[:len(meta)-1], Hash)
+	if len(data) <= 2 {
+		doc.Data = []byte(meta)
+	} else {
+		doc.Data = append([]byte(meta[:len(meta)-1]+","), data[1:]...)
+	}
+	return doc, nil
+}
+
+func parseHistory(history sql.NullString) []string {
+	if !history.Valid {
+		return nil
+	}
+	var hashes []string
+	json.Unmarshal([]byte(history.String), &hashes)
+	return hashes
+}
+
 func (reader *DefaultDatabaseReader) Close() error {
 	return reader.conn.Close()
 }
@@ -1,6 +1,7 @@
-package main
+package kdb
 
 import (
+	"context"
 	"os"
 	"sync"
 	"sync/atomic"
@@ -18,7 +19,7 @@ func setupTestDatabaseWithWriter() error {
 		return err
 	}
 
-	err = writer.Begin()
+	err = writer.Begin(context.Background())
 	if err != nil {
 		return err
 	}
@@ -68,7 +69,7 @@ func TestReaderGetDocumentByID(t *testing.T) {
 	var reader DatabaseReader = serviceLocator.GetDatabaseReader()
 	reader.Open(testConnectionString)
 
-	reader.Begin()
+	reader.Begin(context.Background())
 
 	if _, err := reader.GetDocumentByID("1"); err != nil {
 		t.Errorf("unexpected error %s", err.Error())
@@ -90,7 +91,7 @@ func TestReaderGetDocumentRevisionByID(t *testing.T) {
 	var reader DatabaseReader = serviceLocator.GetDatabaseReader()
 	reader.Open(testConnectionString)
 
-	reader.Begin()
+	reader.Begin(context.Background())
 
 	if _, err := reader.GetDocumentRevisionByID("1"); err != nil {
 		t.Errorf("unexpected error %s", err.Error())
@@ -112,7 +113,7 @@ func TestReaderGetDocumentByIDandVersion(t *testing.T) {
 	var reader DatabaseReader = serviceLocator.GetDatabaseReader()
 	reader.Open(testConnectionString)
 
-	reader.Begin()
+	reader.Begin(context.Background())
 
 	if _, err := reader.GetDocumentByIDandVersion("1", 1); err != nil {
 		t.Errorf("unexpected error %s", err.Error())
@@ -134,7 +135,7 @@ func TestReaderGetDocumentRevisionByIDandVersion(t *testing.T) {
 	var reader DatabaseReader = serviceLocator.GetDatabaseReader()
 	reader.Open(testConnectionString)
 
-	reader.Begin()
+	reader.Begin(context.Background())
 
 	if _, err := reader.GetDocumentRevisionByIDandVersion("1", 1); err != nil {
 		t.Errorf("unexpected error %s", err.Error())
@@ -156,7 +157,7 @@ func TestReaderGetDocumentCount(t *testing.T) {
 	var reader DatabaseReader = serviceLocator.GetDatabaseReader()
 	reader.Open(testConnectionString)
 
-	reader.Begin()
+	reader.Begin(context.Background())
 
 	docCount, deletedDocCount := reader.GetDocumentCount()
 	if docCount != 2 && deletedDocCount != 1 {
@@ -179,7 +180,7 @@ func TestReaderGetLastUpdateSequence(t *testing.T) {
 	var reader DatabaseReader = serviceLocator.GetDatabaseReader()
 	reader.Open(testConnectionString)
 
-	reader.Begin()
+	reader.Begin(context.Background())
 
 	seqID := reader.GetLastUpdateSequence()
 	if seqID != "seqID4" {
@@ -202,7 +203,7 @@ func TestReaderGetChanges(t *testing.T) {
 	var reader DatabaseReader = serviceLocator.GetDatabaseReader()
 	reader.Open(testConnectionString)
 
-	reader.Begin()
+	reader.Begin(context.Background())
 	expected := `{"results":[{"seq":"seqID4","version":1,"id":"_design/_views"},{"seq":"seqID3","version":2,"id":"2","deleted":true},{"seq":"seqID1","version":1,"id":"1"}]}`
 	changes, _ := reader.GetChanges("", 999)
 	if string(changes) != expected {
@@ -224,7 +225,7 @@ func TestReaderGetAllDesignDocuments(t *testing.T) {
 	var reader DatabaseReader = serviceLocator.GetDatabaseReader()
 	reader.Open(testConnectionString)
 
-	reader.Begin()
+	reader.Begin(context.Background())
 
 	docs, err := reader.GetAllDesignDocuments()
 	if err != nil {
@@ -1,10 +1,12 @@
-package main
+package kdb
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/url"
 	"testing"
+	"time"
 )
 
 type FakeDatabaseReaderPool struct {
@@ -49,7 +51,7 @@ func (reader *FakeDatabaseReader) Open(connectionString string) error {
 	return nil
 }
 
-func (reader *FakeDatabaseReader) Begin() error {
+func (reader *FakeDatabaseReader) Begin(ctx context.Context) error {
 	reader.begin = true
 	return nil
 }
@@ -82,7 +84,7 @@ func (writer *FakeDatabaseWriter) Close() error {
 	return nil
 }
 
-func (writer *FakeDatabaseWriter) Begin() error {
+func (writer *FakeDatabaseWriter) Begin(ctx context.Context) error {
 	if writer.beginerr {
 		return ErrInternalError
 	}
@@ -117,10 +119,14 @@ func (writer *FakeDatabaseWriter) ExecBuildScript() error {
 	return nil
 }
 
-func (writer *FakeDatabaseWriter) Vacuum() error {
+func (writer *FakeDatabaseWriter) Vacuum(ctx context.Context) error {
 	return nil
 }
 
+func (writer *FakeDatabaseWriter) GetSize() int64 {
+	return 0
+}
+
 func (writer *FakeDatabaseWriter) GetDocumentRevisionByID(docID string) (*Document, error) {
 	if writer.getdocerror {
 		return nil, ErrInternalError
@@ -144,6 +150,29 @@ func (writer *FakeDatabaseWriter) PutDocument(updateSeqID string, newDoc *Docume
 	return nil
 }
 
+func (writer *FakeDatabaseWriter) PutLocalDocument(ID string, data []byte) error {
+	if writer.putdocerror {
+		return ErrInternalError
+	}
+	return nil
+}
+
+func (writer *FakeDatabaseWriter) GetConflicts(docID string) ([]*Document, error) {
+	return nil, nil
+}
+
+func (writer *FakeDatabaseWriter) PutConflict(doc *Document) error {
+	return nil
+}
+
+func (writer *FakeDatabaseWriter) PutCurrentAsConflict(docID string) error {
+	return nil
+}
+
+func (writer *FakeDatabaseWriter) DeleteConflict(docID string, version int, hash string) (bool, error) {
+	return false, nil
+}
+
 func (reader *FakeDatabaseReader) GetDocumentRevisionByIDandVersion(ID string, Version int) (*Document, error) {
 	return ParseDocument([]byte(`{"_id":2, "_version" :1}`))
 }
@@ -176,6 +205,26 @@ func (db *FakeDatabaseReader) GetDocumentCount() (int, int) {
 	return 3, 0
 }
 
+func (db *FakeDatabaseReader) GetSize() int64 {
+	return 4096
+}
+
+func (reader *FakeDatabaseReader) GetLocalDocument(ID string) ([]byte, error) {
+	return nil, ErrDocNotFound
+}
+
+func (reader *FakeDatabaseReader) GetDocumentsSince(since string, limit int) ([]*Document, string, error) {
+	return nil, since, nil
+}
+
+func (reader *FakeDatabaseReader) GetConflicts(ID string) ([]*Document, error) {
+	return nil, nil
+}
+
+func (reader *FakeDatabaseReader) GetConflict(ID string, Version int, Hash string) (*Document, error) {
+	return nil, ErrDocNotFound
+}
+
 func (reader *FakeDatabaseReader) Close() error {
 	return nil
 }
@@ -203,7 +252,7 @@ func (sl *FakeViewManager) GetView(viewName string) (*View, bool) {
 	return nil, false
 }
 
-func (sl *FakeViewManager) SelectView(updateSeqID string, doc *Document, viewName, selectName string, values url.Values, stale bool) ([]byte, error) {
+func (sl *FakeViewManager) SelectView(ctx context.Context, updateSeqID string, doc *Document, viewName, selectName string, values url.Values, stale bool) ([]byte, error) {
 	return nil, nil
 }
 
@@ -219,7 +268,7 @@ func (sl *FakeViewManager) UpdateDesignDocument(doc *Document) error {
 	return nil
 }
 
-func (sl *FakeViewManager) ValidateDesignDocument(doc *Document) error {
+func (sl *FakeViewManager) ValidateDesignDocument(ctx context.Context, doc *Document) error {
 	return nil
 }
 
@@ -227,10 +276,21 @@ func (sl *FakeViewManager) CalculateSignature(ddocv *DesignDocumentView) string
 	return ""
 }
 
-func (sl *FakeViewManager) ParseQueryParams(query string) (string, []string) {
+func (sl *FakeViewManager) ParseQueryParams(query string) (string, []QueryParam) {
 	return "", nil
 }
 
+func (sl *FakeViewManager) TestDesignDocument(ctx context.Context, doc *Document, docs []*Document, values url.Values) (*DesignDocumentTestResult, error) {
+	return nil, nil
+}
+
+func (sl *FakeViewManager) ViewAccessTimes() map[string]time.Time {
+	return nil
+}
+
+func (sl *FakeViewManager) CloseView(qualifiedViewName string) {
+}
+
 type FakeFileHandler struct {
 }
 
@@ -267,11 +327,27 @@ func (sl *FakeServiceLocator) GetViewManager() ViewManager {
 	return &FakeViewManager{}
 }
 
-func (sl *FakeServiceLocator) GetView(viewName, connectionString, absoluteDatabasePath string, ddoc *DesignDocument, viewManager ViewManager) *View {
+func (sl *FakeServiceLocator) GetView(viewName, connectionString, databaseURI string, sourceURIs, viewURIs map[string]string, decrypt func(interface{}) (string, error), ddoc *DesignDocument, viewManager ViewManager) *View {
+	return nil
+}
+
+func (sl *FakeServiceLocator) GetViewReader(connectionString, databaseURI string, sourceURIs, viewURIs map[string]string, decrypt func(interface{}) (string, error), selectScripts map[string]Query) ViewReader {
+	return nil
+}
+
+func (sl *FakeServiceLocator) GetExternalViewCommand(server string) ([]string, bool) {
+	return nil, false
+}
+
+func (sl *FakeServiceLocator) GetStorage(name string) (Storage, bool) {
+	return NewFileStorage(&FakeFileHandler{}), name == StorageFile
+}
+
+func (sl *FakeServiceLocator) GetAuditLog() AuditLog {
 	return nil
 }
 
-func (sl *FakeServiceLocator) GetViewReader(connectionString, absoluteDatabasePath string, selectScripts map[string]Query) ViewReader {
+func (sl *FakeServiceLocator) GetKeyProvider() KeyProvider {
 	return nil
 }
 
@@ -337,7 +413,7 @@ func TestDBGetChanges(t *testing.T) {
 	reader := new(FakeDatabaseReader)
 	pool := NewTestFakeDatabaseReaderPool(reader)
 	db.readers = pool
-	_, _ = db.GetChanges("", 0)
+	_, _ = db.GetChanges(context.Background(), "", 0)
 
 	if !reader.begin || !reader.commit {
 		t.Errorf("expected to call begin and commit, failed.")
@@ -402,7 +478,7 @@ func TestDBGetDocumentRevisionByID(t *testing.T) {
 	db.readers = pool
 
 	doc, _ := ParseDocument([]byte(`{"_id":1}`))
-	odoc, err := db.GetDocument(doc, false)
+	odoc, err := db.GetDocument(context.Background(), doc, false)
 	if err != nil {
 		t.Errorf("unexpected error %s", err.Error())
 	}
@@ -435,7 +511,7 @@ func TestDBGetDocumentRevisionByIDandVersion(t *testing.T) {
 	db.readers = pool
 
 	doc, _ := ParseDocument([]byte(`{"_id":2, "_version":1}`))
-	odoc, err := db.GetDocument(doc, false)
+	odoc, err := db.GetDocument(context.Background(), doc, false)
 	if err != nil {
 		t.Errorf("unexpected error %s", err.Error())
 	}
@@ -468,7 +544,7 @@ func TestDBGetDocumentByID(t *testing.T) {
 	db.readers = pool
 
 	doc, _ := ParseDocument([]byte(`{"_id":3}`))
-	odoc, err := db.GetDocument(doc, true)
+	odoc, err := db.GetDocument(context.Background(), doc, true)
 	if err != nil {
 		t.Errorf("unexpected error %s", err.Error())
 	}
@@ -501,7 +577,7 @@ func TestDBGetDocumentByIDandVersion(t *testing.T) {
 	db.readers = pool
 
 	doc, _ := ParseDocument([]byte(`{"_id":4, "_version":1}`))
-	odoc, err := db.GetDocument(doc, true)
+	odoc, err := db.GetDocument(context.Background(), doc, true)
 	if err != nil {
 		t.Errorf("unexpected error %s", err.Error())
 	}
@@ -541,7 +617,7 @@ func TestDBPutDocumentNewDocID(t *testing.T) {
 	db.Open(testConnectionString, false)
 
 	doc, _ := ParseDocument([]byte(`{}`))
-	odoc, err := db.PutDocument(doc)
+	odoc, err := db.PutDocument(context.Background(), doc)
 	if err != nil {
 		t.Errorf("unable put document")
 	}
@@ -573,7 +649,7 @@ func TestDBPutDocumentNewDocWithID(t *testing.T) {
 	db.Open(testConnectionString, false)
 
 	doc, _ := ParseDocument([]byte(`{"_id": "4"}`))
-	odoc, err := db.PutDocument(doc)
+	odoc, err := db.PutDocument(context.Background(), doc)
 	if err != nil {
 		t.Errorf("unable put document")
 	}
@@ -606,7 +682,7 @@ func TestDBPutDocumentConflict(t *testing.T) {
 	writer.Reset()
 
 	doc, _ := ParseDocument([]byte(`{"_id":1}`))
-	odoc, err := db.PutDocument(doc)
+	odoc, err := db.PutDocument(context.Background(), doc)
 	if err == nil {
 		t.Errorf("expected fail put document. %w", err)
 	}
@@ -644,7 +720,7 @@ func Test1DBPutDocumentConflict(t *testing.T) {
 	writer.Reset()
 
 	doc, _ := ParseDocument([]byte(`{"_id":1, "_version":2}`))
-	odoc, err := db.PutDocument(doc)
+	odoc, err := db.PutDocument(context.Background(), doc)
 	if err == nil {
 		t.Errorf("expected fail put document. ")
 	}
@@ -680,7 +756,7 @@ func TestDBPutDocumentUpdateDoc(t *testing.T) {
 	db.Open(testConnectionString, false)
 
 	doc, _ := ParseDocument([]byte(`{"_id": "1", "_version":1}`))
-	odoc, err := db.PutDocument(doc)
+	odoc, err := db.PutDocument(context.Background(), doc)
 	if err != nil {
 		t.Errorf("unable put document")
 	}
@@ -712,7 +788,7 @@ func TestDBPutDocumentUpdateDocNoDocExists(t *testing.T) {
 	db.Open(testConnectionString, false)
 
 	doc, _ := ParseDocument([]byte(`{"_id": "151", "_version":4}`))
-	_, err := db.PutDocument(doc)
+	_, err := db.PutDocument(context.Background(), doc)
 	if err != nil {
 		t.Errorf("unexpected err %s", ErrDocConflict)
 	}
@@ -741,7 +817,7 @@ func TestDBPutDocumentBeginError(t *testing.T) {
 	db.Open(testConnectionString, false)
 	writer.Reset()
 	doc, _ := ParseDocument([]byte(`{"_id": "12"}`))
-	odoc, err := db.PutDocument(doc)
+	odoc, err := db.PutDocument(context.Background(), doc)
 	if err == nil {
 		t.Errorf("unable put document")
 	}
@@ -778,7 +854,7 @@ func TestDBPutDocumentCommitError(t *testing.T) {
 	db.Open(testConnectionString, false)
 
 	doc, _ := ParseDocument([]byte(`{"_id": "12"}`))
-	odoc, err := db.PutDocument(doc)
+	odoc, err := db.PutDocument(context.Background(), doc)
 	if err == nil {
 		t.Errorf("unable put document")
 	}
@@ -815,7 +891,7 @@ func TestDBPutDocumentRollbackError(t *testing.T) {
 	db.Open(testConnectionString, false)
 
 	doc, _ := ParseDocument([]byte(`{"_id": "12"}`))
-	_, _ = db.PutDocument(doc)
+	_, _ = db.PutDocument(context.Background(), doc)
 
 	if !writer.begin || !writer.commit || writer.rollback {
 		t.Errorf("expected to call begin and commit, failed.")
@@ -837,7 +913,7 @@ func TestDBPutDocumentWriterPutDocumentError(t *testing.T) {
 	db.Open(testConnectionString, false)
 	writer.Reset()
 	doc, _ := ParseDocument([]byte(`{"_id": "12"}`))
-	odoc, err := db.PutDocument(doc)
+	odoc, err := db.PutDocument(context.Background(), doc)
 	if err == nil {
 		t.Errorf("unable put document")
 	}
@@ -874,7 +950,7 @@ func TestDBPutDocumentWriterGetDocumentError(t *testing.T) {
 	db.Open(testConnectionString, false)
 	writer.Reset()
 	doc, _ := ParseDocument([]byte(`{"_id": "12"}`))
-	odoc, err := db.PutDocument(doc)
+	odoc, err := db.PutDocument(context.Background(), doc)
 	if err == nil {
 		t.Errorf("expected fail put document. ")
 	}
@@ -910,7 +986,7 @@ func TestDBPutDocumentUpdateDeletedDoc(t *testing.T) {
 	db.Open(testConnectionString, false)
 
 	doc, _ := ParseDocument([]byte(`{"_id": "2","_version":2}`))
-	odoc, err := db.PutDocument(doc)
+	odoc, err := db.PutDocument(context.Background(), doc)
 	if err != nil {
 		t.Errorf("unable put document")
 	}
@@ -928,7 +1004,7 @@ func TestDBPutDocumentUpdateDeletedDoc(t *testing.T) {
 	}
 
 	doc, _ = ParseDocument([]byte(`{"_id": "2", "_version": 1}`))
-	odoc, err = db.PutDocument(doc)
+	odoc, err = db.PutDocument(context.Background(), doc)
 	if err == nil {
 		t.Errorf("expected to fail, when you update deleted doc with old verison")
 	}
@@ -948,7 +1024,7 @@ func TestDBDeleteDocument(t *testing.T) {
 	db.Open(testConnectionString, false)
 
 	doc, _ := ParseDocument([]byte(`{"_id": "1", "_version":1}`))
-	odoc, err := db.DeleteDocument(doc)
+	odoc, err := db.DeleteDocument(context.Background(), doc)
 	if err != nil {
 		t.Errorf("unable delete document")
 	}
@@ -1028,7 +1104,7 @@ func TestDatabaseValidateDesignDocument(t *testing.T) {
 		t.Errorf("unexpected err %s, failed", err)
 	}
 
-	err = db.ValidateDesignDocument(designDoc)
+	err = db.ValidateDesignDocument(context.Background(), designDoc)
 }
 
 func TestDatabaseVacuum(t *testing.T) {
@@ -1036,7 +1112,7 @@ func TestDatabaseVacuum(t *testing.T) {
 	if err != nil {
 		t.Errorf("unexpected err %s, failed", err)
 	}
-	err = db.Vacuum()
+	err = db.Vacuum(context.Background())
 }
 
 func TestDatabaseReOpen(t *testing.T) {
@@ -1047,24 +1123,24 @@ func TestDatabaseReOpen(t *testing.T) {
 
 	//db.Open(false)
 	doc, err := ParseDocument([]byte(`{"_id":1}`))
-	doc, err = db.GetDocument(doc, false)
+	doc, err = db.GetDocument(context.Background(), doc, false)
 	db.Close()
 
 	db.Open(testConnectionString, false)
 	doc, err = ParseDocument([]byte(`{"_id":1}`))
-	doc, err = db.GetDocument(doc, false)
+	doc, err = db.GetDocument(context.Background(), doc, false)
 	db.Close()
 
 }
 
 func TestDatabaseSelectView(t *testing.T) {
-	deleteDBFiles("./data/dbs", "./data/mrviews", "testdb1")
+	deleteDBFiles(NewFileStorage(new(DefaultFileHandler)), "./data/dbs", "./data/mrviews", "testdb1")
 	sl := NewServiceLocator()
 	db, err := NewDatabase("testdb1", "testdb1", "./data/dbs", "./data/mrviews", true, sl)
 	if err != nil {
 		t.Errorf("unexpected err %s, failed", err)
 	}
-	data, err := db.SelectView("_design/_views", "_all_docs", "default", nil, false)
+	data, err := db.SelectView(context.Background(), "_design/_views", "_all_docs", "default", nil, false)
 	output := `{"offset":0,"rows":[{"key":"_design/_views","value":{"version":1},"id":"_design/_views"}],"total_rows":1}`
 
 	if string(data) != output {
@@ -1074,5 +1150,5 @@ func TestDatabaseSelectView(t *testing.T) {
 
 	db.Close()
 
-	deleteDBFiles("./data/dbs", "./data/mrviews", "testdb1")
+	deleteDBFiles(NewFileStorage(new(DefaultFileHandler)), "./data/dbs", "./data/mrviews", "testdb1")
 }
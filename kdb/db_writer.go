@@ -0,0 +1,199 @@
+package kdb
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+)
+
+type DatabaseWriter interface {
+	Open(connectionString string) error
+	Close() error
+
+	Begin(ctx context.Context) error
+	Commit() error
+	Rollback() error
+
+	ExecBuildScript() error
+	Vacuum(ctx context.Context) error
+	// GetSize returns the size of the database with the writes of the
+	// transaction.
+	GetSize() int64
+
+	GetDocumentRevisionByID(docID string) (*Document, error)
+	PutDocument(updateSeqID string, newDoc *Document, currentDoc *Document) error
+	PutLocalDocument(ID string, data []byte) error
+
+	GetConflicts(docID string) ([]*Document, error)
+	PutConflict(doc *Document) error
+	PutCurrentAsConflict(docID string) error
+	DeleteConflict(docID string, version int, hash string) (bool, error)
+}
+
+type DefaultDatabaseWriter struct {
+	connectionString string
+	reader           *DefaultDatabaseReader
+	conn             *sql.DB
+	tx               *sql.Tx
+	ctx              context.Context
+}
+
+func (writer *DefaultDatabaseWriter) Open(connectionString string) error {
+	writer.connectionString = connectionString
+	con, err := sql.Open("sqlite3", connectionString)
+	if err != nil {
+		return err
+	}
+	writer.conn = con
+	writer.reader.conn = con
+	return nil
+}
+
+func (writer *DefaultDatabaseWriter) Close() error {
+	err := writer.conn.Close()
+	return err
+}
+
+// Begin starts a write transaction, it's rolled back once ctx is done.
+func (writer *DefaultDatabaseWriter) Begin(ctx context.Context) error {
+	var err error
+	writer.tx, err = writer.conn.BeginTx(ctx, nil)
+	writer.ctx = ctx
+	writer.reader.tx = writer.tx
+	writer.reader.ctx = ctx
+	return err
+}
+
+func (writer *DefaultDatabaseWriter) Commit() error {
+	return writer.tx.Commit()
+}
+
+func (writer *DefaultDatabaseWriter) Rollback() error {
+	return writer.tx.Rollback()
+}
+
+func (writer *DefaultDatabaseWriter) ExecBuildScript() error {
+	tx := writer.tx
+
+	buildSQL := `
+		CREATE TABLE IF NOT EXISTS documents (
+			doc_id 		TEXT, 
+			version     INTEGER, 
+			kind	    TEXT,
+			deleted     BOOL,
+			data        TEXT,
+			seq_id 		TEXT,
+			hash        TEXT,
+			history     TEXT,
+			PRIMARY KEY (doc_id)
+		) WITHOUT ROWID;
+		
+		CREATE INDEX IF NOT EXISTS idx_metadata ON documents 
+			(doc_id, version, kind, deleted);
+
+		CREATE INDEX IF NOT EXISTS idx_changes ON documents 
+			(doc_id, seq_id, deleted);
+
+		CREATE INDEX IF NOT EXISTS idx_seq ON documents 
+			(seq_id);
+
+		CREATE INDEX IF NOT EXISTS idx_kind ON documents 
+			(doc_id, kind) WHERE kind IS NOT NULL;
+
+		CREATE TABLE IF NOT EXISTS local_documents (
+			doc_id 		TEXT,
+			data        TEXT,
+			PRIMARY KEY (doc_id)
+		) WITHOUT ROWID;
+
+		CREATE TABLE IF NOT EXISTS conflicts (
+			doc_id 		TEXT,
+			version     INTEGER,
+			hash        TEXT,
+			deleted     BOOL,
+			data        TEXT,
+			PRIMARY KEY (doc_id, version, hash)
+		) WITHOUT ROWID;
+		`
+	if _, err := tx.ExecContext(writer.ctx, buildSQL); err != nil {
+		return err
+	}
+
+	// databases created before versions had hashes
+	var hasHash bool
+	row := tx.QueryRowContext(writer.ctx, "SELECT COUNT(1) > 0 FROM pragma_table_info('documents') WHERE name = 'hash'")
+	if err := row.Scan(&hasHash); err != nil {
+		return err
+	}
+	if !hasHash {
+		if _, err := tx.ExecContext(writer.ctx, "ALTER TABLE documents ADD COLUMN hash TEXT; ALTER TABLE documents ADD COLUMN history TEXT;"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (writer *DefaultDatabaseWriter) Vacuum(ctx context.Context) error {
+	_, err := writer.conn.ExecContext(ctx, "VACUUM")
+	return err
+}
+
+func (writer *DefaultDatabaseWriter) GetSize() int64 {
+	return writer.reader.GetSize()
+}
+
+func (writer *DefaultDatabaseWriter) GetDocumentRevisionByID(docID string) (*Document, error) {
+	return writer.reader.GetDocumentRevisionByID(docID)
+}
+
+func (writer *DefaultDatabaseWriter) PutDocument(updateSeqID string, newDoc *Document, currentDoc *Document) error {
+	tx := writer.tx
+	var kind []byte
+	if newDoc.Kind != "" {
+		kind = []byte(newDoc.Kind)
+	}
+	var hash, history sql.NullString
+	if newDoc.Hash != "" {
+		hash = sql.NullString{String: newDoc.Hash, Valid: true}
+	}
+	if len(newDoc.History) > 0 {
+		data, _ := json.Marshal(newDoc.History)
+		history = sql.NullString{String: string(data), Valid: true}
+	}
+	if _, err := tx.ExecContext(writer.ctx, "INSERT OR REPLACE INTO documents (doc_id, version, kind, deleted, seq_id, data, hash, history) VALUES(?, ?, ?, ?, ?, ?, ?, ?)", newDoc.ID, newDoc.Version, kind, newDoc.Deleted, updateSeqID, newDoc.Data, hash, history); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (writer *DefaultDatabaseWriter) PutLocalDocument(ID string, data []byte) error {
+	_, err := writer.tx.ExecContext(writer.ctx, "INSERT OR REPLACE INTO local_documents (doc_id, data) VALUES(?, ?)", ID, data)
+	return err
+}
+
+func (writer *DefaultDatabaseWriter) GetConflicts(docID string) ([]*Document, error) {
+	return writer.reader.GetConflicts(docID)
+}
+
+// PutConflict stores a losing version of a document.
+func (writer *DefaultDatabaseWriter) PutConflict(doc *Document) error {
+	_, err := writer.tx.ExecContext(writer.ctx, "INSERT OR REPLACE INTO conflicts (doc_id, version, hash, deleted, data) VALUES(?, ?, ?, ?, ?)", doc.ID, doc.Version, doc.Hash, doc.Deleted, doc.Data)
+	return err
+}
+
+// PutCurrentAsConflict stores the current version of a document as a losing
+// version, before a winning version replaces it.
+func (writer *DefaultDatabaseWriter) PutCurrentAsConflict(docID string) error {
+	_, err := writer.tx.ExecContext(writer.ctx, "INSERT OR REPLACE INTO conflicts (doc_id, version, hash, deleted, data) SELECT doc_id, version, ifnull(hash, ''), deleted, data FROM documents WHERE doc_id = ?", docID)
+	return err
+}
+
+func (writer *DefaultDatabaseWriter) DeleteConflict(docID string, version int, hash string) (bool, error) {
+	rs, err := writer.tx.ExecContext(writer.ctx, "DELETE FROM conflicts WHERE doc_id = ? AND version = ? AND hash = ?", docID, version, hash)
+	if err != nil {
+		return false, err
+	}
+	n, err := rs.RowsAffected()
+	return n > 0, err
+}
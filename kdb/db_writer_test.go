@@ -1,6 +1,7 @@
-package main
+package kdb
 
 import (
+	"context"
 	"os"
 	"testing"
 )
@@ -14,7 +15,7 @@ func TestWriterPutDocument(t *testing.T) {
 	var writer DatabaseWriter = serviceLocator.GetDatabaseWriter()
 	writer.Open(testConnectionString)
 
-	writer.Begin()
+	writer.Begin(context.Background())
 
 	if err := writer.ExecBuildScript(); err != nil {
 		t.Errorf("unable to setup database")
@@ -31,7 +32,7 @@ func TestWriterPutDocument(t *testing.T) {
 
 	writer.Commit()
 
-	writer.Begin()
+	writer.Begin(context.Background())
 
 	if _, err := writer.GetDocumentRevisionByID("1"); err != nil {
 		t.Errorf("unable to get document, error %s", err.Error())
@@ -51,7 +52,7 @@ func TestWriterPutDocument(t *testing.T) {
 	var writer DatabaseWriter = serviceLocator.GetDatabaseWriter(testConnectionString)
 	writer.Open()
 
-	writer.Begin()
+	writer.Begin(context.Background())
 
 	if err := writer.ExecBuildScript(); err != nil {
 		t.Errorf("unable to setup database")
@@ -69,7 +70,7 @@ func TestWriterPutDocument(t *testing.T) {
 
 	writer.Commit()
 
-	writer.Begin()
+	writer.Begin(context.Background())
 
 	doc, _ = ParseDocument([]byte(`{"_id":1}`))
 	if err := writer.PutDocument("seqID", doc, nil); err != nil {
@@ -90,7 +91,7 @@ func TestWriterPutDocumentWithDeplicateSeqID(t *testing.T) {
 	var writer DatabaseWriter = serviceLocator.GetDatabaseWriter(testConnectionString)
 	writer.Open()
 
-	writer.Begin()
+	writer.Begin(context.Background())
 
 	if err := writer.ExecBuildScript(); err != nil {
 		t.Errorf("unable to setup database")
@@ -113,7 +114,7 @@ func TestWriterPutDocumentWithDeplicateSeqID(t *testing.T) {
 
 	writer.Commit()
 
-	writer.Begin()
+	writer.Begin(context.Background())
 
 	doc, _ = ParseDocument([]byte(`{"_id":2}`))
 	err = writer.PutDocument("seqID", doc, nil)
@@ -139,7 +140,7 @@ func TestWriterDeleteDocument(t *testing.T) {
 	var writer DatabaseWriter = serviceLocator.GetDatabaseWriter()
 	writer.Open(testConnectionString)
 
-	writer.Begin()
+	writer.Begin(context.Background())
 
 	if err := writer.ExecBuildScript(); err != nil {
 		t.Errorf("unable to setup database")
@@ -152,7 +153,7 @@ func TestWriterDeleteDocument(t *testing.T) {
 
 	writer.Commit()
 
-	writer.Begin()
+	writer.Begin(context.Background())
 
 	doc, _ = ParseDocument([]byte(`{"_id":1, "_version":1, "_deleted":true}`))
 	if err := writer.PutDocument("seqID2", doc, nil); err != nil {
@@ -161,7 +162,7 @@ func TestWriterDeleteDocument(t *testing.T) {
 
 	writer.Commit()
 
-	writer.Begin()
+	writer.Begin(context.Background())
 
 	if _, err := writer.GetDocumentRevisionByID("1"); err == nil || err != ErrDocNotFound {
 		t.Errorf("expected %s, got doc or err %s", ErrDocNotFound, err)
@@ -181,7 +182,7 @@ func TestWriterDocNotFound(t *testing.T) {
 	var writer DatabaseWriter = serviceLocator.GetDatabaseWriter()
 	writer.Open(testConnectionString)
 
-	writer.Begin()
+	writer.Begin(context.Background())
 
 	if err := writer.ExecBuildScript(); err != nil {
 		t.Errorf("unable to setup database")
@@ -189,7 +190,7 @@ func TestWriterDocNotFound(t *testing.T) {
 
 	writer.Commit()
 
-	writer.Begin()
+	writer.Begin(context.Background())
 
 	if _, err := writer.GetDocumentRevisionByID("1"); err == nil || err != ErrDocNotFound {
 		t.Errorf("expected %s, got doc or err %s", ErrDocNotFound, err)
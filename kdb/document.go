@@ -0,0 +1,175 @@
+package kdb
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/valyala/fastjson"
+)
+
+var parserPool fastjson.ParserPool
+
+// maxHistory is the number of previous version hashes kept with a document.
+var maxHistory = 100
+
+type Document struct {
+	ID      string
+	Version int
+	Kind    string
+	Deleted bool
+	Data    []byte
+
+	// Hash identifies the content of the version, "N-hash" tells apart two
+	// versions N written on different servers. History holds the hashes of
+	// the previous versions, newest first.
+	Hash    string
+	History []string
+}
+
+func (doc *Document) CalculateNextVersion() {
+	doc.Version = doc.Version + 1
+}
+
+// CalculateHash sets the hash of the new version of doc written over parent,
+// parent is nil for a new document.
+func (doc *Document) CalculateHash(parent *Document) {
+	h := md5.New()
+	var history []string
+	if parent != nil {
+		h.Write([]byte(FormatVersion(parent.Version, parent.Hash)))
+		history = append([]string{parent.Hash}, parent.History...)
+		if len(history) > maxHistory {
+			history = history[:maxHistory]
+		}
+	}
+	fmt.Fprintf(h, "\n%t\n", doc.Deleted)
+	h.Write(doc.Data)
+	doc.Hash = hex.EncodeToString(h.Sum(nil))
+	doc.History = history
+}
+
+// hasVersion reports whether version-hash is the version of doc or one of
+// its previous versions.
+func (doc *Document) hasVersion(version int, hash string) bool {
+	if version == doc.Version {
+		return hash == doc.Hash
+	}
+	idx := doc.Version - version - 1
+	return idx >= 0 && idx < len(doc.History) && doc.History[idx] == hash
+}
+
+// winsOver picks the winner of two conflicting versions the same way on
+// every server: a live version wins over a deleted one, then the higher
+// version, then the higher hash.
+func (doc *Document) winsOver(other *Document) bool {
+	if doc.Deleted != other.Deleted {
+		return !doc.Deleted
+	}
+	if doc.Version != other.Version {
+		return doc.Version > other.Version
+	}
+	return doc.Hash > other.Hash
+}
+
+// FormatVersion formats a version with its hash, 2-9a0364b9e99bb480dd25e1f0284c8555.
+func FormatVersion(version int, hash string) string {
+	if hash == "" {
+		return strconv.Itoa(version)
+	}
+	return fmt.Sprintf("%d-%s", version, hash)
+}
+
+// ParseVersion parses a version formatted by FormatVersion.
+func ParseVersion(value string) (int, string, error) {
+	number, hash := value, ""
+	if idx := strings.IndexByte(value, '-'); idx >= 0 {
+		number, hash = value[:idx], value[idx+1:]
+	}
+	version, err := strconv.Atoi(number)
+	if err != nil || version < 1 {
+		return 0, "", fmt.Errorf("%s: %w", "invalid version "+value, ErrDocInvalidInput)
+	}
+	return version, hash, nil
+}
+
+func ParseDocument(value []byte) (*Document, error) {
+	// v belongs to the parser until it's returned to the pool
+	parser := parserPool.Get()
+	defer parserPool.Put(parser)
+	v, err := parser.ParseBytes(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", err, ErrBadJSON)
+	}
+
+	obj := v.GetObject()
+	if obj == nil {
+		return nil, fmt.Errorf("%s: %w", "payload expected as json object", ErrDocInvalidInput)
+	}
+
+	var (
+		id      string
+		version int = 0
+		deleted bool
+		kind    string
+	)
+
+	if v.Exists("_id") {
+		id = strings.ReplaceAll(v.Get("_id").String(), "\"", "")
+		v.Del("_id")
+	}
+
+	if v.Exists("_version") {
+		version, _ = strconv.Atoi(v.Get("_version").String())
+		v.Del("_version")
+	}
+
+	if v.Exists("_kind") {
+		kind = strings.ReplaceAll(v.Get("_kind").String(), "\"", "")
+		v.Del("_kind")
+	}
+
+	var (
+		hash    string
+		history []string
+	)
+	if v.Exists("_hash") {
+		hash = string(v.GetStringBytes("_hash"))
+		v.Del("_hash")
+	}
+	if v.Exists("_history") {
+		for _, item := range v.GetArray("_history") {
+			history = append(history, string(item.GetStringBytes()))
+		}
+		v.Del("_history")
+	}
+	// _conflicts is only shown on reads
+	v.Del("_conflicts")
+
+	if v.Exists("_deleted") {
+		deleted = v.Get("_deleted").GetBool()
+		v.Del("_deleted")
+	} else {
+		deleted = false
+	}
+
+	if id == "" && version != 0 {
+		return nil, fmt.Errorf("%s: %w", "document can't have version without _id", ErrDocInvalidInput)
+	}
+
+	var b []byte
+	value = v.MarshalTo(b)
+
+	doc := &Document{}
+	doc.ID = id
+	doc.Version = version
+	doc.Kind = kind
+	doc.Deleted = deleted
+	doc.Data = value
+	doc.Hash = hash
+	doc.History = history
+
+	return doc, nil
+}
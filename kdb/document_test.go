@@ -1,4 +1,4 @@
-package main
+package kdb
 
 import (
 	"errors"
@@ -74,3 +74,53 @@ func TestParseDocumentObject(t *testing.T) {
 		t.Errorf("expected to fail with %s, got %s", err.Error(), ErrDocInvalidInput)
 	}
 }
+
+func TestDocumentHashAndHistory(t *testing.T) {
+	doc, _ := ParseDocument([]byte(`{"_id":1,"test":1}`))
+	doc.CalculateNextVersion()
+	doc.CalculateHash(nil)
+	if doc.Hash == "" || len(doc.History) != 0 {
+		t.Fatalf("unexpected hash %s and history %v", doc.Hash, doc.History)
+	}
+
+	other, _ := ParseDocument([]byte(`{"_id":1,"test":2}`))
+	other.CalculateNextVersion()
+	other.CalculateHash(nil)
+	if other.Hash == doc.Hash {
+		t.Errorf("expected different hashes for different content")
+	}
+
+	next, _ := ParseDocument([]byte(`{"_id":1,"_version":1,"test":3}`))
+	next.CalculateNextVersion()
+	next.CalculateHash(doc)
+	if len(next.History) != 1 || next.History[0] != doc.Hash {
+		t.Errorf("expected history [%s], got %v", doc.Hash, next.History)
+	}
+	if !next.hasVersion(1, doc.Hash) || next.hasVersion(1, other.Hash) || !next.hasVersion(2, next.Hash) {
+		t.Errorf("unexpected ancestry of %+v", next)
+	}
+	if !next.winsOver(other) || doc.winsOver(next) {
+		t.Errorf("expected the higher version to win")
+	}
+	other.Deleted = true
+	if !doc.winsOver(other) {
+		t.Errorf("expected a live version to win over a deleted one")
+	}
+
+	parsed, _ := ParseDocument(formatDocument(next))
+	if parsed.Hash != next.Hash || len(parsed.History) != 1 || parsed.History[0] != doc.Hash || string(parsed.Data) != `{"test":3}` {
+		t.Errorf("expected %+v, got %+v", next, parsed)
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	version, hash, err := ParseVersion(FormatVersion(2, "abc"))
+	if err != nil || version != 2 || hash != "abc" {
+		t.Errorf("expected 2-abc, got %d-%s %v", version, hash, err)
+	}
+	for _, value := range []string{"", "abc", "0-abc", "x-abc"} {
+		if _, _, err := ParseVersion(value); !errors.Is(err, ErrDocInvalidInput) {
+			t.Errorf("expected %s for %q, got %v", ErrDocInvalidInput, value, err)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+package kdb
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/valyala/fastjson"
+)
+
+// encryptedPrefix starts the encrypted values, the key id and the base64
+// nonce and ciphertext follow, "kdb3:aes-gcm:key1:...". The ciphertext is the
+// json of the value.
+const encryptedPrefix = "kdb3:aes-gcm:"
+
+// EncryptedFields are the fields of a database's documents encrypted at
+// rest. Fields are dotted paths of object keys, "customer.email". Values are
+// encrypted with the key KeyID of the key provider, and decrypted with the
+// key they were encrypted with when it's KeyID or one of RetiredKeyIDs.
+// DecryptViews are the views which can use the decrypt sql function,
+// "_design/reports/customers".
+type EncryptedFields struct {
+	KeyID         string   `json:"key_id"`
+	RetiredKeyIDs []string `json:"retired_key_ids,omitempty"`
+	Fields        []string `json:"fields"`
+	DecryptViews  []string `json:"decrypt_views"`
+}
+
+// KeyProvider returns the AES-256 keys values are encrypted with.
+type KeyProvider interface {
+	Key(id string) ([]byte, error)
+}
+
+var keyIDExp = regexp.MustCompile(`^[A-Za-z0-9_\-]+$`)
+
+// LocalKeyProvider reads the keys from a directory, a file {id}.key per key
+// with the hex encoded key.
+type LocalKeyProvider struct {
+	path string
+	mux  sync.Mutex
+	keys map[string][]byte
+}
+
+func NewLocalKeyProvider(path string) *LocalKeyProvider {
+	return &LocalKeyProvider{path: path, keys: make(map[string][]byte)}
+}
+
+func (provider *LocalKeyProvider) Key(id string) ([]byte, error) {
+	if !keyIDExp.MatchString(id) {
+		return nil, fmt.Errorf("invalid key id %q", id)
+	}
+	provider.mux.Lock()
+	defer provider.mux.Unlock()
+	if key, ok := provider.keys[id]; ok {
+		return key, nil
+	}
+	b, err := ioutil.ReadFile(filepath.Join(provider.path, id+".key"))
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %s", id, err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("key %s has to be 32 hex encoded bytes", id)
+	}
+	provider.keys[id] = key
+	return key, nil
+}
+
+// fieldCipher encrypts and decrypts the fields of a database's documents,
+// only with the keys of the database.
+type fieldCipher struct {
+	keyID        string
+	keyIDs       map[string]bool
+	fields       [][]string
+	decryptViews map[string]bool
+	provider     KeyProvider
+}
+
+// fieldCipher returns the cipher of a database, nil without encrypted
+// fields.
+func (kdb *Engine) fieldCipher(name string) *fieldCipher {
+	encryption, ok := kdb.config.Encryption[name]
+	if !ok || len(encryption.Fields) == 0 {
+		return nil
+	}
+	cipher := &fieldCipher{
+		keyID:        encryption.KeyID,
+		keyIDs:       map[string]bool{encryption.KeyID: true},
+		decryptViews: make(map[string]bool),
+		provider:     kdb.keyProvider,
+	}
+	for _, id := range encryption.RetiredKeyIDs {
+		cipher.keyIDs[id] = true
+	}
+	for _, field := range encryption.Fields {
+		cipher.fields = append(cipher.fields, strings.Split(field, "."))
+	}
+	for _, view := range encryption.DecryptViews {
+		cipher.decryptViews[view] = true
+	}
+	return cipher
+}
+
+// sameEncryption reports whether the databases name and target encrypt the
+// same fields with the same key.
+func (kdb *Engine) sameEncryption(name, target string) bool {
+	fields := func(name string) string {
+		encryption := kdb.config.Encryption[name]
+		if len(encryption.Fields) == 0 {
+			return ""
+		}
+		return encryption.KeyID + ":" + strings.Join(encryption.Fields, ",")
+	}
+	return fields(name) == fields(target)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encrypt returns doc with its fields encrypted. Values encrypted already
+// are only kept as they are when sealed, documents written with their
+// versions like replicated ones, and when they decrypt with the keys of the
+// database. Design documents are never encrypted, the views read them.
+func (fc *fieldCipher) encrypt(doc *Document, sealed bool) (*Document, error) {
+	if fc == nil || doc.Deleted || len(doc.Data) == 0 || strings.HasPrefix(doc.ID, "_design/") {
+		return doc, nil
+	}
+	if fc.provider == nil {
+		return nil, fmt.Errorf("%s: %w", "encrypted fields without a key provider", ErrInternalError)
+	}
+	v, err := fastjson.ParseBytes(doc.Data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", err, ErrBadJSON)
+	}
+
+	var aead cipher.AEAD
+	var arena fastjson.Arena
+	encrypted := false
+	for _, path := range fc.fields {
+		parent, value := v.Get(path[:len(path)-1]...), v.Get(path...)
+		if parent == nil || parent.Type() != fastjson.TypeObject || value == nil {
+			continue
+		}
+		if isEncrypted(value) {
+			field := strings.Join(path, ".")
+			if !sealed {
+				return nil, fmt.Errorf("%s: %w", fmt.Sprintf("%s can't start with %s", field, encryptedPrefix), ErrDocInvalidInput)
+			}
+			if _, err := fc.open(string(value.GetStringBytes())); err != nil {
+				return nil, fmt.Errorf("%s: %w", fmt.Sprintf("%s: %s", field, err), ErrDocInvalidInput)
+			}
+			continue
+		}
+		if aead == nil {
+			key, err := fc.provider.Key(fc.keyID)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", err, ErrInternalError)
+			}
+			if aead, err = newGCM(key); err != nil {
+				return nil, fmt.Errorf("%s: %w", err, ErrInternalError)
+			}
+		}
+		nonce := randomBytes(aead.NonceSize())
+		sealed := aead.Seal(nonce, nonce, value.MarshalTo(nil), nil)
+		parent.Set(path[len(path)-1], arena.NewString(encryptedPrefix+fc.keyID+":"+base64.StdEncoding.EncodeToString(sealed)))
+		encrypted = true
+	}
+	if !encrypted {
+		return doc, nil
+	}
+	sealedDoc := *doc
+	sealedDoc.Data = v.MarshalTo(nil)
+	return &sealedDoc, nil
+}
+
+// decrypt decrypts the fields of doc in place.
+func (fc *fieldCipher) decrypt(doc *Document) error {
+	if fc == nil || doc == nil || len(doc.Data) == 0 {
+		return nil
+	}
+	v, err := fastjson.ParseBytes(doc.Data)
+	if err != nil {
+		return err
+	}
+	decrypted := false
+	for _, path := range fc.fields {
+		parent, value := v.Get(path[:len(path)-1]...), v.Get(path...)
+		if parent == nil || parent.Type() != fastjson.TypeObject || value == nil || !isEncrypted(value) {
+			continue
+		}
+		plaintext, err := fc.open(string(value.GetStringBytes()))
+		if err != nil {
+			return fmt.Errorf("%s: %s: %w", doc.ID, err, ErrInternalError)
+		}
+		plain, err := fastjson.ParseBytes(plaintext)
+		if err != nil {
+			return fmt.Errorf("%s: %s: %w", doc.ID, err, ErrInternalError)
+		}
+		parent.Set(path[len(path)-1], plain)
+		decrypted = true
+	}
+	if decrypted {
+		doc.Data = v.MarshalTo(nil)
+	}
+	return nil
+}
+
+func isEncrypted(value *fastjson.Value) bool {
+	return value.Type() == fastjson.TypeString && strings.HasPrefix(string(value.GetStringBytes()), encryptedPrefix)
+}
+
+// open returns the json of an encrypted value, when it's encrypted with a
+// key of the database.
+func (fc *fieldCipher) open(value string) ([]byte, error) {
+	parts := strings.SplitN(strings.TrimPrefix(value, encryptedPrefix), ":", 2)
+	if len(parts) != 2 {
+		return nil, errors.New("malformed encrypted value")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.New("malformed encrypted value")
+	}
+	if !fc.keyIDs[parts[0]] {
+		return nil, fmt.Errorf("key %s isn't a key of the database", parts[0])
+	}
+	if fc.provider == nil {
+		return nil, errors.New("no key provider")
+	}
+	key, err := fc.provider.Key(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("malformed encrypted value")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+}
+
+// viewDecrypt returns the decrypt sql function of a view, nil when the view
+// isn't granted it.
+func (fc *fieldCipher) viewDecrypt(ddocID, viewName string) func(interface{}) (string, error) {
+	if fc == nil || !fc.decryptViews[ddocID+"/"+viewName] {
+		return nil
+	}
+	return fc.decryptSQL
+}
+
+// decryptSQL is the decrypt sql function, it returns a string value as text
+// and other values as json. Values which aren't encrypted are returned as
+// they are.
+func (fc *fieldCipher) decryptSQL(value interface{}) (string, error) {
+	text := sqlText(value)
+	if !strings.HasPrefix(text, encryptedPrefix) {
+		return text, nil
+	}
+	plaintext, err := fc.open(text)
+	if err != nil {
+		return "", fmt.Errorf("decrypt: %s", err)
+	}
+	var s string
+	if json.Unmarshal(plaintext, &s) == nil {
+		return s, nil
+	}
+	return string(plaintext), nil
+}
+
+// registerDecrypt registers decrypt on the connections of a view, when it's
+// granted it.
+func registerDecrypt(decrypt func(interface{}) (string, error)) func(*sqlite3.SQLiteConn) error {
+	return func(con *sqlite3.SQLiteConn) error {
+		if decrypt == nil {
+			return nil
+		}
+		return con.RegisterFunc("decrypt", decrypt, true)
+	}
+}
+
+var decryptCallExp = regexp.MustCompile(`(?i)\bdecrypt\b`)
+
+// authorizeDecrypt checks the views of a design document only use the
+// decrypt sql function when they're granted it.
+func (kdb *Engine) authorizeDecrypt(name string, doc *Document) error {
+	if doc.Deleted {
+		return nil
+	}
+	ddoc := &DesignDocument{}
+	if err := json.Unmarshal(doc.Data, ddoc); err != nil {
+		return nil
+	}
+	granted := map[string]bool{}
+	for _, view := range kdb.config.Encryption[name].DecryptViews {
+		granted[view] = true
+	}
+	for viewName, view := range ddoc.Views {
+		if view == nil || granted[doc.ID+"/"+viewName] {
+			continue
+		}
+		scripts := append(append([]string{}, view.Setup...), view.Run...)
+		for _, script := range view.Select {
+			scripts = append(scripts, script)
+		}
+		for _, script := range scripts {
+			if decryptCallExp.MatchString(script) {
+				return fmt.Errorf("%s: %w", "view "+viewName+" isn't granted decrypt", ErrForbidden)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package kdb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestLocalKeyProvider(t *testing.T) {
+	os.MkdirAll("./data/keys", 0755)
+	defer os.RemoveAll("./data/keys")
+	ioutil.WriteFile("./data/keys/key1.key", []byte(strings.Repeat("ab", 32)+"\n"), 0600)
+	ioutil.WriteFile("./data/keys/short.key", []byte("abcd"), 0600)
+	provider := NewLocalKeyProvider("./data/keys")
+
+	if key, err := provider.Key("key1"); err != nil || len(key) != 32 || key[0] != 0xab {
+		t.Errorf("expected the key, got %x %v", key, err)
+	}
+	for _, id := range []string{"short", "missing", "../keys/key1"} {
+		if _, err := provider.Key(id); err == nil {
+			t.Errorf("expected an error for %s", id)
+		}
+	}
+}
+
+func TestFieldEncryption(t *testing.T) {
+	os.MkdirAll("./data/keys", 0755)
+	defer os.RemoveAll("./data/keys")
+	ioutil.WriteFile("./data/keys/key1.key", []byte(strings.Repeat("01", 32)), 0600)
+	ioutil.WriteFile("./data/keys/key2.key", []byte(strings.Repeat("02", 32)), 0600)
+
+	config := DefaultConfig()
+	config.EncryptionKeyPath = "./data/keys"
+	config.Encryption = map[string]EncryptedFields{
+		"testdbencrypted":      {KeyID: "key1", Fields: []string{"ssn", "customer.email"}, DecryptViews: []string{"_design/reports/emails"}},
+		"testdbencryptedcopy":  {KeyID: "key1", Fields: []string{"ssn", "customer.email"}},
+		"testdbencryptedother": {KeyID: "key2", Fields: []string{"ssn", "customer.email"}, DecryptViews: []string{"_design/reports/emails"}},
+	}
+	kdb, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kdb.Close()
+	ctx := context.Background()
+
+	for _, name := range []string{"testdbencrypted", "testdbencryptedcopy", "testdbencryptedother"} {
+		kdb.Delete(name)
+		if err := kdb.Open(name, true); err != nil {
+			t.Fatal(err)
+		}
+		defer kdb.Delete(name)
+	}
+
+	inputDoc, _ := ParseDocument([]byte(`{"_id":"1","ssn":123456789,"customer":{"name":"alice","email":"alice@example.com"}}`))
+	doc, err := kdb.PutDocument(ctx, "testdbencrypted", inputDoc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(doc.Data, []byte("alice@example.com")) {
+		t.Errorf("expected the plaintext to be returned, got %s", doc.Data)
+	}
+
+	doc, err = kdb.GetDocument(ctx, "testdbencrypted", &Document{ID: "1"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(doc.Data, []byte(`"ssn":123456789`)) || !bytes.Contains(doc.Data, []byte(`"email":"alice@example.com"`)) {
+		t.Errorf("expected the fields decrypted, got %s", doc.Data)
+	}
+
+	// the stored document has ciphertext, the other fields stay as they are
+	var export bytes.Buffer
+	if _, _, err := kdb.Export(ctx, "testdbencrypted", "", false, &export); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(export.Bytes(), []byte("alice@example.com")) || bytes.Contains(export.Bytes(), []byte("123456789")) ||
+		!bytes.Contains(export.Bytes(), []byte(encryptedPrefix+"key1:")) || !bytes.Contains(export.Bytes(), []byte(`"name":"alice"`)) {
+		t.Errorf("expected the fields encrypted at rest, got %s", export.Bytes())
+	}
+
+	// encrypted values are only kept with the versions, and with a key of
+	// the database
+	sealed := regexp.MustCompile(`"email":"(` + encryptedPrefix + `[^"]+)"`).FindSubmatch(export.Bytes())[1]
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"2","customer":{"email":"` + string(sealed) + `"}}`))
+	if _, err := kdb.PutDocument(ctx, "testdbencrypted", inputDoc); !errors.Is(err, ErrDocInvalidInput) {
+		t.Errorf("expected %s, got %v", ErrDocInvalidInput, err)
+	}
+	if result, err := kdb.Import(ctx, "testdbencryptedcopy", bytes.NewReader(export.Bytes()), true); err != nil || result.Written != 1 {
+		t.Errorf("expected the document written, got %+v %v", result, err)
+	}
+	if _, err := kdb.Import(ctx, "testdbencryptedother", bytes.NewReader(export.Bytes()), true); !errors.Is(err, ErrDocInvalidInput) {
+		t.Errorf("expected %s, got %v", ErrDocInvalidInput, err)
+	}
+	if doc, err := kdb.GetDocument(ctx, "testdbencryptedcopy", &Document{ID: "1"}, true); err != nil || !bytes.Contains(doc.Data, []byte("alice@example.com")) {
+		t.Errorf("expected the imported fields decrypted, got %v %v", doc, err)
+	}
+
+	// views see the ciphertext, unless they're granted decrypt
+	ddoc := `{"_id":"_design/reports","views":{"emails":{
+		"setup":["CREATE TABLE IF NOT EXISTS emails (doc_id, email, PRIMARY KEY(doc_id))"],
+		"run":["INSERT OR REPLACE INTO emails SELECT doc_id, decrypt(json_extract(data, '$.customer.email')) FROM latest_documents WHERE deleted = 0 AND doc_id NOT LIKE '_design/%'"],
+		"select":{"default":"SELECT JSON_GROUP_ARRAY(email) FROM emails"}},
+		"raw":{
+		"setup":["CREATE TABLE IF NOT EXISTS raw (doc_id, email, PRIMARY KEY(doc_id))"],
+		"run":["INSERT OR REPLACE INTO raw SELECT doc_id, json_extract(data, '$.customer.email') FROM latest_documents WHERE deleted = 0 AND doc_id NOT LIKE '_design/%'"],
+		"select":{"default":"SELECT JSON_GROUP_ARRAY(email) FROM raw"}}}}`
+	inputDoc, _ = ParseDocument([]byte(ddoc))
+	if _, err := kdb.PutDocument(ctx, "testdbencrypted", inputDoc); err != nil {
+		t.Fatal(err)
+	}
+	rs, err := kdb.SelectView(ctx, "testdbencrypted", "_design/reports", "emails", "default", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rs) != `["alice@example.com"]` {
+		t.Errorf("expected the decrypted email, got %s", rs)
+	}
+	rs, err = kdb.SelectView(ctx, "testdbencrypted", "_design/reports", "raw", "default", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(rs), `["`+encryptedPrefix) {
+		t.Errorf("expected the ciphertext, got %s", rs)
+	}
+
+	ddoc = `{"_id":"_design/leak","views":{"emails":{
+		"setup":["CREATE TABLE IF NOT EXISTS emails (doc_id, email, PRIMARY KEY(doc_id))"],
+		"run":["INSERT OR REPLACE INTO emails SELECT doc_id, decrypt(json_extract(data, '$.customer.email')) FROM latest_documents"],
+		"select":{"default":"SELECT JSON_GROUP_ARRAY(email) FROM emails"}}}}`
+	inputDoc, _ = ParseDocument([]byte(ddoc))
+	if _, err := kdb.PutDocument(ctx, "testdbencrypted", inputDoc); !errors.Is(err, ErrForbidden) {
+		t.Errorf("expected %s, got %v", ErrForbidden, err)
+	}
+	if _, err := kdb.TestDesignDocument(ctx, "testdbencrypted", []byte(`{"design":`+ddoc+`}`)); !errors.Is(err, ErrForbidden) {
+		t.Errorf("expected %s, got %v", ErrForbidden, err)
+	}
+
+	// decrypt only has the keys of its own database
+	sample := `{"_id":"1","customer":{"email":"` + string(sealed) + `"}}`
+	ddoc = `{"_id":"_design/reports","views":{"emails":{
+		"setup":["CREATE TABLE IF NOT EXISTS emails (doc_id, email, PRIMARY KEY(doc_id))"],
+		"run":["INSERT OR REPLACE INTO emails SELECT doc_id, decrypt(json_extract(data, '$.customer.email')) FROM latest_documents"],
+		"select":{"default":"SELECT JSON_GROUP_ARRAY(email) FROM emails"}}}}`
+	for name, expected := range map[string]string{"testdbencrypted": `alice@example.com`, "testdbencryptedother": `isn't a key of the database`} {
+		rs, err := kdb.TestDesignDocument(ctx, name, []byte(`{"design":`+ddoc+`,"docs":[`+sample+`]}`))
+		if err != nil || !strings.Contains(string(rs), expected) {
+			t.Errorf("%s: expected %s, got %s %v", name, expected, rs, err)
+		}
+	}
+
+	// the encrypted fields don't move to a database without them
+	if err := kdb.Rename(ctx, "testdbencrypted", "testdbplain"); !errors.Is(err, ErrDBInvalidName) {
+		t.Errorf("expected %s, got %v", ErrDBInvalidName, err)
+	}
+	if err := kdb.Copy(ctx, "testdbencrypted", "testdbplain", false); !errors.Is(err, ErrDBInvalidName) {
+		t.Errorf("expected %s, got %v", ErrDBInvalidName, err)
+	}
+
+	// updates encrypt again, deletes have nothing to encrypt
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"1","ssn":987654321}`))
+	inputDoc.Version = doc.Version
+	if doc, err = kdb.PutDocument(ctx, "testdbencrypted", inputDoc); err != nil {
+		t.Fatal(err)
+	}
+	doc, err = kdb.GetDocument(ctx, "testdbencrypted", &Document{ID: "1"}, true)
+	if err != nil || !bytes.Contains(doc.Data, []byte(`"ssn":987654321`)) {
+		t.Errorf("expected the updated field decrypted, got %v %v", doc, err)
+	}
+	if _, err := kdb.DeleteDocument(ctx, "testdbencrypted", &Document{ID: "1", Version: doc.Version}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,120 @@
+package kdb
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+var (
+	ErrBadJSON               = errors.New("bad_json")
+	ErrDBExists              = errors.New("db_exists")
+	ErrDBNotFound            = errors.New("db_not_found")
+	ErrDBInvalidName         = errors.New("invalid_db_name")
+	ErrDocInvalidID          = errors.New("invalid_doc_id")
+	ErrDocConflict           = errors.New("doc_conflict")
+	ErrDocNotFound           = errors.New("doc_not_found")
+	ErrViewNotFound          = errors.New("view_not_found")
+	ErrViewResult            = errors.New("view_result_error")
+	ErrViewInvalidParam      = errors.New("invalid_view_param")
+	ErrViewInvalidSource     = errors.New("invalid_view_source")
+	ErrViewInvalidDependency = errors.New("invalid_view_dependency")
+	ErrViewInvalidExternal   = errors.New("invalid_view_external")
+	ErrExternalView          = errors.New("external_view_error")
+	ErrDocInvalidInput       = errors.New("doc_invalid_input")
+	ErrInvalidStorage        = errors.New("invalid_storage")
+	ErrInvalidSQLStmt        = errors.New("invalid_sql_stmt")
+	ErrInvalidReplication    = errors.New("invalid_replication")
+	ErrBackupNotFound        = errors.New("backup_not_found")
+	ErrUnauthorized          = errors.New("unauthorized")
+	ErrForbidden             = errors.New("forbidden")
+	ErrQuotaExceeded         = errors.New("quota_exceeded")
+	ErrTooManyRequests       = errors.New("too_many_requests")
+	ErrAuditDisabled         = errors.New("audit_disabled")
+	ErrInternalError         = errors.New("internal_error")
+
+	MsgInterError      = "internal error"
+	MsgDBExists        = "database already exists"
+	MsgBadJSON         = "invalid json format"
+	MsgDBNotFound      = "database not found"
+	MsgDBInvalidName   = "invalid db name"
+	MsgDocInvalidID    = "invalid doc id"
+	MsgDocConflict     = "document conflict"
+	MsgDocNotFound     = "document not found"
+	MsgViewNotFound    = "view not found"
+	MsgInvalidStorage  = "unknown storage"
+	MsgBackupNotFound  = "backup not found"
+	MsgUnauthorized    = "name or password is incorrect"
+	MsgTooManyRequests = "too many requests"
+	MsgAuditDisabled   = "the audit log is disabled"
+	MsgTimeout         = "request timed out"
+	MsgCanceled        = "request canceled"
+)
+
+func getErrorDescription(err error) string {
+	e := errors.Unwrap(err)
+	if e == nil {
+		return err.Error()
+	}
+	return strings.Trim(strings.TrimRight(strings.ReplaceAll(err.Error(), e.Error(), ""), " "), ":")
+}
+
+func ErrorString(err error) (string, string) {
+	switch {
+	case errors.Is(err, ErrDBExists):
+		return err.Error(), MsgDBExists
+	case errors.Is(err, ErrBadJSON):
+		return ErrBadJSON.Error(), getErrorDescription(err)
+	case errors.Is(err, ErrDBNotFound):
+		return ErrDBNotFound.Error(), MsgDBNotFound
+	case errors.Is(err, ErrDBInvalidName):
+		return ErrDBInvalidName.Error(), getErrorDescription(err)
+	case errors.Is(err, ErrDocInvalidID):
+		return ErrDocInvalidID.Error(), getErrorDescription(err)
+	case errors.Is(err, ErrDocConflict):
+		return ErrDocConflict.Error(), MsgDocConflict
+	case errors.Is(err, ErrDocNotFound):
+		return ErrDocNotFound.Error(), MsgDocNotFound
+	case errors.Is(err, ErrViewNotFound):
+		return ErrViewNotFound.Error(), MsgViewNotFound
+	case errors.Is(err, ErrViewResult):
+		return ErrViewResult.Error(), getErrorDescription(err)
+	case errors.Is(err, ErrViewInvalidParam):
+		return ErrViewInvalidParam.Error(), getErrorDescription(err)
+	case errors.Is(err, ErrViewInvalidSource):
+		return ErrViewInvalidSource.Error(), getErrorDescription(err)
+	case errors.Is(err, ErrViewInvalidDependency):
+		return ErrViewInvalidDependency.Error(), getErrorDescription(err)
+	case errors.Is(err, ErrViewInvalidExternal):
+		return ErrViewInvalidExternal.Error(), getErrorDescription(err)
+	case errors.Is(err, ErrExternalView):
+		return ErrExternalView.Error(), getErrorDescription(err)
+	case errors.Is(err, ErrInvalidStorage):
+		return ErrInvalidStorage.Error(), MsgInvalidStorage
+	case errors.Is(err, ErrInvalidReplication):
+		return ErrInvalidReplication.Error(), getErrorDescription(err)
+	case errors.Is(err, ErrBackupNotFound):
+		return ErrBackupNotFound.Error(), MsgBackupNotFound
+	case errors.Is(err, ErrUnauthorized):
+		if errors.Unwrap(err) == nil {
+			return ErrUnauthorized.Error(), MsgUnauthorized
+		}
+		return ErrUnauthorized.Error(), getErrorDescription(err)
+	case errors.Is(err, ErrForbidden):
+		return ErrForbidden.Error(), getErrorDescription(err)
+	case errors.Is(err, ErrQuotaExceeded):
+		return ErrQuotaExceeded.Error(), getErrorDescription(err)
+	case errors.Is(err, ErrTooManyRequests):
+		return ErrTooManyRequests.Error(), MsgTooManyRequests
+	case errors.Is(err, ErrAuditDisabled):
+		return ErrAuditDisabled.Error(), MsgAuditDisabled
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout", MsgTimeout
+	case errors.Is(err, context.Canceled):
+		return "canceled", MsgCanceled
+	case errors.Is(err, ErrInvalidSQLStmt):
+		return ErrInvalidSQLStmt.Error(), getErrorDescription(err)
+	default:
+		return ErrInternalError.Error(), getErrorDescription(err)
+	}
+}
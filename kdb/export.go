@@ -0,0 +1,224 @@
+package kdb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// exportBatchSize is the number of documents read per transaction by
+// Export, importBatchSize the number written per transaction by Import.
+var (
+	exportBatchSize = 1000
+	importBatchSize = 500
+)
+
+// importListLimit caps the conflicts and errors listed in an import result,
+// they are counted beyond it.
+var importListLimit = 1000
+
+type ImportResult struct {
+	OK          bool          `json:"ok"`
+	Read        int           `json:"read"`
+	Written     int           `json:"written"`
+	Skipped     int           `json:"skipped"`
+	Conflicts   int           `json:"conflicts"`
+	ConflictIDs []string      `json:"conflict_ids"`
+	Failures    int           `json:"failures"`
+	Errors      []ImportError `json:"errors"`
+}
+
+// ImportError is a line which couldn't be imported.
+type ImportError struct {
+	Line   int    `json:"line"`
+	ID     string `json:"id,omitempty"`
+	Error  string `json:"error"`
+	Reason string `json:"reason"`
+}
+
+// Export writes the documents changed after since to w as lines of json, in
+// the order they changed, with _id, _version, _hash and _history. Deleted
+// documents are left out unless includeDeleted. A document changed during
+// the export can show up twice, the later line is the newer version. It
+// returns the seq of the last change read and the number of documents
+// written.
+func (kdb *Engine) Export(ctx context.Context, name, since string, includeDeleted bool, w io.Writer) (string, int, error) {
+	docs := 0
+	for {
+		kdb.rwmux.RLock()
+		db, err := kdb.database(name)
+		if err != nil {
+			kdb.rwmux.RUnlock()
+			return since, docs, err
+		}
+		batch, seq, err := db.GetDocumentsSince(ctx, since, exportBatchSize)
+		kdb.rwmux.RUnlock()
+		if err != nil {
+			return since, docs, contextError(ctx, err)
+		}
+		if len(batch) == 0 {
+			return since, docs, nil
+		}
+
+		for _, doc := range batch {
+			if doc.Deleted && !includeDeleted {
+				continue
+			}
+			if _, err := w.Write(append(formatDocument(doc), '\n')); err != nil {
+				return since, docs, err
+			}
+			docs++
+		}
+		since = seq
+	}
+}
+
+type importLine struct {
+	line int
+	doc  *Document
+}
+
+// Import reads documents from r as lines of json and writes them in batches,
+// each batch in one transaction. With keepVersions documents keep their
+// _version, _hash and _history like replicated ones, versions the database
+// has already are skipped and conflicting ones are stored as conflicts.
+// Otherwise _version is ignored and each document becomes the next version
+// of whatever version is current. Lines which fail are listed in the result,
+// the others are imported regardless.
+func (kdb *Engine) Import(ctx context.Context, name string, r io.Reader, keepVersions bool) (*ImportResult, error) {
+	kdb.rwmux.RLock()
+	_, err := kdb.database(name)
+	kdb.rwmux.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ImportResult{}
+	var batch []importLine
+	br := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		data, readErr := br.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			return nil, contextError(ctx, readErr)
+		}
+		if data = bytes.TrimSpace(data); len(data) > 0 {
+			result.Read++
+			doc, err := ParseDocument(data)
+			if err != nil {
+				result.addError(line, "", err)
+			} else {
+				batch = append(batch, importLine{line, doc})
+			}
+		}
+		if len(batch) >= importBatchSize || readErr == io.EOF && len(batch) > 0 {
+			if err := kdb.importBatch(ctx, name, batch, keepVersions, result); err != nil {
+				return nil, err
+			}
+			batch = batch[:0]
+		}
+		if readErr == io.EOF {
+			break
+		}
+	}
+
+	result.OK = true
+	return result, nil
+}
+
+func (kdb *Engine) importBatch(ctx context.Context, name string, batch []importLine, keepVersions bool, result *ImportResult) error {
+	kdb.rwmux.RLock()
+	defer kdb.rwmux.RUnlock()
+	db, err := kdb.database(name)
+	if err != nil {
+		return err
+	}
+
+	quota, added := kdb.quota(name), 0
+	var lines []importLine
+	var docs []*Document
+	for _, item := range batch {
+		// design documents are authorized before validating them, which
+		// compiles their views
+		var err error
+		if strings.HasPrefix(item.doc.ID, "_design/") {
+			err = kdb.authorizeDesignDocument(ctx, name)
+			if err == nil {
+				err = kdb.authorizeDecrypt(name, item.doc)
+			}
+		}
+		if err == nil {
+			err = db.validateImport(ctx, item.doc, keepVersions)
+		}
+		// documents keeping their version keep their content, passwords
+		// included
+		if err == nil && name == UsersDB && !keepVersions && !item.doc.Deleted {
+			err = prepareUserDocument(ctx, db, item.doc)
+		}
+		if err == nil {
+			var adds bool
+			if adds, err = db.checkQuota(ctx, quota, item.doc, added); adds {
+				added++
+			}
+		}
+		if err != nil {
+			result.addError(item.line, item.doc.ID, err)
+			continue
+		}
+		lines = append(lines, item)
+		docs = append(docs, item.doc)
+	}
+	if len(docs) == 0 {
+		return nil
+	}
+
+	writes, err := db.importDocuments(ctx, docs, keepVersions)
+	if err != nil {
+		return contextError(ctx, err)
+	}
+	for i, write := range writes {
+		if write.written {
+			// views of a deleted design document only go once it's committed
+			if lines[i].doc.Kind == "design" && lines[i].doc.Deleted {
+				db.viewManager.UpdateDesignDocument(lines[i].doc)
+			}
+			result.Written++
+			kdb.auditDocument(ctx, name, lines[i].doc)
+		} else if !write.conflict {
+			result.Skipped++
+		}
+		if write.conflict {
+			result.Conflicts++
+			if len(result.ConflictIDs) < importListLimit {
+				result.ConflictIDs = append(result.ConflictIDs, lines[i].doc.ID)
+			}
+		}
+	}
+	return nil
+}
+
+func (db *Database) validateImport(ctx context.Context, doc *Document, keepVersions bool) error {
+	if !validateDocID(doc.ID) || keepVersions && doc.ID == "" {
+		return ErrDocInvalidID
+	}
+	if keepVersions && doc.Version <= 0 {
+		return fmt.Errorf("%s: %w", "document without _version", ErrDocInvalidInput)
+	}
+	if strings.HasPrefix(doc.ID, "_design/") {
+		doc.Kind = "design"
+		if err := db.ValidateDesignDocument(ctx, doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (result *ImportResult) addError(line int, id string, err error) {
+	result.Failures++
+	if len(result.Errors) < importListLimit {
+		code, reason := ErrorString(err)
+		result.Errors = append(result.Errors, ImportError{Line: line, ID: id, Error: code, Reason: reason})
+	}
+}
@@ -0,0 +1,272 @@
+package kdb
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqlDriver is the sqlite3 driver with the registered sql functions, it is
+// used for every connection that runs view or query scripts.
+var sqlDriver = "sqlite3_kdb"
+
+type sqlFunction struct {
+	impl      interface{}
+	pure      bool
+	aggregate bool
+}
+
+var sqlFunctions = struct {
+	sync.RWMutex
+	functions map[string]sqlFunction
+}{functions: make(map[string]sqlFunction)}
+
+func init() {
+	sql.Register(sqlDriver, &sqlite3.SQLiteDriver{ConnectHook: registerSQLFunctions})
+
+	RegisterSQLFunction("slugify", slugify, true)
+	RegisterSQLFunction("iso_week", isoWeek, true)
+	RegisterSQLFunction("geo_distance", geoDistance, true)
+	RegisterSQLFunction("hash", hashValue, true)
+	RegisterSQLAggregate("median", newMedianAggregate, true)
+}
+
+// RegisterSQLFunction makes a go function available as a scalar sql function
+// to views and queries. impl follows go-sqlite3's RegisterFunc rules, pure
+// functions must return the same result for the same arguments. Functions are
+// added to connections opened after the call, so register them before the
+// engine is created.
+func RegisterSQLFunction(name string, impl interface{}, pure bool) error {
+	if t := reflect.TypeOf(impl); t == nil || t.Kind() != reflect.Func || t.NumOut() < 1 || t.NumOut() > 2 {
+		return fmt.Errorf("sql function %s must be a func returning a value and an optional error", name)
+	}
+	return registerSQLFunction(name, sqlFunction{impl: impl, pure: pure})
+}
+
+// RegisterSQLAggregate makes a go aggregate available to views and queries.
+// impl is a constructor returning a pointer with Step and Done methods, as in
+// go-sqlite3's RegisterAggregator.
+func RegisterSQLAggregate(name string, impl interface{}, pure bool) error {
+	t := reflect.TypeOf(impl)
+	if t == nil || t.Kind() != reflect.Func || t.NumIn() != 0 || t.NumOut() < 1 || t.NumOut() > 2 {
+		return fmt.Errorf("sql aggregate %s must be a constructor func", name)
+	}
+	if _, ok := t.Out(0).MethodByName("Step"); !ok {
+		return fmt.Errorf("sql aggregate %s has no Step method", name)
+	}
+	if _, ok := t.Out(0).MethodByName("Done"); !ok {
+		return fmt.Errorf("sql aggregate %s has no Done method", name)
+	}
+	return registerSQLFunction(name, sqlFunction{impl: impl, pure: pure, aggregate: true})
+}
+
+func registerSQLFunction(name string, fn sqlFunction) error {
+	if !sourceAliasExp.MatchString(name) {
+		return fmt.Errorf("invalid sql function name %s", name)
+	}
+	sqlFunctions.Lock()
+	defer sqlFunctions.Unlock()
+	sqlFunctions.functions[strings.ToLower(name)] = fn
+	return nil
+}
+
+// sqlConnector opens connections with the sql functions and a hook of its
+// own, for settings that differ between connection pools.
+type sqlConnector struct {
+	dsn    string
+	driver *sqlite3.SQLiteDriver
+}
+
+func (c *sqlConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c *sqlConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// openSQL opens dsn like sql.Open(sqlDriver, dsn), hooks run on each new
+// connection once the sql functions are registered.
+func openSQL(dsn string, hooks ...func(*sqlite3.SQLiteConn) error) *sql.DB {
+	return sql.OpenDB(&sqlConnector{dsn: dsn, driver: &sqlite3.SQLiteDriver{ConnectHook: func(con *sqlite3.SQLiteConn) error {
+		if err := registerSQLFunctions(con); err != nil {
+			return err
+		}
+		for _, hook := range hooks {
+			if err := hook(con); err != nil {
+				return err
+			}
+		}
+		return nil
+	}}})
+}
+
+func registerSQLFunctions(con *sqlite3.SQLiteConn) error {
+	sqlFunctions.RLock()
+	defer sqlFunctions.RUnlock()
+	for name, fn := range sqlFunctions.functions {
+		var err error
+		if fn.aggregate {
+			err = con.RegisterAggregator(name, fn.impl, fn.pure)
+		} else {
+			err = con.RegisterFunc(name, fn.impl, fn.pure)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// slugify lower cases a text and joins its words with dashes.
+func slugify(value interface{}) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(sqlText(value)) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(r)
+			dash = false
+		case !dash && b.Len() > 0:
+			b.WriteRune('-')
+			dash = true
+		}
+	}
+	return strings.TrimRight(b.String(), "-")
+}
+
+var isoWeekLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"}
+
+// isoWeek returns the ISO 8601 week of a date, as 2006-W01.
+func isoWeek(value interface{}) (string, error) {
+	text := sqlText(value)
+	if text == "" {
+		return "", nil
+	}
+	for _, layout := range isoWeekLayouts {
+		if t, err := time.Parse(layout, text); err == nil {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%04d-W%02d", year, week), nil
+		}
+	}
+	return "", fmt.Errorf("iso_week: invalid date %s", text)
+}
+
+// geoDistance returns the great circle distance in kilometers between two
+// points given in degrees.
+func geoDistance(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadius = 6371.0088
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// hashValue returns the hex digest of a value, sha256 unless md5 or sha1 is
+// given as second argument.
+func hashValue(value interface{}, algorithm ...string) (string, error) {
+	var h hash.Hash
+	name := "sha256"
+	if len(algorithm) > 0 {
+		name = strings.ToLower(algorithm[0])
+	}
+	switch name {
+	case "sha256":
+		h = sha256.New()
+	case "sha1":
+		h = sha1.New()
+	case "md5":
+		h = md5.New()
+	default:
+		return "", fmt.Errorf("hash: unknown algorithm %s", name)
+	}
+	h.Write([]byte(sqlText(value)))
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+type medianAggregate struct {
+	values []float64
+}
+
+func newMedianAggregate() *medianAggregate {
+	return &medianAggregate{}
+}
+
+func (m *medianAggregate) Step(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+	case []byte:
+		// NULL is passed as a nil []byte
+		if v == nil {
+			return nil
+		}
+		f, err := strconv.ParseFloat(string(v), 64)
+		if err != nil {
+			return errors.New("median: value is not a number")
+		}
+		m.values = append(m.values, f)
+	case int64:
+		m.values = append(m.values, float64(v))
+	case float64:
+		m.values = append(m.values, v)
+	default:
+		f, err := strconv.ParseFloat(sqlText(v), 64)
+		if err != nil {
+			return errors.New("median: value is not a number")
+		}
+		m.values = append(m.values, f)
+	}
+	return nil
+}
+
+func (m *medianAggregate) Done() float64 {
+	if len(m.values) == 0 {
+		return 0
+	}
+	sort.Float64s(m.values)
+	mid := len(m.values) / 2
+	if len(m.values)%2 == 0 {
+		return (m.values[mid-1] + m.values[mid]) / 2
+	}
+	return m.values[mid]
+}
+
+func sqlText(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	default:
+		return fmt.Sprint(v)
+	}
+}
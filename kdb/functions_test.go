@@ -0,0 +1,91 @@
+package kdb
+
+import (
+	"database/sql"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := map[string]string{
+		"Hello World":          "hello-world",
+		"  Getting started!! ": "getting-started",
+		"Crème brûlée 2":       "crème-brûlée-2",
+		"":                     "",
+	}
+	for input, expected := range tests {
+		if v := slugify(input); v != expected {
+			t.Errorf("slugify(%q): expected %q, got %q", input, expected, v)
+		}
+	}
+}
+
+func TestISOWeek(t *testing.T) {
+	tests := map[string]string{
+		"2021-01-03":           "2020-W53",
+		"2021-01-04":           "2021-W01",
+		"2026-10-18T10:00:00Z": "2026-W42",
+	}
+	for input, expected := range tests {
+		v, err := isoWeek(input)
+		if err != nil || v != expected {
+			t.Errorf("iso_week(%q): expected %q, got %q %v", input, expected, v, err)
+		}
+	}
+	if _, err := isoWeek("yesterday"); err == nil {
+		t.Error("expected error for invalid date")
+	}
+}
+
+func TestGeoDistance(t *testing.T) {
+	// paris to london
+	d := geoDistance(48.8566, 2.3522, 51.5074, -0.1278)
+	if math.Abs(d-343.5) > 1 {
+		t.Errorf("expected about 343.5 km, got %f", d)
+	}
+}
+
+func TestSQLFunctions(t *testing.T) {
+	err := RegisterSQLFunction("double_it", func(x int64) int64 { return x * 2 }, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterSQLFunction("bad", 1, true); err == nil {
+		t.Error("expected error for non func")
+	}
+	if err := RegisterSQLAggregate("bad", func() int { return 1 }, true); err == nil {
+		t.Error("expected error for aggregate without Step")
+	}
+
+	db, err := sql.Open(sqlDriver, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var (
+		slug, week, digest string
+		doubled            int64
+		median             float64
+	)
+	row := db.QueryRow("SELECT slugify('Hello World'), iso_week('2021-01-04'), hash('abc', 'md5'), double_it(21)")
+	if err := row.Scan(&slug, &week, &digest, &doubled); err != nil {
+		t.Fatal(err)
+	}
+	if slug != "hello-world" || week != "2021-W01" || digest != "900150983cd24fb0d6963f7d28e17f72" || doubled != 42 {
+		t.Errorf("unexpected result %s %s %s %d", slug, week, digest, doubled)
+	}
+
+	row = db.QueryRow("SELECT median(value) FROM json_each('[5, 1, null, 3, 10]')")
+	if err := row.Scan(&median); err != nil {
+		t.Fatal(err)
+	}
+	if median != 4 {
+		t.Errorf("expected median 4, got %f", median)
+	}
+
+	if _, err := db.Exec("SELECT hash('abc', 'crc')"); err == nil || !strings.Contains(err.Error(), "unknown algorithm") {
+		t.Errorf("expected unknown algorithm error, got %v", err)
+	}
+}
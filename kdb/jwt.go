@@ -0,0 +1,271 @@
+package kdb
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// JWTConfig configures the Bearer tokens accepted by the server. A token is
+// valid when one of the keys verifies its signature, it hasn't expired and
+// its nbf has passed, both with ClockSkew seconds of tolerance.
+type JWTConfig struct {
+	Keys []JWTKey `json:"keys"`
+	// NameClaim and RolesClaim are the claims of the user name, "sub" by
+	// default, and of its roles, "roles" by default. A dotted path reads a
+	// nested claim, e.g. "realm_access.roles".
+	NameClaim  string `json:"name_claim"`
+	RolesClaim string `json:"roles_claim"`
+	ClockSkew  int    `json:"clock_skew"`
+}
+
+// JWTKey is a key tokens are signed with, a Secret for the HS algorithms or
+// a PEM encoded PublicKey for the RS and ES ones. A token with a kid header
+// is only verified by the key with that ID.
+type JWTKey struct {
+	ID        string `json:"kid,omitempty"`
+	Algorithm string `json:"alg"`
+	Secret    string `json:"secret,omitempty"`
+	PublicKey string `json:"public_key,omitempty"`
+}
+
+var jwtHashes = map[string]crypto.Hash{
+	"256": crypto.SHA256,
+	"384": crypto.SHA384,
+	"512": crypto.SHA512,
+}
+
+// jwtCurves are the curves of the ES algorithms, ES512 being P-521.
+var jwtCurves = map[string]elliptic.Curve{
+	"ES256": elliptic.P256(),
+	"ES384": elliptic.P384(),
+	"ES512": elliptic.P521(),
+}
+
+type jwtVerifier struct {
+	id        string
+	algorithm string
+	hash      crypto.Hash
+	secret    []byte
+	publicKey interface{}
+}
+
+func newJWTVerifier(key JWTKey) (*jwtVerifier, error) {
+	if len(key.Algorithm) != 5 {
+		return nil, fmt.Errorf("jwt key %q: unsupported algorithm %q", key.ID, key.Algorithm)
+	}
+	hash, ok := jwtHashes[key.Algorithm[2:]]
+	if !ok {
+		return nil, fmt.Errorf("jwt key %q: unsupported algorithm %q", key.ID, key.Algorithm)
+	}
+	verifier := &jwtVerifier{id: key.ID, algorithm: key.Algorithm, hash: hash}
+
+	switch key.Algorithm[:2] {
+	case "HS":
+		if key.Secret == "" {
+			return nil, fmt.Errorf("jwt key %q: %s needs a secret", key.ID, key.Algorithm)
+		}
+		verifier.secret = []byte(key.Secret)
+		return verifier, nil
+	case "RS", "ES":
+		block, _ := pem.Decode([]byte(key.PublicKey))
+		if block == nil {
+			return nil, fmt.Errorf("jwt key %q: %s needs a PEM public key", key.ID, key.Algorithm)
+		}
+		publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("jwt key %q: %s", key.ID, err)
+		}
+		switch publicKey := publicKey.(type) {
+		case *rsa.PublicKey:
+			ok = key.Algorithm[:2] == "RS"
+		case *ecdsa.PublicKey:
+			ok = jwtCurves[key.Algorithm] == publicKey.Curve
+		default:
+			ok = false
+		}
+		if !ok {
+			return nil, fmt.Errorf("jwt key %q: the public key doesn't match %s", key.ID, key.Algorithm)
+		}
+		verifier.publicKey = publicKey
+		return verifier, nil
+	}
+	return nil, fmt.Errorf("jwt key %q: unsupported algorithm %q", key.ID, key.Algorithm)
+}
+
+func (verifier *jwtVerifier) verify(signed, signature []byte) bool {
+	if verifier.secret != nil {
+		mac := hmac.New(verifier.hash.New, verifier.secret)
+		mac.Write(signed)
+		return hmac.Equal(signature, mac.Sum(nil))
+	}
+
+	h := verifier.hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+	switch publicKey := verifier.publicKey.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(publicKey, verifier.hash, digest, signature) == nil
+	case *ecdsa.PublicKey:
+		// the signature is r and s, each the size of the curve
+		size := (publicKey.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		return ecdsa.Verify(publicKey, digest, r, s)
+	}
+	return false
+}
+
+// loadJWTVerifiers parses the keys of the config, nil without a JWT config.
+func loadJWTVerifiers(config *JWTConfig) ([]*jwtVerifier, error) {
+	if config == nil {
+		return nil, nil
+	}
+	var verifiers []*jwtVerifier
+	for _, key := range config.Keys {
+		verifier, err := newJWTVerifier(key)
+		if err != nil {
+			return nil, err
+		}
+		verifiers = append(verifiers, verifier)
+	}
+	return verifiers, nil
+}
+
+// AuthenticateToken returns the user of a JWT Bearer token, the user named
+// by its name claim with the roles of its roles claim.
+func (kdb *Engine) AuthenticateToken(token string) (*User, error) {
+	if len(kdb.jwtVerifiers) == 0 {
+		return nil, fmt.Errorf("%s: %w", "bearer tokens aren't accepted", ErrUnauthorized)
+	}
+	claims, err := kdb.verifyJWT(token)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", err, ErrUnauthorized)
+	}
+
+	now := time.Now().Unix()
+	skew := int64(kdb.config.JWT.ClockSkew)
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", "token without exp", ErrUnauthorized)
+	}
+	if now > int64(exp)+skew {
+		return nil, fmt.Errorf("%s: %w", "token expired", ErrUnauthorized)
+	}
+	if nbf, ok := claims["nbf"]; ok {
+		nbf, ok := nbf.(float64)
+		if !ok || now < int64(nbf)-skew {
+			return nil, fmt.Errorf("%s: %w", "token not valid yet", ErrUnauthorized)
+		}
+	}
+
+	nameClaim, rolesClaim := kdb.config.JWT.NameClaim, kdb.config.JWT.RolesClaim
+	if nameClaim == "" {
+		nameClaim = "sub"
+	}
+	if rolesClaim == "" {
+		rolesClaim = "roles"
+	}
+	name, _ := jwtClaim(claims, nameClaim).(string)
+	if name == "" {
+		return nil, fmt.Errorf("%s: %w", "token without "+nameClaim, ErrUnauthorized)
+	}
+	user := &User{Name: name, Roles: []string{}}
+	if roles := jwtClaim(claims, rolesClaim); roles != nil {
+		items, ok := roles.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s: %w", rolesClaim+" has to be an array of strings", ErrUnauthorized)
+		}
+		for _, item := range items {
+			role, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s: %w", rolesClaim+" has to be an array of strings", ErrUnauthorized)
+			}
+			user.Roles = append(user.Roles, role)
+		}
+	}
+	return user, nil
+}
+
+// verifyJWT checks the signature of a compact JWT and returns its claims.
+func (kdb *Engine) verifyJWT(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	header := struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}{}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed token")
+	}
+
+	// the algorithm of the key has to be the one of the token, a token
+	// can't pick how it's verified
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, verifier := range kdb.jwtVerifiers {
+		if verifier.algorithm != header.Algorithm || header.KeyID != "" && verifier.id != header.KeyID {
+			continue
+		}
+		if verifier.verify(signed, signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, errors.New("invalid token signature")
+	}
+
+	var claims map[string]interface{}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return errors.New("malformed token")
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return errors.New("malformed token")
+	}
+	return nil
+}
+
+// jwtClaim returns the claim at a dotted path, nil when it's missing.
+func jwtClaim(claims map[string]interface{}, path string) interface{} {
+	var value interface{} = claims
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[key]
+	}
+	return value
+}
@@ -0,0 +1,123 @@
+package kdb
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func testSignJWT(t *testing.T, header, claims string, sign func([]byte) []byte) string {
+	signed := base64.RawURLEncoding.EncodeToString([]byte(header)) + "." + base64.RawURLEncoding.EncodeToString([]byte(claims))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(signed)))
+}
+
+func testPublicKeyPEM(t *testing.T, publicKey interface{}) string {
+	b, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: b}))
+}
+
+func TestAuthenticateToken(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	config := DefaultConfig()
+	config.JWT = &JWTConfig{
+		Keys: []JWTKey{
+			{ID: "gateway", Algorithm: "HS256", Secret: "hmac-secret"},
+			{ID: "rsa", Algorithm: "RS256", PublicKey: testPublicKeyPEM(t, &rsaKey.PublicKey)},
+			{Algorithm: "ES256", PublicKey: testPublicKeyPEM(t, &ecKey.PublicKey)},
+		},
+		RolesClaim: "realm_access.roles",
+		ClockSkew:  30,
+	}
+	kdb, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kdb.Close()
+
+	hs256 := func(secret string) func([]byte) []byte {
+		return func(b []byte) []byte {
+			mac := hmac.New(sha256.New, []byte(secret))
+			mac.Write(b)
+			return mac.Sum(nil)
+		}
+	}
+	rs256 := func(b []byte) []byte {
+		digest := sha256.Sum256(b)
+		signature, _ := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+		return signature
+	}
+	es256 := func(b []byte) []byte {
+		digest := sha256.Sum256(b)
+		r, s, _ := ecdsa.Sign(rand.Reader, ecKey, digest[:])
+		signature := make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+		return signature
+	}
+
+	now := time.Now().Unix()
+	claims := fmt.Sprintf(`{"sub":"testalice","exp":%d,"realm_access":{"roles":["sales"]}}`, now+60)
+	valid := []string{
+		testSignJWT(t, `{"alg":"HS256","kid":"gateway"}`, claims, hs256("hmac-secret")),
+		testSignJWT(t, `{"alg":"HS256"}`, claims, hs256("hmac-secret")),
+		testSignJWT(t, `{"alg":"RS256","kid":"rsa"}`, claims, rs256),
+		testSignJWT(t, `{"alg":"ES256"}`, claims, es256),
+		// expired and not yet valid within the clock skew
+		testSignJWT(t, `{"alg":"HS256"}`, fmt.Sprintf(`{"sub":"testalice","exp":%d,"nbf":%d,"realm_access":{"roles":["sales"]}}`, now-10, now+10), hs256("hmac-secret")),
+	}
+	for i, token := range valid {
+		user, err := kdb.AuthenticateToken(token)
+		if err != nil || user.Name != "testalice" || !user.HasRole("sales") {
+			t.Errorf("token %d: expected testalice, got %+v %v", i, user, err)
+		}
+	}
+
+	invalid := []string{
+		"not.a.token",
+		testSignJWT(t, `{"alg":"HS256"}`, claims, hs256("wrong-secret")),
+		testSignJWT(t, `{"alg":"HS256","kid":"rsa"}`, claims, hs256("hmac-secret")),
+		testSignJWT(t, `{"alg":"RS256"}`, claims, hs256("hmac-secret")),
+		testSignJWT(t, `{"alg":"none"}`, claims, func([]byte) []byte { return nil }),
+		testSignJWT(t, `{"alg":"HS256"}`, fmt.Sprintf(`{"sub":"testalice","exp":%d}`, now-60), hs256("hmac-secret")),
+		testSignJWT(t, `{"alg":"HS256"}`, fmt.Sprintf(`{"sub":"testalice","exp":%d,"nbf":%d}`, now+120, now+60), hs256("hmac-secret")),
+		testSignJWT(t, `{"alg":"HS256"}`, `{"sub":"testalice"}`, hs256("hmac-secret")),
+		testSignJWT(t, `{"alg":"HS256"}`, fmt.Sprintf(`{"exp":%d}`, now+60), hs256("hmac-secret")),
+		testSignJWT(t, `{"alg":"HS256"}`, fmt.Sprintf(`{"sub":"testalice","exp":%d,"realm_access":{"roles":"sales"}}`, now+60), hs256("hmac-secret")),
+	}
+	for i, token := range invalid {
+		if user, err := kdb.AuthenticateToken(token); !errors.Is(err, ErrUnauthorized) {
+			t.Errorf("token %d: expected %s, got %+v %v", i, ErrUnauthorized, user, err)
+		}
+	}
+
+	if _, err := loadJWTVerifiers(&JWTConfig{Keys: []JWTKey{{Algorithm: "ES256", PublicKey: testPublicKeyPEM(t, &rsaKey.PublicKey)}}}); err == nil {
+		t.Error("expected an rsa key to be rejected for ES256")
+	}
+	// each ES algorithm has its own curve
+	for alg, curve := range map[string]elliptic.Curve{"ES256": elliptic.P384(), "ES384": elliptic.P256(), "ES512": elliptic.P384()} {
+		key, _ := ecdsa.GenerateKey(curve, rand.Reader)
+		if _, err := loadJWTVerifiers(&JWTConfig{Keys: []JWTKey{{Algorithm: alg, PublicKey: testPublicKeyPEM(t, &key.PublicKey)}}}); err == nil {
+			t.Errorf("expected a %s key to be rejected for %s", curve.Params().Name, alg)
+		}
+	}
+	p521Key, _ := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	if _, err := loadJWTVerifiers(&JWTConfig{Keys: []JWTKey{{Algorithm: "ES512", PublicKey: testPublicKeyPEM(t, &p521Key.PublicKey)}}}); err != nil {
+		t.Error(err)
+	}
+}
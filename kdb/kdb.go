@@ -0,0 +1,1071 @@
+// Package kdb is the kdb3 document database engine, with sqlite3 as storage
+// and view engine. An Engine manages named databases, their documents and
+// materialized views, and can be embedded without the http server.
+package kdb
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/valyala/fastjson"
+)
+
+var dbExt = ".db"
+
+type Engine struct {
+	dbPath   string
+	viewPath string
+
+	dbs            map[string]*Database
+	copying        map[string]string
+	rwmux          sync.RWMutex
+	serviceLocator ServiceLocator
+	fileHandler    FileHandler
+	localDB        *LocalDB
+	config         *Config
+	done           chan struct{}
+	replicator     *replicator
+	backupMux      sync.Mutex
+	secret         []byte
+	jwtVerifiers   []*jwtVerifier
+	auditLog       AuditLog
+	keyProvider    KeyProvider
+}
+
+// New creates an engine with the given config, DefaultConfig when nil.
+func New(config *Config) (*Engine, error) {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	kdb := new(Engine)
+	kdb.dbs = make(map[string]*Database)
+	kdb.copying = make(map[string]string)
+	kdb.rwmux = sync.RWMutex{}
+	kdb.config = config
+	kdb.dbPath = config.DBPath
+	kdb.viewPath = config.ViewPath
+	kdb.serviceLocator = NewServiceLocatorWithConfig(config)
+	kdb.localDB = &LocalDB{}
+	kdb.auditLog = kdb.serviceLocator.GetAuditLog()
+	kdb.keyProvider = kdb.serviceLocator.GetKeyProvider()
+	kdb.secret = []byte(config.Secret)
+	if len(kdb.secret) == 0 {
+		kdb.secret = randomBytes(32)
+	}
+	jwtVerifiers, err := loadJWTVerifiers(config.JWT)
+	if err != nil {
+		return nil, err
+	}
+	if err := config.CORS.validate(); err != nil {
+		return nil, err
+	}
+	kdb.jwtVerifiers = jwtVerifiers
+
+	fileHandler := kdb.serviceLocator.GetFileHandler()
+
+	if !fileHandler.IsFileExists(kdb.dbPath) {
+		if err := fileHandler.MkdirAll(kdb.dbPath); err != nil {
+			return nil, err
+		}
+	}
+
+	if !fileHandler.IsFileExists(kdb.viewPath) {
+		if err := fileHandler.MkdirAll(kdb.viewPath); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := kdb.localDB.Open(kdb.dbPath); err != nil {
+		return nil, err
+	}
+
+	// databases are opened on first access, and closed again once idle
+	kdb.done = make(chan struct{})
+	if config.IdleTimeout > 0 {
+		go kdb.closeIdleLoop(time.Duration(config.IdleTimeout) * time.Second)
+	}
+
+	// _users is created on first start and opened on first access
+	if err := kdb.Open(UsersDB, true); err != nil && !errors.Is(err, ErrDBExists) {
+		return nil, err
+	}
+
+	if config.ReplicatorInterval > 0 {
+		err := kdb.Open(ReplicatorDB, true)
+		if errors.Is(err, ErrDBExists) {
+			err = kdb.Open(ReplicatorDB, false)
+		}
+		if err != nil {
+			return nil, err
+		}
+		kdb.replicator = newReplicator(kdb, time.Duration(config.ReplicatorInterval)*time.Second)
+		kdb.replicator.start()
+	}
+
+	return kdb, nil
+}
+
+// DebugAddr returns where the debug endpoints are served, "" for Addr, and
+// false when they're disabled. Without admins everybody is one, so they're
+// only served on a listener of their own.
+func (kdb *Engine) DebugAddr() (string, bool) {
+	if kdb.config.DebugAddr == "" && len(kdb.config.Admins) == 0 {
+		return "", false
+	}
+	return kdb.config.DebugAddr, kdb.config.Debug != DebugOff
+}
+
+// CORS returns the cross-origin requests allowed, nil when there are none.
+func (kdb *Engine) CORS() *CORSConfig {
+	return kdb.config.CORS
+}
+
+// Close stops the replications, the idle loop and closes every open
+// database.
+func (kdb *Engine) Close() error {
+	if kdb.replicator != nil {
+		kdb.replicator.stop()
+	}
+
+	kdb.rwmux.Lock()
+	defer kdb.rwmux.Unlock()
+
+	close(kdb.done)
+	for name, db := range kdb.dbs {
+		db.Close()
+		delete(kdb.dbs, name)
+	}
+	if kdb.auditLog != nil {
+		kdb.auditLog.Close()
+	}
+	return kdb.localDB.Close()
+}
+
+func (kdb *Engine) ListDataBases() ([]string, error) {
+	kdb.localDB.Begin()
+	defer kdb.localDB.Commit()
+	return kdb.localDB.List()
+}
+
+func (kdb *Engine) Open(name string, createIfNotExists bool) error {
+	return kdb.open(context.Background(), name, createIfNotExists, StorageFile)
+}
+
+// CreateWithStorage creates a database in the named storage, StorageFile or
+// StorageMemory. Memory databases are lost when the engine stops.
+func (kdb *Engine) CreateWithStorage(name, storage string) error {
+	return kdb.CreateDatabase(context.Background(), name, storage)
+}
+
+// CreateDatabase is CreateWithStorage for the user of ctx.
+func (kdb *Engine) CreateDatabase(ctx context.Context, name, storage string) error {
+	if storage == "" {
+		storage = StorageFile
+	}
+	if _, ok := kdb.serviceLocator.GetStorage(storage); !ok {
+		return ErrInvalidStorage
+	}
+	return kdb.open(ctx, name, true, storage)
+}
+
+func (kdb *Engine) open(ctx context.Context, name string, createIfNotExists bool, storageName string) error {
+	if !validateDBName(name) {
+		return ErrDBInvalidName
+	}
+
+	kdb.rwmux.Lock()
+	defer kdb.rwmux.Unlock()
+
+	if _, ok := kdb.dbs[name]; ok && !createIfNotExists {
+		return nil
+	}
+
+	kdb.localDB.Begin()
+	defer kdb.localDB.Rollback()
+
+	fileName := name
+
+	if createIfNotExists {
+		if _, ok := kdb.copying[name]; ok {
+			return ErrDBExists
+		}
+		fileName = kdb.newFileName(name)
+		if err := kdb.localDB.Create(name, fileName, storageName); err != nil {
+			if strings.HasPrefix(err.Error(), "UNIQUE constraint failed") {
+				return ErrDBExists
+			}
+			return err
+		}
+	} else {
+		fileName, storageName = kdb.localDB.GetFileName(name)
+	}
+
+	storage, ok := kdb.serviceLocator.GetStorage(storageName)
+	if !ok {
+		return ErrDBNotFound
+	}
+
+	db, err := NewDatabaseWithStorage(name, fileName, kdb.dbPath, kdb.viewPath, createIfNotExists, kdb.serviceLocator, storage)
+	if err != nil {
+		return err
+	}
+
+	db.databaseLocator = kdb
+	db.cipher = kdb.fieldCipher(name)
+	db.touch()
+	kdb.dbs[name] = db
+
+	kdb.localDB.Commit()
+
+	kdb.closeLeastRecentlyUsed(name)
+
+	if createIfNotExists {
+		kdb.audit(ctx, &AuditRecord{Action: AuditCreateDatabase, DB: name})
+	}
+	return nil
+}
+
+// newFileName returns the file name of a new database, its name unless a
+// renamed database or a copy in progress uses it already. Caller holds the
+// write lock and the local database transaction.
+func (kdb *Engine) newFileName(name string) string {
+	isUsed := func(fileName string) bool {
+		for _, copyFileName := range kdb.copying {
+			if copyFileName == fileName {
+				return true
+			}
+		}
+		return kdb.localDB.IsFileNameUsed(fileName)
+	}
+
+	fileName := name
+	for i := 1; isUsed(fileName); i++ {
+		fileName = name + "-" + strconv.Itoa(i)
+	}
+	return fileName
+}
+
+// database returns an open database, opening it on first access. Callers
+// hold the read lock, it is released while the database is opened.
+func (kdb *Engine) database(name string) (*Database, error) {
+	if !validateDBName(name) {
+		return nil, ErrDBNotFound
+	}
+	for {
+		if db, ok := kdb.dbs[name]; ok {
+			db.touch()
+			return db, nil
+		}
+		kdb.rwmux.RUnlock()
+		err := kdb.Open(name, false)
+		kdb.rwmux.RLock()
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// closeLeastRecentlyUsed closes databases over max_open_databases, least
+// recently used first, caller must hold the write lock.
+func (kdb *Engine) closeLeastRecentlyUsed(keep string) {
+	limit := kdb.config.MaxOpenDatabases
+	if limit <= 0 || len(kdb.dbs) <= limit {
+		return
+	}
+
+	var names []string
+	for name := range kdb.dbs {
+		if name != keep {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return kdb.dbs[names[i]].lastAccessTime().Before(kdb.dbs[names[j]].lastAccessTime())
+	})
+
+	for _, name := range names {
+		if len(kdb.dbs) <= limit {
+			break
+		}
+		kdb.dbs[name].Close()
+		delete(kdb.dbs, name)
+	}
+}
+
+type openView struct {
+	db         *Database
+	name       string
+	lastAccess time.Time
+}
+
+func (kdb *Engine) openViews() []openView {
+	var views []openView
+	for _, db := range kdb.dbs {
+		for name, lastAccess := range db.viewManager.ViewAccessTimes() {
+			views = append(views, openView{db: db, name: name, lastAccess: lastAccess})
+		}
+	}
+	return views
+}
+
+// closeLeastRecentlyUsedViews closes views over max_open_views, least
+// recently used first, across all open databases.
+func (kdb *Engine) closeLeastRecentlyUsedViews() {
+	limit := kdb.config.MaxOpenViews
+	if limit <= 0 {
+		return
+	}
+
+	kdb.rwmux.RLock()
+	count := len(kdb.openViews())
+	kdb.rwmux.RUnlock()
+	if count <= limit {
+		return
+	}
+
+	kdb.rwmux.Lock()
+	defer kdb.rwmux.Unlock()
+
+	views := kdb.openViews()
+	sort.Slice(views, func(i, j int) bool {
+		return views[i].lastAccess.Before(views[j].lastAccess)
+	})
+	for _, view := range views {
+		// closing a view also closes the views built on top of it
+		if len(kdb.openViews()) <= limit {
+			break
+		}
+		view.db.viewManager.CloseView(view.name)
+	}
+}
+
+// CloseIdle closes databases and views which weren't used since before.
+func (kdb *Engine) CloseIdle(before time.Time) {
+	kdb.rwmux.Lock()
+	defer kdb.rwmux.Unlock()
+
+	for name, db := range kdb.dbs {
+		if db.lastAccessTime().Before(before) {
+			db.Close()
+			delete(kdb.dbs, name)
+		}
+	}
+
+	for _, view := range kdb.openViews() {
+		if view.lastAccess.Before(before) {
+			view.db.viewManager.CloseView(view.name)
+		}
+	}
+}
+
+func (kdb *Engine) closeIdleLoop(idleTimeout time.Duration) {
+	interval := idleTimeout / 2
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			kdb.CloseIdle(time.Now().Add(-idleTimeout))
+		case <-kdb.done:
+			return
+		}
+	}
+}
+
+func (kdb *Engine) Delete(name string) error {
+	return kdb.DeleteDatabase(context.Background(), name)
+}
+
+// DeleteDatabase is Delete for the user of ctx.
+func (kdb *Engine) DeleteDatabase(ctx context.Context, name string) error {
+	kdb.rwmux.Lock()
+	defer kdb.rwmux.Unlock()
+
+	kdb.localDB.Begin()
+	defer kdb.localDB.Rollback()
+	fileName, storageName := kdb.localDB.GetFileName(name)
+	storage, ok := kdb.serviceLocator.GetStorage(storageName)
+	if fileName == "" || !ok {
+		return ErrDBNotFound
+	}
+	kdb.localDB.Delete(name)
+
+	if db, ok := kdb.dbs[name]; ok {
+		delete(kdb.dbs, name)
+		db.Close()
+	}
+
+	deleteDBFiles(storage, kdb.dbPath, kdb.viewPath, fileName)
+
+	kdb.localDB.Commit()
+
+	kdb.audit(ctx, &AuditRecord{Action: AuditDeleteDatabase, DB: name})
+	return nil
+}
+
+// Copy clones a database into target with sqlite's online backup, name can
+// be written meanwhile. Design documents are copied with the documents, with
+// views the view files are copied as well, otherwise target builds its
+// views on first use.
+func (kdb *Engine) Copy(ctx context.Context, name, target string, withViews bool) error {
+	if !validateDBName(target) {
+		return ErrDBInvalidName
+	}
+	if err := kdb.checkMove(name, target); err != nil {
+		return err
+	}
+
+	fileName, targetFileName, storage, err := kdb.beginCopy(name, target)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		kdb.rwmux.Lock()
+		delete(kdb.copying, target)
+		kdb.rwmux.Unlock()
+	}()
+
+	paths, err := kdb.copyFiles(ctx, storage, fileName, targetFileName, withViews)
+	if err == nil {
+		security, _ := kdb.localDB.LookupSecurity(name)
+		err = kdb.endCopy(target, targetFileName, storage.Name(), security)
+	}
+	if err != nil {
+		for _, path := range paths {
+			storage.Remove(path)
+		}
+		return contextError(ctx, err)
+	}
+	kdb.audit(ctx, &AuditRecord{Action: AuditCopyDatabase, DB: name, Target: target})
+	return nil
+}
+
+// beginCopy reserves target for a copy of name until it's done.
+func (kdb *Engine) beginCopy(name, target string) (string, string, Storage, error) {
+	kdb.rwmux.Lock()
+	defer kdb.rwmux.Unlock()
+
+	kdb.localDB.Begin()
+	defer kdb.localDB.Rollback()
+
+	fileName, storageName := kdb.localDB.GetFileName(name)
+	storage, ok := kdb.serviceLocator.GetStorage(storageName)
+	if fileName == "" || !ok {
+		return "", "", nil, ErrDBNotFound
+	}
+	targetFileName, err := kdb.reserveName(target)
+	if err != nil {
+		return "", "", nil, err
+	}
+	return fileName, targetFileName, storage, nil
+}
+
+// reserveName reserves a database name for a copy or restore and returns
+// the file name it gets. Caller holds the write lock and a local db
+// transaction.
+func (kdb *Engine) reserveName(name string) (string, error) {
+	if _, ok := kdb.copying[name]; ok {
+		return "", ErrDBExists
+	}
+	if fileName, _ := kdb.localDB.GetFileName(name); fileName != "" {
+		return "", ErrDBExists
+	}
+
+	fileName := kdb.newFileName(name)
+	kdb.copying[name] = fileName
+	return fileName, nil
+}
+
+// copyFiles backs up the database file, and with views its view files, of
+// fileName to targetFileName. It returns the paths it wrote to.
+func (kdb *Engine) copyFiles(ctx context.Context, storage Storage, fileName, targetFileName string, withViews bool) ([]string, error) {
+	// the connections have to keep the journal mode of the files, sqlite
+	// can't change it while the engine has them open
+	type file struct {
+		src, dest, options string
+	}
+	var files []file
+	if withViews {
+		names, err := storage.List(kdb.viewPath)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			if strings.HasPrefix(name, fileName+"$") && strings.HasSuffix(name, dbExt) {
+				dest := targetFileName + strings.TrimPrefix(name, fileName)
+				files = append(files, file{filepath.Join(kdb.viewPath, name), filepath.Join(kdb.viewPath, dest), "_journal=MEMORY"})
+			}
+		}
+	}
+	// views go first, so they are never ahead of the documents they were
+	// built from and catch up on first use
+	files = append(files, file{filepath.Join(kdb.dbPath, fileName+dbExt), filepath.Join(kdb.dbPath, targetFileName+dbExt), "_journal=WAL"})
+
+	var paths []string
+	for _, f := range files {
+		if err := storage.Create(f.dest); err != nil {
+			return paths, err
+		}
+		paths = append(paths, f.dest)
+		src := storage.ConnectionString(f.src, f.options)
+		dest := storage.ConnectionString(f.dest, f.options)
+		if err := backupDatabase(ctx, src, dest); err != nil {
+			return paths, err
+		}
+	}
+	return paths, nil
+}
+
+// endCopy registers the copied files as target, with the security object of
+// the source.
+func (kdb *Engine) endCopy(target, targetFileName, storageName, security string) error {
+	kdb.rwmux.Lock()
+	defer kdb.rwmux.Unlock()
+
+	kdb.localDB.Begin()
+	defer kdb.localDB.Rollback()
+	if err := kdb.localDB.Create(target, targetFileName, storageName); err != nil {
+		if strings.HasPrefix(err.Error(), "UNIQUE constraint failed") {
+			return ErrDBExists
+		}
+		return err
+	}
+	if security != "" {
+		if err := kdb.localDB.PutSecurity(target, security); err != nil {
+			return err
+		}
+	}
+	return kdb.localDB.Commit()
+}
+
+// Rename gives a database a new name. Only its entry in the local database
+// changes, its files keep their names. Views of other databases which use it
+// as a source have to be updated to the new name.
+func (kdb *Engine) Rename(ctx context.Context, name, target string) error {
+	if !validateDBName(target) {
+		return ErrDBInvalidName
+	}
+	if err := kdb.checkMove(name, target); err != nil {
+		return err
+	}
+
+	kdb.rwmux.Lock()
+	defer kdb.rwmux.Unlock()
+
+	kdb.localDB.Begin()
+	defer kdb.localDB.Rollback()
+
+	if fileName, _ := kdb.localDB.GetFileName(name); fileName == "" {
+		return ErrDBNotFound
+	}
+	if _, ok := kdb.copying[target]; ok {
+		return ErrDBExists
+	}
+	if fileName, _ := kdb.localDB.GetFileName(target); fileName != "" {
+		return ErrDBExists
+	}
+	if err := kdb.localDB.Rename(name, target); err != nil {
+		return err
+	}
+
+	if db, ok := kdb.dbs[name]; ok {
+		delete(kdb.dbs, name)
+		db.Name = target
+		db.cipher = kdb.fieldCipher(target)
+		kdb.dbs[target] = db
+	}
+
+	if err := kdb.localDB.Commit(); err != nil {
+		return err
+	}
+	kdb.audit(ctx, &AuditRecord{Action: AuditRenameDatabase, DB: name, Target: target})
+	return nil
+}
+
+func (kdb *Engine) PutDocument(ctx context.Context, name string, newDoc *Document) (*Document, error) {
+	kdb.rwmux.RLock()
+	defer kdb.rwmux.RUnlock()
+	db, err := kdb.database(name)
+	if err != nil {
+		return nil, err
+	}
+	if !validateDocID(newDoc.ID) {
+		return nil, ErrDocInvalidID
+	}
+
+	if strings.HasPrefix(newDoc.ID, "_design/") {
+		if err := kdb.authorizeDesignDocument(ctx, name); err != nil {
+			return nil, err
+		}
+		if err := kdb.authorizeDecrypt(name, newDoc); err != nil {
+			return nil, err
+		}
+		newDoc.Kind = "design"
+		err := db.ValidateDesignDocument(ctx, newDoc)
+		if err != nil {
+			return nil, contextError(ctx, err)
+		}
+		if newDoc.Deleted {
+			db.viewManager.UpdateDesignDocument(newDoc)
+		}
+	} else if name == UsersDB && !newDoc.Deleted {
+		if err := prepareUserDocument(ctx, db, newDoc); err != nil {
+			return nil, contextError(ctx, err)
+		}
+	}
+
+	if _, err := db.checkQuota(ctx, kdb.quota(name), newDoc, 0); err != nil {
+		return nil, contextError(ctx, err)
+	}
+
+	doc, err := db.PutDocument(ctx, newDoc)
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	kdb.auditDocument(ctx, name, doc)
+	return doc, nil
+}
+
+func (kdb *Engine) DeleteDocument(ctx context.Context, name string, doc *Document) (*Document, error) {
+	doc.Deleted = true
+	return kdb.PutDocument(ctx, name, doc)
+}
+
+func (kdb *Engine) GetDocument(ctx context.Context, name string, doc *Document, includeDoc bool) (*Document, error) {
+	kdb.rwmux.RLock()
+	defer kdb.rwmux.RUnlock()
+	db, err := kdb.database(name)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err = db.GetDocument(ctx, doc, includeDoc)
+	return doc, contextError(ctx, err)
+}
+
+// PutReplicatedDocument writes a document replicated from another database,
+// keeping its _version, _hash, _history and _deleted. It returns false when
+// the document didn't become the current version, because the database has
+// it already or it lost a conflict.
+func (kdb *Engine) PutReplicatedDocument(ctx context.Context, name string, newDoc *Document) (bool, error) {
+	kdb.rwmux.RLock()
+	defer kdb.rwmux.RUnlock()
+	db, err := kdb.database(name)
+	if err != nil {
+		return false, err
+	}
+	if !validateDocID(newDoc.ID) || newDoc.ID == "" {
+		return false, ErrDocInvalidID
+	}
+	if newDoc.Version <= 0 {
+		return false, fmt.Errorf("%s: %w", "replicated document without _version", ErrDocInvalidInput)
+	}
+
+	if strings.HasPrefix(newDoc.ID, "_design/") {
+		if err := kdb.authorizeDesignDocument(ctx, name); err != nil {
+			return false, err
+		}
+		if err := kdb.authorizeDecrypt(name, newDoc); err != nil {
+			return false, err
+		}
+		newDoc.Kind = "design"
+		err := db.ValidateDesignDocument(ctx, newDoc)
+		if err != nil {
+			return false, contextError(ctx, err)
+		}
+		if newDoc.Deleted {
+			db.viewManager.UpdateDesignDocument(newDoc)
+		}
+	}
+
+	if _, err := db.checkQuota(ctx, kdb.quota(name), newDoc, 0); err != nil {
+		return false, contextError(ctx, err)
+	}
+
+	written, err := db.PutReplicatedDocument(ctx, newDoc)
+	if err != nil {
+		return false, contextError(ctx, err)
+	}
+	if written {
+		kdb.auditDocument(ctx, name, newDoc)
+	}
+	return written, nil
+}
+
+// GetDocumentWithHistory returns the current version of a document with its
+// hash and history, deleted versions included. Replication reads documents
+// with it.
+func (kdb *Engine) GetDocumentWithHistory(ctx context.Context, name, id string) (*Document, error) {
+	current, err := kdb.GetDocument(ctx, name, &Document{ID: id}, true)
+	if current != nil && current.Deleted {
+		return &Document{ID: current.ID, Version: current.Version, Kind: current.Kind, Deleted: true, Data: []byte("{}"), Hash: current.Hash, History: current.History}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	doc, err := ParseDocument(current.Data)
+	if err != nil {
+		return nil, err
+	}
+	doc.Hash = current.Hash
+	doc.History = current.History
+	return doc, nil
+}
+
+// GetConflicts returns the losing versions of a document as "N-hash".
+func (kdb *Engine) GetConflicts(ctx context.Context, name, id string) ([]string, error) {
+	kdb.rwmux.RLock()
+	defer kdb.rwmux.RUnlock()
+	db, err := kdb.database(name)
+	if err != nil {
+		return nil, err
+	}
+	conflicts, err := db.GetConflicts(ctx, id)
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	versions := make([]string, len(conflicts))
+	for i, conflict := range conflicts {
+		versions[i] = FormatVersion(conflict.Version, conflict.Hash)
+	}
+	return versions, nil
+}
+
+// GetConflict reads a losing version of a document, given as "N-hash".
+func (kdb *Engine) GetConflict(ctx context.Context, name, id, version string) (*Document, error) {
+	kdb.rwmux.RLock()
+	defer kdb.rwmux.RUnlock()
+	db, err := kdb.database(name)
+	if err != nil {
+		return nil, err
+	}
+	number, hash, err := ParseVersion(version)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := db.GetConflict(ctx, id, number, hash)
+	return doc, contextError(ctx, err)
+}
+
+// DeleteConflict resolves a conflict by removing the losing version, given as
+// "N-hash". To keep the content of a losing version write it over the
+// current version first.
+func (kdb *Engine) DeleteConflict(ctx context.Context, name, id, version string) error {
+	kdb.rwmux.RLock()
+	defer kdb.rwmux.RUnlock()
+	db, err := kdb.database(name)
+	if err != nil {
+		return err
+	}
+	number, hash, err := ParseVersion(version)
+	if err != nil {
+		return err
+	}
+	return contextError(ctx, db.DeleteConflict(ctx, id, number, hash))
+}
+
+func (kdb *Engine) GetLocalDocument(ctx context.Context, name, id string) ([]byte, error) {
+	kdb.rwmux.RLock()
+	defer kdb.rwmux.RUnlock()
+	db, err := kdb.database(name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := db.GetLocalDocument(ctx, id)
+	return data, contextError(ctx, err)
+}
+
+// PutLocalDocument stores a local document, a json object which isn't
+// versioned, replicated or seen by views. Replication keeps its checkpoints
+// in them.
+func (kdb *Engine) PutLocalDocument(ctx context.Context, name, id string, data []byte) error {
+	kdb.rwmux.RLock()
+	defer kdb.rwmux.RUnlock()
+	db, err := kdb.database(name)
+	if err != nil {
+		return err
+	}
+	if id == "" {
+		return ErrDocInvalidID
+	}
+	if v, err := fastjson.ParseBytes(data); err != nil || v.Type() != fastjson.TypeObject {
+		return fmt.Errorf("%s: %w", "payload expected as json object", ErrBadJSON)
+	}
+	return contextError(ctx, db.PutLocalDocument(ctx, id, data))
+}
+
+// BulkDocuments writes the documents of {"_docs":[...]}, with
+// "new_edits":false they are written as replicated documents.
+func (kdb *Engine) BulkDocuments(ctx context.Context, name string, body []byte) ([]byte, error) {
+	fValues, err := fastjson.ParseBytes(body)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", err, ErrBadJSON)
+	}
+	replicated := fValues.Exists("new_edits") && !fValues.GetBool("new_edits")
+	outputs, _ := fastjson.ParseBytes([]byte("[]"))
+	for idx, item := range fValues.GetArray("_docs") {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		inputDoc, _ := ParseDocument([]byte(item.String()))
+		var jsonb []byte
+		var outputDoc *Document
+		if replicated {
+			_, err = kdb.PutReplicatedDocument(ctx, name, inputDoc)
+			outputDoc = inputDoc
+		} else {
+			outputDoc, err = kdb.PutDocument(ctx, name, inputDoc)
+		}
+		if err != nil {
+			code, reason := ErrorString(err)
+			jsonb = []byte(fmt.Sprintf(`{"error":"%s","reason":"%s"}`, code, reason))
+		} else {
+			jsonb = []byte(FormatDocString(outputDoc.ID, outputDoc.Version, outputDoc.Deleted))
+		}
+		v := fastjson.MustParse(string(jsonb))
+		outputs.SetArrayItem(idx, v)
+	}
+	return []byte(outputs.String()), nil
+}
+
+func (kdb *Engine) BulkGetDocuments(ctx context.Context, name string, body []byte) ([]byte, error) {
+	fValues, err := fastjson.ParseBytes(body)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", err, ErrBadJSON)
+	}
+	// replication reads the current versions with their history, deleted
+	// ones included
+	history := fValues.GetBool("history")
+	outputs, _ := fastjson.ParseBytes([]byte("[]"))
+	for idx, item := range fValues.GetArray("_docs") {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		inputDoc, _ := ParseDocument([]byte(item.String()))
+		var jsonb []byte
+		var outputDoc *Document
+		if history {
+			outputDoc, err = kdb.GetDocumentWithHistory(ctx, name, inputDoc.ID)
+		} else {
+			outputDoc, err = kdb.GetDocument(ctx, name, inputDoc, true)
+		}
+		if err != nil {
+			code, reason := ErrorString(err)
+			jsonb = []byte(fmt.Sprintf(`{"error":"%s","reason":"%s"}`, code, reason))
+		} else if history {
+			jsonb = formatDocument(outputDoc)
+		} else {
+			jsonb = outputDoc.Data
+		}
+		v := fastjson.MustParse(string(jsonb))
+		outputs.SetArrayItem(idx, v)
+	}
+	return []byte(outputs.String()), nil
+}
+
+func (kdb *Engine) DBStat(name string) (*DBStat, error) {
+	kdb.rwmux.RLock()
+	defer kdb.rwmux.RUnlock()
+	db, err := kdb.database(name)
+	if err != nil {
+		return nil, err
+	}
+	return db.GetStat(), nil
+}
+
+func (kdb *Engine) Vacuum(ctx context.Context, name string) error {
+	kdb.rwmux.RLock()
+	defer kdb.rwmux.RUnlock()
+	db, err := kdb.database(name)
+	if err != nil {
+		return err
+	}
+
+	db.viewManager.Vacuum()
+	return contextError(ctx, db.Vacuum(ctx))
+}
+
+func (kdb *Engine) Changes(ctx context.Context, name string, since string, limit int) ([]byte, error) {
+	kdb.rwmux.RLock()
+	defer kdb.rwmux.RUnlock()
+	db, err := kdb.database(name)
+	if err != nil {
+		return nil, err
+	}
+	if limit == 0 {
+		limit = 10000
+	}
+	rs, err := db.GetChanges(ctx, since, limit)
+	return rs, contextError(ctx, err)
+}
+
+func (kdb *Engine) SelectView(ctx context.Context, dbName, designDocID, viewName, selectName string, values url.Values, stale bool) ([]byte, error) {
+	rs, err := kdb.selectView(ctx, dbName, designDocID, viewName, selectName, values, stale)
+	kdb.closeLeastRecentlyUsedViews()
+	return rs, contextError(ctx, err)
+}
+
+func (kdb *Engine) selectView(ctx context.Context, dbName, designDocID, viewName, selectName string, values url.Values, stale bool) ([]byte, error) {
+	kdb.rwmux.RLock()
+	defer kdb.rwmux.RUnlock()
+	db, err := kdb.database(dbName)
+	if err != nil {
+		return nil, err
+	}
+
+	rs, err := db.SelectView(ctx, designDocID, viewName, selectName, values, stale)
+	if err != nil {
+		return nil, err
+	}
+
+	return rs, nil
+}
+
+// GetDatabaseURI returns the uri views attach a database with, open or not.
+// It's called from view managers while the engine lock is already held.
+func (kdb *Engine) GetDatabaseURI(name string) (string, error) {
+	if db, ok := kdb.dbs[name]; ok {
+		return db.Storage().AttachURI(db.DBPath)
+	}
+	fileName, storageName := kdb.localDB.Lookup(name)
+	storage, ok := kdb.serviceLocator.GetStorage(storageName)
+	if fileName == "" || !ok {
+		return "", ErrDBNotFound
+	}
+	return storage.AttachURI(filepath.Join(kdb.dbPath, fileName+dbExt))
+}
+
+func (kdb *Engine) TestDesignDocument(ctx context.Context, name string, body []byte) ([]byte, error) {
+	kdb.rwmux.RLock()
+	defer kdb.rwmux.RUnlock()
+	db, err := kdb.database(name)
+	if err != nil {
+		return nil, err
+	}
+
+	input := struct {
+		Design json.RawMessage            `json:"design"`
+		Docs   []json.RawMessage          `json:"docs"`
+		Params map[string]json.RawMessage `json:"params"`
+	}{}
+	if err := json.Unmarshal(body, &input); err != nil {
+		return nil, fmt.Errorf("%s:%w", err, ErrBadJSON)
+	}
+
+	ddoc, err := ParseDocument(input.Design)
+	if err != nil {
+		return nil, err
+	}
+	// the dry run gets the same decrypt as the views
+	if err := kdb.authorizeDecrypt(name, ddoc); err != nil {
+		return nil, err
+	}
+
+	var docs []*Document
+	for _, item := range input.Docs {
+		doc, err := ParseDocument(item)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+
+	values := url.Values{}
+	for name, raw := range input.Params {
+		var items []json.RawMessage
+		if err := json.Unmarshal(raw, &items); err != nil {
+			items = []json.RawMessage{raw}
+		}
+		for _, item := range items {
+			var text string
+			if err := json.Unmarshal(item, &text); err != nil {
+				text = string(item)
+			}
+			values.Add(name, text)
+		}
+	}
+
+	result, err := db.TestDesignDocument(ctx, ddoc, docs, values)
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return json.Marshal(result)
+}
+
+func (kdb *Engine) Info() []byte {
+	var version, sqliteSourceID string
+	con, _ := sql.Open("sqlite3", ":memory:")
+	row := con.QueryRow("SELECT sqlite_version(), sqlite_source_id()")
+	row.Scan(&version, &sqliteSourceID)
+	con.Close()
+	return []byte(fmt.Sprintf(`{"name":"kdb","version":{"sqlite_version":"%s","sqlite_source_id":"%s"}}`, version, sqliteSourceID))
+}
+
+func deleteDBFiles(storage Storage, dbPath, viewPath, dbname string) {
+	list, _ := storage.List(viewPath)
+	for _, name := range list {
+		if strings.HasPrefix(name, dbname+"$") && strings.HasSuffix(name, dbExt) {
+			storage.Remove(filepath.Join(viewPath, name))
+		}
+	}
+
+	storage.Remove(filepath.Join(dbPath, dbname+dbExt))
+}
+
+// contextError returns err wrapping the context error once ctx is done, an
+// interrupted sqlite statement only reports "interrupted".
+func contextError(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil && !errors.Is(err, ctx.Err()) {
+		return fmt.Errorf("%s: %w", err, ctx.Err())
+	}
+	return err
+}
+
+// systemDatabases are the databases of the engine itself, only they can
+// start with an underscore.
+var systemDatabases = map[string]bool{ReplicatorDB: true, UsersDB: true}
+
+// checkMove checks the documents of name can be copied or renamed to target.
+// System databases stay where they are, and encrypted fields can only move
+// to a database encrypting the same fields with the same key.
+func (kdb *Engine) checkMove(name, target string) error {
+	if systemDatabases[name] || systemDatabases[target] {
+		return fmt.Errorf("%s: %w", "system databases can't be copied or renamed", ErrDBInvalidName)
+	}
+	if !kdb.sameEncryption(name, target) {
+		return fmt.Errorf("%s: %w", target+" doesn't encrypt the fields of "+name, ErrDBInvalidName)
+	}
+	return nil
+}
+
+func validateDBName(name string) bool {
+	if systemDatabases[name] {
+		return true
+	}
+	if len(name) <= 0 || strings.Contains(name, "$") || name[0] == '_' {
+		return false
+	}
+	return true
+}
+
+func validateDocID(id string) bool {
+	id = strings.Trim(id, " ")
+	if len(id) > 0 && !strings.HasPrefix(id, "_design/") && id[0] == '_' {
+		return false
+	}
+	return true
+}
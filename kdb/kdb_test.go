@@ -0,0 +1,1312 @@
+package kdb
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewKDBEngine(t *testing.T) {
+	kdb, err := New(nil)
+	if kdb.dbs == nil {
+		fmt.Println(err)
+		t.Failed()
+	}
+
+	if kdb.viewPath == "" || kdb.dbPath == "" {
+		t.Failed()
+	}
+}
+
+func TestCreateDatabase(t *testing.T) {
+	kdb, _ := New(nil)
+	err := kdb.Open("testdb", true)
+	if err != nil {
+		t.Error(err)
+	}
+	err = kdb.Open("testdb", true)
+	if err.Error() != "db_exists" {
+		t.Error("db not found")
+	}
+
+	if _, ok := kdb.dbs["testdb"]; !ok {
+		t.Error("db instance not found")
+	}
+
+	err = kdb.Open("t$estdb", true)
+	if err.Error() != "invalid_db_name" {
+		t.Error("db name should not accept $")
+	}
+
+	err = kdb.Delete("testdb")
+	if err != nil {
+		t.Error(err)
+	}
+
+	if _, ok := kdb.dbs["testdb"]; ok {
+		t.Error("db instance not deleted")
+	}
+}
+
+func TestListDatabases(t *testing.T) {
+	kdb, _ := New(nil)
+	err := kdb.Open("testdb1", true)
+	if err != nil {
+		t.Error(err)
+	}
+	err = kdb.Open("testdb2", true)
+	if err != nil {
+		t.Error(err)
+	}
+
+	dbs, err := kdb.ListDataBases()
+	if err != nil {
+		t.Error(err)
+	}
+	var dbCount = 0
+	for _, x := range dbs {
+		if x == "testdb1" {
+			dbCount++
+		}
+		if x == "testdb2" {
+			dbCount++
+		}
+	}
+
+	if dbCount != 2 {
+		t.Error("list databases failed")
+	}
+
+	err = kdb.Delete("testdb1")
+	if err != nil {
+		t.Error(err)
+	}
+	err = kdb.Delete("testdb2")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPutDocument(t *testing.T) {
+	kdb, _ := New(nil)
+	err := kdb.Open("testdb", true)
+	if err != nil {
+		t.Error(err)
+	}
+
+	inputDoc, _ := ParseDocument([]byte(`{"_id":"1","test":1}`))
+	doc, err := kdb.PutDocument(context.Background(), "testdb", inputDoc)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if doc.Version != 1 {
+		t.Error("verison missing")
+	}
+
+	kdb.Delete("testdb")
+}
+
+func TestGetDocument(t *testing.T) {
+	kdb, _ := New(nil)
+	err := kdb.Open("testdb", true)
+	if err != nil {
+		t.Error(err)
+	}
+
+	inputDoc, _ := ParseDocument([]byte(`{"_id":"1","test":1}`))
+	_, err = kdb.PutDocument(context.Background(), "testdb", inputDoc)
+	if err != nil {
+		t.Error(err)
+	}
+
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"2","test":1}`))
+	_, err = kdb.PutDocument(context.Background(), "testdb", inputDoc)
+	if err != nil {
+		t.Error(err)
+	}
+
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"1"}`))
+	outputDoc, err := kdb.GetDocument(context.Background(), "testdb", inputDoc, true)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if outputDoc == nil {
+		t.Error("doc not found")
+	}
+
+	if outputDoc.ID != "1" {
+		t.Error("doc not found")
+	}
+
+	ver := strconv.Itoa(outputDoc.Version)
+
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"2"}`))
+	outputDoc, err = kdb.GetDocument(context.Background(), "testdb", inputDoc, true)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if outputDoc == nil {
+		t.Error("doc not found")
+	}
+
+	if outputDoc.ID != "2" {
+		t.Error("wrong doc")
+	}
+
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"1", "_version":"` + ver + `"}`))
+	outputDoc, err = kdb.GetDocument(context.Background(), "testdb", inputDoc, true)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if outputDoc == nil {
+		t.Error("doc not found")
+	}
+
+	if outputDoc.ID != "1" {
+		t.Error("wrong doc")
+	}
+
+	stat, _ := kdb.DBStat("testdb")
+	if stat.DocCount != 3 {
+		t.Error("doc count failed")
+	}
+
+	kdb.Delete("testdb")
+}
+
+func TestDeleteDocument(t *testing.T) {
+	kdb, _ := New(nil)
+	err := kdb.Open("testdb", true)
+	if err != nil {
+		t.Error(err)
+	}
+
+	inputDoc, _ := ParseDocument([]byte(`{"_id":"1","test":1}`))
+	doc, err := kdb.PutDocument(context.Background(), "testdb", inputDoc)
+	if err != nil {
+		t.Error(err)
+	}
+
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"2","test":1}`))
+	doc, err = kdb.PutDocument(context.Background(), "testdb", inputDoc)
+	if err != nil {
+		t.Error(err)
+	}
+
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"1", "_version":1}`))
+	doc, err = kdb.DeleteDocument(context.Background(), "testdb", inputDoc)
+	if err != nil {
+		t.Error("unable to delete doc", err)
+	}
+
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"1"}`))
+	doc, err = kdb.GetDocument(context.Background(), "testdb", inputDoc, true)
+	if err == nil || doc.Deleted == false {
+		t.Error("revision missing for deleted doc")
+	}
+
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"1","test":2}`))
+	doc, err = kdb.PutDocument(context.Background(), "testdb", inputDoc)
+	if err != nil {
+		t.Error(err)
+	}
+
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"2","test":2}`))
+	doc, err = kdb.PutDocument(context.Background(), "testdb", inputDoc)
+	if err.Error() != "doc_conflict" {
+		t.Error("doc missing")
+	}
+
+	// the design document of the views, 1 recreated and 2
+	stat, _ := kdb.DBStat("testdb")
+	if stat.DocCount != 3 || stat.DeletedDocCount != 0 {
+		t.Error("doc count failed")
+	}
+
+	kdb.Delete("testdb")
+}
+
+func TestDatabaseVaccum(t *testing.T) {
+	kdb, _ := New(nil)
+	err := kdb.Open("testdb", true)
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = kdb.Vacuum(context.Background(), "testdb")
+	if err != nil {
+		t.Error(err)
+	}
+	err = kdb.Delete("testdb")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDatabaseStat(t *testing.T) {
+	kdb, _ := New(nil)
+	err := kdb.Open("testdb", true)
+	if err != nil {
+		t.Error(err)
+	}
+
+	stat, err := kdb.DBStat("testdb")
+	if err != nil {
+		t.Error(err)
+	}
+
+	if stat.DocCount != 1 || stat.DBName != "testdb" || stat.UpdateSeq == "" {
+		t.Error("db stat err")
+	}
+
+	err = kdb.Delete("testdb")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGetDesignDocumentAllViews(t *testing.T) {
+	kdb, _ := New(nil)
+	err := kdb.Open("testdb", true)
+	if err != nil {
+		t.Error(err)
+	}
+
+	stat, err := kdb.DBStat("testdb")
+	if err != nil {
+		t.Error(err)
+	}
+
+	if stat.DocCount != 1 {
+		t.Error("db creation err")
+	}
+
+	doc, _ := ParseDocument([]byte(`{"_id":"_design/_views"}`))
+	ddoc, _ := kdb.GetDocument(context.Background(), "testdb", doc, true)
+
+	if ddoc.ID != "_design/_views" {
+		t.Error("build in view missing")
+	}
+
+	err = kdb.Delete("testdb")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+type AllDocsViewResult struct {
+	Offset int `json:"offset"`
+	Rows   []struct {
+		Key   string `json:"key"`
+		Value struct {
+			Rev string
+		} `json:"value"`
+		DocID string `json:"doc_id"`
+	} `json:"rows"`
+	TotalRows int `json:"total_rows"`
+}
+
+func TestBuildView(t *testing.T) {
+	kdb, _ := New(nil)
+	err := kdb.Open("testdb", true)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if _, ok := kdb.dbs["testdb"].viewManager.GetView("_design/_views$_all_docs"); ok {
+		t.Error("view failed")
+	}
+
+	rs, _ := kdb.SelectView(context.Background(), "testdb", "_design/_views", "_all_docs", "default", nil, false)
+	r := AllDocsViewResult{}
+	json.Unmarshal(rs, &r)
+
+	if _, ok := kdb.dbs["testdb"].viewManager.GetView("_design/_views$_all_docs"); !ok {
+		t.Error("view failed")
+	}
+
+	if len(r.Rows) != 1 {
+		t.Error("row count failed", r.Rows)
+	}
+
+	inputDoc, _ := ParseDocument([]byte(`{"_id":"1","test":1}`))
+	_, err = kdb.PutDocument(context.Background(), "testdb", inputDoc)
+	if err != nil {
+		t.Error(err)
+	}
+
+	rs, _ = kdb.SelectView(context.Background(), "testdb", "_design/_views", "_all_docs", "default", nil, false)
+	r = AllDocsViewResult{}
+	json.Unmarshal(rs, &r)
+
+	if len(r.Rows) != 2 {
+		t.Error("row count failed")
+	}
+
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"2","test":1}`))
+	_, err = kdb.PutDocument(context.Background(), "testdb", inputDoc)
+	if err != nil {
+		t.Error(err)
+	}
+
+	rs, _ = kdb.SelectView(context.Background(), "testdb", "_design/_views", "_all_docs", "default", nil, false)
+	r = AllDocsViewResult{}
+	json.Unmarshal(rs, &r)
+
+	if len(r.Rows) != 3 {
+		t.Error("row count failed")
+	}
+
+	kdb.Delete("testdb")
+}
+
+func TestSelectViewArrayItems(t *testing.T) {
+	kdb, _ := New(nil)
+	kdb.Delete("testarrayitems")
+	if err := kdb.Open("testarrayitems", true); err != nil {
+		t.Fatal(err)
+	}
+	defer kdb.Delete("testarrayitems")
+	ctx := context.Background()
+
+	ddoc := `{"_id":"_design/numbers","views":{"by_n":{
+		"select":{"default":"SELECT JSON_GROUP_ARRAY(doc_id) FROM (SELECT doc_id FROM latest_documents WHERE json_extract(data, '$.n') IN ${ns:array} ORDER BY doc_id)"},
+		"params":{"ns":{"type":"array","items":"int"}}},
+		"by_n_params":{
+		"select":{"default":"SELECT JSON_GROUP_ARRAY(doc_id) FROM (SELECT doc_id FROM documents WHERE json_extract(data, '$.n') IN ${ns} ORDER BY doc_id)"},
+		"params":{"ns":{"type":"array","items":"int"}}}}}`
+	inputDoc, _ := ParseDocument([]byte(ddoc))
+	if _, err := kdb.PutDocument(ctx, "testarrayitems", inputDoc); err != nil {
+		t.Fatal(err)
+	}
+	for _, doc := range []string{`{"_id":"1","n":1}`, `{"_id":"2","n":2}`, `{"_id":"3","n":3}`} {
+		inputDoc, _ = ParseDocument([]byte(doc))
+		kdb.PutDocument(ctx, "testarrayitems", inputDoc)
+	}
+
+	// the array type is the same inline or only in the params
+	for _, view := range []string{"by_n", "by_n_params"} {
+		for _, query := range []string{"ns=1&ns=3", "ns=1,3", "ns=[1,3]", `ns=["1","3"]`} {
+			values, _ := url.ParseQuery(query)
+			rs, err := kdb.SelectView(ctx, "testarrayitems", "_design/numbers", view, "default", values, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(rs) != `["1","3"]` {
+				t.Errorf("%s %s: expected %s, got %s", view, query, `["1","3"]`, rs)
+			}
+		}
+	}
+}
+
+func TestBuildViewWithSources(t *testing.T) {
+	kdb, _ := New(nil)
+	if err := kdb.Open("testorders", true); err != nil {
+		t.Error(err)
+	}
+	if err := kdb.Open("testcustomers", true); err != nil {
+		t.Error(err)
+	}
+
+	ddoc := `{"_id":"_design/orders","views":{"by_customer":{
+		"sources":{"crm":"testcustomers"},
+		"setup":["CREATE TABLE IF NOT EXISTS orders (doc_id, customer, PRIMARY KEY(doc_id))","CREATE TABLE IF NOT EXISTS customers (doc_id, name, PRIMARY KEY(doc_id))"],
+		"run":["INSERT OR REPLACE INTO orders SELECT doc_id, json_extract(data, '$.customer') FROM latest_documents WHERE deleted = 0 AND doc_id NOT LIKE '_design/%'",
+			"INSERT OR REPLACE INTO customers SELECT doc_id, json_extract(data, '$.name') FROM latest_documents_crm WHERE deleted = 0 AND doc_id NOT LIKE '_design/%'"],
+		"select":{"default":"SELECT JSON_GROUP_ARRAY(name) FROM (SELECT c.name FROM orders o JOIN customers c ON c.doc_id = o.customer ORDER BY o.doc_id)"}}}}`
+	inputDoc, _ := ParseDocument([]byte(ddoc))
+	if _, err := kdb.PutDocument(context.Background(), "testorders", inputDoc); err != nil {
+		t.Error(err)
+	}
+
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"c1","name":"alice"}`))
+	kdb.PutDocument(context.Background(), "testcustomers", inputDoc)
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"o1","customer":"c1"}`))
+	kdb.PutDocument(context.Background(), "testorders", inputDoc)
+
+	rs, err := kdb.SelectView(context.Background(), "testorders", "_design/orders", "by_customer", "default", nil, false)
+	if err != nil {
+		t.Error(err)
+	}
+	if string(rs) != `["alice"]` {
+		t.Errorf("expected %s, got %s", `["alice"]`, rs)
+	}
+
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"c2","name":"bob"}`))
+	kdb.PutDocument(context.Background(), "testcustomers", inputDoc)
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"o2","customer":"c2"}`))
+	kdb.PutDocument(context.Background(), "testorders", inputDoc)
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"c1","_version":1,"name":"carol"}`))
+	kdb.PutDocument(context.Background(), "testcustomers", inputDoc)
+
+	rs, err = kdb.SelectView(context.Background(), "testorders", "_design/orders", "by_customer", "default", nil, false)
+	if err != nil {
+		t.Error(err)
+	}
+	if string(rs) != `["carol","bob"]` {
+		t.Errorf("expected %s, got %s", `["carol","bob"]`, rs)
+	}
+
+	kdb.Delete("testorders")
+	kdb.Delete("testcustomers")
+}
+
+func TestBuildChainedViews(t *testing.T) {
+	kdb, _ := New(nil)
+	if err := kdb.Open("testchain", true); err != nil {
+		t.Error(err)
+	}
+
+	ddoc := `{"_id":"_design/orders","views":{"by_customer":{
+		"setup":["CREATE TABLE IF NOT EXISTS orders (doc_id, customer, amount, PRIMARY KEY(doc_id))"],
+		"run":["INSERT OR REPLACE INTO orders SELECT doc_id, json_extract(data, '$.customer'), json_extract(data, '$.amount') FROM latest_documents WHERE deleted = 0 AND doc_id NOT LIKE '_design/%'"],
+		"select":{"default":"SELECT COUNT(1) FROM orders"}}}}`
+	inputDoc, _ := ParseDocument([]byte(ddoc))
+	if _, err := kdb.PutDocument(context.Background(), "testchain", inputDoc); err != nil {
+		t.Error(err)
+	}
+
+	ddoc = `{"_id":"_design/reports","views":{"totals":{
+		"depends":{"o":"orders/by_customer"},
+		"setup":["CREATE TABLE IF NOT EXISTS totals (customer, amount, PRIMARY KEY(customer))"],
+		"run":["DELETE FROM totals","INSERT INTO totals SELECT customer, SUM(amount) FROM o.orders GROUP BY customer"],
+		"select":{"default":"SELECT JSON_GROUP_OBJECT(customer, amount) FROM totals"}}}}`
+	inputDoc, _ = ParseDocument([]byte(ddoc))
+	if _, err := kdb.PutDocument(context.Background(), "testchain", inputDoc); err != nil {
+		t.Error(err)
+	}
+
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"o1","customer":"c1","amount":10}`))
+	kdb.PutDocument(context.Background(), "testchain", inputDoc)
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"o2","customer":"c1","amount":5}`))
+	kdb.PutDocument(context.Background(), "testchain", inputDoc)
+
+	rs, err := kdb.SelectView(context.Background(), "testchain", "_design/reports", "totals", "default", nil, false)
+	if err != nil {
+		t.Error(err)
+	}
+	if string(rs) != `{"c1":15}` {
+		t.Errorf("expected %s, got %s", `{"c1":15}`, rs)
+	}
+
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"o3","customer":"c2","amount":1}`))
+	kdb.PutDocument(context.Background(), "testchain", inputDoc)
+
+	rs, err = kdb.SelectView(context.Background(), "testchain", "_design/reports", "totals", "default", nil, false)
+	if err != nil {
+		t.Error(err)
+	}
+	if string(rs) != `{"c1":15,"c2":1}` {
+		t.Errorf("expected %s, got %s", `{"c1":15,"c2":1}`, rs)
+	}
+
+	ddoc = `{"_id":"_design/cycle","views":{"a":{"depends":{"b":"cycle/b"}},"b":{"depends":{"a":"cycle/a"}}}}`
+	inputDoc, _ = ParseDocument([]byte(ddoc))
+	if _, err := kdb.PutDocument(context.Background(), "testchain", inputDoc); !errors.Is(err, ErrViewInvalidDependency) {
+		t.Errorf("expected %s, got %v", ErrViewInvalidDependency, err)
+	}
+
+	// two aliases of one view would lock it twice while building
+	ddoc = `{"_id":"_design/twice","views":{"a":{"depends":{"x":"reports/totals","y":"_design/reports/totals"}}}}`
+	inputDoc, _ = ParseDocument([]byte(ddoc))
+	if _, err := kdb.PutDocument(context.Background(), "testchain", inputDoc); !errors.Is(err, ErrViewInvalidDependency) {
+		t.Errorf("expected %s, got %v", ErrViewInvalidDependency, err)
+	}
+
+	kdb.Delete("testchain")
+}
+
+func BenchmarkPutDocument(b *testing.B) {
+	kdb, _ := New(nil)
+	kdb.Open("testdb", true)
+	inputDoc, _ := ParseDocument([]byte(`{"test":1}`))
+
+	for x := 0; x < b.N; x++ {
+		kdb.PutDocument(context.Background(), "testdb", inputDoc)
+	}
+
+	kdb.Delete("testdb")
+}
+
+func BenchmarkParseDocument(b *testing.B) {
+	for x := 0; x < b.N; x++ {
+		ParseDocument([]byte(`{"test":1}`))
+	}
+}
+
+// TestHelperExternalViewServer is started by TestBuildExternalView as an
+// external view server, it emits the title of every document as key.
+func TestHelperExternalViewServer(t *testing.T) {
+	if os.Getenv("KDB_EXTERNAL_VIEW_HELPER") != "1" {
+		return
+	}
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var batch struct {
+			Docs []map[string]interface{} `json:"docs"`
+		}
+		json.Unmarshal(scanner.Bytes(), &batch)
+		resp := map[string][]map[string]interface{}{"upsert": {}, "delete": {}}
+		for _, doc := range batch.Docs {
+			resp["delete"] = append(resp["delete"], map[string]interface{}{"doc_id": doc["_id"]})
+			if title, ok := doc["title"]; ok && doc["_deleted"] == nil {
+				resp["upsert"] = append(resp["upsert"], map[string]interface{}{"doc_id": doc["_id"], "key": title, "value": doc["_version"]})
+			}
+		}
+		b, _ := json.Marshal(resp)
+		fmt.Println(string(b))
+	}
+	os.Exit(0)
+}
+
+func TestBuildExternalView(t *testing.T) {
+	os.Setenv("KDB_EXTERNAL_VIEW_HELPER", "1")
+	defer os.Unsetenv("KDB_EXTERNAL_VIEW_HELPER")
+
+	config := DefaultConfig()
+	config.ExternalViews = map[string][]string{"titles": {os.Args[0], "-test.run=TestHelperExternalViewServer"}}
+	kdb, _ := New(config)
+	if err := kdb.Open("testexternal", true); err != nil {
+		t.Error(err)
+	}
+
+	ddoc := `{"_id":"_design/titles","views":{"by_title":{
+		"external":{"server":"unknown"},
+		"select":{"default":"SELECT JSON_GROUP_ARRAY(key) FROM (SELECT key FROM rows ORDER BY key)"}}}}`
+	inputDoc, _ := ParseDocument([]byte(ddoc))
+	if _, err := kdb.PutDocument(context.Background(), "testexternal", inputDoc); !errors.Is(err, ErrViewInvalidExternal) {
+		t.Errorf("expected %s, got %v", ErrViewInvalidExternal, err)
+	}
+
+	ddoc = `{"_id":"_design/titles","views":{"by_title":{
+		"external":{"server":"titles","table":"titles","batch_size":2},
+		"select":{"default":"SELECT JSON_GROUP_ARRAY(key) FROM (SELECT key FROM titles ORDER BY key)"}}}}`
+	inputDoc, _ = ParseDocument([]byte(ddoc))
+	if _, err := kdb.PutDocument(context.Background(), "testexternal", inputDoc); err != nil {
+		t.Error(err)
+	}
+
+	for i, title := range []string{"c", "a", "d", "b"} {
+		inputDoc, _ = ParseDocument([]byte(fmt.Sprintf(`{"_id":"%d","title":"%s"}`, i+1, title)))
+		kdb.PutDocument(context.Background(), "testexternal", inputDoc)
+	}
+
+	rs, err := kdb.SelectView(context.Background(), "testexternal", "_design/titles", "by_title", "default", nil, false)
+	if err != nil {
+		t.Error(err)
+	}
+	if string(rs) != `["a","b","c","d"]` {
+		t.Errorf("expected %s, got %s", `["a","b","c","d"]`, rs)
+	}
+
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"1","_version":1,"title":"e"}`))
+	kdb.PutDocument(context.Background(), "testexternal", inputDoc)
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"2","_version":1}`))
+	kdb.DeleteDocument(context.Background(), "testexternal", inputDoc)
+
+	rs, err = kdb.SelectView(context.Background(), "testexternal", "_design/titles", "by_title", "default", nil, false)
+	if err != nil {
+		t.Error(err)
+	}
+	if string(rs) != `["b","d","e"]` {
+		t.Errorf("expected %s, got %s", `["b","d","e"]`, rs)
+	}
+
+	kdb.Delete("testexternal")
+}
+
+func TestLazyOpenAndIdleClose(t *testing.T) {
+	kdb, _ := New(nil)
+	kdb.Open("testlazy1", true)
+	kdb.Open("testlazy2", true)
+	inputDoc, _ := ParseDocument([]byte(`{"_id":"1","test":1}`))
+	kdb.PutDocument(context.Background(), "testlazy1", inputDoc)
+
+	config := DefaultConfig()
+	config.MaxOpenDatabases = 1
+	config.MaxOpenViews = 1
+	// the replication manager would open _replicator
+	config.ReplicatorInterval = 0
+	lazy, _ := New(config)
+	if len(lazy.dbs) != 0 {
+		t.Errorf("expected no open databases, got %d", len(lazy.dbs))
+	}
+
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"1"}`))
+	if _, err := lazy.GetDocument(context.Background(), "testlazy1", inputDoc, true); err != nil {
+		t.Error(err)
+	}
+	if _, ok := lazy.dbs["testlazy1"]; !ok {
+		t.Error("expected testlazy1 to be opened on first access")
+	}
+
+	if _, err := lazy.DBStat("testlazy2"); err != nil {
+		t.Error(err)
+	}
+	if _, ok := lazy.dbs["testlazy1"]; ok || len(lazy.dbs) != 1 {
+		t.Error("expected testlazy1 to be closed over max_open_databases")
+	}
+
+	if _, err := lazy.DBStat("testlazynotfound"); !errors.Is(err, ErrDBNotFound) {
+		t.Errorf("expected %s, got %v", ErrDBNotFound, err)
+	}
+
+	if _, err := lazy.SelectView(context.Background(), "testlazy2", "_design/_views", "_all_docs", "default", nil, false); err != nil {
+		t.Error(err)
+	}
+	if _, err := lazy.SelectView(context.Background(), "testlazy2", "_design/_views", "_all_docs", "with_docs", nil, false); err != nil {
+		t.Error(err)
+	}
+	if n := len(lazy.openViews()); n != 1 {
+		t.Errorf("expected 1 open view, got %d", n)
+	}
+
+	lazy.CloseIdle(time.Now().Add(time.Minute))
+	if len(lazy.dbs) != 0 {
+		t.Errorf("expected idle databases to be closed, got %d", len(lazy.dbs))
+	}
+
+	rs, err := lazy.SelectView(context.Background(), "testlazy1", "_design/_views", "_all_docs", "default", nil, false)
+	if err != nil {
+		t.Error(err)
+	}
+	if !strings.Contains(string(rs), `"id":"1"`) {
+		t.Errorf("unexpected result %s", rs)
+	}
+
+	lazy.Close()
+	kdb.Delete("testlazy1")
+	kdb.Delete("testlazy2")
+}
+
+func TestMemoryStorage(t *testing.T) {
+	kdb, _ := New(nil)
+	if err := kdb.CreateWithStorage("testmem", "disk"); !errors.Is(err, ErrInvalidStorage) {
+		t.Errorf("expected %s, got %v", ErrInvalidStorage, err)
+	}
+	if err := kdb.CreateWithStorage("testmem", StorageMemory); err != nil {
+		t.Error(err)
+	}
+	if err := kdb.CreateWithStorage("testmemcustomers", StorageMemory); err != nil {
+		t.Error(err)
+	}
+
+	ddoc := `{"_id":"_design/orders","views":{"by_customer":{
+		"sources":{"crm":"testmemcustomers"},
+		"setup":["CREATE TABLE IF NOT EXISTS orders (doc_id, customer, PRIMARY KEY(doc_id))","CREATE TABLE IF NOT EXISTS customers (doc_id, name, PRIMARY KEY(doc_id))"],
+		"run":["INSERT OR REPLACE INTO orders SELECT doc_id, json_extract(data, '$.customer') FROM latest_documents WHERE deleted = 0 AND doc_id NOT LIKE '_design/%'",
+			"INSERT OR REPLACE INTO customers SELECT doc_id, json_extract(data, '$.name') FROM latest_documents_crm WHERE deleted = 0 AND doc_id NOT LIKE '_design/%'"],
+		"select":{"default":"SELECT JSON_GROUP_ARRAY(name) FROM (SELECT c.name FROM orders o JOIN customers c ON c.doc_id = o.customer ORDER BY o.doc_id)"}}}}`
+	inputDoc, _ := ParseDocument([]byte(ddoc))
+	if _, err := kdb.PutDocument(context.Background(), "testmem", inputDoc); err != nil {
+		t.Error(err)
+	}
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"c1","name":"alice"}`))
+	kdb.PutDocument(context.Background(), "testmemcustomers", inputDoc)
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"o1","customer":"c1"}`))
+	kdb.PutDocument(context.Background(), "testmem", inputDoc)
+
+	rs, err := kdb.SelectView(context.Background(), "testmem", "_design/orders", "by_customer", "default", nil, false)
+	if err != nil {
+		t.Error(err)
+	}
+	if string(rs) != `["alice"]` {
+		t.Errorf("expected %s, got %s", `["alice"]`, rs)
+	}
+
+	for _, name := range []string{"data/dbs/testmem.db", "data/dbs/testmemcustomers.db"} {
+		if _, err := os.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("expected no file %s", name)
+		}
+	}
+
+	// closed memory databases keep their documents until deleted
+	kdb.CloseIdle(time.Now().Add(time.Minute))
+	stat, err := kdb.DBStat("testmem")
+	if err != nil {
+		t.Error(err)
+	} else if stat.DocCount != 3 || stat.Storage != StorageMemory {
+		t.Errorf("unexpected stat %+v", stat)
+	}
+
+	if err := kdb.Delete("testmemcustomers"); err != nil {
+		t.Error(err)
+	}
+	if err := kdb.CreateWithStorage("testmemcustomers", StorageMemory); err != nil {
+		t.Error(err)
+	}
+	stat, _ = kdb.DBStat("testmemcustomers")
+	if stat == nil || stat.DocCount != 1 {
+		t.Errorf("expected a new database, got %+v", stat)
+	}
+
+	kdb.Close()
+	restarted, _ := New(nil)
+	if _, err := restarted.DBStat("testmem"); !errors.Is(err, ErrDBNotFound) {
+		t.Errorf("expected %s, got %v", ErrDBNotFound, err)
+	}
+	restarted.Close()
+}
+
+func TestMemoryStorageReadOnlyAttachments(t *testing.T) {
+	kdb, _ := New(nil)
+	defer kdb.Close()
+	ctx := context.Background()
+	for _, name := range []string{"testmemro", "testmemrosource"} {
+		if err := kdb.CreateWithStorage(name, StorageMemory); err != nil {
+			t.Fatal(err)
+		}
+		defer kdb.Delete(name)
+	}
+	inputDoc, _ := ParseDocument([]byte(`{"_id":"1"}`))
+	kdb.PutDocument(ctx, "testmemro", inputDoc)
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"1"}`))
+	kdb.PutDocument(ctx, "testmemrosource", inputDoc)
+
+	databaseURI, _ := kdb.GetDatabaseURI("testmemro")
+	sourceURI, _ := kdb.GetDatabaseURI("testmemrosource")
+	sourceURIs := map[string]string{"crm": sourceURI}
+
+	writer := NewViewWriter("file:testmemroview?mode=memory&cache=shared", databaseURI, sourceURIs, nil, nil, []Query{{text: "CREATE TABLE IF NOT EXISTS ids (doc_id)"}}, nil)
+	if err := writer.Open(); err != nil {
+		t.Fatalf("expected the view to write its own tables, got %v", err)
+	}
+	writer.Close()
+
+	for _, write := range []string{"DELETE FROM docsdb.documents", "DELETE FROM crm.documents", "UPDATE docsdb.documents SET data = '{}'", "DROP TABLE docsdb.documents", "CREATE TABLE crm.stolen (x)", "PRAGMA docsdb.user_version = 5", "ATTACH DATABASE 'file:testmemrosource?mode=memory&cache=shared' AS other"} {
+		writer := NewViewWriter("file:testmemroview?mode=memory&cache=shared", databaseURI, sourceURIs, nil, nil, []Query{{text: write}}, nil)
+		if err := writer.Open(); err == nil {
+			t.Errorf("%s: expected the write to fail", write)
+			writer.Close()
+		}
+	}
+
+	for _, name := range []string{"testmemro", "testmemrosource"} {
+		if doc, err := kdb.GetDocument(ctx, name, &Document{ID: "1"}, true); err != nil || doc.ID != "1" {
+			t.Errorf("%s: expected the document to stay, got %v", name, err)
+		}
+	}
+}
+
+func TestSelectViewContextDeadline(t *testing.T) {
+	kdb, _ := New(nil)
+	kdb.Open("testctx", true)
+
+	ddoc := `{"_id":"_design/slow","views":{"count":{
+		"setup":["CREATE TABLE IF NOT EXISTS total (n)"],
+		"run":["INSERT INTO total SELECT (WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM c LIMIT 100000000) SELECT MAX(x) FROM c) FROM latest_documents WHERE json_extract(data, '$.slow')"],
+		"select":{"default":"SELECT JSON_GROUP_ARRAY(n) FROM total"}}}}`
+	inputDoc, _ := ParseDocument([]byte(ddoc))
+	if _, err := kdb.PutDocument(context.Background(), "testctx", inputDoc); err != nil {
+		t.Error(err)
+	}
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"1","slow":true}`))
+	kdb.PutDocument(context.Background(), "testctx", inputDoc)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := kdb.SelectView(ctx, "testctx", "_design/slow", "count", "default", nil, false)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected %s, got %v", context.DeadlineExceeded, err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("expected the build to be interrupted, took %s", time.Since(start))
+	}
+
+	// the interrupted build was rolled back
+	rs, err := kdb.SelectView(context.Background(), "testctx", "_design/slow", "count", "default", nil, true)
+	if err != nil {
+		t.Error(err)
+	}
+	if string(rs) != "[]" {
+		t.Errorf("expected %s, got %s", "[]", rs)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"_design/slow"}`))
+	if _, err := kdb.GetDocument(ctx, "testctx", inputDoc, true); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected %s, got %v", context.Canceled, err)
+	}
+
+	kdb.Delete("testctx")
+}
+
+func TestCopyAndRenameDatabase(t *testing.T) {
+	kdb, _ := New(nil)
+	kdb.Open("testcopy", true)
+
+	ddoc := `{"_id":"_design/names","views":{"all":{
+		"setup":["CREATE TABLE IF NOT EXISTS names (doc_id, name, PRIMARY KEY(doc_id))"],
+		"run":["INSERT OR REPLACE INTO names SELECT doc_id, json_extract(data, '$.name') FROM latest_documents WHERE deleted = 0 AND doc_id NOT LIKE '_design/%'"],
+		"select":{"default":"SELECT JSON_GROUP_ARRAY(name) FROM (SELECT name FROM names ORDER BY doc_id)"}}}}`
+	inputDoc, _ := ParseDocument([]byte(ddoc))
+	if _, err := kdb.PutDocument(context.Background(), "testcopy", inputDoc); err != nil {
+		t.Error(err)
+	}
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"1","name":"alice"}`))
+	kdb.PutDocument(context.Background(), "testcopy", inputDoc)
+	kdb.SelectView(context.Background(), "testcopy", "_design/names", "all", "default", nil, false)
+
+	if err := kdb.Copy(context.Background(), "testcopy", "testcopy", false); !errors.Is(err, ErrDBExists) {
+		t.Errorf("expected %s, got %v", ErrDBExists, err)
+	}
+	if err := kdb.Copy(context.Background(), "testcopymissing", "testcopy2", false); !errors.Is(err, ErrDBNotFound) {
+		t.Errorf("expected %s, got %v", ErrDBNotFound, err)
+	}
+	if err := kdb.Copy(context.Background(), "testcopy", "testcopy2", true); err != nil {
+		t.Error(err)
+	}
+
+	// the copy has the documents and the view, stale reads the copied view
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"2","name":"bob"}`))
+	kdb.PutDocument(context.Background(), "testcopy", inputDoc)
+	rs, err := kdb.SelectView(context.Background(), "testcopy2", "_design/names", "all", "default", nil, true)
+	if err != nil {
+		t.Error(err)
+	}
+	if string(rs) != `["alice"]` {
+		t.Errorf("expected %s, got %s", `["alice"]`, rs)
+	}
+	stat, _ := kdb.DBStat("testcopy2")
+	if stat == nil || stat.DocCount != 3 {
+		t.Errorf("expected 3 documents, got %+v", stat)
+	}
+
+	for _, names := range [][2]string{{UsersDB, "testcopyusers"}, {"testcopy2", ReplicatorDB}} {
+		if err := kdb.Copy(context.Background(), names[0], names[1], false); !errors.Is(err, ErrDBInvalidName) {
+			t.Errorf("copy %s: expected %s, got %v", names[0], ErrDBInvalidName, err)
+		}
+		if err := kdb.Rename(context.Background(), names[0], names[1]); !errors.Is(err, ErrDBInvalidName) {
+			t.Errorf("rename %s: expected %s, got %v", names[0], ErrDBInvalidName, err)
+		}
+	}
+
+	if err := kdb.Rename(context.Background(), "testcopy2", "testcopy"); !errors.Is(err, ErrDBExists) {
+		t.Errorf("expected %s, got %v", ErrDBExists, err)
+	}
+	if err := kdb.Rename(context.Background(), "testcopy2", "testcopy3"); err != nil {
+		t.Error(err)
+	}
+	if _, err := kdb.DBStat("testcopy2"); !errors.Is(err, ErrDBNotFound) {
+		t.Errorf("expected %s, got %v", ErrDBNotFound, err)
+	}
+
+	// renamed databases keep their files, a new database gets another one
+	kdb.CloseIdle(time.Now().Add(time.Minute))
+	if err := kdb.Open("testcopy2", true); err != nil {
+		t.Error(err)
+	}
+	stat, _ = kdb.DBStat("testcopy2")
+	if stat == nil || stat.DocCount != 1 {
+		t.Errorf("expected a new database, got %+v", stat)
+	}
+	rs, _ = kdb.SelectView(context.Background(), "testcopy3", "_design/names", "all", "default", nil, true)
+	if string(rs) != `["alice"]` {
+		t.Errorf("expected %s, got %s", `["alice"]`, rs)
+	}
+
+	kdb.Delete("testcopy")
+	kdb.Delete("testcopy2")
+	kdb.Delete("testcopy3")
+	kdb.Close()
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	kdb, _ := New(nil)
+	os.RemoveAll(filepath.Join(kdb.config.BackupPath, "testbackup"))
+	kdb.Open("testbackup", true)
+
+	ddoc := `{"_id":"_design/names","views":{"all":{
+		"setup":["CREATE TABLE IF NOT EXISTS names (doc_id, name, PRIMARY KEY(doc_id))"],
+		"run":["DELETE FROM names WHERE doc_id IN (SELECT doc_id FROM latest_changes WHERE deleted = 1)","INSERT OR REPLACE INTO names SELECT doc_id, json_extract(data, '$.name') FROM latest_documents WHERE deleted = 0 AND doc_id NOT LIKE '_design/%'"],
+		"select":{"default":"SELECT JSON_GROUP_ARRAY(name) FROM (SELECT name FROM names ORDER BY doc_id)"}}}}`
+	for _, doc := range []string{ddoc, `{"_id":"1","name":"alice"}`, `{"_id":"2","name":"bob"}`} {
+		inputDoc, _ := ParseDocument([]byte(doc))
+		kdb.PutDocument(context.Background(), "testbackup", inputDoc)
+	}
+
+	result, err := kdb.Backup(context.Background(), "testbackup", false)
+	if err != nil || result.Kind != BackupFull {
+		t.Fatalf("expected a full backup, got %+v %v", result, err)
+	}
+
+	inputDoc, _ := ParseDocument([]byte(`{"_id":"1","_version":1,"name":"alice b"}`))
+	kdb.PutDocument(context.Background(), "testbackup", inputDoc)
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"2","_version":1}`))
+	kdb.DeleteDocument(context.Background(), "testbackup", inputDoc)
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"3","name":"carol"}`))
+	kdb.PutDocument(context.Background(), "testbackup", inputDoc)
+
+	result, err = kdb.Backup(context.Background(), "testbackup", false)
+	if err != nil || result.Kind != BackupIncremental || result.Docs != 3 || result.File == "" {
+		t.Errorf("expected an incremental backup of 3 documents, got %+v %v", result, err)
+	}
+	result, err = kdb.Backup(context.Background(), "testbackup", false)
+	if err != nil || result.Docs != 0 || result.File != "" {
+		t.Errorf("expected an empty incremental backup, got %+v %v", result, err)
+	}
+
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"4","name":"dave"}`))
+	kdb.PutDocument(context.Background(), "testbackup", inputDoc)
+	kdb.Backup(context.Background(), "testbackup", false)
+
+	restored, err := kdb.Restore(context.Background(), "testbackup", "testbackuprestored")
+	if err != nil || restored.Incrementals != 2 || restored.Docs != 4 {
+		t.Fatalf("unexpected restore %+v %v", restored, err)
+	}
+	rs, err := kdb.SelectView(context.Background(), "testbackuprestored", "_design/names", "all", "default", nil, true)
+	if expected := `["alice b","carol","dave"]`; err != nil || string(rs) != expected {
+		t.Errorf("expected %s, got %s %v", expected, rs, err)
+	}
+	source, _ := kdb.GetDocumentWithHistory(context.Background(), "testbackup", "1")
+	doc, _ := kdb.GetDocumentWithHistory(context.Background(), "testbackuprestored", "1")
+	if doc == nil || doc.Version != 2 || doc.Hash != source.Hash {
+		t.Errorf("expected %+v, got %+v", source, doc)
+	}
+
+	if _, err := kdb.Restore(context.Background(), "testbackup", "testbackuprestored"); !errors.Is(err, ErrDBExists) {
+		t.Errorf("expected %s, got %v", ErrDBExists, err)
+	}
+	if _, err := kdb.Restore(context.Background(), "testbackupmissing", "testbackupmissing"); !errors.Is(err, ErrBackupNotFound) {
+		t.Errorf("expected %s, got %v", ErrBackupNotFound, err)
+	}
+
+	// a full backup starts over
+	result, err = kdb.Backup(context.Background(), "testbackup", true)
+	if err != nil || result.Kind != BackupFull {
+		t.Errorf("expected a full backup, got %+v %v", result, err)
+	}
+	if names, _ := filepath.Glob(filepath.Join(kdb.config.BackupPath, "testbackup", "incremental-*")); len(names) != 0 {
+		t.Errorf("expected the incremental backups removed, got %v", names)
+	}
+
+	os.RemoveAll(filepath.Join(kdb.config.BackupPath, "testbackup"))
+	kdb.Delete("testbackup")
+	kdb.Delete("testbackuprestored")
+	kdb.Close()
+}
+
+func TestExportAndImport(t *testing.T) {
+	kdb, _ := New(nil)
+	kdb.Open("testexport", true)
+	kdb.Open("testimport", true)
+
+	for _, doc := range []string{`{"_id":"1","name":"alice"}`, `{"_id":"2","name":"bob"}`, `{"_id":"3","name":"carol"}`} {
+		inputDoc, _ := ParseDocument([]byte(doc))
+		kdb.PutDocument(context.Background(), "testexport", inputDoc)
+	}
+	inputDoc, _ := ParseDocument([]byte(`{"_id":"1","_version":1,"name":"alice b"}`))
+	kdb.PutDocument(context.Background(), "testexport", inputDoc)
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"2","_version":1}`))
+	kdb.DeleteDocument(context.Background(), "testexport", inputDoc)
+
+	exportBatchSize = 2
+	defer func() { exportBatchSize = 1000 }()
+	var live, all strings.Builder
+	if _, docs, err := kdb.Export(context.Background(), "testexport", "", false, &live); err != nil || docs != 3 {
+		t.Fatalf("expected 3 documents, got %d %v", docs, err)
+	}
+	// the design document of _all_docs comes first
+	lines := strings.Split(live.String(), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[1], `{"_id":"3","_version":1,"_hash":`) || !strings.HasSuffix(lines[2], `"name":"alice b"}`) {
+		t.Errorf("unexpected export %s", live.String())
+	}
+	if _, docs, err := kdb.Export(context.Background(), "testexport", "", true, &all); err != nil || docs != 4 {
+		t.Fatalf("expected 4 documents, got %d %v", docs, err)
+	}
+
+	result, err := kdb.Import(context.Background(), "testimport", strings.NewReader(all.String()), true)
+	if err != nil || result.Read != 4 || result.Written != 3 || result.Skipped != 1 || result.Failures != 0 {
+		t.Fatalf("expected 3 documents written, got %+v %v", result, err)
+	}
+	source, _ := kdb.GetDocumentWithHistory(context.Background(), "testexport", "1")
+	target, _ := kdb.GetDocumentWithHistory(context.Background(), "testimport", "1")
+	if target == nil || target.Version != 2 || target.Hash != source.Hash || string(target.Data) != string(source.Data) {
+		t.Errorf("expected %+v, got %+v", source, target)
+	}
+	if _, err := kdb.GetDocument(context.Background(), "testimport", &Document{ID: "2"}, true); !errors.Is(err, ErrDocNotFound) {
+		t.Errorf("expected %s, got %v", ErrDocNotFound, err)
+	}
+
+	// both sides write version 3 of 1, importing again only conflicts on 1
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"1","_version":2,"name":"alice c"}`))
+	kdb.PutDocument(context.Background(), "testexport", inputDoc)
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"1","_version":2,"name":"alice d"}`))
+	kdb.PutDocument(context.Background(), "testimport", inputDoc)
+	var export strings.Builder
+	kdb.Export(context.Background(), "testexport", "", true, &export)
+	result, err = kdb.Import(context.Background(), "testimport", strings.NewReader(export.String()), true)
+	if err != nil || result.Skipped != 3 || result.Conflicts != 1 || len(result.ConflictIDs) != 1 || result.ConflictIDs[0] != "1" {
+		t.Errorf("expected 3 documents skipped and a conflict on 1, got %+v %v", result, err)
+	}
+	if conflicts, _ := kdb.GetConflicts(context.Background(), "testimport", "1"); len(conflicts) != 1 {
+		t.Errorf("expected a conflict, got %v", conflicts)
+	}
+
+	// ignoring versions updates whatever version is current
+	lines = []string{`{"_id":"3","_version":7,"name":"carol b"}`, "", `{"name":"dave"}`, `{"_id":"4"`}
+	result, err = kdb.Import(context.Background(), "testimport", strings.NewReader(strings.Join(lines, "\n")), false)
+	if err != nil || result.Read != 3 || result.Written != 2 || result.Failures != 1 || result.Errors[0].Line != 4 || result.Errors[0].Error != "bad_json" {
+		t.Errorf("expected 2 documents written and a failure on line 4, got %+v %v", result, err)
+	}
+	doc, _ := kdb.GetDocument(context.Background(), "testimport", &Document{ID: "3"}, true)
+	if doc == nil || doc.Version != 2 || !strings.Contains(string(doc.Data), `"name":"carol b"`) {
+		t.Errorf("expected version 2, got %+v", doc)
+	}
+
+	// keeping versions needs them
+	result, err = kdb.Import(context.Background(), "testimport", strings.NewReader(`{"_id":"5"}`), true)
+	if err != nil || result.Written != 0 || result.Failures != 1 || result.Errors[0].ID != "5" {
+		t.Errorf("expected a failure on 5, got %+v %v", result, err)
+	}
+	if _, err := kdb.Import(context.Background(), "testimportmissing", strings.NewReader(""), true); !errors.Is(err, ErrDBNotFound) {
+		t.Errorf("expected %s, got %v", ErrDBNotFound, err)
+	}
+
+	kdb.Delete("testexport")
+	kdb.Delete("testimport")
+	kdb.Close()
+}
+
+func TestReplicateLocalDatabases(t *testing.T) {
+	kdb, _ := New(nil)
+	kdb.Open("testrepsource", true)
+
+	ddoc := `{"_id":"_design/names","views":{"all":{
+		"setup":["CREATE TABLE IF NOT EXISTS names (doc_id, name, PRIMARY KEY(doc_id))"],
+		"run":["INSERT OR REPLACE INTO names SELECT doc_id, json_extract(data, '$.name') FROM latest_documents WHERE deleted = 0 AND doc_id NOT LIKE '_design/%'"],
+		"select":{"default":"SELECT JSON_GROUP_ARRAY(name) FROM (SELECT name FROM names ORDER BY doc_id)"}}}}`
+	for _, doc := range []string{ddoc, `{"_id":"1","name":"alice"}`, `{"_id":"2","name":"bob"}`} {
+		inputDoc, _ := ParseDocument([]byte(doc))
+		kdb.PutDocument(context.Background(), "testrepsource", inputDoc)
+	}
+	inputDoc, _ := ParseDocument([]byte(`{"_id":"2","_version":1}`))
+	kdb.DeleteDocument(context.Background(), "testrepsource", inputDoc)
+
+	req := &ReplicationRequest{Source: "testrepsource", Target: "testreptarget"}
+	if _, err := kdb.Replicate(context.Background(), req); !errors.Is(err, ErrDBNotFound) {
+		t.Errorf("expected %s, got %v", ErrDBNotFound, err)
+	}
+	req.CreateTarget = true
+	result, err := kdb.Replicate(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.DocsRead != 4 || result.DocWriteFailures != 0 {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	rs, err := kdb.SelectView(context.Background(), "testreptarget", "_design/names", "all", "default", nil, false)
+	if err != nil || string(rs) != `["alice"]` {
+		t.Errorf("expected %s, got %s %v", `["alice"]`, rs, err)
+	}
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"2"}`))
+	if doc, err := kdb.GetDocument(context.Background(), "testreptarget", inputDoc, false); !errors.Is(err, ErrDocNotFound) || doc == nil || doc.Version != 2 {
+		t.Errorf("expected deleted version 2, got %+v %v", doc, err)
+	}
+
+	// the checkpoint is on both sides, nothing is read again
+	result, err = kdb.Replicate(context.Background(), req)
+	if err != nil || result.DocsRead != 0 {
+		t.Errorf("expected no documents read, got %+v %v", result, err)
+	}
+	if _, err := kdb.GetLocalDocument(context.Background(), "testreptarget", "replication-"+result.ReplicationID); err != nil {
+		t.Error(err)
+	}
+
+	kdb.Delete("testrepsource")
+	kdb.Delete("testreptarget")
+	kdb.Close()
+}
+
+func TestReplicateConflicts(t *testing.T) {
+	kdb, _ := New(nil)
+	kdb.Open("testconflicta", true)
+	kdb.Open("testconflictb", true)
+
+	inputDoc, _ := ParseDocument([]byte(`{"_id":"1","name":"alice"}`))
+	kdb.PutDocument(context.Background(), "testconflicta", inputDoc)
+	ab := &ReplicationRequest{Source: "testconflicta", Target: "testconflictb"}
+	ba := &ReplicationRequest{Source: "testconflictb", Target: "testconflicta"}
+	if _, err := kdb.Replicate(context.Background(), ab); err != nil {
+		t.Fatal(err)
+	}
+
+	// both sides write version 2
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"1","_version":1,"name":"alice a"}`))
+	docA, _ := kdb.PutDocument(context.Background(), "testconflicta", inputDoc)
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"1","_version":1,"name":"alice b"}`))
+	docB, _ := kdb.PutDocument(context.Background(), "testconflictb", inputDoc)
+	if docA.Version != 2 || docB.Version != 2 || docA.Hash == docB.Hash {
+		t.Fatalf("expected two versions 2, got %+v %+v", docA, docB)
+	}
+	winner, loser := docA, docB
+	if docB.winsOver(docA) {
+		winner, loser = docB, docA
+	}
+
+	for _, req := range []*ReplicationRequest{ab, ba} {
+		if result, err := kdb.Replicate(context.Background(), req); err != nil || result.DocWriteFailures != 0 {
+			t.Fatalf("unexpected result %+v %v", result, err)
+		}
+	}
+	for _, name := range []string{"testconflicta", "testconflictb"} {
+		doc, err := kdb.GetDocumentWithHistory(context.Background(), name, "1")
+		if err != nil || doc.Version != 2 || doc.Hash != winner.Hash {
+			t.Errorf("expected the winner %s in %s, got %+v %v", winner.Hash, name, doc, err)
+		}
+		conflicts, err := kdb.GetConflicts(context.Background(), name, "1")
+		if err != nil || len(conflicts) != 1 || conflicts[0] != FormatVersion(2, loser.Hash) {
+			t.Errorf("expected the conflict 2-%s in %s, got %v %v", loser.Hash, name, conflicts, err)
+		}
+	}
+
+	// an update based on the winner replicates without a conflict
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"1","_version":2,"name":"alice c"}`))
+	if _, err := kdb.PutDocument(context.Background(), "testconflicta", inputDoc); err != nil {
+		t.Fatal(err)
+	}
+	kdb.Replicate(context.Background(), ab)
+	doc, _ := kdb.GetDocumentWithHistory(context.Background(), "testconflictb", "1")
+	if doc == nil || doc.Version != 3 || string(doc.Data) != `{"name":"alice c"}` {
+		t.Errorf("expected version 3, got %+v", doc)
+	}
+
+	if err := kdb.DeleteConflict(context.Background(), "testconflictb", "1", FormatVersion(2, loser.Hash)); err != nil {
+		t.Error(err)
+	}
+	if err := kdb.DeleteConflict(context.Background(), "testconflictb", "1", FormatVersion(2, loser.Hash)); !errors.Is(err, ErrDocNotFound) {
+		t.Errorf("expected %s, got %v", ErrDocNotFound, err)
+	}
+	if conflicts, _ := kdb.GetConflicts(context.Background(), "testconflictb", "1"); len(conflicts) != 0 {
+		t.Errorf("expected no conflicts, got %v", conflicts)
+	}
+
+	kdb.Delete("testconflicta")
+	kdb.Delete("testconflictb")
+	kdb.Close()
+}
+
+func TestReplicateKnownConflict(t *testing.T) {
+	kdb, _ := New(nil)
+	defer kdb.Close()
+	ctx := context.Background()
+	kdb.Delete("testconflictknown")
+	kdb.Open("testconflictknown", true)
+	defer kdb.Delete("testconflictknown")
+
+	// 3-n3 loses to 4-c4 and is stored as a conflict, then its ancestor 2-x2
+	// arrives and is stored as one too
+	for _, doc := range []string{
+		`{"_id":"1","_version":4,"_hash":"c4","_history":["c3","c2","c1"]}`,
+		`{"_id":"1","_version":3,"_hash":"n3","_history":["x2","c1"]}`,
+		`{"_id":"1","_version":2,"_hash":"x2","_history":["c1"]}`,
+	} {
+		inputDoc, _ := ParseDocument([]byte(doc))
+		if _, err := kdb.PutReplicatedDocument(ctx, "testconflictknown", inputDoc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if conflicts, _ := kdb.GetConflicts(ctx, "testconflictknown", "1"); len(conflicts) != 2 {
+		t.Fatalf("expected 2 conflicts, got %v", conflicts)
+	}
+
+	// 3-n3 again is known, the conflict it supersedes goes away
+	inputDoc, _ := ParseDocument([]byte(`{"_id":"1","_version":3,"_hash":"n3","_history":["x2","c1"]}`))
+	written, err := kdb.PutReplicatedDocument(ctx, "testconflictknown", inputDoc)
+	if err != nil || written {
+		t.Errorf("expected the known conflict to be skipped, got %v %v", written, err)
+	}
+	conflicts, _ := kdb.GetConflicts(ctx, "testconflictknown", "1")
+	if len(conflicts) != 1 || conflicts[0] != FormatVersion(3, "n3") {
+		t.Errorf("expected the conflict 3-n3, got %v", conflicts)
+	}
+}
+
+func TestReplicator(t *testing.T) {
+	config := DefaultConfig()
+	config.ReplicatorInterval = 0
+	kdb, _ := New(config)
+	if err := kdb.Open(ReplicatorDB, true); err != nil && !errors.Is(err, ErrDBExists) {
+		t.Fatal(err)
+	}
+	kdb.replicator = newReplicator(kdb, 20*time.Millisecond)
+	kdb.replicator.start()
+
+	kdb.Open("testreplicatorsource", true)
+	inputDoc, _ := ParseDocument([]byte(`{"_id":"1"}`))
+	kdb.PutDocument(context.Background(), "testreplicatorsource", inputDoc)
+
+	waitForState := func(id, state string) map[string]interface{} {
+		doc := map[string]interface{}{}
+		for i := 0; i < 100; i++ {
+			time.Sleep(20 * time.Millisecond)
+			outputDoc, err := kdb.GetDocument(context.Background(), ReplicatorDB, &Document{ID: id}, true)
+			if err == nil {
+				json.Unmarshal(outputDoc.Data, &doc)
+				if doc["_replication_state"] == state {
+					return doc
+				}
+			}
+		}
+		t.Errorf("expected %s to be %s, got %v", id, state, doc)
+		return doc
+	}
+
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"once","source":"testreplicatorsource","target":"testreplicatoronce","create_target":true}`))
+	kdb.PutDocument(context.Background(), ReplicatorDB, inputDoc)
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"continuous","source":"testreplicatorsource","target":"testreplicatorcont","create_target":true,"continuous":true}`))
+	kdb.PutDocument(context.Background(), ReplicatorDB, inputDoc)
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"broken","source":"testreplicatormissing","target":"testreplicatorcont"}`))
+	kdb.PutDocument(context.Background(), ReplicatorDB, inputDoc)
+
+	waitForState("once", ReplicationCompleted)
+	waitForState("continuous", ReplicationRunning)
+	if doc := waitForState("broken", ReplicationError); doc["_replication_state_reason"] == nil {
+		t.Errorf("expected a reason, got %v", doc)
+	}
+
+	// continuous replications pick up new documents
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"2"}`))
+	kdb.PutDocument(context.Background(), "testreplicatorsource", inputDoc)
+	var stat *DBStat
+	for i := 0; i < 100; i++ {
+		time.Sleep(20 * time.Millisecond)
+		if stat, _ = kdb.DBStat("testreplicatorcont"); stat != nil && stat.DocCount == 3 {
+			break
+		}
+	}
+	if stat == nil || stat.DocCount != 3 {
+		t.Errorf("expected 3 documents, got %+v", stat)
+	}
+
+	// a restarted engine doesn't run completed replications again
+	kdb.Close()
+	kdb, _ = New(config)
+	r := newReplicator(kdb, time.Hour)
+	r.poll()
+	if _, ok := r.jobs["once"]; ok || len(r.jobs) != 2 {
+		t.Errorf("expected the continuous and broken jobs, got %v", r.jobs)
+	}
+	r.stop()
+
+	for _, name := range []string{ReplicatorDB, "testreplicatorsource", "testreplicatoronce", "testreplicatorcont"} {
+		kdb.Delete(name)
+	}
+	kdb.Close()
+}
@@ -0,0 +1,124 @@
+package kdb
+
+import (
+	"database/sql"
+)
+
+type LocalDB struct {
+	con *sql.DB
+	tx  *sql.Tx
+}
+
+func (db *LocalDB) Open(dbPath string) error {
+	con, err := sql.Open("sqlite3", dbPath+"/_local.db")
+	if err != nil {
+		return err
+	}
+
+	tx, _ := con.Begin()
+
+	tx.Exec(`
+		CREATE TABLE IF NOT EXISTS dbs (name TEXT, filename TEXT, storage TEXT NOT NULL DEFAULT 'file', security TEXT, PRIMARY KEY(name));
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_filename (filename);
+	`)
+	// databases created before storages were added, fails once it exists
+	tx.Exec("ALTER TABLE dbs ADD COLUMN storage TEXT NOT NULL DEFAULT 'file'")
+	// and before security objects
+	tx.Exec("ALTER TABLE dbs ADD COLUMN security TEXT")
+
+	// memory databases didn't survive the restart
+	tx.Exec("DELETE FROM dbs WHERE storage = ?", StorageMemory)
+
+	tx.Commit()
+
+	db.con = con
+
+	return nil
+}
+
+func (db *LocalDB) Close() error {
+	return db.con.Close()
+}
+
+func (db *LocalDB) Begin() error {
+	var err error
+	db.tx, err = db.con.Begin()
+	return err
+}
+
+func (db *LocalDB) Commit() error {
+	return db.tx.Commit()
+}
+
+func (db *LocalDB) Rollback() error {
+	return db.tx.Rollback()
+}
+
+func (db *LocalDB) Create(name, filename, storage string) error {
+	_, err := db.tx.Exec("INSERT INTO dbs (name, filename, storage) VALUES(?, ?, ?)", name, filename, storage)
+	return err
+}
+
+func (db *LocalDB) Delete(name string) error {
+	_, err := db.tx.Exec("DELETE FROM dbs WHERE name = ?", name)
+	return err
+}
+
+// Rename changes the name of a database, its file name stays.
+func (db *LocalDB) Rename(name, newName string) error {
+	_, err := db.tx.Exec("UPDATE dbs SET name = ? WHERE name = ?", newName, name)
+	return err
+}
+
+func (db *LocalDB) IsFileNameUsed(fileName string) bool {
+	var count int
+	row := db.tx.QueryRow("SELECT COUNT(*) FROM dbs WHERE filename = ?", fileName)
+	row.Scan(&count)
+	return count > 0
+}
+
+func (db *LocalDB) GetFileName(name string) (string, string) {
+	var fileName, storage string
+	row := db.tx.QueryRow("SELECT filename, storage FROM dbs WHERE name = ?", name)
+	row.Scan(&fileName, &storage)
+	return fileName, storage
+}
+
+// Lookup returns the file name and storage of a database outside of the
+// shared transaction, it can be called concurrently.
+func (db *LocalDB) Lookup(name string) (string, string) {
+	var fileName, storage string
+	row := db.con.QueryRow("SELECT filename, storage FROM dbs WHERE name = ?", name)
+	row.Scan(&fileName, &storage)
+	return fileName, storage
+}
+
+// LookupSecurity returns the security object of a database outside of the
+// shared transaction, and whether the database exists.
+func (db *LocalDB) LookupSecurity(name string) (string, bool) {
+	var security string
+	row := db.con.QueryRow("SELECT ifnull(security, '') FROM dbs WHERE name = ?", name)
+	if err := row.Scan(&security); err != nil {
+		return "", false
+	}
+	return security, true
+}
+
+func (db *LocalDB) PutSecurity(name, security string) error {
+	_, err := db.tx.Exec("UPDATE dbs SET security = ? WHERE name = ?", security, name)
+	return err
+}
+
+func (db *LocalDB) List() ([]string, error) {
+	var dbs []string
+	rows, err := db.tx.Query("SELECT name FROM dbs")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var name string
+		rows.Scan(&name)
+		dbs = append(dbs, name)
+	}
+	return dbs, nil
+}
@@ -0,0 +1,79 @@
+package kdb
+
+import "encoding/json"
+
+type DBStat struct {
+	DBName          string `json:"db_name"`
+	UpdateSeq       string `json:"update_seq"`
+	DocCount        int    `json:"doc_count"`
+	DeletedDocCount int    `json:"deleted_doc_count"`
+	Storage         string `json:"storage"`
+}
+
+type DesignDocumentViewParam struct {
+	Type     string      `json:"type,omitempty"`
+	Items    string      `json:"items,omitempty"`
+	Required bool        `json:"required,omitempty"`
+	Default  interface{} `json:"default,omitempty"`
+}
+
+type DesignDocumentView struct {
+	Setup    []string                            `json:"setup,omitempty"`
+	Run      []string                            `json:"run,omitempty"`
+	Select   map[string]string                   `json:"select,omitempty"`
+	Params   map[string]*DesignDocumentViewParam `json:"params,omitempty"`
+	Sources  map[string]string                   `json:"sources,omitempty"`
+	Depends  map[string]string                   `json:"depends,omitempty"`
+	External *DesignDocumentViewExternal         `json:"external,omitempty"`
+}
+
+// DesignDocumentViewExternal builds a view with an external view server
+// registered in the config. Server names the registered command, Table is
+// the table the server's rows are written to.
+type DesignDocumentViewExternal struct {
+	Server    string `json:"server"`
+	Table     string `json:"table,omitempty"`
+	BatchSize int    `json:"batch_size,omitempty"`
+}
+
+type DesignDocument struct {
+	ID      string                         `json:"_id"`
+	Version int                            `json:"_version,omitempty"`
+	Kind    string                         `json:"_kind"`
+	Views   map[string]*DesignDocumentView `json:"views"`
+}
+
+type StatementTestResult struct {
+	Statement string `json:"statement"`
+	Error     string `json:"error,omitempty"`
+}
+
+type SelectTestResult struct {
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+type DesignDocumentViewTestResult struct {
+	Error  string                       `json:"error,omitempty"`
+	Setup  []StatementTestResult        `json:"setup"`
+	Run    []StatementTestResult        `json:"run"`
+	Select map[string]*SelectTestResult `json:"select"`
+}
+
+type DesignDocumentTestResult struct {
+	OK    bool                                     `json:"ok"`
+	Views map[string]*DesignDocumentViewTestResult `json:"views"`
+}
+
+type QueryParam struct {
+	name         string
+	kind         string
+	items        string
+	required     bool
+	defaultValue []string
+}
+
+type Query struct {
+	text   string
+	params []QueryParam
+}
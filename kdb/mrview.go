@@ -0,0 +1,1151 @@
+package kdb
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+type ViewManager interface {
+	SetupViews(db *Database) error
+	Initialize(db *Database) error
+	ListViewFiles() ([]string, error)
+	OpenView(viewName string, ddoc *DesignDocument) error
+	GetView(viewName string) (*View, bool)
+	SelectView(ctx context.Context, updateSeqID string, doc *Document, viewName, selectName string, values url.Values, stale bool) ([]byte, error)
+	Close() error
+	Vacuum() error
+	UpdateDesignDocument(doc *Document) error
+	ValidateDesignDocument(ctx context.Context, doc *Document) error
+	CalculateSignature(ddocv *DesignDocumentView) string
+	ParseQueryParams(query string) (string, []QueryParam)
+	TestDesignDocument(ctx context.Context, doc *Document, docs []*Document, values url.Values) (*DesignDocumentTestResult, error)
+	ViewAccessTimes() map[string]time.Time
+	CloseView(qualifiedViewName string)
+}
+
+type DefaultViewManager struct {
+	fileName    string
+	viewDirPath string
+	databaseURI string
+	storage     Storage
+	db          *Database
+
+	rwmux     sync.RWMutex
+	views     map[string]*View
+	ddocs     map[string]*DesignDocument
+	viewFiles map[string]map[string]bool
+
+	serviceLocator ServiceLocator
+}
+
+func (mgr *DefaultViewManager) SetupViews(db *Database) error {
+	ddoc := &DesignDocument{}
+	ddoc.ID = "_design/_views"
+	ddoc.Kind = "design"
+	ddoc.Views = make(map[string]*DesignDocumentView)
+	ddv := &DesignDocumentView{}
+	ddv.Setup = append(ddv.Setup, "CREATE TABLE IF NOT EXISTS all_docs (key, value, doc_id,  PRIMARY KEY(key)) WITHOUT ROWID")
+	ddv.Run = append(ddv.Run, "DELETE FROM all_docs WHERE doc_id in (SELECT doc_id FROM latest_changes WHERE deleted = 1)")
+	ddv.Run = append(ddv.Run, "INSERT OR REPLACE INTO all_docs (key, value, doc_id) SELECT doc_id, JSON_OBJECT('version', version), doc_id FROM latest_documents WHERE deleted = 0")
+	ddv.Select = make(map[string]string)
+	ddv.Select["default"] = "SELECT JSON_OBJECT('offset', min(offset),'rows',JSON_GROUP_ARRAY(JSON_OBJECT('key', key, 'value', JSON(value), 'id', doc_id)),'total_rows',(SELECT COUNT(1) FROM all_docs)) FROM (SELECT (ROW_NUMBER() OVER(ORDER BY key) - 1) as offset, * FROM all_docs ORDER BY key) WHERE (${key} IS NULL or key = ${key})"
+	ddv.Select["with_docs"] = "SELECT JSON_OBJECT('offset', min(offset),'rows',JSON_GROUP_ARRAY(JSON_OBJECT('id', doc_id, 'key', key, 'value', JSON(value), 'doc', JSON((SELECT data FROM documents WHERE doc_id = o.doc_id)))),'total_rows',(SELECT COUNT(1) FROM all_docs)) FROM (SELECT (ROW_NUMBER() OVER(ORDER BY key) - 1) as offset, * FROM all_docs ORDER BY key) o WHERE (${key} IS NULL or key = ${key})"
+
+	ddoc.Views["_all_docs"] = ddv
+
+	buffer := &bytes.Buffer{}
+	encoder := json.NewEncoder(buffer)
+	encoder.SetEscapeHTML(false)
+	err := encoder.Encode(ddoc)
+	if err != nil {
+		panic(err)
+	}
+
+	designDoc, err := ParseDocument(buffer.Bytes())
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = db.PutDocument(context.Background(), designDoc)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (mgr *DefaultViewManager) Initialize(db *Database) error {
+	mgr.rwmux = sync.RWMutex{}
+	// view files are named by the database file, they stay valid on rename
+	mgr.fileName = strings.TrimSuffix(filepath.Base(db.DBPath), dbExt)
+	mgr.viewDirPath = db.ViewDirPath
+
+	mgr.storage = db.Storage()
+	databaseURI, err := mgr.storage.AttachURI(db.DBPath)
+	if err != nil {
+		panic(err)
+	}
+	mgr.databaseURI = databaseURI
+	mgr.db = db
+
+	mgr.rwmux.Lock()
+	defer mgr.rwmux.Unlock()
+
+	//load all design docs into memory
+	docs, _ := db.GetAllDesignDocuments()
+	for _, x := range docs {
+		ddoc := &DesignDocument{}
+		err := json.Unmarshal(x.Data, ddoc)
+		if err != nil {
+			return err
+		}
+		mgr.ddocs[x.ID] = ddoc
+	}
+
+	//load all view files names for this database into memory
+	viewFiles, _ := mgr.ListViewFiles()
+	for _, x := range viewFiles {
+		if _, ok := mgr.viewFiles[x]; !ok {
+			mgr.viewFiles[x] = make(map[string]bool)
+		}
+	}
+
+	// calculate view file with views reference counter
+	for _, ddoc := range mgr.ddocs {
+		for vname, ddocv := range ddoc.Views {
+			viewFile := mgr.fileName + "$" + mgr.CalculateSignature(ddocv)
+			qualifiedViewName := ddoc.ID + "$" + vname
+			if _, ok := mgr.viewFiles[viewFile]; !ok {
+				mgr.viewFiles[viewFile] = make(map[string]bool)
+			}
+			(mgr.viewFiles[viewFile])[qualifiedViewName] = true
+		}
+	}
+
+	// delete unused view files, if reference counter is zero
+	for fileName, x := range mgr.viewFiles {
+		if len(x) <= 0 {
+			delete(mgr.viewFiles, fileName)
+			mgr.storage.Remove(filepath.Join(mgr.viewDirPath, fileName+dbExt))
+		}
+	}
+
+	return nil
+}
+
+func (mgr *DefaultViewManager) ListViewFiles() ([]string, error) {
+	list, err := mgr.storage.List(mgr.viewDirPath)
+	if err != nil {
+		return nil, err
+	}
+	var viewFiles []string
+	for _, name := range list {
+		if strings.HasPrefix(name, mgr.fileName+"$") && strings.HasSuffix(name, dbExt) {
+			viewFiles = append(viewFiles, strings.ReplaceAll(name, dbExt, ""))
+		}
+	}
+	return viewFiles, nil
+}
+
+func (mgr *DefaultViewManager) OpenView(viewName string, ddoc *DesignDocument) error {
+	mgr.rwmux.Lock()
+	defer mgr.rwmux.Unlock()
+
+	_, err := mgr.openView(viewName, ddoc, make(map[string]bool))
+	return err
+}
+
+// openView opens a view after the views it depends on, caller must hold the
+// write lock.
+func (mgr *DefaultViewManager) openView(viewName string, ddoc *DesignDocument, visited map[string]bool) (*View, error) {
+	qualifiedViewName := ddoc.ID + "$" + viewName
+	if view, ok := mgr.views[qualifiedViewName]; ok {
+		return view, nil
+	}
+
+	designDocView, ok := ddoc.Views[viewName]
+	if !ok {
+		return nil, nil
+	}
+
+	if visited[qualifiedViewName] {
+		return nil, fmt.Errorf("%s: %w", fmt.Sprintf("%s depends on itself", qualifiedViewName), ErrViewInvalidDependency)
+	}
+	visited[qualifiedViewName] = true
+
+	sourceURIs := make(map[string]string)
+	for alias, name := range designDocView.Sources {
+		uri, err := mgr.db.GetDatabaseURI(name)
+		if err != nil {
+			return nil, err
+		}
+		sourceURIs[alias] = uri
+	}
+
+	dependencies := make(map[string]*View)
+	viewURIs := make(map[string]string)
+	for alias, target := range designDocView.Depends {
+		depDDocID, depViewName, _ := parseViewDependency(target)
+		depDDoc := ddoc
+		if depDDocID != ddoc.ID {
+			var err error
+			depDDoc, err = mgr.getDesignDocument(depDDocID)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		dependency, err := mgr.openView(depViewName, depDDoc, visited)
+		if err != nil {
+			return nil, err
+		}
+		if dependency == nil {
+			return nil, fmt.Errorf("%s: %w", fmt.Sprintf("dependency %s not found", target), ErrViewNotFound)
+		}
+
+		uri, err := mgr.storage.AttachURI(mgr.viewFilePath(depDDoc.Views[depViewName]))
+		if err != nil {
+			return nil, err
+		}
+		dependencies[alias] = dependency
+		viewURIs[alias] = uri
+	}
+
+	viewFilePath := mgr.viewFilePath(designDocView)
+	if err := mgr.storage.Create(viewFilePath); err != nil {
+		return nil, err
+	}
+	viewConnectionString := mgr.storage.ConnectionString(viewFilePath, "_journal=MEMORY&cache=shared&_mutex=no")
+
+	// only the views granted decrypt get it, with the keys of the database
+	decrypt := mgr.db.cipher.viewDecrypt(ddoc.ID, viewName)
+	view := mgr.serviceLocator.GetView(viewName, viewConnectionString, mgr.databaseURI, sourceURIs, viewURIs, decrypt, ddoc, mgr)
+	view.dependencies = dependencies
+	view.lastAccess = time.Now().UnixNano()
+	if err := view.Open(); err != nil {
+		return nil, err
+	}
+
+	mgr.views[qualifiedViewName] = view
+
+	return view, nil
+}
+
+func (mgr *DefaultViewManager) viewFilePath(ddocv *DesignDocumentView) string {
+	return filepath.Join(mgr.viewDirPath, mgr.fileName+"$"+mgr.CalculateSignature(ddocv)+dbExt)
+}
+
+func (mgr *DefaultViewManager) getDesignDocument(ddocID string) (*DesignDocument, error) {
+	if mgr.db == nil {
+		return nil, ErrDocNotFound
+	}
+	doc, err := mgr.db.GetDocument(context.Background(), &Document{ID: ddocID}, true)
+	if err != nil {
+		return nil, err
+	}
+	ddoc := &DesignDocument{}
+	if err := json.Unmarshal(doc.Data, ddoc); err != nil {
+		return nil, err
+	}
+	return ddoc, nil
+}
+
+// closeView closes a view together with every view built on top of it,
+// caller must hold the write lock.
+func (mgr *DefaultViewManager) closeView(qualifiedViewName string) {
+	view, ok := mgr.views[qualifiedViewName]
+	if !ok {
+		return
+	}
+
+	view.Close()
+	delete(mgr.views, qualifiedViewName)
+
+	for name, v := range mgr.views {
+		for _, dependency := range v.dependencies {
+			if dependency == view {
+				mgr.closeView(name)
+				break
+			}
+		}
+	}
+}
+
+// ViewAccessTimes returns the open views with the time they were last used.
+func (mgr *DefaultViewManager) ViewAccessTimes() map[string]time.Time {
+	mgr.rwmux.RLock()
+	defer mgr.rwmux.RUnlock()
+
+	times := make(map[string]time.Time, len(mgr.views))
+	for name, view := range mgr.views {
+		times[name] = time.Unix(0, atomic.LoadInt64(&view.lastAccess))
+	}
+	return times
+}
+
+// CloseView closes an open view, it is opened again on the next select.
+func (mgr *DefaultViewManager) CloseView(qualifiedViewName string) {
+	mgr.rwmux.Lock()
+	defer mgr.rwmux.Unlock()
+
+	mgr.closeView(qualifiedViewName)
+}
+
+func (mgr *DefaultViewManager) SelectView(ctx context.Context, updateSeqID string, doc *Document, viewName, selectName string, values url.Values, stale bool) ([]byte, error) {
+	ddocID := doc.ID
+	qualifiedViewName := ddocID + "$" + viewName
+
+	unlocked := false
+	ReadUnlock := func() {
+		if !unlocked {
+			mgr.rwmux.RUnlock()
+		}
+		unlocked = true
+	}
+	ResetReadUnlock := func() {
+		unlocked = false
+		mgr.rwmux.RLock()
+	}
+
+	ResetReadUnlock()
+	defer ReadUnlock()
+
+	view, ok := mgr.views[qualifiedViewName]
+
+	if !ok {
+		ddoc := &DesignDocument{}
+		err := json.Unmarshal(doc.Data, ddoc)
+		if err != nil {
+			panic("invalid_design_document " + ddocID)
+		}
+
+		ReadUnlock()
+		err = mgr.OpenView(viewName, ddoc)
+		if err != nil {
+			return nil, err
+		}
+		ResetReadUnlock()
+		view = mgr.views[qualifiedViewName]
+	}
+
+	if view == nil {
+		return nil, ErrViewNotFound
+	}
+	atomic.StoreInt64(&view.lastAccess, time.Now().UnixNano())
+
+	if !stale {
+		ddoc, ok := mgr.ddocs[ddocID]
+
+		if !ok || doc.Version != ddoc.Version {
+			ReadUnlock()
+			err := mgr.UpdateDesignDocument(doc)
+			if err != nil {
+				return nil, err
+			}
+
+			ddoc, ok = mgr.ddocs[ddocID]
+			err = mgr.OpenView(viewName, ddoc)
+			if err != nil {
+				return nil, err
+			}
+
+			view = mgr.views[qualifiedViewName]
+
+			ResetReadUnlock()
+		}
+
+		err := view.Build(ctx, updateSeqID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return view.Select(ctx, selectName, values)
+}
+
+func (mgr *DefaultViewManager) Close() error {
+	mgr.rwmux.Lock()
+	defer mgr.rwmux.Unlock()
+
+	for k, v := range mgr.views {
+		v.Close()
+		delete(mgr.views, k)
+	}
+
+	return nil
+}
+
+func (mgr *DefaultViewManager) Vacuum() error {
+	mgr.rwmux.Lock()
+	defer mgr.rwmux.Unlock()
+	for _, v := range mgr.views {
+		v.Vacuum()
+	}
+	return nil
+}
+
+func (mgr *DefaultViewManager) UpdateDesignDocument(doc *Document) error {
+	mgr.rwmux.Lock()
+	defer mgr.rwmux.Unlock()
+
+	ddocID := doc.ID
+	var updatedViews map[string]string = make(map[string]string)
+	newDDoc := &DesignDocument{}
+
+	if doc.Deleted {
+		for qualifiedViewName := range mgr.views {
+			if strings.HasPrefix(qualifiedViewName, ddocID+"$") {
+				mgr.closeView(qualifiedViewName)
+				updatedViews[qualifiedViewName] = ""
+			}
+		}
+	} else {
+		err := json.Unmarshal(doc.Data, newDDoc)
+		if err != nil {
+			panic("invalid_design_document " + ddocID)
+		}
+
+		for vname, nddv := range newDDoc.Views {
+			var (
+				currentViewFile   string
+				newViewFile       string
+				qualifiedViewName string = ddocID + "$" + vname
+			)
+			newViewFile = mgr.fileName + "$" + mgr.CalculateSignature(nddv)
+
+			if currentDDoc, ok := mgr.ddocs[ddocID]; ok {
+				if cddv, _ := currentDDoc.Views[vname]; cddv != nil {
+					currentViewFile = mgr.fileName + "$" + mgr.CalculateSignature(cddv)
+				}
+			}
+			if newViewFile == currentViewFile {
+				continue
+			}
+
+			if _, ok := mgr.viewFiles[newViewFile]; !ok {
+				mgr.viewFiles[newViewFile] = make(map[string]bool)
+			}
+			mgr.viewFiles[newViewFile][qualifiedViewName] = true
+
+			mgr.closeView(qualifiedViewName)
+
+			//To takecare old one
+			if currentViewFile != "" && len(mgr.viewFiles[currentViewFile]) <= 0 {
+				delete(mgr.viewFiles, currentViewFile)
+				mgr.storage.Remove(filepath.Join(mgr.viewDirPath, currentViewFile+dbExt))
+			}
+
+			updatedViews[qualifiedViewName] = newViewFile
+		}
+	}
+
+	currentDDoc, ok := mgr.ddocs[ddocID]
+	if ok {
+		//to takecare of missing ones
+
+		for vname, cddv := range currentDDoc.Views {
+			qualifiedViewName := ddocID + "$" + vname
+			currentViewFile := mgr.fileName + "$" + mgr.CalculateSignature(cddv)
+			if newViewFile, ok := updatedViews[qualifiedViewName]; !ok || newViewFile != currentViewFile {
+				delete(mgr.viewFiles[currentViewFile], qualifiedViewName)
+				if len(mgr.viewFiles[currentViewFile]) <= 0 {
+
+					delete(mgr.viewFiles, currentViewFile)
+					mgr.storage.Remove(filepath.Join(mgr.viewDirPath, currentViewFile+dbExt))
+				}
+			}
+		}
+	}
+
+	if doc.Deleted {
+		delete(mgr.ddocs, ddocID)
+	} else {
+		mgr.ddocs[ddocID] = newDDoc
+	}
+
+	return nil
+}
+
+func (mgr *DefaultViewManager) CalculateSignature(ddocv *DesignDocumentView) string {
+	content := ""
+	if ddocv != nil {
+		crc32q := crc32.MakeTable(0xD5828281)
+		if ddocv.Select != nil {
+			for _, x := range ddocv.Setup {
+				content += x
+			}
+		}
+		if ddocv.Run != nil {
+			for _, x := range ddocv.Run {
+				content += x
+			}
+		}
+		if len(ddocv.Sources) > 0 {
+			var aliases []string
+			for alias := range ddocv.Sources {
+				aliases = append(aliases, alias)
+			}
+			sort.Strings(aliases)
+			for _, alias := range aliases {
+				content += alias + "=" + ddocv.Sources[alias]
+			}
+		}
+		if len(ddocv.Depends) > 0 {
+			var aliases []string
+			for alias := range ddocv.Depends {
+				aliases = append(aliases, alias)
+			}
+			sort.Strings(aliases)
+			for _, alias := range aliases {
+				content += alias + "->" + ddocv.Depends[alias]
+			}
+		}
+		if ddocv.External != nil {
+			content += fmt.Sprintf("external=%s/%s/%d", ddocv.External.Server, ddocv.External.Table, ddocv.External.BatchSize)
+		}
+		v := crc32.Checksum([]byte(content), crc32q)
+		return strconv.Itoa(int(v))
+	}
+	return ""
+}
+
+var queryParamExp = regexp.MustCompile(`\$\{(.*?)\}`)
+
+var queryParamTypes = map[string]bool{"": true, "text": true, "int": true, "real": true, "bool": true, "json": true, "array": true}
+
+// queryParamItemTypes are the types of array elements, text by default
+var queryParamItemTypes = map[string]bool{"": true, "text": true, "int": true, "real": true, "bool": true}
+
+// ParseQueryParams replaces ${name} and ${name:type} placeholders with bind
+// parameters. array placeholders expand to a json_each sub select, so they can
+// be used as the right hand side of IN, ${name:array:int} declares the type of
+// the elements.
+func (mgr *DefaultViewManager) ParseQueryParams(query string) (string, []QueryParam) {
+	return parseQueryParams(query, nil)
+}
+
+// parseQueryParams is ParseQueryParams with the definitions of a view's
+// params applied, before array placeholders are expanded, so a type declared
+// only in the definitions expands too.
+func parseQueryParams(query string, definitions map[string]*DesignDocumentViewParam) (string, []QueryParam) {
+	var params []QueryParam
+	text := queryParamExp.ReplaceAllStringFunc(query, func(placeholder string) string {
+		param := QueryParam{}
+		o := strings.SplitN(queryParamExp.FindStringSubmatch(placeholder)[1], ":", 3)
+		param.name = strings.TrimSpace(o[0])
+		if len(o) > 1 {
+			param.kind = strings.ToLower(strings.TrimSpace(o[1]))
+		}
+		if len(o) > 2 {
+			param.items = strings.ToLower(strings.TrimSpace(o[2]))
+		}
+		param = applyQueryParamDefinition(param, definitions)
+		params = append(params, param)
+		if param.kind == "array" {
+			return "(SELECT value FROM json_each(?))"
+		}
+		return "?"
+	})
+	return text, params
+}
+
+func applyQueryParamDefinitions(params []QueryParam, definitions map[string]*DesignDocumentViewParam) []QueryParam {
+	for i, param := range params {
+		params[i] = applyQueryParamDefinition(param, definitions)
+	}
+	return params
+}
+
+func applyQueryParamDefinition(param QueryParam, definitions map[string]*DesignDocumentViewParam) QueryParam {
+	definition, ok := definitions[param.name]
+	if !ok || definition == nil {
+		return param
+	}
+	if param.kind == "" {
+		param.kind = strings.ToLower(definition.Type)
+	}
+	if param.items == "" {
+		param.items = strings.ToLower(definition.Items)
+	}
+	param.required = definition.Required
+	switch v := definition.Default.(type) {
+	case nil:
+	case string:
+		param.defaultValue = []string{v}
+	default:
+		b, _ := json.Marshal(v)
+		param.defaultValue = []string{string(b)}
+	}
+	return param
+}
+
+func validateQueryParams(params []QueryParam) error {
+	for _, param := range params {
+		if !queryParamTypes[param.kind] {
+			return fmt.Errorf("%s: %w", fmt.Sprintf("%s has unknown type %s", param.name, param.kind), ErrViewInvalidParam)
+		}
+		if !queryParamItemTypes[param.items] || (param.items != "" && param.kind != "array") {
+			return fmt.Errorf("%s: %w", fmt.Sprintf("%s has unknown item type %s", param.name, param.items), ErrViewInvalidParam)
+		}
+		if len(param.defaultValue) > 0 {
+			if _, err := bindQueryParam(param, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (mgr *DefaultViewManager) ValidateDesignDocument(ctx context.Context, doc *Document) error {
+	newDDoc := &DesignDocument{}
+	err := json.Unmarshal(doc.Data, newDDoc)
+	if err != nil {
+		return fmt.Errorf("%s: %w", err, ErrBadJSON)
+	}
+	newDDoc.ID = doc.ID
+
+	sources := make(map[string]bool)
+	dependencies := make(map[string]*DesignDocumentView)
+	for _, v := range newDDoc.Views {
+		targets := make(map[string]bool)
+		for _, x := range v.Select {
+			_, params := parseQueryParams(x, v.Params)
+			if err := validateQueryParams(params); err != nil {
+				return err
+			}
+		}
+		for alias, name := range v.Sources {
+			if !validateSourceAlias(alias) {
+				return fmt.Errorf("%s: %w", fmt.Sprintf("invalid source alias %s", alias), ErrViewInvalidSource)
+			}
+			if !validateDBName(name) || strings.HasPrefix(name, "_") {
+				return fmt.Errorf("%s: %w", fmt.Sprintf("invalid source database %s", name), ErrViewInvalidSource)
+			}
+			if err := mgr.db.authorizeSource(ctx, name); err != nil {
+				return err
+			}
+			sources[alias] = true
+		}
+		if v.External != nil {
+			if err := mgr.validateExternalView(v.External); err != nil {
+				return err
+			}
+		}
+		for alias, target := range v.Depends {
+			if !validateSourceAlias(alias) || sources[alias] || dependencies[alias] != nil {
+				return fmt.Errorf("%s: %w", fmt.Sprintf("invalid dependency alias %s", alias), ErrViewInvalidDependency)
+			}
+			ddocID, viewName, ok := parseViewDependency(target)
+			if !ok {
+				return fmt.Errorf("%s: %w", fmt.Sprintf("invalid dependency %s", target), ErrViewInvalidDependency)
+			}
+			if targets[ddocID+"$"+viewName] {
+				return fmt.Errorf("%s: %w", fmt.Sprintf("duplicate dependency %s", target), ErrViewInvalidDependency)
+			}
+			targets[ddocID+"$"+viewName] = true
+			depDDoc := newDDoc
+			if ddocID != doc.ID {
+				depDDoc, err = mgr.getDesignDocument(ddocID)
+				if err != nil {
+					return fmt.Errorf("%s: %w", fmt.Sprintf("dependency %s not found", target), ErrViewInvalidDependency)
+				}
+			}
+			if _, ok := depDDoc.Views[viewName]; !ok {
+				return fmt.Errorf("%s: %w", fmt.Sprintf("dependency %s not found", target), ErrViewInvalidDependency)
+			}
+			dependencies[alias] = depDDoc.Views[viewName]
+		}
+	}
+
+	if err := mgr.validateViewDependencies(newDDoc); err != nil {
+		return err
+	}
+
+	// the stubs have nothing to decrypt, the views granted decrypt are
+	// checked with the one of the database
+	var decrypt func(interface{}) (string, error)
+	if mgr.db != nil && mgr.db.cipher != nil {
+		decrypt = mgr.db.cipher.decryptSQL
+	}
+	db := openSQL(":memory:", registerDecrypt(decrypt))
+	defer db.Close()
+	// attached stubs only exist on the connection they were attached to
+	db.SetMaxOpenConns(1)
+
+	// dependencies are stubbed with their setup scripts in a shared memory
+	// database, so run scripts can be checked against their tables
+	for alias, depView := range dependencies {
+		name := "file:" + hex.EncodeToString(randomBytes(8)) + "?mode=memory&cache=shared"
+		depDB, err := sql.Open(sqlDriver, name)
+		if err != nil {
+			return err
+		}
+		defer depDB.Close()
+		depDB.SetMaxOpenConns(1)
+
+		for _, x := range depView.Setup {
+			if _, err := depDB.Exec(x); err != nil {
+				return fmt.Errorf("%s: %w", fmt.Sprintf("%s: %s ;", x, err.Error()), ErrInvalidSQLStmt)
+			}
+		}
+
+		_, err = db.Exec("ATTACH DATABASE '" + name + "' as " + alias)
+		if err != nil {
+			return err
+		}
+	}
+
+	for alias := range sources {
+		_, err = db.Exec("ATTACH DATABASE ':memory:' as " + alias)
+		if err != nil {
+			return err
+		}
+		_, err = db.Exec("CREATE VIEW " + alias + ".documents (doc_id, version, kind, deleted, data, seq_id) AS select '' as doc_id, 1 as version, '', 0, '{}' as data, '' as seq_id;")
+		if err != nil {
+			return err
+		}
+		_, err = db.Exec("CREATE TEMP VIEW latest_changes_" + alias + " (doc_id, deleted) AS select '', 0 as doc_id;")
+		if err != nil {
+			return err
+		}
+		_, err = db.Exec("CREATE TEMP VIEW latest_documents_" + alias + " (doc_id, version, kind, deleted, data) AS select '' as doc_id, 1 as version, '', 0, '{}' as data ;")
+		if err != nil {
+			return err
+		}
+	}
+
+	tx, _ := db.Begin()
+	defer tx.Rollback()
+
+	_, err = tx.Exec("CREATE VIEW latest_changes (doc_id, deleted) AS select '', 0 as doc_id;")
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("CREATE VIEW latest_documents (doc_id, version, deleted, data) AS select '' as doc_id, 1 as version, 0, '{}' as data ;")
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("CREATE VIEW documents (doc_id, version, deleted, data) AS select '' as doc_id, 1 as version, 0, '{}' as data ;")
+	if err != nil {
+		return err
+	}
+	var sqlErr string = ""
+
+	for _, v := range newDDoc.Views {
+		if v.External != nil {
+			table := v.External.Table
+			if table == "" {
+				table = defaultExternalViewTable
+			}
+			if _, err := tx.Exec(externalViewTableSQL(table)); err != nil {
+				return err
+			}
+		}
+		for _, x := range v.Setup {
+			_, err := tx.ExecContext(ctx, x)
+			if err != nil {
+				sqlErr += fmt.Sprintf("%s: %s ;", x, err.Error())
+			}
+		}
+
+		if sqlErr != "" {
+			break
+		}
+
+		for _, x := range v.Run {
+			_, err := tx.ExecContext(ctx, x)
+			if err != nil {
+				sqlErr += fmt.Sprintf("%s: %s ;", x, err.Error())
+			}
+		}
+
+		if sqlErr != "" {
+			break
+		}
+	}
+
+	// interrupted statements aren't invalid
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("SELECT * FROM latest_changes WHERE 1 = 2")
+	if err != nil {
+		return errors.New("your script can't drop latest_changes")
+	}
+	_, err = tx.Exec("SELECT * FROM latest_documents WHERE 1 = 2")
+	if err != nil {
+		return errors.New("your script can't drop latest_documents")
+	}
+	_, err = tx.Exec("SELECT * FROM documents WHERE 1 = 2")
+	if err != nil {
+		return errors.New("your script can't drop documents")
+	}
+
+	if sqlErr != "" {
+		return fmt.Errorf("%s : %w", sqlErr, ErrInvalidSQLStmt)
+	}
+
+	return nil
+}
+
+func (mgr *DefaultViewManager) GetView(viewName string) (*View, bool) {
+	if view, ok := mgr.views[viewName]; ok {
+		return view, true
+	}
+	return nil, false
+}
+
+// validateViewDependencies walks the dependencies of every view in ddoc and
+// fails if a view ends up depending on itself.
+func (mgr *DefaultViewManager) validateViewDependencies(ddoc *DesignDocument) error {
+	ddocs := map[string]*DesignDocument{ddoc.ID: ddoc}
+
+	var visit func(ddocID, viewName string, path map[string]bool) error
+	visit = func(ddocID, viewName string, path map[string]bool) error {
+		qualifiedViewName := ddocID + "$" + viewName
+		if path[qualifiedViewName] {
+			return fmt.Errorf("%s: %w", fmt.Sprintf("%s depends on itself", qualifiedViewName), ErrViewInvalidDependency)
+		}
+
+		current, ok := ddocs[ddocID]
+		if !ok {
+			var err error
+			current, err = mgr.getDesignDocument(ddocID)
+			if err != nil {
+				return nil
+			}
+			ddocs[ddocID] = current
+		}
+
+		ddocv, ok := current.Views[viewName]
+		if !ok {
+			return nil
+		}
+
+		path[qualifiedViewName] = true
+		defer delete(path, qualifiedViewName)
+
+		for _, target := range ddocv.Depends {
+			if depDDocID, depViewName, ok := parseViewDependency(target); ok {
+				if err := visit(depDDocID, depViewName, path); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	for viewName := range ddoc.Views {
+		if err := visit(ddoc.ID, viewName, make(map[string]bool)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseViewDependency splits "<design doc>/<view>" into a design doc id and
+// view name, the _design/ prefix is optional.
+func parseViewDependency(target string) (string, string, bool) {
+	target = strings.TrimPrefix(target, "_design/")
+	i := strings.LastIndex(target, "/")
+	if i <= 0 || i == len(target)-1 {
+		return "", "", false
+	}
+	return "_design/" + target[:i], target[i+1:], true
+}
+
+func (mgr *DefaultViewManager) validateExternalView(external *DesignDocumentViewExternal) error {
+	if external.Server == "" {
+		return fmt.Errorf("%s: %w", "external view server is required", ErrViewInvalidExternal)
+	}
+	if mgr.serviceLocator == nil {
+		return fmt.Errorf("%s: %w", fmt.Sprintf("external view server %s is not registered", external.Server), ErrViewInvalidExternal)
+	}
+	if _, ok := mgr.serviceLocator.GetExternalViewCommand(external.Server); !ok {
+		return fmt.Errorf("%s: %w", fmt.Sprintf("external view server %s is not registered", external.Server), ErrViewInvalidExternal)
+	}
+	if external.Table != "" && !validateSourceAlias(external.Table) {
+		return fmt.Errorf("%s: %w", fmt.Sprintf("invalid external view table %s", external.Table), ErrViewInvalidExternal)
+	}
+	if external.BatchSize < 0 {
+		return fmt.Errorf("%s: %w", "external view batch_size can't be negative", ErrViewInvalidExternal)
+	}
+	return nil
+}
+
+var sourceAliasExp = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+func validateSourceAlias(alias string) bool {
+	switch strings.ToLower(alias) {
+	case "main", "temp", "docsdb":
+		return false
+	}
+	return sourceAliasExp.MatchString(alias)
+}
+
+func NewViewManager(serviceLocator ServiceLocator) *DefaultViewManager {
+	mgr := &DefaultViewManager{}
+	mgr.views = make(map[string]*View)
+	mgr.ddocs = make(map[string]*DesignDocument)
+	mgr.viewFiles = make(map[string]map[string]bool)
+	mgr.serviceLocator = serviceLocator
+	return mgr
+}
+
+var viewResultValidation = regexp.MustCompile("sql: expected (\\d+) destination arguments in Scan, not 1")
+
+type View struct {
+	name         string
+	ddocID       string
+	databaseURI  string
+	sourceURIs   map[string]string
+	dependencies map[string]*View
+
+	currentSeqID string
+	lastAccess   int64
+
+	viewReaderPool ViewReaderPool
+	viewWriter     ViewWriter
+
+	mux sync.Mutex
+}
+
+func (view *View) Open() error {
+	view.viewWriter.Open()
+	view.viewReaderPool.Open()
+	return nil
+}
+
+func (view *View) Close() error {
+	view.viewReaderPool.Close()
+	view.viewWriter.Close()
+	return nil
+}
+
+func (view *View) Build(ctx context.Context, nextSeqID string) error {
+	// views with source databases can't tell from nextSeqID alone whether
+	// the other change streams moved, so they always build
+	hasSources := view.hasSources()
+	if !hasSources && view.currentSeqID >= nextSeqID {
+		return nil
+	}
+
+	view.mux.Lock()
+	defer view.mux.Unlock()
+
+	return view.build(ctx, nextSeqID, hasSources)
+}
+
+// build brings dependencies up to nextSeqID first and keeps them locked until
+// this view is built, so a chain reads consistent tables. caller holds view.mux
+func (view *View) build(ctx context.Context, nextSeqID string, hasSources bool) error {
+	if !hasSources && view.currentSeqID >= nextSeqID {
+		return nil
+	}
+
+	for _, dependency := range view.sortedDependencies() {
+		dependency.mux.Lock()
+		defer dependency.mux.Unlock()
+
+		if err := dependency.build(ctx, nextSeqID, dependency.hasSources()); err != nil {
+			return err
+		}
+	}
+
+	err := view.viewWriter.Build(ctx, nextSeqID)
+	if err != nil {
+		return err
+	}
+
+	view.currentSeqID = nextSeqID
+
+	return nil
+}
+
+// sortedDependencies returns each dependency once, ordered by qualified name,
+// so concurrent builds sharing dependencies lock them in the same order
+func (view *View) sortedDependencies() []*View {
+	seen := make(map[*View]bool, len(view.dependencies))
+	dependencies := make([]*View, 0, len(view.dependencies))
+	for _, dependency := range view.dependencies {
+		if !seen[dependency] {
+			seen[dependency] = true
+			dependencies = append(dependencies, dependency)
+		}
+	}
+	sort.Slice(dependencies, func(i, j int) bool {
+		if dependencies[i].ddocID != dependencies[j].ddocID {
+			return dependencies[i].ddocID < dependencies[j].ddocID
+		}
+		return dependencies[i].name < dependencies[j].name
+	})
+	return dependencies
+}
+
+func (view *View) hasSources() bool {
+	if len(view.sourceURIs) > 0 {
+		return true
+	}
+	for _, dependency := range view.dependencies {
+		if dependency.hasSources() {
+			return true
+		}
+	}
+	return false
+}
+
+func (view *View) Select(ctx context.Context, name string, values url.Values) ([]byte, error) {
+	viewReader := view.viewReaderPool.Borrow()
+	defer view.viewReaderPool.Return(viewReader)
+	return viewReader.Select(ctx, name, values)
+}
+
+func (view *View) Vacuum() error {
+	return nil
+}
+
+var changeViewsSQL = `
+		CREATE TEMP VIEW latest_changes AS SELECT doc_id, deleted FROM docsdb.documents INDEXED BY idx_changes WHERE seq_id > (SELECT current_seq_id FROM view_meta WHERE Id = 1) AND seq_id <= (SELECT next_seq_id FROM view_meta WHERE Id = 1);
+		CREATE TEMP VIEW latest_documents AS SELECT doc_id, version, kind, deleted, JSON(data) as data FROM docsdb.documents WHERE seq_id > (SELECT current_seq_id FROM view_meta WHERE Id = 1) AND seq_id <= (SELECT next_seq_id FROM view_meta WHERE Id = 1);
+		CREATE TEMP VIEW documents AS SELECT doc_id, version, kind, deleted, JSON(data) as data FROM docsdb.documents;
+	`
+
+// readOnlySchemas makes the databases a view attaches read only for its
+// scripts. Memory databases can't be attached with mode=ro, so writes to
+// them are denied by an authorizer, which also keeps scripts from attaching
+// any other database.
+func readOnlySchemas(databaseURI string, sourceURIs, viewURIs map[string]string) func(*sqlite3.SQLiteConn) error {
+	schemas := map[string]bool{"docsdb": true}
+	uris := map[string]bool{databaseURI: true}
+	for alias, uri := range sourceURIs {
+		schemas[alias] = true
+		uris[uri] = true
+	}
+	for alias, uri := range viewURIs {
+		schemas[alias] = true
+		uris[uri] = true
+	}
+	return func(con *sqlite3.SQLiteConn) error {
+		con.RegisterAuthorizer(func(action int, arg1, arg2, schema string) int {
+			switch action {
+			case sqlite3.SQLITE_ATTACH:
+				if uris[arg1] {
+					return sqlite3.SQLITE_OK
+				}
+				return sqlite3.SQLITE_DENY
+			case sqlite3.SQLITE_ALTER_TABLE:
+				schema = arg1
+			case sqlite3.SQLITE_PRAGMA:
+				// pragmas with a value set it
+				if arg2 == "" {
+					return sqlite3.SQLITE_OK
+				}
+			case sqlite3.SQLITE_INSERT, sqlite3.SQLITE_UPDATE, sqlite3.SQLITE_DELETE,
+				sqlite3.SQLITE_CREATE_INDEX, sqlite3.SQLITE_CREATE_TABLE, sqlite3.SQLITE_CREATE_TRIGGER,
+				sqlite3.SQLITE_CREATE_VIEW, sqlite3.SQLITE_CREATE_VTABLE, sqlite3.SQLITE_DROP_INDEX,
+				sqlite3.SQLITE_DROP_TABLE, sqlite3.SQLITE_DROP_TRIGGER, sqlite3.SQLITE_DROP_VIEW,
+				sqlite3.SQLITE_DROP_VTABLE, sqlite3.SQLITE_REINDEX, sqlite3.SQLITE_ANALYZE:
+			default:
+				return sqlite3.SQLITE_OK
+			}
+			if schemas[schema] {
+				return sqlite3.SQLITE_DENY
+			}
+			return sqlite3.SQLITE_OK
+		})
+		return nil
+	}
+}
+
+func setupDatabase(db *sql.DB, databaseURI string, sourceURIs, viewURIs map[string]string) error {
+	_, err := db.Exec("ATTACH DATABASE '" + databaseURI + "' as docsdb;")
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(changeViewsSQL)
+	if err != nil {
+		return err
+	}
+
+	for alias, uri := range sourceURIs {
+		_, err := db.Exec("ATTACH DATABASE '" + uri + "' as " + alias + ";")
+		if err != nil {
+			return err
+		}
+
+		_, err = db.Exec(fmt.Sprintf(`
+			CREATE TEMP VIEW latest_changes_%[1]s AS SELECT doc_id, deleted FROM %[1]s.documents INDEXED BY idx_changes WHERE seq_id > (SELECT current_seq_id FROM view_meta WHERE source = '%[1]s') AND seq_id <= (SELECT next_seq_id FROM view_meta WHERE source = '%[1]s');
+			CREATE TEMP VIEW latest_documents_%[1]s AS SELECT doc_id, version, kind, deleted, JSON(data) as data FROM %[1]s.documents WHERE seq_id > (SELECT current_seq_id FROM view_meta WHERE source = '%[1]s') AND seq_id <= (SELECT next_seq_id FROM view_meta WHERE source = '%[1]s');
+		`, alias))
+
+		if err != nil {
+			return err
+		}
+	}
+
+	for alias, uri := range viewURIs {
+		_, err := db.Exec("ATTACH DATABASE '" + uri + "' as " + alias + ";")
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func NewView(viewName, connectionString, databaseURI string, sourceURIs, viewURIs map[string]string, decrypt func(interface{}) (string, error), ddoc *DesignDocument, viewManager ViewManager, serviceLocator ServiceLocator) *View {
+	view := &View{}
+
+	if _, ok := ddoc.Views[viewName]; !ok {
+		return nil
+	}
+
+	view.name = viewName
+	view.ddocID = ddoc.ID
+	view.databaseURI = databaseURI
+	view.sourceURIs = sourceURIs
+
+	setupScripts := *new([]Query)
+	scripts := *new([]Query)
+	selectScripts := make(map[string]Query)
+	designDocView := ddoc.Views[viewName]
+
+	for _, text := range designDocView.Setup {
+		setupScripts = append(setupScripts, Query{text: text})
+	}
+	for _, text := range designDocView.Run {
+		scripts = append(scripts, Query{text: text})
+	}
+	for k, v := range designDocView.Select {
+		text, params := parseQueryParams(v, designDocView.Params)
+		selectScripts[k] = Query{text: text, params: params}
+	}
+
+	if designDocView.External != nil {
+		command, _ := serviceLocator.GetExternalViewCommand(designDocView.External.Server)
+		view.viewWriter = NewExternalViewWriter(withMode(connectionString, "rwc"), databaseURI, sourceURIs, viewURIs, decrypt, setupScripts, scripts, designDocView.External, command)
+	} else {
+		view.viewWriter = NewViewWriter(withMode(connectionString, "rwc"), databaseURI, sourceURIs, viewURIs, decrypt, setupScripts, scripts)
+	}
+	view.viewReaderPool = NewViewReaderPool(withMode(connectionString, "ro"), databaseURI, sourceURIs, viewURIs, decrypt, 4, serviceLocator, selectScripts)
+
+	return view
+}
@@ -0,0 +1,193 @@
+package kdb
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+)
+
+// TestDesignDocument runs a design document against sample documents in
+// memory, without touching the database or its views. Every statement is
+// reported with its error, and every select with its result.
+func (mgr *DefaultViewManager) TestDesignDocument(ctx context.Context, doc *Document, docs []*Document, values url.Values) (*DesignDocumentTestResult, error) {
+	ddoc := &DesignDocument{}
+	if err := json.Unmarshal(doc.Data, ddoc); err != nil {
+		return nil, fmt.Errorf("%s: %w", err, ErrBadJSON)
+	}
+
+	docsDBName := "file:" + hex.EncodeToString(randomBytes(8)) + "?mode=memory&cache=shared"
+	writer := new(DefaultDatabaseWriter)
+	writer.reader = new(DefaultDatabaseReader)
+	if err := writer.Open(docsDBName); err != nil {
+		return nil, err
+	}
+	defer writer.Close()
+
+	updateSeqID, err := loadSampleDocuments(ctx, writer, docs)
+	if err != nil {
+		return nil, err
+	}
+
+	var viewNames []string
+	for name := range ddoc.Views {
+		viewNames = append(viewNames, name)
+	}
+	sort.Strings(viewNames)
+
+	result := &DesignDocumentTestResult{OK: true, Views: make(map[string]*DesignDocumentViewTestResult)}
+	for _, name := range viewNames {
+		decrypt := mgr.db.cipher.viewDecrypt(doc.ID, name)
+		viewResult, err := mgr.testDesignDocumentView(ctx, docsDBName, updateSeqID, ddoc.Views[name], decrypt, values)
+		if err != nil {
+			return nil, err
+		}
+		result.Views[name] = viewResult
+		if viewResult.Error != "" {
+			result.OK = false
+		}
+		for _, x := range append(viewResult.Setup, viewResult.Run...) {
+			if x.Error != "" {
+				result.OK = false
+			}
+		}
+		for _, x := range viewResult.Select {
+			if x.Error != "" {
+				result.OK = false
+			}
+		}
+	}
+
+	return result, nil
+}
+
+func loadSampleDocuments(ctx context.Context, writer *DefaultDatabaseWriter, docs []*Document) (string, error) {
+	if err := writer.Begin(ctx); err != nil {
+		return "", err
+	}
+	defer writer.Rollback()
+
+	if err := writer.ExecBuildScript(); err != nil {
+		return "", err
+	}
+
+	idSeq := NewSequenceUUIDGenarator()
+	changeSeq := NewChangeSequenceGenarator(138, "")
+	updateSeqID := ""
+	for _, doc := range docs {
+		if doc.ID == "" {
+			doc.ID = idSeq.Next()
+		}
+		if doc.Version <= 0 {
+			doc.CalculateNextVersion()
+		}
+		updateSeqID = changeSeq.Next()
+		if err := writer.PutDocument(updateSeqID, doc, nil); err != nil {
+			return "", err
+		}
+	}
+
+	return updateSeqID, writer.Commit()
+}
+
+func (mgr *DefaultViewManager) testDesignDocumentView(ctx context.Context, docsDBName, updateSeqID string, ddocv *DesignDocumentView, decrypt func(interface{}) (string, error), values url.Values) (*DesignDocumentViewTestResult, error) {
+	result := &DesignDocumentViewTestResult{Select: make(map[string]*SelectTestResult)}
+	if len(ddocv.Sources) > 0 || len(ddocv.Depends) > 0 {
+		result.Error = "views with sources or depends can't be tested"
+		return result, nil
+	}
+	if ddocv.External != nil {
+		result.Error = "external views can't be tested"
+		return result, nil
+	}
+
+	db := openSQL(":memory:", readOnlySchemas(docsDBName, nil, nil), registerDecrypt(decrypt))
+	defer db.Close()
+	// temp views and attached databases are per connection
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(viewMetaBuildSQL); err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec("UPDATE view_meta SET next_seq_id = ? WHERE Id = 1", updateSeqID); err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec("ATTACH DATABASE '" + docsDBName + "' as docsdb;"); err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(changeViewsSQL); err != nil {
+		return nil, err
+	}
+
+	failed := false
+	for _, x := range ddocv.Setup {
+		stmt := StatementTestResult{Statement: x}
+		if _, err := db.ExecContext(ctx, x); err != nil {
+			stmt.Error = err.Error()
+			failed = true
+		}
+		result.Setup = append(result.Setup, stmt)
+	}
+
+	if !failed {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, x := range ddocv.Run {
+			stmt := StatementTestResult{Statement: x}
+			if _, err := tx.ExecContext(ctx, x); err != nil {
+				stmt.Error = err.Error()
+				failed = true
+			}
+			result.Run = append(result.Run, stmt)
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+	}
+
+	for name, text := range ddocv.Select {
+		selectResult := &SelectTestResult{}
+		result.Select[name] = selectResult
+		if failed {
+			selectResult.Error = "skipped, setup or run failed"
+			continue
+		}
+
+		text, params := parseQueryParams(text, ddocv.Params)
+		pValues := make([]interface{}, len(params))
+		for i, p := range params {
+			value, err := bindQueryParam(p, values[p.name])
+			if err != nil {
+				selectResult.Error = getErrorDescription(err)
+				break
+			}
+			pValues[i] = value
+		}
+		if selectResult.Error != "" {
+			continue
+		}
+
+		var rs string
+		if err := db.QueryRowContext(ctx, text, pValues...).Scan(&rs); err != nil {
+			selectResult.Error = err.Error()
+			continue
+		}
+		if json.Valid([]byte(rs)) {
+			selectResult.Result = json.RawMessage(rs)
+		} else {
+			b, _ := json.Marshal(rs)
+			selectResult.Result = b
+		}
+	}
+
+	// interrupted statements would be reported as failing
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
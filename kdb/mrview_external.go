@@ -0,0 +1,284 @@
+package kdb
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+var defaultExternalViewTable = "rows"
+var defaultExternalViewBatchSize = 100
+
+func externalViewTableSQL(table string) string {
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		key,
+		value,
+		doc_id TEXT,
+		PRIMARY KEY (key, doc_id)
+	) WITHOUT ROWID;
+
+	CREATE INDEX IF NOT EXISTS %s_doc_id ON %s (doc_id);`, table, table, table)
+}
+
+type externalViewRow struct {
+	Key   json.RawMessage `json:"key"`
+	Value json.RawMessage `json:"value"`
+	DocID string          `json:"doc_id"`
+}
+
+type externalViewResponse struct {
+	Upsert []externalViewRow `json:"upsert"`
+	Delete []externalViewRow `json:"delete"`
+	Error  string            `json:"error"`
+}
+
+// ExternalViewWriter builds a view with an external view server. Changed
+// documents are written to the server's stdin as one json line per batch,
+// {"docs":[...]}, and the server answers each batch with one json line,
+// {"upsert":[{"key":..,"value":..,"doc_id":..}],"delete":[{"doc_id":..}]}.
+// Rows are kept in the view table, seq tracking and selects stay with kdb.
+type ExternalViewWriter struct {
+	DefaultViewWriter
+
+	server    string
+	command   []string
+	table     string
+	batchSize int
+
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+}
+
+func (vw *ExternalViewWriter) Close() error {
+	vw.stop()
+	return vw.DefaultViewWriter.Close()
+}
+
+func (vw *ExternalViewWriter) Build(ctx context.Context, nextSeqID string) error {
+	db := vw.con
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := vw.updateViewMeta(tx, nextSeqID); err != nil {
+		return err
+	}
+
+	lastDocID := ""
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		docs, err := vw.nextBatch(ctx, tx, lastDocID)
+		if err != nil {
+			return err
+		}
+		if len(docs) == 0 {
+			break
+		}
+		lastDocID = docs[len(docs)-1].id
+
+		resp, err := vw.exchange(docs)
+		if err != nil {
+			// the server is restarted on the next build
+			vw.stop()
+			return err
+		}
+		if err := vw.apply(tx, resp); err != nil {
+			return err
+		}
+
+		if len(docs) < vw.batchSize {
+			break
+		}
+	}
+
+	for _, x := range vw.scripts {
+		if _, err = tx.ExecContext(ctx, x.text); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+type externalViewDocument struct {
+	id   string
+	data string
+}
+
+func (vw *ExternalViewWriter) nextBatch(ctx context.Context, tx *sql.Tx, lastDocID string) ([]externalViewDocument, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT doc_id, version, IFNULL(kind, ''), deleted, IFNULL(data, '{}') FROM latest_documents WHERE doc_id > ? ORDER BY doc_id LIMIT ?", lastDocID, vw.batchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var docs []externalViewDocument
+	for rows.Next() {
+		var (
+			id, kind, data string
+			version        int
+			deleted        bool
+		)
+		if err := rows.Scan(&id, &version, &kind, &deleted, &data); err != nil {
+			return nil, err
+		}
+		idJSON, _ := json.Marshal(id)
+		meta := fmt.Sprintf(`{"_id":%s,"_version":%d`, idJSON, version)
+		if kind != "" {
+			kindJSON, _ := json.Marshal(kind)
+			meta += fmt.Sprintf(`,"_kind":%s`, kindJSON)
+		}
+		if deleted {
+			meta += `,"_deleted":true`
+		}
+		if len(data) > 2 {
+			meta += ","
+		}
+		docs = append(docs, externalViewDocument{id: id, data: meta + data[1:]})
+	}
+
+	return docs, rows.Err()
+}
+
+func (vw *ExternalViewWriter) start() error {
+	if len(vw.command) == 0 {
+		return fmt.Errorf("%s: %w", fmt.Sprintf("external view server %s is not registered", vw.server), ErrExternalView)
+	}
+
+	cmd := exec.Command(vw.command[0], vw.command[1:]...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("%s: %w", err, ErrExternalView)
+	}
+
+	vw.cmd = cmd
+	vw.stdin = stdin
+	vw.stdout = bufio.NewReader(stdout)
+	return nil
+}
+
+func (vw *ExternalViewWriter) stop() {
+	if vw.cmd == nil {
+		return
+	}
+	vw.stdin.Close()
+	vw.cmd.Process.Kill()
+	vw.cmd.Wait()
+	vw.cmd = nil
+	vw.stdin = nil
+	vw.stdout = nil
+}
+
+func (vw *ExternalViewWriter) exchange(docs []externalViewDocument) (*externalViewResponse, error) {
+	if vw.cmd == nil {
+		if err := vw.start(); err != nil {
+			return nil, err
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(`{"docs":[`)
+	for i, doc := range docs {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString(doc.data)
+	}
+	b.WriteString("]}\n")
+
+	if _, err := io.WriteString(vw.stdin, b.String()); err != nil {
+		return nil, fmt.Errorf("%s: %w", err, ErrExternalView)
+	}
+
+	line, err := vw.stdout.ReadBytes('\n')
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", err, ErrExternalView)
+	}
+
+	resp := &externalViewResponse{}
+	if err := json.Unmarshal(line, resp); err != nil {
+		return nil, fmt.Errorf("%s: %w", err, ErrExternalView)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("%s: %w", resp.Error, ErrExternalView)
+	}
+
+	return resp, nil
+}
+
+func (vw *ExternalViewWriter) apply(tx *sql.Tx, resp *externalViewResponse) error {
+	for _, row := range resp.Delete {
+		if len(row.Key) == 0 {
+			if _, err := tx.Exec("DELETE FROM "+vw.table+" WHERE doc_id = ?", row.DocID); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := tx.Exec("DELETE FROM "+vw.table+" WHERE doc_id = ? AND key = ?", row.DocID, externalViewKey(row.Key)); err != nil {
+			return err
+		}
+	}
+
+	for _, row := range resp.Upsert {
+		var value interface{}
+		if len(row.Value) > 0 {
+			value = string(row.Value)
+		}
+		if _, err := tx.Exec("INSERT OR REPLACE INTO "+vw.table+" (key, value, doc_id) VALUES (?, ?, ?)", externalViewKey(row.Key), value, row.DocID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// externalViewKey stores scalar keys as sqlite values, so they sort and
+// compare naturally, and objects or arrays as json text.
+func externalViewKey(raw json.RawMessage) interface{} {
+	var v interface{}
+	if len(raw) == 0 || json.Unmarshal(raw, &v) != nil {
+		return nil
+	}
+	switch v.(type) {
+	case map[string]interface{}, []interface{}:
+		return string(raw)
+	}
+	return v
+}
+
+func NewExternalViewWriter(connectionString, databaseURI string, sourceURIs, viewURIs map[string]string, decrypt func(interface{}) (string, error), setupScripts, scripts []Query, external *DesignDocumentViewExternal, command []string) *ExternalViewWriter {
+	viewWriter := new(ExternalViewWriter)
+	viewWriter.server = external.Server
+	viewWriter.command = command
+	viewWriter.table = external.Table
+	if viewWriter.table == "" {
+		viewWriter.table = defaultExternalViewTable
+	}
+	viewWriter.batchSize = external.BatchSize
+	if viewWriter.batchSize <= 0 {
+		viewWriter.batchSize = defaultExternalViewBatchSize
+	}
+
+	setupScripts = append([]Query{{text: externalViewTableSQL(viewWriter.table)}}, setupScripts...)
+	viewWriter.DefaultViewWriter = *NewViewWriter(connectionString, databaseURI, sourceURIs, viewURIs, decrypt, setupScripts, scripts)
+	return viewWriter
+}
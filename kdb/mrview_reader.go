@@ -0,0 +1,233 @@
+package kdb
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/valyala/fastjson"
+)
+
+type ViewReader interface {
+	Open() error
+	Close() error
+	Select(ctx context.Context, name string, values url.Values) ([]byte, error)
+}
+
+type DefaultViewReader struct {
+	connectionString string
+	databaseURI      string
+	sourceURIs       map[string]string
+	viewURIs         map[string]string
+	decrypt          func(interface{}) (string, error)
+	selectScripts    map[string]Query
+
+	con *sql.DB
+}
+
+func (vr *DefaultViewReader) Open() error {
+	db := openSQL(vr.connectionString, readOnlySchemas(vr.databaseURI, vr.sourceURIs, vr.viewURIs), registerDecrypt(vr.decrypt))
+	vr.con = db
+
+	return setupDatabase(db, vr.databaseURI, vr.sourceURIs, vr.viewURIs)
+}
+
+func (vr *DefaultViewReader) Close() error {
+	return vr.con.Close()
+}
+
+func (vr *DefaultViewReader) Select(ctx context.Context, name string, values url.Values) ([]byte, error) {
+	var rs string
+	selectStmt := vr.selectScripts[name]
+	pValues := make([]interface{}, len(selectStmt.params))
+	for i, p := range selectStmt.params {
+		pv, err := bindQueryParam(p, values[p.name])
+		if err != nil {
+			return nil, err
+		}
+		pValues[i] = pv
+	}
+
+	row := vr.con.QueryRowContext(ctx, selectStmt.text, pValues...)
+	err := row.Scan(&rs)
+	if err != nil {
+		o := viewResultValidation.FindAllStringSubmatch(err.Error(), -1)
+		if len(o) > 0 {
+			return nil, fmt.Errorf("%s: %w", fmt.Sprintf("select have %s, want 1 column", o[0][1]), ErrViewResult)
+		}
+		return nil, err
+	}
+	return []byte(rs), nil
+}
+
+// bindQueryParam converts query string values to the type declared by the
+// select placeholder, falling back to the design document default.
+func bindQueryParam(param QueryParam, input []string) (interface{}, error) {
+	if len(input) == 0 || input[0] == "" {
+		input = param.defaultValue
+	}
+
+	if len(input) == 0 || input[0] == "" {
+		if param.required {
+			return nil, fmt.Errorf("%s: %w", fmt.Sprintf("%s is required", param.name), ErrViewInvalidParam)
+		}
+		return nil, nil
+	}
+
+	value := input[0]
+	invalidParam := fmt.Errorf("%s: %w", fmt.Sprintf("%s expected %s, got %q", param.name, param.kind, value), ErrViewInvalidParam)
+
+	switch param.kind {
+	case "int":
+		v, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, invalidParam
+		}
+		return v, nil
+	case "real":
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, invalidParam
+		}
+		return v, nil
+	case "bool":
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, invalidParam
+		}
+		return v, nil
+	case "json":
+		if err := fastjson.Validate(value); err != nil {
+			return nil, invalidParam
+		}
+		return value, nil
+	case "array":
+		if len(input) == 1 && strings.HasPrefix(strings.TrimSpace(value), "[") {
+			v, err := fastjson.Parse(value)
+			if err != nil || v.Type() != fastjson.TypeArray {
+				return nil, invalidParam
+			}
+			if param.items == "" {
+				return value, nil
+			}
+			input = input[:0:0]
+			for _, item := range v.GetArray() {
+				if item.Type() == fastjson.TypeString {
+					input = append(input, string(item.GetStringBytes()))
+				} else {
+					input = append(input, item.String())
+				}
+			}
+		} else if len(input) == 1 {
+			input = strings.Split(value, ",")
+		}
+		if param.items == "" {
+			b, _ := json.Marshal(input)
+			return string(b), nil
+		}
+		// json_each returns numbers as numbers, so they match numeric
+		// expressions
+		items := make([]interface{}, len(input))
+		for i, item := range input {
+			if strings.TrimSpace(item) == "" {
+				return nil, invalidParam
+			}
+			v, err := bindQueryParam(QueryParam{name: param.name, kind: param.items}, []string{strings.TrimSpace(item)})
+			if err != nil {
+				return nil, err
+			}
+			items[i] = v
+		}
+		b, _ := json.Marshal(items)
+		return string(b), nil
+	}
+
+	return value, nil
+}
+
+func NewViewReader(connectionString, databaseURI string, sourceURIs, viewURIs map[string]string, decrypt func(interface{}) (string, error), selectScripts map[string]Query) *DefaultViewReader {
+	viewReader := new(DefaultViewReader)
+	viewReader.connectionString = connectionString
+	viewReader.databaseURI = databaseURI
+	viewReader.sourceURIs = sourceURIs
+	viewReader.viewURIs = viewURIs
+	viewReader.decrypt = decrypt
+	viewReader.selectScripts = selectScripts
+	return viewReader
+}
+
+type ViewReaderPool interface {
+	Open() error
+	Borrow() ViewReader
+	Return(r ViewReader)
+	Close() error
+}
+
+type DefaultViewReaderPool struct {
+	connectionString string
+	databaseURI      string
+	sourceURIs       map[string]string
+	viewURIs         map[string]string
+	decrypt          func(interface{}) (string, error)
+	selectScripts    map[string]Query
+
+	serviceLocator ServiceLocator
+	pool           chan ViewReader
+	limit          int
+}
+
+func (p *DefaultViewReaderPool) Open() error {
+	for x := 0; x < p.limit; x++ {
+		r := p.serviceLocator.GetViewReader(p.connectionString, p.databaseURI, p.sourceURIs, p.viewURIs, p.decrypt, p.selectScripts)
+		err := r.Open()
+		if err != nil {
+			panic(err)
+		}
+		p.pool <- r
+	}
+	return nil
+}
+
+func (p *DefaultViewReaderPool) Borrow() ViewReader {
+	return <-p.pool
+}
+
+func (p *DefaultViewReaderPool) Return(r ViewReader) {
+	p.pool <- r
+}
+
+func (p *DefaultViewReaderPool) Close() error {
+	var err error
+	for {
+		var r ViewReader
+		select {
+		case r = <-p.pool:
+			err = r.Close()
+		default:
+		}
+		if r == nil {
+			break
+		}
+	}
+
+	return err
+}
+
+func NewViewReaderPool(connectionString, databaseURI string, sourceURIs, viewURIs map[string]string, decrypt func(interface{}) (string, error), limit int, serviceLocator ServiceLocator, selectScripts map[string]Query) ViewReaderPool {
+	readers := DefaultViewReaderPool{
+		connectionString: connectionString,
+		databaseURI:      databaseURI,
+		sourceURIs:       sourceURIs,
+		viewURIs:         viewURIs,
+		decrypt:          decrypt,
+		pool:             make(chan ViewReader, limit),
+		limit:            limit,
+		selectScripts:    selectScripts,
+		serviceLocator:   serviceLocator,
+	}
+	return &readers
+}
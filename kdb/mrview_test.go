@@ -0,0 +1,180 @@
+package kdb
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+)
+
+func TestParseQueryParamsTyped(t *testing.T) {
+	mgr := NewViewManager(nil)
+	text, params := mgr.ParseQueryParams("SELECT * FROM t WHERE age > ${age:int} AND id IN ${ids:array} AND key = ${key}")
+
+	expected := "SELECT * FROM t WHERE age > ? AND id IN (SELECT value FROM json_each(?)) AND key = ?"
+	if text != expected {
+		t.Errorf("expected %s, got %s", expected, text)
+	}
+
+	if len(params) != 3 {
+		t.Fatalf("expected 3 params, got %d", len(params))
+	}
+
+	if params[0].name != "age" || params[0].kind != "int" {
+		t.Errorf("unexpected param %v", params[0])
+	}
+
+	if params[1].name != "ids" || params[1].kind != "array" {
+		t.Errorf("unexpected param %v", params[1])
+	}
+
+	if params[2].name != "key" || params[2].kind != "" {
+		t.Errorf("unexpected param %v", params[2])
+	}
+
+	_, params = mgr.ParseQueryParams("SELECT * FROM t WHERE n IN ${ns:array:int}")
+	if len(params) != 1 || params[0].kind != "array" || params[0].items != "int" {
+		t.Errorf("unexpected params %v", params)
+	}
+}
+
+func TestApplyQueryParamDefinitions(t *testing.T) {
+	mgr := NewViewManager(nil)
+	_, params := mgr.ParseQueryParams("SELECT ${age}, ${tags:json}, ${limit}")
+	params = applyQueryParamDefinitions(params, map[string]*DesignDocumentViewParam{
+		"age":   {Type: "int", Required: true},
+		"tags":  {Type: "int", Default: []interface{}{"a"}},
+		"limit": {Default: 10.0},
+	})
+
+	if params[0].kind != "int" || !params[0].required {
+		t.Errorf("unexpected param %v", params[0])
+	}
+
+	if params[1].kind != "json" || params[1].defaultValue[0] != `["a"]` {
+		t.Errorf("placeholder type should win, got %v", params[1])
+	}
+
+	if params[2].defaultValue[0] != "10" {
+		t.Errorf("unexpected default %v", params[2].defaultValue)
+	}
+}
+
+func TestBindQueryParam(t *testing.T) {
+	values := url.Values{}
+	values.Set("age", "18")
+	values.Set("score", "1.5")
+	values.Set("active", "true")
+	values.Set("tags", `{"a":1}`)
+	values.Add("ids", "1")
+	values.Add("ids", "2")
+	values.Set("keys", "a,b")
+	values.Set("nums", "[1,2]")
+
+	tests := []struct {
+		param    QueryParam
+		expected interface{}
+	}{
+		{QueryParam{name: "age", kind: "int"}, int64(18)},
+		{QueryParam{name: "score", kind: "real"}, 1.5},
+		{QueryParam{name: "active", kind: "bool"}, true},
+		{QueryParam{name: "tags", kind: "json"}, `{"a":1}`},
+		{QueryParam{name: "ids", kind: "array"}, `["1","2"]`},
+		{QueryParam{name: "keys", kind: "array"}, `["a","b"]`},
+		{QueryParam{name: "nums", kind: "array"}, `[1,2]`},
+		{QueryParam{name: "ids", kind: "array", items: "int"}, `[1,2]`},
+		{QueryParam{name: "nums", kind: "array", items: "real"}, `[1,2]`},
+		{QueryParam{name: "keys", kind: "array", items: "text"}, `["a","b"]`},
+		{QueryParam{name: "age"}, "18"},
+		{QueryParam{name: "missing"}, nil},
+		{QueryParam{name: "missing", kind: "int", defaultValue: []string{"5"}}, int64(5)},
+	}
+
+	for _, x := range tests {
+		v, err := bindQueryParam(x.param, values[x.param.name])
+		if err != nil {
+			t.Errorf("unexpected error %s", err)
+		}
+		if v != x.expected {
+			t.Errorf("%s: expected %v, got %v", x.param.name, x.expected, v)
+		}
+	}
+}
+
+func TestBindQueryParamInvalid(t *testing.T) {
+	values := url.Values{}
+	values.Set("age", "abc")
+	values.Set("tags", "{")
+	values.Set("ids", "[1,")
+
+	values.Set("keys", "1,a")
+
+	tests := []QueryParam{
+		{name: "age", kind: "int"},
+		{name: "tags", kind: "json"},
+		{name: "ids", kind: "array"},
+		{name: "keys", kind: "array", items: "int"},
+		{name: "missing", required: true},
+	}
+
+	for _, x := range tests {
+		_, err := bindQueryParam(x, values[x.name])
+		if !errors.Is(err, ErrViewInvalidParam) {
+			t.Errorf("%s: expected %s, got %v", x.name, ErrViewInvalidParam, err)
+		}
+	}
+}
+
+func TestValidateDesignDocumentParams(t *testing.T) {
+	mgr := NewViewManager(nil)
+
+	doc, _ := ParseDocument([]byte(`{"_id":"_design/test","views":{"v":{"select":{"default":"SELECT ${age:integer}"}}}}`))
+	if err := mgr.ValidateDesignDocument(context.Background(), doc); !errors.Is(err, ErrViewInvalidParam) {
+		t.Errorf("expected %s, got %v", ErrViewInvalidParam, err)
+	}
+
+	doc, _ = ParseDocument([]byte(`{"_id":"_design/test","views":{"v":{"select":{"default":"SELECT ${age}"},"params":{"age":{"type":"int","default":"abc"}}}}}`))
+	if err := mgr.ValidateDesignDocument(context.Background(), doc); !errors.Is(err, ErrViewInvalidParam) {
+		t.Errorf("expected %s, got %v", ErrViewInvalidParam, err)
+	}
+
+	doc, _ = ParseDocument([]byte(`{"_id":"_design/test","views":{"v":{"select":{"default":"SELECT ${age}"},"params":{"age":{"type":"int","items":"int"}}}}}`))
+	if err := mgr.ValidateDesignDocument(context.Background(), doc); !errors.Is(err, ErrViewInvalidParam) {
+		t.Errorf("expected %s, got %v", ErrViewInvalidParam, err)
+	}
+
+	doc, _ = ParseDocument([]byte(`{"_id":"_design/test","views":{"v":{"select":{"default":"SELECT ${age}"},"params":{"age":{"type":"int","default":18}}}}}`))
+	if err := mgr.ValidateDesignDocument(context.Background(), doc); err != nil {
+		t.Errorf("unexpected error %s", err)
+	}
+}
+
+func TestValidateDesignDocumentSources(t *testing.T) {
+	mgr := NewViewManager(nil)
+
+	doc, _ := ParseDocument([]byte(`{"_id":"_design/test","views":{"v":{"sources":{"main":"crm"}}}}`))
+	if err := mgr.ValidateDesignDocument(context.Background(), doc); !errors.Is(err, ErrViewInvalidSource) {
+		t.Errorf("expected %s, got %v", ErrViewInvalidSource, err)
+	}
+
+	for _, name := range []string{"_crm", "_users", "_replicator"} {
+		doc, _ = ParseDocument([]byte(`{"_id":"_design/test","views":{"v":{"sources":{"crm":"` + name + `"}}}}`))
+		if err := mgr.ValidateDesignDocument(context.Background(), doc); !errors.Is(err, ErrViewInvalidSource) {
+			t.Errorf("%s: expected %s, got %v", name, ErrViewInvalidSource, err)
+		}
+	}
+
+	doc, _ = ParseDocument([]byte(`{"_id":"_design/test","views":{"v":{"sources":{"crm":"crm"},"run":["SELECT * FROM latest_changes_crm JOIN crm.documents USING (doc_id)"]}}}`))
+	if err := mgr.ValidateDesignDocument(context.Background(), doc); err != nil {
+		t.Errorf("unexpected error %s", err)
+	}
+}
+
+func TestValidateDesignDocumentBadJSON(t *testing.T) {
+	mgr := NewViewManager(nil)
+
+	doc, _ := ParseDocument([]byte(`{"_id":"_design/test","views":{"v":{"setup":"CREATE TABLE t (a)"}}}`))
+	if err := mgr.ValidateDesignDocument(context.Background(), doc); !errors.Is(err, ErrBadJSON) {
+		t.Errorf("expected %s, got %v", ErrBadJSON, err)
+	}
+}
@@ -0,0 +1,162 @@
+package kdb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+var viewMetaBuildSQL = `CREATE TABLE IF NOT EXISTS view_meta (
+		Id						INTEGER PRIMARY KEY,
+		current_seq_id		  	TEXT,
+		next_seq_id		  		TEXT
+	) WITHOUT ROWID;
+
+	INSERT INTO view_meta (Id, current_seq_id, next_seq_id) 
+		SELECT 1,"", "" WHERE NOT EXISTS (SELECT 1 FROM view_meta WHERE Id = 1);
+	`
+
+type ViewWriter interface {
+	Open() error
+	Close() error
+	Build(ctx context.Context, nextSeqID string) error
+}
+
+type DefaultViewWriter struct {
+	connectionString string
+	databaseURI      string
+	sourceURIs       map[string]string
+	viewURIs         map[string]string
+	decrypt          func(interface{}) (string, error)
+	setupScripts     []Query
+	scripts          []Query
+
+	con *sql.DB
+}
+
+func (vw *DefaultViewWriter) Open() error {
+	db := openSQL(vw.connectionString, readOnlySchemas(vw.databaseURI, vw.sourceURIs, vw.viewURIs), registerDecrypt(vw.decrypt))
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec(viewMetaBuildSQL); err != nil {
+		return err
+	}
+
+	if len(vw.sourceURIs) > 0 {
+		if err = setupSourceMeta(tx, vw.sourceURIs); err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	err = setupDatabase(db, vw.databaseURI, vw.sourceURIs, vw.viewURIs)
+	if err != nil {
+		return err
+	}
+
+	tx, err = db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, x := range vw.setupScripts {
+		if _, err = tx.Exec(x.text); err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	vw.con = db
+
+	return nil
+}
+
+func (vw *DefaultViewWriter) Close() error {
+	return vw.con.Close()
+}
+
+// Build runs the view scripts for the changes up to nextSeqID, they are
+// interrupted and rolled back once ctx is done.
+func (vw *DefaultViewWriter) Build(ctx context.Context, nextSeqID string) error {
+	db := vw.con
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := vw.updateViewMeta(tx, nextSeqID); err != nil {
+		return err
+	}
+
+	for _, x := range vw.scripts {
+		if _, err = tx.ExecContext(ctx, x.text); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// setupSourceMeta adds a view_meta row per source database, so each attached
+// change stream keeps its own current and next seq ids.
+func setupSourceMeta(tx *sql.Tx, sourceURIs map[string]string) error {
+	var hasSource int
+	row := tx.QueryRow("SELECT COUNT(1) FROM pragma_table_info('view_meta') WHERE name = 'source'")
+	if err := row.Scan(&hasSource); err != nil {
+		return err
+	}
+
+	if hasSource == 0 {
+		if _, err := tx.Exec("ALTER TABLE view_meta ADD COLUMN source TEXT"); err != nil {
+			return err
+		}
+	}
+
+	for alias := range sourceURIs {
+		sqlInsertSourceMeta := `INSERT INTO view_meta (Id, source, current_seq_id, next_seq_id)
+			SELECT (SELECT MAX(Id) FROM view_meta) + 1, ?, "", "" WHERE NOT EXISTS (SELECT 1 FROM view_meta WHERE source = ?)`
+		if _, err := tx.Exec(sqlInsertSourceMeta, alias, alias); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (vw *DefaultViewWriter) updateViewMeta(tx *sql.Tx, nextSeqID string) error {
+	sqlUpdateViewMeta := "UPDATE view_meta SET current_seq_id = next_seq_id, next_seq_id = ? WHERE Id = 1"
+	if _, err := tx.Exec(sqlUpdateViewMeta, nextSeqID); err != nil {
+		return err
+	}
+
+	for alias := range vw.sourceURIs {
+		sqlUpdateSourceMeta := fmt.Sprintf("UPDATE view_meta SET current_seq_id = next_seq_id, next_seq_id = (SELECT IFNULL(MAX(seq_id), '') FROM %s.documents) WHERE source = ?", alias)
+		if _, err := tx.Exec(sqlUpdateSourceMeta, alias); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func NewViewWriter(connectionString, databaseURI string, sourceURIs, viewURIs map[string]string, decrypt func(interface{}) (string, error), setupScripts, scripts []Query) *DefaultViewWriter {
+	viewWriter := new(DefaultViewWriter)
+	viewWriter.connectionString = connectionString
+	viewWriter.databaseURI = databaseURI
+	viewWriter.sourceURIs = sourceURIs
+	viewWriter.viewURIs = viewURIs
+	viewWriter.decrypt = decrypt
+	viewWriter.setupScripts = setupScripts
+	viewWriter.scripts = scripts
+	return viewWriter
+}
@@ -0,0 +1,70 @@
+package kdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Quota limits what a database stores, 0 is unlimited. MaxDocs is the number
+// of live documents, MaxBytes the size of the database file and MaxDocSize
+// the size of a document's json. Deletes are always allowed.
+type Quota struct {
+	MaxDocs    int   `json:"max_docs"`
+	MaxBytes   int64 `json:"max_bytes"`
+	MaxDocSize int   `json:"max_doc_size"`
+}
+
+// quota returns the quota of a database, the "*" quota of the config for
+// databases without their own one. System databases only have their own.
+func (kdb *Engine) quota(name string) *Quota {
+	if quota, ok := kdb.config.Quotas[name]; ok {
+		return &quota
+	}
+	if quota, ok := kdb.config.Quotas["*"]; ok && !systemDatabases[name] {
+		return &quota
+	}
+	return nil
+}
+
+// checkQuota checks the database stays within quota when doc is written,
+// after the pending new documents of the same batch. It reports whether doc
+// adds a document. It's checked before the write, concurrent writes can go
+// over the quota by a few documents.
+func (db *Database) checkQuota(ctx context.Context, quota *Quota, doc *Document, pending int) (bool, error) {
+	if quota == nil || doc.Deleted {
+		return false, nil
+	}
+	if quota.MaxDocSize > 0 && len(doc.Data) > quota.MaxDocSize {
+		return false, fmt.Errorf("%s: %w", fmt.Sprintf("document is larger than %d bytes", quota.MaxDocSize), ErrQuotaExceeded)
+	}
+	db.mux.Lock()
+	size, docCount := db.size, db.DocCount
+	db.mux.Unlock()
+	if quota.MaxBytes > 0 && size >= quota.MaxBytes {
+		return false, fmt.Errorf("%s: %w", fmt.Sprintf("database is larger than %d bytes", quota.MaxBytes), ErrQuotaExceeded)
+	}
+	if quota.MaxDocs <= 0 {
+		return false, nil
+	}
+
+	// updates of live documents don't add one
+	if doc.ID != "" {
+		_, err := db.GetDocument(ctx, &Document{ID: doc.ID}, false)
+		if err == nil {
+			return false, nil
+		}
+		if !errors.Is(err, ErrDocNotFound) {
+			return false, err
+		}
+	}
+	if docCount+pending >= quota.MaxDocs {
+		return false, fmt.Errorf("%s: %w", fmt.Sprintf("database has %d documents", quota.MaxDocs), ErrQuotaExceeded)
+	}
+	return true, nil
+}
+
+// RateLimits returns the rate limits of the config by class of routes.
+func (kdb *Engine) RateLimits() map[string]RateLimit {
+	return kdb.config.RateLimits
+}
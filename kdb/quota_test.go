@@ -0,0 +1,128 @@
+package kdb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestQuota(t *testing.T) {
+	config := DefaultConfig()
+	config.Quotas = map[string]Quota{
+		"*":              {MaxDocs: 100},
+		"testdbquota":    {MaxDocs: 3, MaxDocSize: 64},
+		"testdbquotamem": {MaxBytes: 1},
+	}
+	kdb, _ := New(config)
+	defer kdb.Close()
+	ctx := context.Background()
+
+	if quota := kdb.quota("testdbother"); quota == nil || quota.MaxDocs != 100 {
+		t.Errorf("expected the default quota, got %+v", quota)
+	}
+	if quota := kdb.quota(UsersDB); quota != nil {
+		t.Errorf("expected no quota for %s, got %+v", UsersDB, quota)
+	}
+
+	kdb.Delete("testdbquota")
+	if err := kdb.Open("testdbquota", true); err != nil {
+		t.Fatal(err)
+	}
+	defer kdb.Delete("testdbquota")
+
+	// the design document of the views is the first document
+	inputDoc, _ := ParseDocument([]byte(`{"_id":"1","name":"` + strings.Repeat("a", 64) + `"}`))
+	if _, err := kdb.PutDocument(ctx, "testdbquota", inputDoc); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("expected %s, got %v", ErrQuotaExceeded, err)
+	}
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"1","name":"one"}`))
+	doc, err := kdb.PutDocument(ctx, "testdbquota", inputDoc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"2"}`))
+	if _, err := kdb.PutDocument(ctx, "testdbquota", inputDoc); err != nil {
+		t.Fatal(err)
+	}
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"3"}`))
+	if _, err = kdb.PutDocument(ctx, "testdbquota", inputDoc); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("expected %s, got %v", ErrQuotaExceeded, err)
+	}
+	if code, _ := ErrorString(err); code != "quota_exceeded" {
+		t.Errorf("expected quota_exceeded, got %s", code)
+	}
+
+	// updates and deletes don't add documents
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"1","name":"uno"}`))
+	inputDoc.Version = doc.Version
+	if doc, err = kdb.PutDocument(ctx, "testdbquota", inputDoc); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kdb.DeleteDocument(ctx, "testdbquota", &Document{ID: "1", Version: doc.Version}); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := kdb.Import(ctx, "testdbquota", bytes.NewBufferString("{\"_id\":\"4\"}\n{\"_id\":\"5\"}\n"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Written != 1 || result.Failures != 1 || result.Errors[0].Error != ErrQuotaExceeded.Error() {
+		t.Errorf("expected one document over quota, got %+v", result)
+	}
+
+	// deleting a deleted document again doesn't free a document
+	if _, err := kdb.DeleteDocument(ctx, "testdbquota", &Document{ID: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"6"}`))
+	if _, err := kdb.PutDocument(ctx, "testdbquota", inputDoc); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("expected %s, got %v", ErrQuotaExceeded, err)
+	}
+
+	// recreating a deleted document adds it again
+	if doc, err = kdb.GetDocument(ctx, "testdbquota", &Document{ID: "4"}, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kdb.DeleteDocument(ctx, "testdbquota", &Document{ID: "4", Version: doc.Version}); err != nil {
+		t.Fatal(err)
+	}
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"1","name":"one"}`))
+	if _, err := kdb.PutDocument(ctx, "testdbquota", inputDoc); err != nil {
+		t.Fatal(err)
+	}
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"6"}`))
+	if _, err := kdb.PutDocument(ctx, "testdbquota", inputDoc); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("expected %s, got %v", ErrQuotaExceeded, err)
+	}
+	if stat, err := kdb.DBStat("testdbquota"); err != nil || stat.DocCount != 3 || stat.DeletedDocCount != 1 {
+		t.Errorf("expected 3 documents and 1 deleted, got %+v %v", stat, err)
+	}
+
+	// the size is kept as of the last commit
+	db := kdb.dbs["testdbquota"]
+	size := db.size
+	var docs []*Document
+	for i := 0; i < 20; i++ {
+		inputDoc, _ = ParseDocument([]byte(`{"_id":"large` + strconv.Itoa(i) + `","name":"` + strings.Repeat("a", 1000) + `"}`))
+		docs = append(docs, inputDoc)
+	}
+	if _, err := db.importDocuments(ctx, docs, false); err != nil {
+		t.Fatal(err)
+	}
+	if db.size <= size || db.size != db.GetSize() {
+		t.Errorf("expected the size to grow to %d, got %d from %d", db.GetSize(), db.size, size)
+	}
+
+	kdb.Delete("testdbquotamem")
+	if err := kdb.CreateWithStorage("testdbquotamem", StorageMemory); err != nil {
+		t.Fatal(err)
+	}
+	defer kdb.Delete("testdbquotamem")
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"1"}`))
+	if _, err := kdb.PutDocument(ctx, "testdbquotamem", inputDoc); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("expected %s, got %v", ErrQuotaExceeded, err)
+	}
+}
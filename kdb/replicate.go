@@ -0,0 +1,371 @@
+package kdb
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/clementmac/kdb3/client"
+)
+
+// replicationBatchSize is the number of changes replicated at once, the
+// checkpoint is written after each batch.
+var replicationBatchSize = 100
+
+// ReplicationRequest is the body of _replicate. Source and target are local
+// database names or urls of databases on other servers, e.g.
+// http://central:8001/orders.
+type ReplicationRequest struct {
+	Source       string `json:"source"`
+	Target       string `json:"target"`
+	CreateTarget bool   `json:"create_target,omitempty"`
+}
+
+type ReplicationResult struct {
+	OK               bool   `json:"ok"`
+	ReplicationID    string `json:"replication_id"`
+	SourceLastSeq    string `json:"source_last_seq"`
+	DocsRead         int    `json:"docs_read"`
+	DocsWritten      int    `json:"docs_written"`
+	DocWriteFailures int    `json:"doc_write_failures"`
+}
+
+type Change struct {
+	Seq     string `json:"seq"`
+	ID      string `json:"id"`
+	Version int    `json:"version"`
+	Deleted bool   `json:"deleted,omitempty"`
+	Hash    string `json:"hash,omitempty"`
+}
+
+// replicationPeer is one side of a replication, a local database or a
+// database on another server.
+type replicationPeer interface {
+	// Changes returns the changes after since, oldest first.
+	Changes(ctx context.Context, since string, limit int) ([]Change, error)
+	// BulkGet returns the current versions of ids with their history,
+	// deleted ones included, missing ones are left out.
+	BulkGet(ctx context.Context, ids []string) ([]*Document, error)
+	// BulkReplicate writes docs with their versions and returns how many
+	// failed.
+	BulkReplicate(ctx context.Context, docs []*Document) (int, error)
+	GetCheckpoint(ctx context.Context, id string) (string, error)
+	PutCheckpoint(ctx context.Context, id, seq string) error
+}
+
+// Replicate copies the documents changed in the source since the last
+// checkpoint to the target, with their _id, _version, _hash, _history and
+// _deleted. The target keeps the versions it has already and stores
+// conflicting versions, see Database.PutReplicatedDocument. Checkpoints are
+// stored on both sides, so an interrupted replication resumes where it
+// stopped.
+func (kdb *Engine) Replicate(ctx context.Context, req *ReplicationRequest) (*ReplicationResult, error) {
+	if req.Source == "" || req.Target == "" {
+		return nil, fmt.Errorf("%s: %w", "source and target are required", ErrInvalidReplication)
+	}
+	source, err := kdb.replicationPeer(req.Source)
+	if err != nil {
+		return nil, err
+	}
+	target, err := kdb.replicationPeer(req.Target)
+	if err != nil {
+		return nil, err
+	}
+	if req.CreateTarget {
+		if err := kdb.createReplicationTarget(ctx, req.Target); err != nil {
+			return nil, err
+		}
+	}
+
+	result := &ReplicationResult{ReplicationID: replicationID(req.Source, req.Target)}
+	checkpointID := "replication-" + result.ReplicationID
+
+	// a checkpoint is only trusted when both sides have it
+	since, err := source.GetCheckpoint(ctx, checkpointID)
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	if targetSince, err := target.GetCheckpoint(ctx, checkpointID); err != nil {
+		return nil, contextError(ctx, err)
+	} else if targetSince != since {
+		since = ""
+	}
+	result.SourceLastSeq = since
+
+	for {
+		changes, err := source.Changes(ctx, since, replicationBatchSize)
+		if err != nil {
+			return nil, contextError(ctx, err)
+		}
+		if len(changes) == 0 {
+			break
+		}
+
+		ids := make([]string, len(changes))
+		for i, change := range changes {
+			ids[i] = change.ID
+			if change.Seq > since {
+				since = change.Seq
+			}
+		}
+		docs, err := source.BulkGet(ctx, ids)
+		if err != nil {
+			return nil, contextError(ctx, err)
+		}
+		result.DocsRead += len(docs)
+
+		failures, err := target.BulkReplicate(ctx, docs)
+		if err != nil {
+			return nil, contextError(ctx, err)
+		}
+		result.DocsWritten += len(docs) - failures
+		result.DocWriteFailures += failures
+
+		if err := target.PutCheckpoint(ctx, checkpointID, since); err != nil {
+			return nil, contextError(ctx, err)
+		}
+		if err := source.PutCheckpoint(ctx, checkpointID, since); err != nil {
+			return nil, contextError(ctx, err)
+		}
+		result.SourceLastSeq = since
+	}
+
+	result.OK = true
+	return result, nil
+}
+
+func replicationID(source, target string) string {
+	sum := md5.Sum([]byte(source + "\n" + target))
+	return hex.EncodeToString(sum[:])
+}
+
+func isRemoteDatabase(name string) bool {
+	return strings.HasPrefix(name, "http://") || strings.HasPrefix(name, "https://")
+}
+
+func (kdb *Engine) replicationPeer(name string) (replicationPeer, error) {
+	if !isRemoteDatabase(name) {
+		if !validateDBName(name) {
+			return nil, ErrDBInvalidName
+		}
+		return &localPeer{engine: kdb, name: name}, nil
+	}
+	serverURL, db, err := splitDatabaseURL(name)
+	if err != nil {
+		return nil, err
+	}
+	return &remotePeer{client: client.New(serverURL), db: db}, nil
+}
+
+// splitDatabaseURL splits http://host:8001/prefix/db into the server url
+// and the database name.
+func splitDatabaseURL(rawURL string) (string, string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", err, ErrInvalidReplication)
+	}
+	path := strings.TrimRight(u.EscapedPath(), "/")
+	idx := strings.LastIndex(path, "/")
+	if idx < 0 || idx == len(path)-1 {
+		return "", "", fmt.Errorf("%s: %w", "database url without database name", ErrInvalidReplication)
+	}
+	db, err := url.PathUnescape(path[idx+1:])
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", err, ErrInvalidReplication)
+	}
+	u.RawPath = ""
+	u.Path, _ = url.PathUnescape(path[:idx])
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String(), db, nil
+}
+
+func (kdb *Engine) createReplicationTarget(ctx context.Context, name string) error {
+	if !isRemoteDatabase(name) {
+		if err := kdb.Open(name, true); err != nil && !errors.Is(err, ErrDBExists) {
+			return err
+		}
+		return nil
+	}
+	serverURL, db, _ := splitDatabaseURL(name)
+	if err := client.New(serverURL).CreateDatabase(ctx, db); err != nil && !errors.Is(err, client.ErrDBExists) {
+		return err
+	}
+	return nil
+}
+
+type checkpoint struct {
+	Seq string `json:"seq"`
+}
+
+type localPeer struct {
+	engine *Engine
+	name   string
+}
+
+func (p *localPeer) Changes(ctx context.Context, since string, limit int) ([]Change, error) {
+	rs, err := p.engine.Changes(ctx, p.name, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	return parseChanges(rs)
+}
+
+func (p *localPeer) BulkGet(ctx context.Context, ids []string) ([]*Document, error) {
+	var docs []*Document
+	for _, id := range ids {
+		doc, err := p.engine.GetDocumentWithHistory(ctx, p.name, id)
+		if errors.Is(err, ErrDocNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+func (p *localPeer) BulkReplicate(ctx context.Context, docs []*Document) (int, error) {
+	failures := 0
+	for _, doc := range docs {
+		if _, err := p.engine.PutReplicatedDocument(ctx, p.name, doc); err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return failures, ctxErr
+			}
+			failures++
+		}
+	}
+	return failures, nil
+}
+
+func (p *localPeer) GetCheckpoint(ctx context.Context, id string) (string, error) {
+	data, err := p.engine.GetLocalDocument(ctx, p.name, id)
+	if errors.Is(err, ErrDocNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	cp := checkpoint{}
+	json.Unmarshal(data, &cp)
+	return cp.Seq, nil
+}
+
+func (p *localPeer) PutCheckpoint(ctx context.Context, id, seq string) error {
+	data, _ := json.Marshal(checkpoint{Seq: seq})
+	return p.engine.PutLocalDocument(ctx, p.name, id, data)
+}
+
+type remotePeer struct {
+	client *client.Client
+	db     string
+}
+
+func (p *remotePeer) Changes(ctx context.Context, since string, limit int) ([]Change, error) {
+	remoteChanges, err := p.client.Changes(ctx, p.db, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	changes := make([]Change, len(remoteChanges))
+	for i, change := range remoteChanges {
+		changes[i] = Change{Seq: change.Seq, ID: change.ID, Version: change.Version, Deleted: change.Deleted, Hash: change.Hash}
+	}
+	sortChanges(changes)
+	return changes, nil
+}
+
+func (p *remotePeer) BulkGet(ctx context.Context, ids []string) ([]*Document, error) {
+	items, err := p.client.BulkGetHistory(ctx, p.db, ids)
+	if err != nil {
+		return nil, err
+	}
+	var docs []*Document
+	for _, item := range items {
+		doc, err := ParseDocument(item)
+		if err != nil {
+			return nil, err
+		}
+		// missing documents come back as errors without _id
+		if doc.ID == "" {
+			continue
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+func (p *remotePeer) BulkReplicate(ctx context.Context, docs []*Document) (int, error) {
+	items := make([]json.RawMessage, len(docs))
+	for i, doc := range docs {
+		items[i] = formatDocument(doc)
+	}
+	results, err := p.client.ReplicateDocuments(ctx, p.db, items)
+	if err != nil {
+		return 0, err
+	}
+	failures := 0
+	for _, result := range results {
+		if result.Err != nil {
+			failures++
+		}
+	}
+	return failures, nil
+}
+
+func (p *remotePeer) GetCheckpoint(ctx context.Context, id string) (string, error) {
+	cp := checkpoint{}
+	err := p.client.GetLocalDocument(ctx, p.db, id, &cp)
+	if errors.Is(err, client.ErrDocNotFound) {
+		return "", nil
+	}
+	return cp.Seq, err
+}
+
+func (p *remotePeer) PutCheckpoint(ctx context.Context, id, seq string) error {
+	return p.client.PutLocalDocument(ctx, p.db, id, checkpoint{Seq: seq})
+}
+
+func parseChanges(rs []byte) ([]Change, error) {
+	result := struct {
+		Results []Change `json:"results"`
+	}{}
+	if err := json.Unmarshal(rs, &result); err != nil {
+		return nil, err
+	}
+	sortChanges(result.Results)
+	return result.Results, nil
+}
+
+// sortChanges sorts changes oldest first, _changes lists the newest first.
+func sortChanges(changes []Change) {
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Seq < changes[j].Seq
+	})
+}
+
+// formatDocument is the json of a parsed document with its meta fields.
+func formatDocument(doc *Document) []byte {
+	meta := FormatDocString(doc.ID, doc.Version, doc.Deleted)
+	if doc.Kind != "" {
+		meta = fmt.Sprintf(`%s,"_kind":"%s"}`, meta[:len(meta)-1], doc.Kind)
+	}
+	if doc.Hash != "" {
+		meta = fmt.Sprintf(`%s,"_hash":"%s"}`, meta[:len(meta)-1], doc.Hash)
+	}
+	if len(doc.History) > 0 {
+		history, _ := json.Marshal(doc.History)
+		meta = fmt.Sprintf(`%s,"_history":%s}`, meta[:len(meta)-1], history)
+	}
+	if len(doc.Data) <= 2 {
+		return []byte(meta)
+	}
+	data := []byte(meta[:len(meta)-1] + ",")
+	return append(data, doc.Data[1:]...)
+}
@@ -0,0 +1,240 @@
+package kdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/valyala/fastjson"
+)
+
+// ReplicatorDB is the system database whose documents describe the
+// replications the engine runs, {"source":..., "target":..., "continuous":
+// true}. The engine records their state back into the documents.
+const ReplicatorDB = "_replicator"
+
+const (
+	ReplicationRunning   = "running"
+	ReplicationError     = "error"
+	ReplicationCompleted = "completed"
+)
+
+// failed replications are retried after a backoff, doubled on each failure
+var (
+	replicationMinBackoff = time.Second
+	replicationMaxBackoff = 5 * time.Minute
+)
+
+type replicationDocument struct {
+	ReplicationRequest
+	Continuous bool `json:"continuous"`
+
+	State         string `json:"_replication_state"`
+	ReplicationID string `json:"_replication_id"`
+}
+
+type replicationJob struct {
+	docID      string
+	req        ReplicationRequest
+	continuous bool
+	cancel     context.CancelFunc
+}
+
+// replicator runs the replications of _replicator. It follows the changes of
+// _replicator, starts a job for every new or changed document and stops it
+// once the document is deleted. After a restart it starts every job which
+// didn't complete.
+type replicator struct {
+	engine   *Engine
+	interval time.Duration
+
+	mux     sync.Mutex
+	jobs    map[string]*replicationJob
+	lastSeq string
+	wg      sync.WaitGroup
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+func newReplicator(engine *Engine, interval time.Duration) *replicator {
+	r := &replicator{engine: engine, interval: interval, jobs: make(map[string]*replicationJob)}
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	return r
+}
+
+func (r *replicator) start() {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			r.poll()
+			select {
+			case <-ticker.C:
+			case <-r.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// stop cancels the running jobs and waits for them.
+func (r *replicator) stop() {
+	r.cancel()
+	r.wg.Wait()
+}
+
+// poll reads the changes of _replicator since the last poll.
+func (r *replicator) poll() {
+	for {
+		rs, err := r.engine.Changes(r.ctx, ReplicatorDB, r.lastSeq, replicationBatchSize)
+		if err != nil {
+			return
+		}
+		changes, err := parseChanges(rs)
+		if err != nil || len(changes) == 0 {
+			return
+		}
+		for _, change := range changes {
+			r.update(change)
+			if change.Seq > r.lastSeq {
+				r.lastSeq = change.Seq
+			}
+		}
+	}
+}
+
+func (r *replicator) update(change Change) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	job, running := r.jobs[change.ID]
+	if change.Deleted || len(change.ID) > 0 && change.ID[0] == '_' {
+		if running {
+			job.cancel()
+			delete(r.jobs, change.ID)
+		}
+		return
+	}
+
+	doc, err := r.document(change.ID)
+	if err != nil {
+		return
+	}
+	if running {
+		// the job records its state in the document, only other changes
+		// restart it
+		if job.req == doc.ReplicationRequest && job.continuous == doc.Continuous {
+			return
+		}
+		job.cancel()
+		delete(r.jobs, change.ID)
+	}
+	if doc.State == ReplicationCompleted && doc.ReplicationID == replicationID(doc.Source, doc.Target) {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.ctx)
+	job = &replicationJob{docID: change.ID, req: doc.ReplicationRequest, continuous: doc.Continuous, cancel: cancel}
+	r.jobs[change.ID] = job
+	r.wg.Add(1)
+	go r.run(ctx, job)
+}
+
+func (r *replicator) document(id string) (*replicationDocument, error) {
+	doc, err := r.engine.GetDocument(r.ctx, ReplicatorDB, &Document{ID: id}, true)
+	if err != nil {
+		return nil, err
+	}
+	rdoc := &replicationDocument{}
+	if err := json.Unmarshal(doc.Data, rdoc); err != nil {
+		return nil, err
+	}
+	return rdoc, nil
+}
+
+// run replicates until a one shot job completes or the job is stopped,
+// continuous jobs replicate again every interval.
+func (r *replicator) run(ctx context.Context, job *replicationJob) {
+	defer r.wg.Done()
+
+	r.setState(ctx, job, ReplicationRunning, "")
+	backoff := replicationMinBackoff
+	for {
+		wait := r.interval
+		req := job.req
+		_, err := r.engine.Replicate(ctx, &req)
+		if ctx.Err() != nil {
+			return
+		}
+		switch {
+		case err != nil:
+			r.setState(ctx, job, ReplicationError, err.Error())
+			wait = backoff
+			if backoff *= 2; backoff > replicationMaxBackoff {
+				backoff = replicationMaxBackoff
+			}
+		case job.continuous:
+			r.setState(ctx, job, ReplicationRunning, "")
+			backoff = replicationMinBackoff
+		default:
+			r.setState(ctx, job, ReplicationCompleted, "")
+			r.mux.Lock()
+			if r.jobs[job.docID] == job {
+				delete(r.jobs, job.docID)
+			}
+			r.mux.Unlock()
+			return
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// setState records the state of a job in its document, unless it's the
+// state there already or the document describes another job by now.
+func (r *replicator) setState(ctx context.Context, job *replicationJob, state, reason string) {
+	for attempt := 0; attempt < 3; attempt++ {
+		doc, err := r.engine.GetDocument(ctx, ReplicatorDB, &Document{ID: job.docID}, true)
+		if err != nil {
+			return
+		}
+		v, err := fastjson.ParseBytes(doc.Data)
+		if err != nil {
+			return
+		}
+		rdoc := &replicationDocument{}
+		json.Unmarshal(doc.Data, rdoc)
+		if rdoc.ReplicationRequest != job.req || rdoc.Continuous != job.continuous {
+			return
+		}
+		if rdoc.State == state && string(v.GetStringBytes("_replication_state_reason")) == reason {
+			return
+		}
+
+		var arena fastjson.Arena
+		v.Set("_replication_state", arena.NewString(state))
+		v.Set("_replication_state_time", arena.NewString(time.Now().UTC().Format(time.RFC3339)))
+		v.Set("_replication_id", arena.NewString(replicationID(job.req.Source, job.req.Target)))
+		if reason != "" {
+			v.Set("_replication_state_reason", arena.NewString(reason))
+		} else {
+			v.Del("_replication_state_reason")
+		}
+
+		newDoc, err := ParseDocument(v.MarshalTo(nil))
+		if err != nil {
+			return
+		}
+		if _, err := r.engine.PutDocument(ctx, ReplicatorDB, newDoc); !errors.Is(err, ErrDocConflict) {
+			return
+		}
+	}
+}
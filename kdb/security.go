@@ -0,0 +1,161 @@
+package kdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// Access is what a user can do in a database, each level includes the ones
+// below it.
+type Access int
+
+const (
+	AccessNone Access = iota
+	// AccessReader reads documents, changes and views.
+	AccessReader
+	// AccessMember writes documents.
+	AccessMember
+	// AccessAdmin writes design documents and the security object.
+	AccessAdmin
+)
+
+// SecurityMembers lists users by name and by role.
+type SecurityMembers struct {
+	Names []string `json:"names"`
+	Roles []string `json:"roles"`
+}
+
+func (members *SecurityMembers) includes(user *User) bool {
+	if user.Name != "" {
+		for _, name := range members.Names {
+			if name == user.Name {
+				return true
+			}
+		}
+	}
+	for _, role := range members.Roles {
+		if user.HasRole(role) {
+			return true
+		}
+	}
+	return false
+}
+
+func (members *SecurityMembers) isEmpty() bool {
+	return len(members.Names) == 0 && len(members.Roles) == 0
+}
+
+// Security is the security object of a database. A database without
+// members and readers is public, everybody reads and writes its documents.
+// System databases are only public to the server admins.
+type Security struct {
+	Admins  SecurityMembers `json:"admins"`
+	Members SecurityMembers `json:"members"`
+	Readers SecurityMembers `json:"readers"`
+}
+
+// Access returns the access of user to a database with this security
+// object.
+func (security *Security) Access(user *User, systemDB bool) Access {
+	switch {
+	case user.IsAdmin() || security.Admins.includes(user):
+		return AccessAdmin
+	case security.Members.includes(user):
+		return AccessMember
+	case security.Readers.includes(user):
+		return AccessReader
+	case !systemDB && security.Members.isEmpty() && security.Readers.isEmpty():
+		return AccessMember
+	}
+	return AccessNone
+}
+
+func (security *Security) normalize() {
+	for _, members := range []*SecurityMembers{&security.Admins, &security.Members, &security.Readers} {
+		if members.Names == nil {
+			members.Names = []string{}
+		}
+		if members.Roles == nil {
+			members.Roles = []string{}
+		}
+	}
+}
+
+// GetSecurity returns the security object of a database, an empty one until
+// one is put.
+func (kdb *Engine) GetSecurity(name string) (*Security, error) {
+	data, ok := kdb.localDB.LookupSecurity(name)
+	if !ok {
+		return nil, ErrDBNotFound
+	}
+	security := &Security{}
+	if data != "" {
+		if err := json.Unmarshal([]byte(data), security); err != nil {
+			return nil, fmt.Errorf("%s: %w", err, ErrInternalError)
+		}
+	}
+	security.normalize()
+	return security, nil
+}
+
+func (kdb *Engine) PutSecurity(ctx context.Context, name string, security *Security) error {
+	security.normalize()
+	data, err := json.Marshal(security)
+	if err != nil {
+		return err
+	}
+
+	kdb.rwmux.Lock()
+	defer kdb.rwmux.Unlock()
+
+	kdb.localDB.Begin()
+	defer kdb.localDB.Rollback()
+
+	if fileName, _ := kdb.localDB.GetFileName(name); fileName == "" {
+		return ErrDBNotFound
+	}
+	if err := kdb.localDB.PutSecurity(name, string(data)); err != nil {
+		return err
+	}
+	if err := kdb.localDB.Commit(); err != nil {
+		return err
+	}
+	kdb.audit(ctx, &AuditRecord{Action: AuditPutSecurity, DB: name})
+	return nil
+}
+
+// DatabaseAccess returns the access of user to a database.
+func (kdb *Engine) DatabaseAccess(name string, user *User) (Access, error) {
+	if user == nil {
+		user = &User{}
+	}
+	if user.IsAdmin() {
+		if _, ok := kdb.localDB.LookupSecurity(name); !ok {
+			return AccessNone, ErrDBNotFound
+		}
+		return AccessAdmin, nil
+	}
+	security, err := kdb.GetSecurity(name)
+	if err != nil {
+		return AccessNone, err
+	}
+	return security.Access(user, systemDatabases[name]), nil
+}
+
+// authorizeDesignDocument checks the user of ctx can write design documents,
+// writes without a user are the engine's own.
+func (kdb *Engine) authorizeDesignDocument(ctx context.Context, name string) error {
+	user := UserFromContext(ctx)
+	if user == nil {
+		return nil
+	}
+	access, err := kdb.DatabaseAccess(name, user)
+	if err != nil {
+		return err
+	}
+	if access < AccessAdmin {
+		return fmt.Errorf("%s: %w", "only admins can change design documents", ErrForbidden)
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package kdb
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSecurityAccess(t *testing.T) {
+	security := &Security{
+		Admins:  SecurityMembers{Names: []string{"alice"}},
+		Members: SecurityMembers{Roles: []string{"sales"}},
+		Readers: SecurityMembers{Names: []string{"carol"}},
+	}
+	tests := []struct {
+		user     *User
+		systemDB bool
+		access   Access
+	}{
+		{&User{Roles: []string{AdminRole}}, true, AccessAdmin},
+		{&User{Name: "alice"}, false, AccessAdmin},
+		{&User{Name: "bob", Roles: []string{"sales"}}, false, AccessMember},
+		{&User{Name: "carol"}, false, AccessReader},
+		{&User{Name: "dave"}, false, AccessNone},
+		{&User{}, false, AccessNone},
+	}
+	for _, test := range tests {
+		if access := security.Access(test.user, test.systemDB); access != test.access {
+			t.Errorf("expected %d for %+v, got %d", test.access, test.user, access)
+		}
+	}
+
+	// without members and readers only system databases are closed
+	public := &Security{Admins: SecurityMembers{Names: []string{"alice"}}}
+	if access := public.Access(&User{}, false); access != AccessMember {
+		t.Errorf("expected %d, got %d", AccessMember, access)
+	}
+	if access := public.Access(&User{Name: "dave"}, true); access != AccessNone {
+		t.Errorf("expected %d, got %d", AccessNone, access)
+	}
+}
+
+func TestPutSecurity(t *testing.T) {
+	kdb, _ := New(nil)
+	kdb.Open("testsecurity", true)
+	ctx := context.Background()
+
+	if security, err := kdb.GetSecurity("testsecurity"); err != nil || security.Admins.Names == nil || len(security.Members.Roles) != 0 {
+		t.Errorf("expected an empty security object, got %+v %v", security, err)
+	}
+	security := &Security{Members: SecurityMembers{Names: []string{"bob"}}}
+	if err := kdb.PutSecurity(ctx, "testsecurity", security); err != nil {
+		t.Fatal(err)
+	}
+	if err := kdb.PutSecurity(ctx, "testsecuritymissing", security); !errors.Is(err, ErrDBNotFound) {
+		t.Errorf("expected %s, got %v", ErrDBNotFound, err)
+	}
+	if access, err := kdb.DatabaseAccess("testsecurity", &User{Name: "bob"}); err != nil || access != AccessMember {
+		t.Errorf("expected %d, got %d %v", AccessMember, access, err)
+	}
+	if _, err := kdb.DatabaseAccess("testsecuritymissing", &User{Name: "bob"}); !errors.Is(err, ErrDBNotFound) {
+		t.Errorf("expected %s, got %v", ErrDBNotFound, err)
+	}
+
+	// members write documents, not design documents
+	bobCtx := NewUserContext(ctx, &User{Name: "bob"})
+	inputDoc, _ := ParseDocument([]byte(`{"_id":"1"}`))
+	if _, err := kdb.PutDocument(bobCtx, "testsecurity", inputDoc); err != nil {
+		t.Error(err)
+	}
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"_design/names","views":{}}`))
+	if _, err := kdb.PutDocument(bobCtx, "testsecurity", inputDoc); !errors.Is(err, ErrForbidden) {
+		t.Errorf("expected %s, got %v", ErrForbidden, err)
+	}
+
+	// copies keep the security object
+	if err := kdb.Copy(ctx, "testsecurity", "testsecuritycopy", false); err != nil {
+		t.Fatal(err)
+	}
+	if security, err := kdb.GetSecurity("testsecuritycopy"); err != nil || len(security.Members.Names) != 1 {
+		t.Errorf("expected the security object of the source, got %+v %v", security, err)
+	}
+
+	kdb.Delete("testsecurity")
+	kdb.Delete("testsecuritycopy")
+	kdb.Close()
+}
+
+func TestSourceAccess(t *testing.T) {
+	kdb, _ := New(nil)
+	defer kdb.Close()
+	ctx := context.Background()
+	for _, name := range []string{"testsourceorders", "testsourcecustomers"} {
+		kdb.Delete(name)
+		if err := kdb.Open(name, true); err != nil {
+			t.Fatal(err)
+		}
+		defer kdb.Delete(name)
+	}
+	kdb.PutSecurity(ctx, "testsourceorders", &Security{Admins: SecurityMembers{Names: []string{"bob"}}})
+	kdb.PutSecurity(ctx, "testsourcecustomers", &Security{Readers: SecurityMembers{Names: []string{"alice"}}})
+
+	// views only read the sources their author can read
+	bobCtx := NewUserContext(ctx, &User{Name: "bob"})
+	ddoc := `{"_id":"_design/orders","views":{"customers":{"sources":{"crm":"testsourcecustomers"},
+		"setup":["CREATE TABLE IF NOT EXISTS customers (doc_id, PRIMARY KEY(doc_id))"],
+		"run":["INSERT OR REPLACE INTO customers SELECT doc_id FROM latest_documents_crm"],
+		"select":{"default":"SELECT COUNT(1) FROM customers"}}}}`
+	inputDoc, _ := ParseDocument([]byte(ddoc))
+	if _, err := kdb.PutDocument(bobCtx, "testsourceorders", inputDoc); !errors.Is(err, ErrForbidden) {
+		t.Errorf("expected %s, got %v", ErrForbidden, err)
+	}
+	kdb.PutSecurity(ctx, "testsourcecustomers", &Security{Readers: SecurityMembers{Names: []string{"alice", "bob"}}})
+	inputDoc, _ = ParseDocument([]byte(ddoc))
+	if _, err := kdb.PutDocument(bobCtx, "testsourceorders", inputDoc); err != nil {
+		t.Error(err)
+	}
+
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"_design/missing","views":{"v":{"sources":{"crm":"testsourcemissing"}}}}`))
+	if _, err := kdb.PutDocument(bobCtx, "testsourceorders", inputDoc); !errors.Is(err, ErrViewInvalidSource) {
+		t.Errorf("expected %s, got %v", ErrViewInvalidSource, err)
+	}
+}
+
+func TestImportDesignDocumentAccess(t *testing.T) {
+	kdb, _ := New(nil)
+	defer kdb.Close()
+	ctx := context.Background()
+	kdb.Delete("testsecurityimport")
+	if err := kdb.Open("testsecurityimport", true); err != nil {
+		t.Fatal(err)
+	}
+	defer kdb.Delete("testsecurityimport")
+	kdb.PutSecurity(ctx, "testsecurityimport", &Security{Members: SecurityMembers{Names: []string{"bob"}}})
+
+	inputDoc, _ := ParseDocument([]byte(`{"_id":"_design/names","views":{"count":{"select":{"default":"SELECT COUNT(1) FROM documents"}}}}`))
+	if _, err := kdb.PutDocument(ctx, "testsecurityimport", inputDoc); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kdb.SelectView(ctx, "testsecurityimport", "_design/names", "count", "default", nil, false); err != nil {
+		t.Fatal(err)
+	}
+	viewFiles := filepath.Join(kdb.config.ViewPath, "testsecurityimport*")
+	if names, _ := filepath.Glob(viewFiles); len(names) == 0 {
+		t.Fatal("expected view files")
+	}
+
+	// members can't delete design documents by importing them either, nor
+	// their view files
+	bobCtx := NewUserContext(ctx, &User{Name: "bob"})
+	result, err := kdb.Import(bobCtx, "testsecurityimport", strings.NewReader(`{"_id":"_design/names","_deleted":true}`), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Written != 0 || len(result.Errors) != 1 || result.Errors[0].Error != ErrForbidden.Error() {
+		t.Errorf("expected a forbidden line, got %+v", result)
+	}
+	if names, _ := filepath.Glob(viewFiles); len(names) == 0 {
+		t.Error("expected the view files to survive")
+	}
+	if _, err := kdb.SelectView(ctx, "testsecurityimport", "_design/names", "count", "default", nil, false); err != nil {
+		t.Error(err)
+	}
+
+	result, err = kdb.Import(ctx, "testsecurityimport", strings.NewReader(`{"_id":"_design/names","_deleted":true}`), false)
+	if err != nil || result.Written != 1 {
+		t.Fatalf("expected the design document deleted, got %+v %v", result, err)
+	}
+	if names, _ := filepath.Glob(viewFiles); len(names) != 0 {
+		t.Errorf("expected no view files, got %v", names)
+	}
+}
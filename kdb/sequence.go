@@ -1,4 +1,4 @@
-package main
+package kdb
 
 import (
 	"encoding/hex"
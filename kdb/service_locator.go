@@ -0,0 +1,100 @@
+package kdb
+
+type ServiceLocator interface {
+	GetFileHandler() FileHandler
+
+	GetDatabaseWriter() DatabaseWriter
+	GetDatabaseReader() DatabaseReader
+
+	GetViewManager() ViewManager
+	GetView(viewName, connectionString, databaseURI string, sourceURIs, viewURIs map[string]string, decrypt func(interface{}) (string, error), ddoc *DesignDocument, viewManager ViewManager) *View
+
+	GetViewReader(connectionString, databaseURI string, sourceURIs, viewURIs map[string]string, decrypt func(interface{}) (string, error), selectScripts map[string]Query) ViewReader
+
+	GetExternalViewCommand(server string) ([]string, bool)
+
+	GetStorage(name string) (Storage, bool)
+
+	// GetAuditLog returns the audit log of the config, nil when it's
+	// disabled.
+	GetAuditLog() AuditLog
+
+	// GetKeyProvider returns the provider of the encryption keys, nil
+	// without one.
+	GetKeyProvider() KeyProvider
+}
+
+type DefaultServiceLocator struct {
+	fileHandler *DefaultFileHandler
+	config      *Config
+	storages    map[string]Storage
+}
+
+func (sl *DefaultServiceLocator) GetFileHandler() FileHandler {
+	return sl.fileHandler
+}
+
+func (sl *DefaultServiceLocator) GetDatabaseWriter() DatabaseWriter {
+	databaseWriter := new(DefaultDatabaseWriter)
+	databaseWriter.reader = new(DefaultDatabaseReader)
+	return databaseWriter
+}
+
+func (sl *DefaultServiceLocator) GetDatabaseReader() DatabaseReader {
+	databaseReader := new(DefaultDatabaseReader)
+	return databaseReader
+}
+
+func (sl *DefaultServiceLocator) GetViewManager() ViewManager {
+	return NewViewManager(sl)
+}
+
+func (sl *DefaultServiceLocator) GetView(viewName, connectionString, databaseURI string, sourceURIs, viewURIs map[string]string, decrypt func(interface{}) (string, error), ddoc *DesignDocument, viewManager ViewManager) *View {
+	return NewView(viewName, connectionString, databaseURI, sourceURIs, viewURIs, decrypt, ddoc, viewManager, sl)
+}
+
+func (sl *DefaultServiceLocator) GetViewReader(connectionString, databaseURI string, sourceURIs, viewURIs map[string]string, decrypt func(interface{}) (string, error), selectScripts map[string]Query) ViewReader {
+	return NewViewReader(connectionString, databaseURI, sourceURIs, viewURIs, decrypt, selectScripts)
+}
+
+func (sl *DefaultServiceLocator) GetExternalViewCommand(server string) ([]string, bool) {
+	command, ok := sl.config.ExternalViews[server]
+	if !ok || len(command) == 0 {
+		return nil, false
+	}
+	return command, true
+}
+
+func (sl *DefaultServiceLocator) GetStorage(name string) (Storage, bool) {
+	storage, ok := sl.storages[name]
+	return storage, ok
+}
+
+func (sl *DefaultServiceLocator) GetAuditLog() AuditLog {
+	if sl.config.AuditPath == "" {
+		return nil
+	}
+	return NewFileAuditLog(sl.config.AuditPath, sl.config.AuditMaxSize, sl.config.AuditMaxFiles)
+}
+
+func (sl *DefaultServiceLocator) GetKeyProvider() KeyProvider {
+	if sl.config.EncryptionKeyPath == "" {
+		return nil
+	}
+	return NewLocalKeyProvider(sl.config.EncryptionKeyPath)
+}
+
+func NewServiceLocator() ServiceLocator {
+	return NewServiceLocatorWithConfig(DefaultConfig())
+}
+
+func NewServiceLocatorWithConfig(config *Config) ServiceLocator {
+	serviceLocator := new(DefaultServiceLocator)
+	serviceLocator.fileHandler = new(DefaultFileHandler)
+	serviceLocator.config = config
+	serviceLocator.storages = map[string]Storage{
+		StorageFile:   NewFileStorage(serviceLocator.fileHandler),
+		StorageMemory: NewMemoryStorage(),
+	}
+	return serviceLocator
+}
@@ -0,0 +1,178 @@
+package kdb
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	StorageFile   = "file"
+	StorageMemory = "memory"
+)
+
+// Storage is where the sqlite databases of a database and its views live.
+// Databases are named by their file path, for memory storage the path is
+// only a name.
+type Storage interface {
+	Name() string
+	Exists(path string) bool
+	// Create makes sure path exists before it's opened for the first time.
+	Create(path string) error
+	// ConnectionString returns the dsn to open path with the given
+	// go-sqlite3 options, without the open mode.
+	ConnectionString(path, options string) string
+	// AttachURI returns the uri views attach path with, read only where
+	// the storage supports it.
+	AttachURI(path string) (string, error)
+	// List returns the file names of the databases in dir.
+	List(dir string) ([]string, error)
+	Remove(path string) error
+}
+
+type FileStorage struct {
+	fileHandler FileHandler
+}
+
+func NewFileStorage(fileHandler FileHandler) *FileStorage {
+	return &FileStorage{fileHandler: fileHandler}
+}
+
+func (s *FileStorage) Name() string {
+	return StorageFile
+}
+
+func (s *FileStorage) Exists(path string) bool {
+	return s.fileHandler.IsFileExists(path)
+}
+
+// Create does nothing, sqlite creates the file when it's opened with rwc.
+func (s *FileStorage) Create(path string) error {
+	return nil
+}
+
+func (s *FileStorage) ConnectionString(path, options string) string {
+	return path + "?" + options
+}
+
+func (s *FileStorage) AttachURI(path string) (string, error) {
+	absolutePath, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	return "file://" + absolutePath + "?mode=ro", nil
+}
+
+func (s *FileStorage) List(dir string) ([]string, error) {
+	list, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for idx := range list {
+		names = append(names, list[idx].Name())
+	}
+	return names, nil
+}
+
+func (s *FileStorage) Remove(path string) error {
+	os.Remove(path + "-shm")
+	os.Remove(path + "-wal")
+	return os.Remove(path)
+}
+
+// MemoryStorage keeps databases in shared cache sqlite memory databases. A
+// memory database is gone once its last connection closes, so each one
+// keeps a connection open until it's removed.
+type MemoryStorage struct {
+	mux sync.Mutex
+	dbs map[string]*sql.DB
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{dbs: make(map[string]*sql.DB)}
+}
+
+func (s *MemoryStorage) Name() string {
+	return StorageMemory
+}
+
+func (s *MemoryStorage) uri(path string) string {
+	return "file:" + filepath.ToSlash(filepath.Clean(path)) + "?mode=memory&cache=shared"
+}
+
+func (s *MemoryStorage) Exists(path string) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	_, ok := s.dbs[filepath.Clean(path)]
+	return ok
+}
+
+func (s *MemoryStorage) Create(path string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	path = filepath.Clean(path)
+	if _, ok := s.dbs[path]; ok {
+		return nil
+	}
+	con, err := sql.Open("sqlite3", s.uri(path))
+	if err != nil {
+		return err
+	}
+	if err := con.Ping(); err != nil {
+		con.Close()
+		return err
+	}
+	s.dbs[path] = con
+	return nil
+}
+
+func (s *MemoryStorage) ConnectionString(path, options string) string {
+	return s.uri(path) + "&" + options
+}
+
+// AttachURI can't add mode=ro to a memory database, views deny writes to
+// the databases they attach instead, see readOnlySchemas.
+func (s *MemoryStorage) AttachURI(path string) (string, error) {
+	return s.uri(path), nil
+}
+
+func (s *MemoryStorage) List(dir string) ([]string, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	dir = filepath.Clean(dir)
+	var names []string
+	for path := range s.dbs {
+		if filepath.Dir(path) == dir {
+			names = append(names, filepath.Base(path))
+		}
+	}
+	return names, nil
+}
+
+func (s *MemoryStorage) Remove(path string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	path = filepath.Clean(path)
+	con, ok := s.dbs[path]
+	if !ok {
+		return os.ErrNotExist
+	}
+	delete(s.dbs, path)
+	return con.Close()
+}
+
+// withMode adds the open mode to a connection string. sqlite uses the last
+// mode given, so memory databases keep mode=memory.
+func withMode(connectionString, mode string) string {
+	if strings.Contains(connectionString, "mode=memory") {
+		return connectionString
+	}
+	return connectionString + "&mode=" + mode
+}
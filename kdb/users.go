@@ -0,0 +1,293 @@
+package kdb
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valyala/fastjson"
+)
+
+// UsersDB is the system database of the user accounts, one document per
+// user with the user name as _id, {"_id":"alice","roles":["sales"],
+// "password":"..."}. The password is replaced by a salted hash when the
+// document is written.
+const UsersDB = "_users"
+
+// AdminRole is the role of the server admins, it can't be given to users in
+// _users.
+const AdminRole = "_admin"
+
+const (
+	passwordScheme     = "pbkdf2"
+	passwordIterations = 10000
+	passwordKeyLength  = 32
+)
+
+type User struct {
+	Name  string   `json:"name"`
+	Roles []string `json:"roles"`
+}
+
+func (user *User) IsAdmin() bool {
+	return user != nil && user.HasRole(AdminRole)
+}
+
+func (user *User) HasRole(role string) bool {
+	if user == nil {
+		return false
+	}
+	for _, r := range user.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+type userContextKey struct{}
+
+// NewUserContext returns a copy of ctx carrying the authenticated user.
+func NewUserContext(ctx context.Context, user *User) context.Context {
+	return context.WithValue(ctx, userContextKey{}, user)
+}
+
+// UserFromContext returns the user of NewUserContext, nil when the engine
+// is called without one.
+func UserFromContext(ctx context.Context) *User {
+	user, _ := ctx.Value(userContextKey{}).(*User)
+	return user
+}
+
+// AnonymousUser is the user of requests without credentials, a user
+// without name and roles once admins are configured, an admin until then.
+func (kdb *Engine) AnonymousUser() *User {
+	if len(kdb.config.Admins) > 0 {
+		return &User{Roles: []string{}}
+	}
+	return &User{Roles: []string{AdminRole}}
+}
+
+// RequireValidUser reports whether requests without credentials are
+// rejected.
+func (kdb *Engine) RequireValidUser() bool {
+	return kdb.config.RequireValidUser
+}
+
+// userAccount is a user with its password hash, the admins of the config
+// have their password instead.
+type userAccount struct {
+	User
+	salt       string
+	derivedKey string
+	iterations int
+	password   string
+}
+
+func (kdb *Engine) userAccount(ctx context.Context, name string) (*userAccount, error) {
+	if password, ok := kdb.config.Admins[name]; ok {
+		return &userAccount{User: User{Name: name, Roles: []string{AdminRole}}, password: password}, nil
+	}
+	if !validateDocID(name) || name == "" || strings.HasPrefix(name, "_design/") {
+		return nil, ErrUnauthorized
+	}
+	doc, err := kdb.GetDocument(ctx, UsersDB, &Document{ID: name}, true)
+	if errors.Is(err, ErrDocNotFound) || errors.Is(err, ErrDBNotFound) {
+		return nil, ErrUnauthorized
+	}
+	if err != nil {
+		return nil, err
+	}
+	v, err := fastjson.ParseBytes(doc.Data)
+	if err != nil {
+		return nil, err
+	}
+	account := &userAccount{
+		User:       User{Name: name, Roles: []string{}},
+		salt:       string(v.GetStringBytes("salt")),
+		derivedKey: string(v.GetStringBytes("derived_key")),
+		iterations: v.GetInt("iterations"),
+	}
+	for _, role := range v.GetArray("roles") {
+		account.Roles = append(account.Roles, string(role.GetStringBytes()))
+	}
+	return account, nil
+}
+
+func (account *userAccount) checkPassword(password string) bool {
+	if account.derivedKey == "" {
+		return account.password != "" && subtle.ConstantTimeCompare([]byte(password), []byte(account.password)) == 1
+	}
+	salt, err := hex.DecodeString(account.salt)
+	if err != nil || account.iterations <= 0 {
+		return false
+	}
+	key := hex.EncodeToString(pbkdf2SHA256([]byte(password), salt, account.iterations, passwordKeyLength))
+	return subtle.ConstantTimeCompare([]byte(key), []byte(account.derivedKey)) == 1
+}
+
+// Authenticate returns the user name and password belong to, an admin of the
+// config or a user of _users.
+func (kdb *Engine) Authenticate(ctx context.Context, name, password string) (*User, error) {
+	account, err := kdb.userAccount(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if !account.checkPassword(password) {
+		return nil, ErrUnauthorized
+	}
+	return &account.User, nil
+}
+
+// NewSession returns a session token of user and when it expires. The token
+// is signed with the secret of the config and the salt of the password, a
+// new password ends the sessions of the user.
+func (kdb *Engine) NewSession(ctx context.Context, user *User) (string, time.Time, error) {
+	account, err := kdb.userAccount(ctx, user.Name)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expires := time.Now().Add(time.Duration(kdb.config.SessionTimeout) * time.Second)
+	payload := user.Name + ":" + strconv.FormatInt(expires.Unix(), 16)
+	token := payload + ":" + kdb.signSession(payload, account.salt)
+	return base64.RawURLEncoding.EncodeToString([]byte(token)), expires, nil
+}
+
+// SessionUser returns the user of a session token of NewSession.
+func (kdb *Engine) SessionUser(ctx context.Context, token string) (*User, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrUnauthorized
+	}
+	// the name can contain colons, the expiry and signature can't
+	value := string(b)
+	idx := strings.LastIndexByte(value, ':')
+	if idx < 0 {
+		return nil, ErrUnauthorized
+	}
+	payload, signature := value[:idx], value[idx+1:]
+	idx = strings.LastIndexByte(payload, ':')
+	if idx < 0 {
+		return nil, ErrUnauthorized
+	}
+	expires, err := strconv.ParseInt(payload[idx+1:], 16, 64)
+	if err != nil || time.Now().Unix() >= expires {
+		return nil, ErrUnauthorized
+	}
+
+	account, err := kdb.userAccount(ctx, payload[:idx])
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal([]byte(signature), []byte(kdb.signSession(payload, account.salt))) {
+		return nil, ErrUnauthorized
+	}
+	return &account.User, nil
+}
+
+func (kdb *Engine) signSession(payload, salt string) string {
+	mac := hmac.New(sha256.New, kdb.secret)
+	mac.Write([]byte(payload + ":" + salt))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// prepareUserDocument validates a document of _users and replaces its
+// password with a salted hash. Users who aren't admins can't change their
+// roles.
+func prepareUserDocument(ctx context.Context, db *Database, doc *Document) error {
+	v, err := fastjson.ParseBytes(doc.Data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", err, ErrBadJSON)
+	}
+	if name := v.Get("name"); name != nil && string(name.GetStringBytes()) != doc.ID {
+		return fmt.Errorf("%s: %w", "name has to be the _id of the user", ErrDocInvalidInput)
+	}
+	var roles []string
+	if v.Exists("roles") {
+		items, err := v.Get("roles").Array()
+		if err != nil {
+			return fmt.Errorf("%s: %w", "roles have to be an array of strings", ErrDocInvalidInput)
+		}
+		for _, item := range items {
+			role, err := item.StringBytes()
+			if err != nil || len(role) == 0 {
+				return fmt.Errorf("%s: %w", "roles have to be an array of strings", ErrDocInvalidInput)
+			}
+			if role[0] == '_' {
+				return fmt.Errorf("%s: %w", "roles can't start with an underscore", ErrDocInvalidInput)
+			}
+			roles = append(roles, string(role))
+		}
+	}
+
+	if user := UserFromContext(ctx); user != nil && !user.IsAdmin() {
+		var currentRoles []string
+		currentDoc, err := db.GetDocument(ctx, &Document{ID: doc.ID}, true)
+		if err != nil && !errors.Is(err, ErrDocNotFound) {
+			return err
+		}
+		if currentDoc != nil {
+			current, err := fastjson.ParseBytes(currentDoc.Data)
+			if err != nil {
+				return err
+			}
+			for _, item := range current.GetArray("roles") {
+				currentRoles = append(currentRoles, string(item.GetStringBytes()))
+			}
+		}
+		if strings.Join(roles, "\n") != strings.Join(currentRoles, "\n") {
+			return fmt.Errorf("%s: %w", "only admins can change roles", ErrForbidden)
+		}
+	}
+
+	if !v.Exists("password") {
+		return nil
+	}
+	password, err := v.Get("password").StringBytes()
+	if err != nil || len(password) == 0 {
+		return fmt.Errorf("%s: %w", "password has to be a string", ErrDocInvalidInput)
+	}
+	salt := randomBytes(16)
+	key := pbkdf2SHA256(password, salt, passwordIterations, passwordKeyLength)
+
+	var arena fastjson.Arena
+	v.Del("password")
+	v.Set("password_scheme", arena.NewString(passwordScheme))
+	v.Set("iterations", arena.NewNumberInt(passwordIterations))
+	v.Set("salt", arena.NewString(hex.EncodeToString(salt)))
+	v.Set("derived_key", arena.NewString(hex.EncodeToString(key)))
+	doc.Data = v.MarshalTo(nil)
+	return nil
+}
+
+// pbkdf2SHA256 derives a key from password, see RFC 8018.
+func pbkdf2SHA256(password, salt []byte, iterations, keyLength int) []byte {
+	prf := hmac.New(sha256.New, password)
+	var key []byte
+	for block := uint32(1); len(key) < keyLength; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.Write(prf, binary.BigEndian, block)
+		u := prf.Sum(nil)
+		t := append([]byte(nil), u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLength]
+}
@@ -0,0 +1,93 @@
+package kdb
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestPBKDF2SHA256(t *testing.T) {
+	// RFC 7914, section 11
+	key := pbkdf2SHA256([]byte("passwd"), []byte("salt"), 1, 64)
+	expected := "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"
+	if hex.EncodeToString(key) != expected {
+		t.Errorf("expected %s, got %x", expected, key)
+	}
+}
+
+func TestUsersAndSessions(t *testing.T) {
+	config := DefaultConfig()
+	config.Admins = map[string]string{"admin": "secret"}
+	kdb, _ := New(config)
+	ctx := context.Background()
+
+	if user, err := kdb.Authenticate(ctx, "admin", "secret"); err != nil || !user.IsAdmin() {
+		t.Errorf("expected the admin, got %+v %v", user, err)
+	}
+	if _, err := kdb.Authenticate(ctx, "admin", "wrong"); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expected %s, got %v", ErrUnauthorized, err)
+	}
+	if user := kdb.AnonymousUser(); user.Name != "" || user.IsAdmin() {
+		t.Errorf("expected an anonymous user, got %+v", user)
+	}
+
+	inputDoc, _ := ParseDocument([]byte(`{"_id":"testalice","roles":["_admin"],"password":"apple"}`))
+	if _, err := kdb.PutDocument(ctx, UsersDB, inputDoc); !errors.Is(err, ErrDocInvalidInput) {
+		t.Errorf("expected %s, got %v", ErrDocInvalidInput, err)
+	}
+	inputDoc, _ = ParseDocument([]byte(`{"_id":"testalice","roles":["sales"],"password":"apple"}`))
+	userDoc, err := kdb.PutDocument(ctx, UsersDB, inputDoc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, _ := kdb.GetDocument(ctx, UsersDB, &Document{ID: "testalice"}, true)
+	if doc == nil || strings.Contains(string(doc.Data), "apple") || !strings.Contains(string(doc.Data), `"derived_key":`) {
+		t.Errorf("expected a password hash, got %+v", doc)
+	}
+
+	user, err := kdb.Authenticate(ctx, "testalice", "apple")
+	if err != nil || user.Name != "testalice" || !user.HasRole("sales") || user.IsAdmin() {
+		t.Fatalf("expected testalice, got %+v %v", user, err)
+	}
+	if _, err := kdb.Authenticate(ctx, "testalice", "pear"); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expected %s, got %v", ErrUnauthorized, err)
+	}
+	if _, err := kdb.Authenticate(ctx, "testnobody", "apple"); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expected %s, got %v", ErrUnauthorized, err)
+	}
+
+	token, _, err := kdb.NewSession(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session, err := kdb.SessionUser(ctx, token); err != nil || session.Name != "testalice" {
+		t.Errorf("expected testalice, got %+v %v", session, err)
+	}
+	if _, err := kdb.SessionUser(ctx, token[:len(token)-2]); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expected %s, got %v", ErrUnauthorized, err)
+	}
+
+	// users can change their password, not their roles
+	userCtx := NewUserContext(ctx, user)
+	inputDoc, _ = ParseDocument([]byte(fmt.Sprintf(`{"_id":"testalice","_version":%d,"roles":["sales","finance"]}`, userDoc.Version)))
+	if _, err := kdb.PutDocument(userCtx, UsersDB, inputDoc); !errors.Is(err, ErrForbidden) {
+		t.Errorf("expected %s, got %v", ErrForbidden, err)
+	}
+	inputDoc, _ = ParseDocument([]byte(fmt.Sprintf(`{"_id":"testalice","_version":%d,"roles":["sales"],"password":"pear"}`, userDoc.Version)))
+	if userDoc, err = kdb.PutDocument(userCtx, UsersDB, inputDoc); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kdb.Authenticate(ctx, "testalice", "pear"); err != nil {
+		t.Error(err)
+	}
+	// a new password ends the sessions
+	if _, err := kdb.SessionUser(ctx, token); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expected %s, got %v", ErrUnauthorized, err)
+	}
+
+	kdb.DeleteDocument(ctx, UsersDB, &Document{ID: "testalice", Version: userDoc.Version})
+	kdb.Close()
+}
@@ -1,4 +1,4 @@
-package main
+package kdb
 
 import (
 	"crypto/rand"
@@ -6,7 +6,7 @@ import (
 	"strings"
 )
 
-func formatDocString(id string, version int, deleted bool) string {
+func FormatDocString(id string, version int, deleted bool) string {
 	var item []string
 	item = append(item, fmt.Sprintf(`"_id":"%s"`, id))
 	if version != 0 {
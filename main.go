@@ -1,30 +1,64 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"time"
-)
 
-var kdb *KDBEngine
+	"github.com/clementmac/kdb3/kdb"
+	"github.com/clementmac/kdb3/server"
+)
 
 func main() {
-	var err error
-	kdb, err = NewKDB()
+	configPath := flag.String("config", "", "path to the config file")
+	restore := flag.String("restore", "", "restore the backups of this database and exit")
+	restoreTarget := flag.String("restore-target", "", "name of the restored database, the backed up name when empty")
+	flag.Parse()
+
+	config := kdb.DefaultConfig()
+	if *configPath != "" {
+		var err error
+		config, err = kdb.LoadConfig(*configPath)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	engine, err := kdb.New(config)
 	if err != nil {
 		panic(err)
 	}
 
-	router := NewRouter()
+	if *restore != "" {
+		target := *restoreTarget
+		if target == "" {
+			target = *restore
+		}
+		result, err := engine.Restore(context.Background(), *restore, target)
+		engine.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("restored %s into %s, %d incremental backups with %d documents\n", *restore, target, result.Incrementals, result.Docs)
+		return
+	}
 
 	srv := &http.Server{
-		Handler:      router,
-		Addr:         "0.0.0.0:8001",
+		Handler:      server.NewHandler(engine),
+		Addr:         config.Addr,
 		WriteTimeout: 1 * time.Hour,
 		ReadTimeout:  1 * time.Hour,
 	}
 
+	if addr, enabled := engine.DebugAddr(); enabled && addr != "" {
+		go func() {
+			log.Fatal(http.ListenAndServe(addr, server.NewDebugHandler()))
+		}()
+	}
+
 	log.Fatal(srv.ListenAndServe())
 
 	fmt.Println("Started")
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/clementmac/kdb3/kdb"
+	"github.com/gorilla/mux"
+)
+
+// sessionCookie is the cookie of a session started with POST /_session.
+const sessionCookie = "AuthSession"
+
+// access is what the user of a request needs for a route, the database
+// levels are the ones of kdb.Access.
+type access int
+
+const (
+	accessPublic      = access(kdb.AccessNone)
+	accessReader      = access(kdb.AccessReader)
+	accessMember      = access(kdb.AccessMember)
+	accessDBAdmin     = access(kdb.AccessAdmin)
+	accessServerAdmin = accessDBAdmin + 1
+)
+
+// authenticate attaches the user of a request to its context, the user of
+// its Basic credentials, of its Bearer token, of its session cookie or else
+// the anonymous user. Wrong credentials and invalid tokens are rejected, an
+// expired session is anonymous. Failures count against the rate limit of
+// the ip address, which is checked before the credentials.
+func (h *Handler) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !h.allowAuthentication(w, r) {
+			return
+		}
+		user := h.engine.AnonymousUser()
+		if name, password, ok := r.BasicAuth(); ok {
+			var err error
+			if user, err = h.engine.Authenticate(r.Context(), name, password); err != nil {
+				h.authenticationFailed(w, r, err)
+				return
+			}
+		} else if token, ok := bearerToken(r); ok {
+			var err error
+			if user, err = h.engine.AuthenticateToken(token); err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="kdb3", error="invalid_token"`)
+				h.authenticationFailed(w, r, err)
+				return
+			}
+		} else if cookie, err := r.Cookie(sessionCookie); err == nil {
+			if sessionUser, err := h.engine.SessionUser(r.Context(), cookie.Value); err == nil {
+				user = sessionUser
+			}
+		}
+
+		if user.Name == "" && h.engine.RequireValidUser() && !isLogin(r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="kdb3"`)
+			h.authenticationFailed(w, r, fmt.Errorf("%s: %w", "authentication required", kdb.ErrUnauthorized))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(kdb.NewUserContext(r.Context(), user)))
+	})
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	const prefix = "Bearer "
+	if len(auth) <= len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(auth[len(prefix):]), true
+}
+
+func isLogin(r *http.Request) bool {
+	return r.URL.Path == "/_session" && r.Method == http.MethodPost
+}
+
+// authorize checks the user of a request has the access a route needs.
+func (h *Handler) authorize(required access, handler http.HandlerFunc) http.HandlerFunc {
+	if required == accessPublic {
+		return handler
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := h.checkAccess(required, r); err != nil {
+			NotOK(err, w)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func (h *Handler) checkAccess(required access, r *http.Request) error {
+	user := kdb.UserFromContext(r.Context())
+	if user.IsAdmin() {
+		return nil
+	}
+	if required != accessServerAdmin {
+		db := mux.Vars(r)["db"]
+		// users read, update and delete their own document in _users
+		if db == kdb.UsersDB && user.Name != "" && mux.Vars(r)["docid"] == user.Name {
+			switch mux.CurrentRoute(r).GetName() {
+			case "GetDocument", "HeadDocument", "PutDocument", "DeleteDocument":
+				return nil
+			}
+		}
+		granted, err := h.engine.DatabaseAccess(db, user)
+		if err != nil {
+			return err
+		}
+		if granted >= kdb.Access(required) {
+			return nil
+		}
+	}
+	if user.Name == "" {
+		return fmt.Errorf("%s: %w", "authentication required", kdb.ErrUnauthorized)
+	}
+	return fmt.Errorf("%s: %w", "not allowed for "+user.Name, kdb.ErrForbidden)
+}
+
+// GetSession returns the user of the request, a null name when anonymous.
+func (h *Handler) GetSession(w http.ResponseWriter, r *http.Request) {
+	writeSession(w, kdb.UserFromContext(r.Context()))
+}
+
+// PostSession starts a session, {"name":"alice","password":"..."}, and sets
+// its cookie.
+func (h *Handler) PostSession(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1048576))
+	if err != nil {
+		NotOK(err, w)
+		return
+	}
+	req := struct {
+		Name     string `json:"name"`
+		Password string `json:"password"`
+	}{}
+	if err := json.Unmarshal(body, &req); err != nil {
+		NotOK(fmt.Errorf("%s: %w", err, kdb.ErrBadJSON), w)
+		return
+	}
+	user, err := h.engine.Authenticate(r.Context(), req.Name, req.Password)
+	if err != nil {
+		h.authenticationFailed(w, r, err)
+		return
+	}
+	token, expires, err := h.engine.NewSession(r.Context(), user)
+	if err != nil {
+		NotOK(err, w)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	writeSession(w, user)
+}
+
+// DeleteSession ends the session by removing its cookie.
+func (h *Handler) DeleteSession(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1, HttpOnly: true})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, `{"ok":true}`)
+}
+
+func writeSession(w http.ResponseWriter, user *kdb.User) {
+	session := struct {
+		OK    bool     `json:"ok"`
+		Name  *string  `json:"name"`
+		Roles []string `json:"roles"`
+	}{OK: true, Roles: []string{}}
+	if user != nil {
+		if user.Name != "" {
+			session.Name = &user.Name
+		}
+		if user.Roles != nil {
+			session.Roles = user.Roles
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(session)
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/clementmac/kdb3/kdb"
+)
+
+// headers the browser apps can read besides the simple ones, E-Tag has the
+// version of a document
+const corsExposedHeaders = "E-Tag, Retry-After, WWW-Authenticate"
+
+var (
+	corsDefaultMethods = []string{"GET", "HEAD", "POST", "PUT", "DELETE"}
+	corsDefaultHeaders = []string{"Accept", "Authorization", "Content-Type"}
+)
+
+// corsPolicy adds the CORS headers to the responses to allowed origins.
+type corsPolicy struct {
+	anyOrigin   bool
+	origins     map[string]bool
+	methods     string
+	headers     string
+	credentials bool
+	maxAge      string
+}
+
+func newCORSPolicy(config *kdb.CORSConfig) *corsPolicy {
+	if config == nil || len(config.Origins) == 0 {
+		return nil
+	}
+	policy := &corsPolicy{origins: make(map[string]bool), credentials: config.Credentials}
+	for _, origin := range config.Origins {
+		if origin == "*" {
+			policy.anyOrigin = true
+		}
+		policy.origins[strings.ToLower(strings.TrimRight(origin, "/"))] = true
+	}
+	methods, headers := config.Methods, config.Headers
+	if len(methods) == 0 {
+		methods = corsDefaultMethods
+	}
+	if len(headers) == 0 {
+		headers = corsDefaultHeaders
+	}
+	policy.methods = strings.ToUpper(strings.Join(methods, ", "))
+	policy.headers = strings.Join(headers, ", ")
+	if config.MaxAge > 0 {
+		policy.maxAge = strconv.Itoa(config.MaxAge)
+	}
+	return policy
+}
+
+func (policy *corsPolicy) allowed(origin string) bool {
+	return policy.anyOrigin || policy.origins[strings.ToLower(origin)]
+}
+
+// handle adds the CORS headers for the origin of r. It answers preflight
+// requests and returns true for them, other requests go on to the routes.
+func (policy *corsPolicy) handle(w http.ResponseWriter, r *http.Request) bool {
+	if policy == nil {
+		return false
+	}
+	origin := r.Header.Get("Origin")
+	preflight := r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != ""
+	w.Header().Add("Vary", "Origin")
+	if origin == "" || !policy.allowed(origin) {
+		if preflight {
+			w.WriteHeader(http.StatusForbidden)
+		}
+		return preflight
+	}
+
+	// credentials are never allowed for every origin
+	if policy.anyOrigin {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if policy.credentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+	}
+	if !preflight {
+		w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+		return false
+	}
+
+	w.Header().Set("Access-Control-Allow-Methods", policy.methods)
+	w.Header().Set("Access-Control-Allow-Headers", policy.headers)
+	if policy.maxAge != "" {
+		w.Header().Set("Access-Control-Max-Age", policy.maxAge)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
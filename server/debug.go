@@ -0,0 +1,26 @@
+package server
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/gorilla/mux"
+)
+
+// NewDebugHandler serves the pprof and expvar endpoints under /_debug,
+// without authentication, for a listener of their own.
+func NewDebugHandler() http.Handler {
+	router := mux.NewRouter().StrictSlash(true)
+	addDebugRoutes(router, func(handler http.Handler) http.Handler { return handler })
+	return router
+}
+
+// addDebugRoutes adds the debug endpoints to router, each wrapped by wrap.
+func addDebugRoutes(router *mux.Router, wrap func(http.Handler) http.Handler) {
+	router.Handle("/_debug/vars", wrap(expvar.Handler()))
+	router.Handle("/_debug/pprof", wrap(http.HandlerFunc(pprof.Index)))
+	for _, profile := range []string{"allocs", "block", "cmdline", "goroutine", "heap", "mutex", "profile", "threadcreate", "trace"} {
+		router.Handle("/_debug/"+profile, wrap(pprof.Handler(profile)))
+	}
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/clementmac/kdb3/kdb"
+)
+
+func NotOK(err error, w http.ResponseWriter) {
+	var (
+		statusCode = 0
+		code       = ""
+		reason     = ""
+	)
+
+	switch {
+	case errors.Is(err, kdb.ErrDBExists) || errors.Is(err, kdb.ErrDBInvalidName) || errors.Is(err, kdb.ErrInvalidSQLStmt) || errors.Is(err, kdb.ErrViewInvalidSource) || errors.Is(err, kdb.ErrViewInvalidDependency) || errors.Is(err, kdb.ErrViewInvalidExternal):
+		statusCode = http.StatusPreconditionFailed
+	case errors.Is(err, kdb.ErrDocConflict):
+		statusCode = http.StatusConflict
+	case errors.Is(err, kdb.ErrDBNotFound) || errors.Is(err, kdb.ErrDocNotFound) || errors.Is(err, kdb.ErrViewNotFound) || errors.Is(err, kdb.ErrBackupNotFound) || errors.Is(err, kdb.ErrAuditDisabled):
+		statusCode = http.StatusNotFound
+	case errors.Is(err, kdb.ErrBadJSON) || errors.Is(err, kdb.ErrViewInvalidParam) || errors.Is(err, kdb.ErrInvalidStorage) || errors.Is(err, kdb.ErrInvalidReplication):
+		statusCode = http.StatusBadRequest
+	case errors.Is(err, kdb.ErrUnauthorized):
+		statusCode = http.StatusUnauthorized
+	case errors.Is(err, kdb.ErrForbidden):
+		statusCode = http.StatusForbidden
+	case errors.Is(err, kdb.ErrQuotaExceeded):
+		statusCode = http.StatusRequestEntityTooLarge
+	case errors.Is(err, kdb.ErrTooManyRequests):
+		statusCode = http.StatusTooManyRequests
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled):
+		statusCode = http.StatusServiceUnavailable
+	}
+
+	if statusCode == 0 {
+		statusCode = http.StatusInternalServerError
+	}
+
+	code, reason = kdb.ErrorString(err)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(map[string]string{"error": code, "reason": reason})
+}
@@ -0,0 +1,1216 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/clementmac/kdb3/kdb"
+	"github.com/valyala/fastjson"
+)
+
+var engine *kdb.Engine
+
+//https://blog.questionable.services/article/testing-http-handlers-go/
+func TestGetUUID(t *testing.T) {
+	var parser fastjson.Parser
+	req, _ := http.NewRequest("GET", "/_uuids?count=10", nil)
+	rr := httptest.NewRecorder()
+	handler := NewHandler(engine)
+	handler.ServeHTTP(rr, req)
+
+	testExpect200(t, rr)
+
+	v, _ := parser.Parse(rr.Body.String())
+	uuids := v.GetArray()
+
+	if len(uuids) != 10 {
+		t.Errorf("expected 10 items, got %d", len(uuids))
+	}
+
+	testExpectJSONContentType(t, rr)
+
+	req, _ = http.NewRequest("GET", "/_uuids?count=-1", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	testExpect200(t, rr)
+
+	v, _ = parser.Parse(rr.Body.String())
+	uuids = v.GetArray()
+
+	if len(uuids) != 1 {
+		t.Errorf("expected 1 items, got %d", len(uuids))
+	}
+
+	testExpectJSONContentType(t, rr)
+}
+
+func testExpectJSONContentType(t *testing.T, rr *httptest.ResponseRecorder) {
+	if rr.HeaderMap.Get("Content-Type") != "application/json" {
+		t.Errorf(`expected json content type`)
+	}
+}
+
+func testExpect200(t *testing.T, rr *httptest.ResponseRecorder) {
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+}
+
+func testExpect409(t *testing.T, rr *httptest.ResponseRecorder) {
+	if status := rr.Code; status != http.StatusConflict {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusConflict)
+	}
+}
+func TestGetInfo(t *testing.T) {
+	var parser fastjson.Parser
+	req, _ := http.NewRequest("GET", "/", nil)
+	rr := httptest.NewRecorder()
+	handler := NewHandler(engine)
+	handler.ServeHTTP(rr, req)
+
+	testExpect200(t, rr)
+
+	v, _ := parser.Parse(rr.Body.String())
+
+	version := v.GetObject("version").Get("sqlite_version").String()
+	if version != `"3.29.0"` {
+		t.Errorf(`expected version "3.29.0", got %s`, version)
+	}
+
+	testExpectJSONContentType(t, rr)
+}
+
+func TestHandlerPutDatabase(t *testing.T) {
+	engine, _ = kdb.New(nil)
+	req, _ := http.NewRequest("PUT", "/testdb", nil)
+	rr := httptest.NewRecorder()
+	handler := NewHandler(engine)
+	handler.ServeHTTP(rr, req)
+
+	testExpect200(t, rr)
+	testExpectJSONContentType(t, rr)
+
+	expected := `{"ok":true}`
+	if expected != rr.Body.String() {
+		t.Errorf(`expected to have ok %s`, rr.Body.String())
+	}
+}
+
+func TestHandlerPutDocument(t *testing.T) {
+	body := bytes.NewBufferString("{}")
+	req, _ := http.NewRequest("POST", "/testdb", body)
+	rr := httptest.NewRecorder()
+	handler := NewHandler(engine)
+	handler.ServeHTTP(rr, req)
+
+	testExpect200(t, rr)
+	doc, _ := kdb.ParseDocument(rr.Body.Bytes())
+	if doc.Version != 1 || doc.ID == "" {
+		t.Errorf(`expected to have ok, got %s`, rr.Body.String())
+	}
+
+	testExpectJSONContentType(t, rr)
+}
+
+func TestHandlerPutDocument1(t *testing.T) {
+	handler := NewHandler(engine)
+
+	body := bytes.NewBufferString(`{"_id":1}`)
+	req, _ := http.NewRequest("POST", "/testdb", body)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	testExpect200(t, rr)
+	testExpectJSONContentType(t, rr)
+
+	doc, _ := kdb.ParseDocument(rr.Body.Bytes())
+	if doc.Version != 1 || doc.ID != "1" {
+		t.Errorf(`expected to have ok, got %s`, rr.Body.String())
+	}
+
+	body = bytes.NewBufferString(`{"_id":1, "_version":1}`)
+	req, _ = http.NewRequest("POST", "/testdb", body)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	testExpectJSONContentType(t, rr)
+	testExpect200(t, rr)
+
+	doc, _ = kdb.ParseDocument(rr.Body.Bytes())
+	if doc.Version != 2 || doc.ID != "1" {
+		t.Errorf(`expected to have ok, got %s`, rr.Body.String())
+	}
+
+	body = bytes.NewBufferString(`{"_id":1, "_version":1}`)
+	req, _ = http.NewRequest("POST", "/testdb", body)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	testExpect409(t, rr)
+}
+
+func TestHandlerDeleteDocument(t *testing.T) {
+	handler := NewHandler(engine)
+
+	req, _ := http.NewRequest("DELETE", "/testdb/1?version=2", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	testExpect200(t, rr)
+	testExpectJSONContentType(t, rr)
+
+	doc, _ := kdb.ParseDocument(rr.Body.Bytes())
+
+	if doc.ID != "1" || doc.Version != 3 || doc.Deleted != true {
+		t.Errorf(`expected to have ok, got %s`, rr.Body.String())
+	}
+}
+
+func TestHandlerPutDeletedDocument(t *testing.T) {
+	handler := NewHandler(engine)
+
+	body := bytes.NewBufferString(`{"_id":1, "_version":2}`)
+	req, _ := http.NewRequest("POST", "/testdb", body)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	testExpect409(t, rr)
+	testExpectJSONContentType(t, rr)
+
+	body = bytes.NewBufferString(`{"_id":1}`)
+	req, _ = http.NewRequest("POST", "/testdb", body)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	testExpect200(t, rr)
+	testExpectJSONContentType(t, rr)
+
+	doc, _ := kdb.ParseDocument(rr.Body.Bytes())
+
+	if doc.ID != "1" || doc.Version != 4 || doc.Deleted != false {
+		t.Errorf(`expected to have ok, got %s`, rr.Body.String())
+	}
+}
+
+func TestHandlerBulkDocuments(t *testing.T) {
+	body := bytes.NewBufferString(`{"_docs":[{"_id":3},{"_id":4}]}`)
+	req, _ := http.NewRequest("POST", "/testdb/_bulk_docs", body)
+	rr := httptest.NewRecorder()
+	handler := NewHandler(engine)
+	handler.ServeHTTP(rr, req)
+	expected := `[{"_id":"3","_version":1},{"_id":"4","_version":1}]`
+
+	testExpect200(t, rr)
+	testExpectJSONContentType(t, rr)
+
+	if expected != rr.Body.String() {
+		t.Errorf(`expected to have %s, got %s`, expected, rr.Body.String())
+	}
+}
+
+func TestHandlerBulkGetDocuments(t *testing.T) {
+	body := bytes.NewBufferString(`{"_docs":[{"_id":3},{"_id":4}]}`)
+	req, _ := http.NewRequest("POST", "/testdb/_bulk_gets", body)
+	rr := httptest.NewRecorder()
+	handler := NewHandler(engine)
+	handler.ServeHTTP(rr, req)
+	expected := `[{"_id":"3","_version":1},{"_id":"4","_version":1}]`
+
+	testExpect200(t, rr)
+	testExpectJSONContentType(t, rr)
+
+	if expected != rr.Body.String() {
+		t.Errorf(`expected to have %s, got %s`, expected, rr.Body.String())
+	}
+}
+
+type testChanges struct {
+	Results []testChange `json:"results"`
+}
+
+type testChange struct {
+	ID      string `json:"id"`
+	Version int    `json:"version"`
+	Seq     string `json:"seq"`
+}
+
+func TestHandlerGetChanges(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/testdb/_changes", nil)
+	rr := httptest.NewRecorder()
+	handler := NewHandler(engine)
+	handler.ServeHTTP(rr, req)
+
+	testExpect200(t, rr)
+	testExpectJSONContentType(t, rr)
+
+	a := testChanges{}
+	json.Unmarshal(rr.Body.Bytes(), &a)
+
+	a0 := a.Results[0]
+	if a0.ID != "4" || a0.Version != 1 {
+		t.Errorf(`failed`)
+	}
+
+	a1 := a.Results[1]
+	if a1.ID != "3" || a1.Version != 1 {
+		t.Errorf(`failed`)
+	}
+
+	a2 := a.Results[2]
+	if a2.ID != "1" || a2.Version != 4 {
+		t.Errorf(`failed`)
+	}
+
+	a4 := a.Results[4]
+	if a4.ID != "_design/_views" || a4.Version != 1 {
+		t.Errorf(`failed`)
+	}
+}
+
+func TestHandlerGetDocument(t *testing.T) {
+	handler := NewHandler(engine)
+
+	req, _ := http.NewRequest("GET", "/testdb/1", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	testExpect200(t, rr)
+	testExpectJSONContentType(t, rr)
+	doc, _ := kdb.ParseDocument(rr.Body.Bytes())
+	if doc.Version != 4 || doc.ID != "1" {
+		t.Errorf(`expected to have ok, got %s`, rr.Body.String())
+	}
+	//fmt.Println(doc)
+	req, _ = http.NewRequest("GET", "/testdb/1?version=4", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	testExpect200(t, rr)
+	testExpectJSONContentType(t, rr)
+	doc, _ = kdb.ParseDocument(rr.Body.Bytes())
+	if doc.Version != 4 || doc.ID != "1" {
+		t.Errorf(`expected to have ok, got %s`, rr.Body.String())
+	}
+}
+
+func TestHandlerDocumentConflicts(t *testing.T) {
+	handler := NewHandler(engine)
+
+	// a version 4 written elsewhere, it loses against the current one
+	body := bytes.NewBufferString(`{"new_edits":false,"_docs":[{"_id":"1","_version":4,"_hash":"0","test":"conflict"}]}`)
+	req, _ := http.NewRequest("POST", "/testdb/_bulk_docs", body)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	testExpect200(t, rr)
+
+	req, _ = http.NewRequest("GET", "/testdb/1?conflicts=true", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	testExpect200(t, rr)
+	doc := struct {
+		Version   int      `json:"_version"`
+		Hash      string   `json:"_hash"`
+		Conflicts []string `json:"_conflicts"`
+	}{}
+	json.Unmarshal(rr.Body.Bytes(), &doc)
+	if doc.Version != 4 || doc.Hash == "" || len(doc.Conflicts) != 1 || doc.Conflicts[0] != "4-0" {
+		t.Errorf(`expected the conflict 4-0, got %s`, rr.Body.String())
+	}
+
+	req, _ = http.NewRequest("GET", "/testdb/1?conflict=4-0", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	testExpect200(t, rr)
+	if expected := `{"_id":"1","_version":4,"_hash":"0","test":"conflict"}`; rr.Body.String() != expected {
+		t.Errorf(`expected to have %s, got %s`, expected, rr.Body.String())
+	}
+
+	req, _ = http.NewRequest("DELETE", "/testdb/1?conflict=4-0", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	testExpect200(t, rr)
+
+	req, _ = http.NewRequest("DELETE", "/testdb/1?conflict=4-0", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestHandlerGetDatabase(t *testing.T) {
+	engine, _ = kdb.New(nil)
+	req, _ := http.NewRequest("GET", "/testdb", nil)
+	rr := httptest.NewRecorder()
+	handler := NewHandler(engine)
+	handler.ServeHTTP(rr, req)
+
+	testExpect200(t, rr)
+	testExpectJSONContentType(t, rr)
+	stat := &kdb.DBStat{}
+	json.Unmarshal(rr.Body.Bytes(), stat)
+
+	if stat.DBName != "testdb" || stat.DocCount != 5 {
+		t.Errorf(`failed, got %s`, rr.Body.String())
+	}
+}
+
+func TestHandlerGetDDatabase(t *testing.T) {
+	engine, _ = kdb.New(nil)
+	req, _ := http.NewRequest("GET", "/testdb/_design/_views", nil)
+	rr := httptest.NewRecorder()
+	handler := NewHandler(engine)
+	handler.ServeHTTP(rr, req)
+
+	testExpect200(t, rr)
+	testExpectJSONContentType(t, rr)
+
+	doc, _ := kdb.ParseDocument(rr.Body.Bytes())
+
+	if doc.ID != "_design/_views" {
+		t.Errorf(`failed, got %s`, rr.Body.String())
+	}
+}
+
+type testAllDocsRows struct {
+	Rows []testEmpty
+}
+type testEmpty struct {
+	ID string `json:"id"`
+}
+
+func TestHandlerPutDDatabase(t *testing.T) {
+	engine, _ = kdb.New(nil)
+	req, _ := http.NewRequest("GET", "/testdb/_design/_views", nil)
+	rr := httptest.NewRecorder()
+	handler := NewHandler(engine)
+	handler.ServeHTTP(rr, req)
+
+	testExpect200(t, rr)
+	testExpectJSONContentType(t, rr)
+
+	viewDoc := rr.Body.Bytes()
+
+	req, _ = http.NewRequest("PUT", "/testdb/_design/_views", rr.Body)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	testExpect200(t, rr)
+	testExpectJSONContentType(t, rr)
+
+	doc, _ := kdb.ParseDocument(rr.Body.Bytes())
+	if doc.ID != "_design/_views" || doc.Version != 2 {
+		t.Errorf(`failed, got %s`, rr.Body.String())
+	}
+
+	doc, _ = kdb.ParseDocument(viewDoc)
+	req, _ = http.NewRequest("PUT", "/testdb/_design/_views1", bytes.NewBuffer(doc.Data))
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	testExpect200(t, rr)
+	testExpectJSONContentType(t, rr)
+
+	doc, _ = kdb.ParseDocument(rr.Body.Bytes())
+	if doc.ID != "_design/_views1" || doc.Version != 1 {
+		t.Errorf(`failed, got %s`, rr.Body.String())
+	}
+
+	req, _ = http.NewRequest("GET", "/testdb/_all_docs", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	testExpect200(t, rr)
+	testExpectJSONContentType(t, rr)
+
+	req, _ = http.NewRequest("GET", "/testdb/_design/_views1/_all_docs", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	testExpect200(t, rr)
+	testExpectJSONContentType(t, rr)
+
+	rows := testAllDocsRows{}
+	json.Unmarshal(rr.Body.Bytes(), &rows)
+
+	if len(rows.Rows) != 6 {
+		t.Errorf(`failed, got %s`, rr.Body.String())
+	}
+
+	req, _ = http.NewRequest("GET", "/testdb/_all_docs?key=1", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	testExpect200(t, rr)
+	testExpectJSONContentType(t, rr)
+
+	json.Unmarshal(rr.Body.Bytes(), &rows)
+
+	if rows.Rows[0].ID != "1" {
+		t.Errorf(`failed, got %s`, rr.Body.String())
+	}
+}
+
+func TestHandlerSelectViewTypedParams(t *testing.T) {
+	handler := NewHandler(engine)
+	ddoc := `{"views":{"typed":{"select":{"default":"SELECT JSON_OBJECT('n', ${n:int} + 1, 'ids', (SELECT JSON_GROUP_ARRAY(value) FROM json_each('[1,2,3]') WHERE value IN ${ids:array}))"},"params":{"n":{"required":true}}}}}`
+	req, _ := http.NewRequest("PUT", "/testdb/_design/typed", bytes.NewBufferString(ddoc))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	testExpect200(t, rr)
+
+	req, _ = http.NewRequest("GET", "/testdb/_design/typed/typed?n=1&ids=[1,2]", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	testExpect200(t, rr)
+	testExpectJSONContentType(t, rr)
+
+	expected := `{"n":2,"ids":[1,2]}`
+	if expected != rr.Body.String() {
+		t.Errorf(`expected %s, got %s`, expected, rr.Body.String())
+	}
+
+	req, _ = http.NewRequest("GET", "/testdb/_design/typed/typed?n=abc", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	req, _ = http.NewRequest("GET", "/testdb/_design/typed/typed", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+}
+
+func TestHandlerDesignDocumentTest(t *testing.T) {
+	handler := NewHandler(engine)
+	body := `{
+		"design": {"views":{"posts":{
+			"setup":["CREATE TABLE IF NOT EXISTS posts (title, doc_id, PRIMARY KEY(doc_id))"],
+			"run":["INSERT OR REPLACE INTO posts (title, doc_id) SELECT json_extract(data, '$.title'), doc_id FROM latest_documents WHERE deleted = 0"],
+			"select":{"default":"SELECT JSON_GROUP_ARRAY(title) FROM (SELECT title FROM posts WHERE title != ${skip} ORDER BY title)","broken":"SELECT title FROM missing"}}}},
+		"docs": [{"_id":"1","title":"a"},{"_id":"2","title":"b"},{"title":"c"}],
+		"params": {"skip":"b"}
+	}`
+	req, _ := http.NewRequest("POST", "/testdb/_design_test", bytes.NewBufferString(body))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	testExpect200(t, rr)
+	testExpectJSONContentType(t, rr)
+
+	result := kdb.DesignDocumentTestResult{}
+	json.Unmarshal(rr.Body.Bytes(), &result)
+
+	if result.OK {
+		t.Errorf("expected broken select to fail, got %s", rr.Body.String())
+	}
+
+	posts := result.Views["posts"]
+	if posts == nil || len(posts.Setup) != 1 || posts.Setup[0].Error != "" || len(posts.Run) != 1 || posts.Run[0].Error != "" {
+		t.Fatalf("unexpected result %s", rr.Body.String())
+	}
+
+	if string(posts.Select["default"].Result) != `["a","c"]` {
+		t.Errorf("expected %s, got %s", `["a","c"]`, posts.Select["default"].Result)
+	}
+
+	if posts.Select["broken"].Error == "" {
+		t.Errorf("expected error, got %s", rr.Body.String())
+	}
+
+	req, _ = http.NewRequest("POST", "/testdb/_design_test", bytes.NewBufferString(`{"design":{"views":[]}}`))
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+}
+
+func TestHandlerPutMemoryDatabase(t *testing.T) {
+	handler := NewHandler(engine)
+	req, _ := http.NewRequest("PUT", "/testmemdb?storage=unknown", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	req, _ = http.NewRequest("PUT", "/testmemdb?storage=memory", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	testExpect200(t, rr)
+
+	req, _ = http.NewRequest("GET", "/testmemdb", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	testExpect200(t, rr)
+
+	stat := &kdb.DBStat{}
+	json.Unmarshal(rr.Body.Bytes(), stat)
+	if stat.Storage != "memory" {
+		t.Errorf("expected storage memory, got %s", rr.Body.String())
+	}
+
+	req, _ = http.NewRequest("DELETE", "/testmemdb", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	testExpect200(t, rr)
+}
+
+func TestHandlerCopyAndRenameDatabase(t *testing.T) {
+	handler := NewHandler(engine)
+	req, _ := http.NewRequest("POST", "/testdb/_copy", bytes.NewBufferString(`{"target":"testdbcopy"}`))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	testExpect200(t, rr)
+
+	req, _ = http.NewRequest("POST", "/testdbcopy/_rename", bytes.NewBufferString(`{"target":"testdbcopy2"}`))
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	testExpect200(t, rr)
+
+	req, _ = http.NewRequest("POST", "/testdbcopy2/_rename", bytes.NewBufferString(`{"target":"testdb"}`))
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusPreconditionFailed)
+	}
+
+	req, _ = http.NewRequest("GET", "/testdbcopy2/_design/_views/_all_docs", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	testExpect200(t, rr)
+
+	req, _ = http.NewRequest("DELETE", "/testdbcopy2", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	testExpect200(t, rr)
+}
+
+func TestHandlerBackupAndRestore(t *testing.T) {
+	handler := NewHandler(engine)
+	os.RemoveAll("./data/backups/testdb")
+	defer os.RemoveAll("./data/backups/testdb")
+
+	for _, kind := range []string{"full", "incremental"} {
+		req, _ := http.NewRequest("POST", "/testdb/_backup", bytes.NewBufferString(""))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		testExpect200(t, rr)
+		result := kdb.BackupResult{}
+		json.Unmarshal(rr.Body.Bytes(), &result)
+		if result.Kind != kind {
+			t.Errorf("expected a %s backup, got %s", kind, rr.Body.String())
+		}
+	}
+
+	req, _ := http.NewRequest("POST", "/_restore", bytes.NewBufferString(`{"backup":"testdb","target":"testdbrestored"}`))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	testExpect200(t, rr)
+
+	req, _ = http.NewRequest("POST", "/_restore", bytes.NewBufferString(`{"backup":"testdbmissing","target":"testdbmissing"}`))
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+
+	req, _ = http.NewRequest("DELETE", "/testdbrestored", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	testExpect200(t, rr)
+}
+
+func TestHandlerExportAndImport(t *testing.T) {
+	handler := NewHandler(engine)
+
+	req, _ := http.NewRequest("GET", "/testdb/_export", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	testExpect200(t, rr)
+	if rr.HeaderMap.Get("Content-Type") != "application/x-ndjson" {
+		t.Errorf("handler returned wrong content type: got %v", rr.HeaderMap.Get("Content-Type"))
+	}
+	export := rr.Body.String()
+	if !strings.HasSuffix(export, "}\n") {
+		t.Errorf("unexpected export %s", export)
+	}
+
+	req, _ = http.NewRequest("PUT", "/testdbimport", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	testExpect200(t, rr)
+
+	req, _ = http.NewRequest("POST", "/testdbimport/_import", bytes.NewBufferString(export+"{\n"))
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	testExpect200(t, rr)
+	result := kdb.ImportResult{}
+	json.Unmarshal(rr.Body.Bytes(), &result)
+	lines := strings.Count(export, "\n")
+	if result.Read != lines+1 || result.Written+result.Skipped != lines || result.Failures != 1 {
+		t.Errorf("expected %d documents imported and a failure, got %s", lines, rr.Body.String())
+	}
+
+	req, _ = http.NewRequest("POST", "/testdbimport/_import?versions=ignore", bytes.NewBufferString(`{"_id":"imported","_version":5}`))
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	testExpect200(t, rr)
+	if !strings.Contains(rr.Body.String(), `"written":1`) {
+		t.Errorf("expected a document written, got %s", rr.Body.String())
+	}
+
+	req, _ = http.NewRequest("GET", "/testdbmissing/_export", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+
+	req, _ = http.NewRequest("DELETE", "/testdbimport", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	testExpect200(t, rr)
+}
+
+func TestHandlerAuthentication(t *testing.T) {
+	config := kdb.DefaultConfig()
+	config.DBPath = "./data/auth/dbs"
+	config.ViewPath = "./data/auth/mrviews"
+	config.Admins = map[string]string{"admin": "secret"}
+	authEngine, _ := kdb.New(config)
+	defer authEngine.Close()
+	defer os.RemoveAll("./data/auth")
+	handler := NewHandler(authEngine)
+
+	req, _ := http.NewRequest("GET", "/_session", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	testExpect200(t, rr)
+	if rr.Body.String() != `{"ok":true,"name":null,"roles":[]}`+"\n" {
+		t.Errorf("expected an anonymous session, got %s", rr.Body.String())
+	}
+
+	req, _ = http.NewRequest("PUT", "/_users/testbob", bytes.NewBufferString(`{"roles":["sales"],"password":"apple"}`))
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+
+	req, _ = http.NewRequest("PUT", "/_users/testbob", bytes.NewBufferString(`{"roles":["sales"],"password":"apple"}`))
+	req.SetBasicAuth("admin", "secret")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	testExpect200(t, rr)
+
+	req, _ = http.NewRequest("GET", "/testdbauth", nil)
+	req.SetBasicAuth("testbob", "pear")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+
+	req, _ = http.NewRequest("POST", "/_session", bytes.NewBufferString(`{"name":"testbob","password":"apple"}`))
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	testExpect200(t, rr)
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != sessionCookie || !cookies[0].HttpOnly {
+		t.Fatalf("expected a session cookie, got %v", cookies)
+	}
+
+	req, _ = http.NewRequest("GET", "/_session", nil)
+	req.AddCookie(cookies[0])
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Body.String() != `{"ok":true,"name":"testbob","roles":["sales"]}`+"\n" {
+		t.Errorf("expected the session of testbob, got %s", rr.Body.String())
+	}
+
+	// users only see their own document in _users
+	for path, statusCode := range map[string]int{"/_users/testbob": http.StatusOK, "/_users/admin": http.StatusForbidden, "/_users/_all_docs": http.StatusForbidden} {
+		req, _ = http.NewRequest("GET", path, nil)
+		req.AddCookie(cookies[0])
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != statusCode {
+			t.Errorf("%s returned wrong status code: got %v want %v", path, rr.Code, statusCode)
+		}
+	}
+
+	req, _ = http.NewRequest("DELETE", "/_session", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	testExpect200(t, rr)
+	if cookies := rr.Result().Cookies(); len(cookies) != 1 || cookies[0].MaxAge >= 0 {
+		t.Errorf("expected the session cookie to be removed, got %v", cookies)
+	}
+}
+
+func TestHandlerSecurity(t *testing.T) {
+	config := kdb.DefaultConfig()
+	config.DBPath = "./data/security/dbs"
+	config.ViewPath = "./data/security/mrviews"
+	config.Admins = map[string]string{"admin": "secret"}
+	securityEngine, _ := kdb.New(config)
+	defer securityEngine.Close()
+	defer os.RemoveAll("./data/security")
+	handler := NewHandler(securityEngine)
+
+	request := func(method, path, body, name string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		if name != "" {
+			req.SetBasicAuth(name, name+"pw")
+		}
+		if name == "admin" {
+			req.SetBasicAuth("admin", "secret")
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	request("PUT", "/_users/bob", `{"password":"bobpw"}`, "admin")
+	request("PUT", "/_users/carol", `{"password":"carolpw","roles":["auditors"]}`, "admin")
+	request("PUT", "/_users/dave", `{"password":"davepw"}`, "admin")
+	if rr := request("PUT", "/testdbsecure", "", "bob"); rr.Code != http.StatusForbidden {
+		t.Errorf("expected only admins to create databases, got %v", rr.Code)
+	}
+	testExpect200(t, request("PUT", "/testdbsecure", "", "admin"))
+	testExpect200(t, request("PUT", "/testdbsecure/_security", `{"members":{"names":["bob"]},"readers":{"roles":["auditors"]}}`, "admin"))
+
+	tests := []struct {
+		method, path, body, name string
+		statusCode               int
+	}{
+		{"PUT", "/testdbsecure/_security", `{}`, "bob", http.StatusForbidden},
+		{"PUT", "/testdbsecure/1", `{"name":"one"}`, "bob", http.StatusOK},
+		{"PUT", "/testdbsecure/_design/names", `{"views":{}}`, "bob", http.StatusForbidden},
+		{"POST", "/testdbsecure/_bulk_docs", `{"_docs":[{"_id":"2"}]}`, "bob", http.StatusOK},
+		{"GET", "/testdbsecure/1", "", "carol", http.StatusOK},
+		{"GET", "/testdbsecure/_changes", "", "carol", http.StatusOK},
+		{"GET", "/testdbsecure/_security", "", "carol", http.StatusOK},
+		{"PUT", "/testdbsecure/3", `{}`, "carol", http.StatusForbidden},
+		{"GET", "/testdbsecure/1", "", "dave", http.StatusForbidden},
+		{"GET", "/testdbsecure/_all_docs", "", "dave", http.StatusForbidden},
+		{"GET", "/testdbsecure/1", "", "", http.StatusUnauthorized},
+		{"GET", "/testdbsecuremissing/1", "", "bob", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		if rr := request(test.method, test.path, test.body, test.name); rr.Code != test.statusCode {
+			t.Errorf("%s %s as %q returned wrong status code: got %v want %v", test.method, test.path, test.name, rr.Code, test.statusCode)
+		}
+	}
+
+	// design documents in _bulk_docs need admins too
+	rr := request("POST", "/testdbsecure/_bulk_docs", `{"_docs":[{"_id":"_design/names","views":{}}]}`, "bob")
+	if !strings.Contains(rr.Body.String(), `"error":"forbidden"`) {
+		t.Errorf("expected the design document to be forbidden, got %s", rr.Body.String())
+	}
+
+	for name, listed := range map[string]bool{"carol": true, "dave": false} {
+		rr := request("GET", "/_all_dbs", "", name)
+		if strings.Contains(rr.Body.String(), `"testdbsecure"`) != listed || strings.Contains(rr.Body.String(), kdb.UsersDB) {
+			t.Errorf("unexpected databases for %s: %s", name, rr.Body.String())
+		}
+	}
+
+	testExpect200(t, request("DELETE", "/testdbsecure", "", "admin"))
+}
+
+func TestHandlerBearerToken(t *testing.T) {
+	config := kdb.DefaultConfig()
+	config.DBPath = "./data/jwt/dbs"
+	config.ViewPath = "./data/jwt/mrviews"
+	config.Admins = map[string]string{"admin": "secret"}
+	config.JWT = &kdb.JWTConfig{Keys: []kdb.JWTKey{{Algorithm: "HS256", Secret: "hmac-secret"}}}
+	jwtEngine, _ := kdb.New(config)
+	defer jwtEngine.Close()
+	defer os.RemoveAll("./data/jwt")
+	handler := NewHandler(jwtEngine)
+
+	sign := func(claims string) string {
+		signed := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." + base64.RawURLEncoding.EncodeToString([]byte(claims))
+		mac := hmac.New(sha256.New, []byte("hmac-secret"))
+		mac.Write([]byte(signed))
+		return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	}
+	exp := time.Now().Add(time.Minute).Unix()
+
+	req, _ := http.NewRequest("GET", "/_session", nil)
+	req.Header.Set("Authorization", "Bearer "+sign(fmt.Sprintf(`{"sub":"testcarol","roles":["sales"],"exp":%d}`, exp)))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	testExpect200(t, rr)
+	if rr.Body.String() != `{"ok":true,"name":"testcarol","roles":["sales"]}`+"\n" {
+		t.Errorf("expected the user of the token, got %s", rr.Body.String())
+	}
+
+	req, _ = http.NewRequest("PUT", "/testdbjwt", nil)
+	req.Header.Set("Authorization", "Bearer "+sign(fmt.Sprintf(`{"sub":"testcarol","roles":["sales"],"exp":%d}`, exp)))
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusForbidden)
+	}
+
+	req, _ = http.NewRequest("GET", "/_session", nil)
+	req.Header.Set("Authorization", "Bearer "+sign(fmt.Sprintf(`{"sub":"testcarol","exp":%d}`, exp-120)))
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnauthorized || !strings.Contains(rr.Header().Get("WWW-Authenticate"), "invalid_token") {
+		t.Errorf("expected an expired token to be rejected, got %v %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(kdb.RateLimit{Rate: 2, Burst: 3})
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		if ok, _ := limiter.allow("ip:10.0.0.1", now); !ok {
+			t.Fatalf("expected request %d within the burst", i)
+		}
+	}
+	ok, wait := limiter.allow("ip:10.0.0.1", now)
+	if ok || wait != 500*time.Millisecond {
+		t.Errorf("expected a wait of 500ms, got %v %v", ok, wait)
+	}
+	if ok, _ := limiter.allow("ip:10.0.0.2", now); !ok {
+		t.Error("expected clients to have their own bucket")
+	}
+	if ok, _ := limiter.allow("ip:10.0.0.1", now.Add(500*time.Millisecond)); !ok {
+		t.Error("expected a token after 500ms")
+	}
+
+	// full buckets are pruned
+	limiter.allow("ip:10.0.0.3", now.Add(2*time.Minute))
+	if len(limiter.buckets) != 1 {
+		t.Errorf("expected the idle buckets to be pruned, got %d", len(limiter.buckets))
+	}
+}
+
+func TestHandlerRateLimit(t *testing.T) {
+	config := kdb.DefaultConfig()
+	config.DBPath = "./data/ratelimit/dbs"
+	config.ViewPath = "./data/ratelimit/mrviews"
+	config.RateLimits = map[string]kdb.RateLimit{"write": {Rate: 0.01, Burst: 2}}
+	config.Quotas = map[string]kdb.Quota{"testdbratelimit": {MaxDocSize: 32}}
+	limitedEngine, _ := kdb.New(config)
+	defer limitedEngine.Close()
+	defer os.RemoveAll("./data/ratelimit")
+	handler := NewHandler(limitedEngine)
+
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.RemoteAddr = "10.0.0.1:41234"
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	testExpect200(t, request("PUT", "/testdbratelimit", ""))
+	rr := request("PUT", "/testdbratelimit/1", `{"name":"`+strings.Repeat("a", 32)+`"}`)
+	if rr.Code != http.StatusRequestEntityTooLarge || !strings.Contains(rr.Body.String(), `"error":"quota_exceeded"`) {
+		t.Errorf("expected the quota to be exceeded, got %v %s", rr.Code, rr.Body.String())
+	}
+	rr = request("PUT", "/testdbratelimit/1", `{"name":"one"}`)
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") == "" {
+		t.Errorf("expected the rate limit to be reached, got %v %s", rr.Code, rr.Body.String())
+	}
+	// reads have their own limit
+	testExpect200(t, request("GET", "/testdbratelimit", ""))
+}
+
+func TestHandlerRateLimitAuthentication(t *testing.T) {
+	config := kdb.DefaultConfig()
+	config.DBPath = "./data/ratelimitauth/dbs"
+	config.ViewPath = "./data/ratelimitauth/mrviews"
+	config.Admins = map[string]string{"admin": "secret"}
+	config.RateLimits = map[string]kdb.RateLimit{"write": {Rate: 0.01, Burst: 2}}
+	limitedEngine, _ := kdb.New(config)
+	defer limitedEngine.Close()
+	defer os.RemoveAll("./data/ratelimitauth")
+	handler := NewHandler(limitedEngine)
+
+	request := func(addr, password string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("PUT", "/testdbratelimitauth", nil)
+		req.RemoteAddr = addr
+		req.SetBasicAuth("admin", password)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// failed authentications count against the ip address, once it's over
+	// the limit credentials aren't checked anymore
+	for i := 0; i < 2; i++ {
+		if rr := request("10.0.0.2:41234", "guess"); rr.Code != http.StatusUnauthorized {
+			t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusUnauthorized)
+		}
+	}
+	if rr := request("10.0.0.2:41234", "guess"); rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") == "" {
+		t.Errorf("expected the rate limit to be reached, got %v %s", rr.Code, rr.Body.String())
+	}
+	if rr := request("10.0.0.2:41234", "secret"); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("expected the rate limit to be reached, got %v %s", rr.Code, rr.Body.String())
+	}
+	testExpect200(t, request("10.0.0.3:41234", "secret"))
+}
+
+func TestHandlerAudit(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/_audit", nil)
+	rr := httptest.NewRecorder()
+	NewHandler(engine).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+
+	config := kdb.DefaultConfig()
+	config.DBPath = "./data/audit/dbs"
+	config.ViewPath = "./data/audit/mrviews"
+	config.AuditPath = "./data/audit/audit.log"
+	config.Admins = map[string]string{"admin": "secret"}
+	auditEngine, _ := kdb.New(config)
+	defer auditEngine.Close()
+	defer os.RemoveAll("./data/audit")
+	handler := NewHandler(auditEngine)
+
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.SetBasicAuth("admin", "secret")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	testExpect200(t, request("PUT", "/testdbaudit", ""))
+	testExpect200(t, request("PUT", "/testdbaudit/1", `{"name":"one"}`))
+
+	rr = request("GET", "/_audit?db=testdbaudit&action=put_doc", "")
+	testExpect200(t, rr)
+	if !strings.Contains(rr.Body.String(), `"user":"admin","roles":["_admin"],"action":"put_doc","db":"testdbaudit","doc_id":"1","version":1`) {
+		t.Errorf("expected the document write, got %s", rr.Body.String())
+	}
+	if rr := request("GET", "/_audit?since=yesterday", ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	req, _ = http.NewRequest("GET", "/_audit", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestHandlerDebug(t *testing.T) {
+	config := kdb.DefaultConfig()
+	config.DBPath = "./data/debug/dbs"
+	config.ViewPath = "./data/debug/mrviews"
+	config.Admins = map[string]string{"admin": "secret"}
+	debugEngine, _ := kdb.New(config)
+	defer debugEngine.Close()
+	defer os.RemoveAll("./data/debug")
+
+	request := func(handler http.Handler, admin bool) int {
+		req, _ := http.NewRequest("GET", "/_debug/vars", nil)
+		if admin {
+			req.SetBasicAuth("admin", "secret")
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	handler := NewHandler(debugEngine)
+	if code := request(handler, false); code != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v", code, http.StatusUnauthorized)
+	}
+	if code := request(handler, true); code != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", code, http.StatusOK)
+	}
+
+	// with a listener of their own they're only served there
+	config.DebugAddr = "localhost:8002"
+	if code := request(NewHandler(debugEngine), true); code == http.StatusOK {
+		t.Errorf("expected no debug endpoints, got %v", code)
+	}
+	if code := request(NewDebugHandler(), false); code != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", code, http.StatusOK)
+	}
+
+	config.DebugAddr, config.Debug = "", kdb.DebugOff
+	if code := request(NewHandler(debugEngine), true); code == http.StatusOK {
+		t.Errorf("expected no debug endpoints, got %v", code)
+	}
+
+	// without admins the anonymous user is one, they aren't served on addr
+	config.Admins, config.Debug = nil, kdb.DebugAdmin
+	if code := request(NewHandler(debugEngine), false); code == http.StatusOK {
+		t.Errorf("expected no debug endpoints, got %v", code)
+	}
+}
+
+func TestHandlerCORS(t *testing.T) {
+	config := kdb.DefaultConfig()
+	config.DBPath = "./data/cors/dbs"
+	config.ViewPath = "./data/cors/mrviews"
+	config.CORS = &kdb.CORSConfig{Origins: []string{"https://app.example.com"}, Credentials: true, MaxAge: 600}
+	corsEngine, _ := kdb.New(config)
+	defer corsEngine.Close()
+	defer os.RemoveAll("./data/cors")
+	handler := NewHandler(corsEngine)
+
+	request := func(method, path, origin string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, nil)
+		if method == "OPTIONS" {
+			req.Header.Set("Access-Control-Request-Method", "PUT")
+		}
+		req.Header.Set("Origin", origin)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := request("OPTIONS", "/testdbcors/1", "https://app.example.com")
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+	expected := map[string]string{
+		"Access-Control-Allow-Origin":      "https://app.example.com",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Allow-Methods":     "GET, HEAD, POST, PUT, DELETE",
+		"Access-Control-Allow-Headers":     "Accept, Authorization, Content-Type",
+		"Access-Control-Max-Age":           "600",
+	}
+	for header, value := range expected {
+		if rr.Header().Get(header) != value {
+			t.Errorf("expected %s %q, got %q", header, value, rr.Header().Get(header))
+		}
+	}
+
+	req, _ := http.NewRequest("PUT", "/testdbcors", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	req, _ = http.NewRequest("PUT", "/testdbcors/1", bytes.NewBufferString(`{"name":"one"}`))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	rr = request("GET", "/testdbcors/1", "https://app.example.com")
+	testExpect200(t, rr)
+	if rr.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" || !strings.Contains(rr.Header().Get("Access-Control-Expose-Headers"), "E-Tag") {
+		t.Errorf("expected the CORS headers, got %v", rr.Header())
+	}
+
+	rr = request("OPTIONS", "/testdbcors/1", "https://evil.example.com")
+	if rr.Code != http.StatusForbidden || rr.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("expected the origin rejected, got %v %v", rr.Code, rr.Header())
+	}
+	rr = request("GET", "/testdbcors/1", "https://evil.example.com")
+	if rr.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("expected no CORS headers, got %v", rr.Header())
+	}
+
+	// every origin gets "*" without credentials, and credentials for every
+	// origin are rejected
+	config.CORS = &kdb.CORSConfig{Origins: []string{"*"}}
+	rr = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/testdbcors/1", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	NewHandler(corsEngine).ServeHTTP(rr, req)
+	if rr.Header().Get("Access-Control-Allow-Origin") != "*" || rr.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Errorf("expected any origin without credentials, got %v", rr.Header())
+	}
+	config.CORS.Credentials = true
+	if _, err := kdb.New(config); err == nil {
+		t.Error("expected credentials for every origin to be rejected")
+	}
+}
+
+func TestRouteTimeout(t *testing.T) {
+	var deadline time.Time
+	handler := withTimeout(func(w http.ResponseWriter, r *http.Request) {
+		deadline, _ = r.Context().Deadline()
+	}, time.Minute)
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if deadline.IsZero() || time.Until(deadline) > time.Minute {
+		t.Errorf("expected a deadline within a minute, got %s", deadline)
+	}
+}
+
+func TestDeleteDatabase(t *testing.T) {
+	req, _ := http.NewRequest("DELETE", "/testdb", nil)
+	rr := httptest.NewRecorder()
+	handler := NewHandler(engine)
+	handler.ServeHTTP(rr, req)
+
+	testExpect200(t, rr)
+
+	expected := `{"ok":true}`
+	if expected != rr.Body.String() {
+		t.Errorf(`expected to have ok`)
+	}
+
+	testExpectJSONContentType(t, rr)
+}
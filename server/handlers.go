@@ -0,0 +1,725 @@
+// Package server is the kdb3 http api, served for an engine.
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/clementmac/kdb3/kdb"
+	"github.com/gorilla/mux"
+)
+
+// Handler serves the kdb3 http api for an engine.
+type Handler struct {
+	engine   *kdb.Engine
+	seq      *kdb.SequenceUUIDGenarator
+	router   *mux.Router
+	limiters map[string]*rateLimiter
+	cors     *corsPolicy
+}
+
+func NewHandler(engine *kdb.Engine) *Handler {
+	h := &Handler{engine: engine, seq: kdb.NewSequenceUUIDGenarator()}
+	if engine != nil {
+		h.limiters = newRateLimiters(engine.RateLimits())
+		h.cors = newCORSPolicy(engine.CORS())
+	}
+	h.router = h.newRouter()
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// preflight requests are answered before routing, no route has OPTIONS
+	if h.cors.handle(w, r) {
+		return
+	}
+	h.router.ServeHTTP(w, r)
+}
+
+func (h *Handler) HeadDocument(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	db := vars["db"]
+	docid := vars["docid"]
+	h.getDocument(db, docid, false, w, r)
+}
+
+func (h *Handler) GetDatabase(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	db := vars["db"]
+	if err := h.engine.Open(db, false); err != nil {
+		NotOK(err, w)
+		return
+	}
+	stat, err := h.engine.DBStat(db)
+	if err != nil {
+		NotOK(err, w)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(stat)
+}
+
+func (h *Handler) PutDatabase(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	db := vars["db"]
+	if err := h.engine.CreateDatabase(r.Context(), db, r.FormValue("storage")); err != nil {
+		NotOK(err, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, `{"ok":true}`)
+}
+
+func (h *Handler) DeleteDatabase(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	db := vars["db"]
+	if err := h.engine.DeleteDatabase(r.Context(), db); err != nil {
+		NotOK(err, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, `{"ok":true}`)
+}
+
+func (h *Handler) DatabaseAllDocs(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	db := vars["db"]
+	r.ParseForm()
+	includeDocs, _ := strconv.ParseBool(r.FormValue("include_docs"))
+	selectName := "default"
+	if includeDocs {
+		selectName = "with_docs"
+	}
+	rs, err := h.engine.SelectView(r.Context(), db, "_design/_views", "_all_docs", selectName, r.Form, false)
+	if err != nil {
+		NotOK(err, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(rs)
+}
+
+func (h *Handler) DatabaseChanges(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	db := vars["db"]
+	r.ParseForm()
+	since := r.FormValue("since")
+	limit, _ := strconv.Atoi(r.FormValue("limit"))
+	rs, err := h.engine.Changes(r.Context(), db, since, limit)
+	if err != nil {
+		NotOK(err, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(rs)
+}
+
+func (h *Handler) DatabaseCompact(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	db := vars["db"]
+	err := h.engine.Vacuum(r.Context(), db)
+	if err != nil {
+		NotOK(err, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, `{"ok":true}`)
+}
+
+// copyRequest is the body of _copy and _rename.
+type copyRequest struct {
+	Target string `json:"target"`
+	Views  bool   `json:"views"`
+}
+
+func readCopyRequest(r *http.Request) (*copyRequest, error) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1048576))
+	if err != nil {
+		return nil, err
+	}
+	req := &copyRequest{}
+	if err := json.Unmarshal(body, req); err != nil {
+		return nil, fmt.Errorf("%s: %w", err, kdb.ErrBadJSON)
+	}
+	return req, nil
+}
+
+func (h *Handler) DatabaseCopy(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	db := vars["db"]
+	req, err := readCopyRequest(r)
+	if err != nil {
+		NotOK(err, w)
+		return
+	}
+	if err := h.engine.Copy(r.Context(), db, req.Target, req.Views); err != nil {
+		NotOK(err, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, `{"ok":true}`)
+}
+
+func (h *Handler) DatabaseRename(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	db := vars["db"]
+	req, err := readCopyRequest(r)
+	if err != nil {
+		NotOK(err, w)
+		return
+	}
+	if err := h.engine.Rename(r.Context(), db, req.Target); err != nil {
+		NotOK(err, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, `{"ok":true}`)
+}
+
+func (h *Handler) putDocument(db, docid string, w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1048576))
+	if err != nil {
+		NotOK(err, w)
+		return
+	}
+	inputDoc, err := kdb.ParseDocument(body)
+	if err != nil {
+		NotOK(err, w)
+		return
+	}
+
+	if inputDoc.ID == "" {
+		inputDoc.ID = docid
+	}
+	if docid == "" {
+		docid = inputDoc.ID
+	}
+
+	if docid != inputDoc.ID {
+		NotOK(errors.New("mismatch_id"), w)
+		return
+	}
+	outputDoc, err := h.engine.PutDocument(r.Context(), db, inputDoc)
+	if err != nil {
+		NotOK(err, w)
+		return
+	}
+	output := kdb.FormatDocString(outputDoc.ID, outputDoc.Version, outputDoc.Deleted)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(output))
+}
+
+func (h *Handler) getDocument(db, docid string, includeDocs bool, w http.ResponseWriter, r *http.Request) {
+	ver := r.FormValue("version")
+	var inputDoc = &kdb.Document{}
+	if ver != "" {
+		version, _ := strconv.Atoi(ver)
+		inputDoc.ID = docid
+		inputDoc.Version = version
+	} else {
+		inputDoc.ID = docid
+	}
+
+	var outputDoc *kdb.Document
+	var err error
+	conflict := r.FormValue("conflict")
+	if conflict != "" {
+		outputDoc, err = h.engine.GetConflict(r.Context(), db, docid, conflict)
+	} else {
+		outputDoc, err = h.engine.GetDocument(r.Context(), db, inputDoc, includeDocs)
+	}
+	if err != nil {
+		NotOK(err, w)
+		return
+	}
+	data := outputDoc.Data
+	if includeDocs && conflict == "" && r.FormValue("conflicts") == "true" {
+		conflicts, err := h.engine.GetConflicts(r.Context(), db, docid)
+		if err != nil {
+			NotOK(err, w)
+			return
+		}
+		var meta string
+		if outputDoc.Hash != "" {
+			meta = fmt.Sprintf(`,"_hash":"%s"`, outputDoc.Hash)
+		}
+		if len(conflicts) > 0 {
+			list, _ := json.Marshal(conflicts)
+			meta += `,"_conflicts":` + string(list)
+		}
+		data = []byte(fmt.Sprintf(`%s%s}`, data[:len(data)-1], meta))
+	}
+	w.Header().Set("E-Tag", strconv.Itoa(outputDoc.Version))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if includeDocs {
+		w.Write(data)
+	}
+}
+
+func (h *Handler) deleteDocument(db, docid string, w http.ResponseWriter, r *http.Request) {
+	if conflict := r.FormValue("conflict"); conflict != "" {
+		if err := h.engine.DeleteConflict(r.Context(), db, docid, conflict); err != nil {
+			NotOK(err, w)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, `{"ok":true}`)
+		return
+	}
+
+	ver := r.FormValue("version")
+
+	if ver == "" {
+		ver = r.Header.Get("If-Match")
+	}
+	if ver == "" {
+		NotOK(errors.New("version_missing"), w)
+		return
+	}
+	version, _ := strconv.Atoi(ver)
+	inputDoc := &kdb.Document{ID: docid, Version: version, Deleted: true}
+	outputDoc, err := h.engine.DeleteDocument(r.Context(), db, inputDoc)
+	if err != nil {
+		NotOK(err, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, kdb.FormatDocString(outputDoc.ID, outputDoc.Version, outputDoc.Deleted))
+}
+
+func (h *Handler) GetDocument(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	db := vars["db"]
+	docid := vars["docid"]
+
+	h.getDocument(db, docid, true, w, r)
+}
+
+func (h *Handler) DeleteDocument(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	db := vars["db"]
+	docid := vars["docid"]
+	h.deleteDocument(db, docid, w, r)
+}
+
+func (h *Handler) PutDocument(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	db := vars["db"]
+	docid := vars["docid"]
+	h.putDocument(db, docid, w, r)
+}
+
+func (h *Handler) BulkPutDocuments(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	db := vars["db"]
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1048576))
+	if err != nil {
+		NotOK(err, w)
+		return
+	}
+
+	outputs, err := h.engine.BulkDocuments(r.Context(), db, body)
+	if err != nil {
+		NotOK(err, w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(outputs)
+}
+
+func (h *Handler) BulkGetDocuments(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	db := vars["db"]
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1048576))
+	if err != nil {
+		NotOK(err, w)
+		return
+	}
+
+	outputs, err := h.engine.BulkGetDocuments(r.Context(), db, body)
+	if err != nil {
+		NotOK(err, w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(outputs)
+}
+
+func (h *Handler) GetDDocument(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	db := vars["db"]
+	docid := "_design/" + vars["docid"]
+	h.getDocument(db, docid, true, w, r)
+}
+
+func (h *Handler) DeleteDDocument(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	db := vars["db"]
+	docid := "_design/" + vars["docid"]
+	h.deleteDocument(db, docid, w, r)
+}
+
+func (h *Handler) PutDDocument(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	db := vars["db"]
+	docid := "_design/" + vars["docid"]
+	h.putDocument(db, docid, w, r)
+}
+
+// AllDatabases lists the databases the user of the request can read.
+func (h *Handler) AllDatabases(w http.ResponseWriter, r *http.Request) {
+	list, err := h.engine.ListDataBases()
+	if err != nil {
+		NotOK(err, w)
+		return
+	}
+	if user := kdb.UserFromContext(r.Context()); user != nil && !user.IsAdmin() {
+		readable := list[:0]
+		for _, name := range list {
+			if access, err := h.engine.DatabaseAccess(name, user); err == nil && access >= kdb.AccessReader {
+				readable = append(readable, name)
+			}
+		}
+		list = readable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if len(list) > 0 {
+		json.NewEncoder(w).Encode(list)
+	} else {
+		w.Write([]byte("[]"))
+	}
+}
+
+func (h *Handler) SelectView(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	db := vars["db"]
+	ddocID := "_design/" + vars["docid"]
+	view := vars["view"]
+
+	selectName := vars["select"]
+	if selectName == "" {
+		selectName = "default"
+	}
+
+	r.ParseForm()
+	includeDocs, _ := strconv.ParseBool(r.FormValue("include_docs"))
+
+	if includeDocs {
+		selectName = selectName + "_with_docs"
+	}
+	r.ParseForm()
+	stale, _ := strconv.ParseBool(r.FormValue("stale"))
+	rs, err := h.engine.SelectView(r.Context(), db, ddocID, view, selectName, r.Form, stale)
+	if err != nil {
+		NotOK(err, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(rs)
+}
+
+func (h *Handler) DesignDocumentTest(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	db := vars["db"]
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1048576))
+	if err != nil {
+		NotOK(err, w)
+		return
+	}
+
+	rs, err := h.engine.TestDesignDocument(r.Context(), db, body)
+	if err != nil {
+		NotOK(err, w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(rs)
+}
+
+func (h *Handler) GetLocalDocument(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	data, err := h.engine.GetLocalDocument(r.Context(), vars["db"], vars["docid"])
+	if err != nil {
+		NotOK(err, w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+func (h *Handler) PutLocalDocument(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1048576))
+	if err != nil {
+		NotOK(err, w)
+		return
+	}
+	if err := h.engine.PutLocalDocument(r.Context(), vars["db"], vars["docid"], body); err != nil {
+		NotOK(err, w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, `{"ok":true}`)
+}
+
+func (h *Handler) Replicate(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1048576))
+	if err != nil {
+		NotOK(err, w)
+		return
+	}
+	req := &kdb.ReplicationRequest{}
+	if err := json.Unmarshal(body, req); err != nil {
+		NotOK(fmt.Errorf("%s: %w", err, kdb.ErrBadJSON), w)
+		return
+	}
+	result, err := h.engine.Replicate(r.Context(), req)
+	if err != nil {
+		NotOK(err, w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
+
+// DatabaseBackup writes a backup, {"full":true} starts over with a full
+// backup.
+func (h *Handler) DatabaseBackup(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	db := vars["db"]
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1048576))
+	if err != nil {
+		NotOK(err, w)
+		return
+	}
+	req := struct {
+		Full bool `json:"full"`
+	}{}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &req); err != nil {
+			NotOK(fmt.Errorf("%s: %w", err, kdb.ErrBadJSON), w)
+			return
+		}
+	}
+	result, err := h.engine.Backup(r.Context(), db, req.Full)
+	if err != nil {
+		NotOK(err, w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
+
+// Restore restores the backups of a database into a new database,
+// {"backup":"orders","target":"orders-restored"}.
+func (h *Handler) Restore(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1048576))
+	if err != nil {
+		NotOK(err, w)
+		return
+	}
+	req := struct {
+		Backup string `json:"backup"`
+		Target string `json:"target"`
+	}{}
+	if err := json.Unmarshal(body, &req); err != nil {
+		NotOK(fmt.Errorf("%s: %w", err, kdb.ErrBadJSON), w)
+		return
+	}
+	result, err := h.engine.Restore(r.Context(), req.Backup, req.Target)
+	if err != nil {
+		NotOK(err, w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
+
+// DatabaseExport streams the documents as lines of json, ?deleted=true
+// includes the deleted ones.
+func (h *Handler) DatabaseExport(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	includeDeleted, _ := strconv.ParseBool(r.FormValue("deleted"))
+	ew := &exportWriter{w: w}
+	if _, _, err := h.engine.Export(r.Context(), vars["db"], "", includeDeleted, ew); err != nil {
+		// once the export started the status is sent, the client gets a
+		// truncated export
+		if !ew.started {
+			NotOK(err, w)
+		}
+		return
+	}
+	ew.start()
+}
+
+// exportWriter sends the headers with the first line of an export, so an
+// export failing right away still gets an error response.
+type exportWriter struct {
+	w       http.ResponseWriter
+	started bool
+}
+
+func (ew *exportWriter) start() {
+	if !ew.started {
+		ew.started = true
+		ew.w.Header().Set("Content-Type", "application/x-ndjson")
+		ew.w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (ew *exportWriter) Write(p []byte) (int, error) {
+	ew.start()
+	return ew.w.Write(p)
+}
+
+// DatabaseImport imports documents sent as lines of json, they keep their
+// _version unless ?versions=ignore.
+func (h *Handler) DatabaseImport(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	keepVersions := r.URL.Query().Get("versions") != "ignore"
+	result, err := h.engine.Import(r.Context(), vars["db"], r.Body, keepVersions)
+	if err != nil {
+		NotOK(err, w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
+
+func (h *Handler) GetSecurity(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	security, err := h.engine.GetSecurity(vars["db"])
+	if err != nil {
+		NotOK(err, w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(security)
+}
+
+// PutSecurity replaces the security object of a database,
+// {"admins":{"names":[],"roles":[]},"members":{...},"readers":{...}}.
+func (h *Handler) PutSecurity(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1048576))
+	if err != nil {
+		NotOK(err, w)
+		return
+	}
+	security := &kdb.Security{}
+	if err := json.Unmarshal(body, security); err != nil {
+		NotOK(fmt.Errorf("%s: %w", err, kdb.ErrBadJSON), w)
+		return
+	}
+	if err := h.engine.PutSecurity(r.Context(), vars["db"], security); err != nil {
+		NotOK(err, w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, `{"ok":true}`)
+}
+
+// GetAudit returns the audit records matching since and until, RFC 3339
+// times, user, db and action, oldest first and at most limit, 1000 by
+// default.
+func (h *Handler) GetAudit(w http.ResponseWriter, r *http.Request) {
+	query := kdb.AuditQuery{
+		User:   r.FormValue("user"),
+		DB:     r.FormValue("db"),
+		Action: r.FormValue("action"),
+		Limit:  1000,
+	}
+	for _, param := range []struct {
+		name  string
+		value *time.Time
+	}{{"since", &query.Since}, {"until", &query.Until}} {
+		if v := r.FormValue(param.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				NotOK(fmt.Errorf("%s: %w", param.name+" has to be an RFC 3339 time", kdb.ErrBadJSON), w)
+				return
+			}
+			*param.value = t
+		}
+	}
+	if v := r.FormValue("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			NotOK(fmt.Errorf("%s: %w", "limit has to be a positive number", kdb.ErrBadJSON), w)
+			return
+		}
+		query.Limit = limit
+	}
+
+	records, err := h.engine.QueryAudit(r.Context(), query)
+	if err != nil {
+		NotOK(err, w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"records": records})
+}
+
+func (h *Handler) GetInfo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(h.engine.Info())
+}
+
+func (h *Handler) GetUUIDs(w http.ResponseWriter, r *http.Request) {
+	c := r.FormValue("count")
+	count, _ := strconv.Atoi(c)
+	if count <= 0 {
+		count = 1
+	}
+	var list []string
+	for i := 0; i < count; i++ {
+		list = append(list, h.seq.Next())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(list)
+}
@@ -0,0 +1,199 @@
+package server
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/clementmac/kdb3/kdb"
+	"github.com/gorilla/mux"
+)
+
+// classes of routes with their own rate limit
+const (
+	rateRead  = "read"
+	rateWrite = "write"
+	rateView  = "view"
+)
+
+// rateClass is the class of a route by name and method, views and design
+// document tests build views, other reads only read.
+func rateClass(name, method string) string {
+	switch {
+	case name == "SelectView" || name == "DesignDocumentTest":
+		return rateView
+	case method == "GET" || method == "HEAD" || name == "BulkGetDocuments":
+		return rateRead
+	}
+	return rateWrite
+}
+
+// rateLimiter keeps a token bucket per client.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	mux       sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastPrune time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(limit kdb.RateLimit) *rateLimiter {
+	if limit.Rate <= 0 {
+		return nil
+	}
+	burst := float64(limit.Burst)
+	if burst <= 0 {
+		burst = math.Ceil(limit.Rate)
+	}
+	return &rateLimiter{rate: limit.Rate, burst: burst, buckets: make(map[string]*tokenBucket), lastPrune: time.Now()}
+}
+
+// allow takes a token from the bucket of client, when there is none it
+// returns how long until there is.
+func (limiter *rateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	limiter.mux.Lock()
+	defer limiter.mux.Unlock()
+
+	// buckets which filled up again are the same as new ones
+	if now.Sub(limiter.lastPrune) > time.Minute {
+		for key, bucket := range limiter.buckets {
+			if limiter.refill(bucket, now) >= limiter.burst {
+				delete(limiter.buckets, key)
+			}
+		}
+		limiter.lastPrune = now
+	}
+
+	bucket, ok := limiter.buckets[client]
+	if !ok {
+		bucket = &tokenBucket{tokens: limiter.burst, last: now}
+		limiter.buckets[client] = bucket
+	}
+	bucket.tokens = limiter.refill(bucket, now)
+	bucket.last = now
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+	return false, limiter.wait(bucket.tokens)
+}
+
+// peek is allow without taking a token.
+func (limiter *rateLimiter) peek(client string, now time.Time) (bool, time.Duration) {
+	limiter.mux.Lock()
+	defer limiter.mux.Unlock()
+
+	bucket, ok := limiter.buckets[client]
+	if !ok {
+		return true, 0
+	}
+	if tokens := limiter.refill(bucket, now); tokens < 1 {
+		return false, limiter.wait(tokens)
+	}
+	return true, 0
+}
+
+func (limiter *rateLimiter) refill(bucket *tokenBucket, now time.Time) float64 {
+	return math.Min(limiter.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*limiter.rate)
+}
+
+func (limiter *rateLimiter) wait(tokens float64) time.Duration {
+	return time.Duration((1 - tokens) / limiter.rate * float64(time.Second))
+}
+
+func newRateLimiters(limits map[string]kdb.RateLimit) map[string]*rateLimiter {
+	limiters := make(map[string]*rateLimiter)
+	for class, limit := range limits {
+		if limiter := newRateLimiter(limit); limiter != nil {
+			limiters[class] = limiter
+		}
+	}
+	return limiters
+}
+
+// rateLimit rejects the requests of a client over the rate limit of class
+// with 429 and Retry-After.
+func (h *Handler) rateLimit(class string, handler http.HandlerFunc) http.HandlerFunc {
+	limiter, ok := h.limiters[class]
+	if !ok {
+		return handler
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ok, wait := limiter.allow(rateClient(r), time.Now()); !ok {
+			tooManyRequests(w, wait)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// allowAuthentication rejects the requests of an ip address which used up
+// the rate limit of their route with failed authentications, before their
+// credentials are checked.
+func (h *Handler) allowAuthentication(w http.ResponseWriter, r *http.Request) bool {
+	limiter := h.routeLimiter(r)
+	if limiter == nil {
+		return true
+	}
+	if ok, wait := limiter.peek(failureClient(r), time.Now()); !ok {
+		tooManyRequests(w, wait)
+		return false
+	}
+	return true
+}
+
+// authenticationFailed answers a failed authentication and charges it to the
+// ip address of the request.
+func (h *Handler) authenticationFailed(w http.ResponseWriter, r *http.Request, err error) {
+	if limiter := h.routeLimiter(r); limiter != nil {
+		limiter.allow(failureClient(r), time.Now())
+	}
+	NotOK(err, w)
+}
+
+// routeLimiter is the rate limiter of the route of a request, nil without
+// one.
+func (h *Handler) routeLimiter(r *http.Request) *rateLimiter {
+	name := ""
+	if route := mux.CurrentRoute(r); route != nil {
+		name = route.GetName()
+	}
+	return h.limiters[rateClass(name, r.Method)]
+}
+
+func tooManyRequests(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	NotOK(kdb.ErrTooManyRequests, w)
+}
+
+// rateClient identifies the client of a request, its user or else its ip
+// address.
+func rateClient(r *http.Request) string {
+	if user := kdb.UserFromContext(r.Context()); user != nil && user.Name != "" {
+		return "user:" + user.Name
+	}
+	return "ip:" + remoteIP(r)
+}
+
+// failureClient is the client failed authentications are charged to, the ip
+// address of a request whichever user it claims to be.
+func failureClient(r *http.Request) string {
+	return "failed:" + remoteIP(r)
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,376 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type Route struct {
+	Name        string
+	Methods     string
+	Pattern     string
+	HandlerFunc http.HandlerFunc
+	// Timeout is the deadline of the request context, the engine stops
+	// working on a request once it's reached or the client went away.
+	Timeout time.Duration
+	// Access is what the user of a request needs, database levels are
+	// checked against the security object of {db}.
+	Access access
+}
+
+// default deadlines per kind of route
+const (
+	shortTimeout   = 30 * time.Second
+	bulkTimeout    = 5 * time.Minute
+	viewTimeout    = 10 * time.Minute
+	compactTimeout = time.Hour
+)
+
+type Routes []Route
+
+func (h *Handler) newRouter() *mux.Router {
+	router := mux.NewRouter().StrictSlash(true)
+
+	// the debug endpoints are for server admins, unless they have a
+	// listener of their own or are disabled
+	if h.engine != nil {
+		if addr, enabled := h.engine.DebugAddr(); enabled && addr == "" {
+			addDebugRoutes(router, func(handler http.Handler) http.Handler {
+				return h.authorize(accessServerAdmin, handler.ServeHTTP)
+			})
+		}
+	}
+
+	router.PathPrefix("/_utils").
+		Handler(http.StripPrefix("/_utils", http.FileServer(http.Dir("./share/www/"))))
+
+	for _, route := range h.routes() {
+		router.
+			Methods(route.Methods).
+			Path(route.Pattern).
+			Name(route.Name).
+			Handler(withTimeout(h.rateLimit(rateClass(route.Name, route.Methods), h.authorize(route.Access, route.HandlerFunc)), route.Timeout))
+	}
+	// users are checked against the engine, a handler without one only
+	// serves what doesn't need it
+	if h.engine != nil {
+		router.Use(h.authenticate)
+	}
+
+	return router
+}
+
+func withTimeout(handler http.HandlerFunc, timeout time.Duration) http.Handler {
+	if timeout <= 0 {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		handler(w, r.WithContext(ctx))
+	})
+}
+
+func (h *Handler) routes() Routes {
+	return Routes{
+		Route{
+			"Info",
+			"GET",
+			"/",
+			h.GetInfo,
+			shortTimeout,
+			accessPublic,
+		},
+		Route{
+			"AllDatabases",
+			"GET",
+			"/_all_dbs",
+			h.AllDatabases,
+			shortTimeout,
+			accessPublic,
+		},
+		Route{
+			"UUID",
+			"GET",
+			"/_uuids",
+			h.GetUUIDs,
+			shortTimeout,
+			accessPublic,
+		},
+		Route{
+			"GetSession",
+			"GET",
+			"/_session",
+			h.GetSession,
+			shortTimeout,
+			accessPublic,
+		},
+		Route{
+			"PostSession",
+			"POST",
+			"/_session",
+			h.PostSession,
+			shortTimeout,
+			accessPublic,
+		},
+		Route{
+			"DeleteSession",
+			"DELETE",
+			"/_session",
+			h.DeleteSession,
+			shortTimeout,
+			accessPublic,
+		},
+		Route{
+			"Replicate",
+			"POST",
+			"/_replicate",
+			h.Replicate,
+			compactTimeout,
+			accessServerAdmin,
+		},
+		Route{
+			"Restore",
+			"POST",
+			"/_restore",
+			h.Restore,
+			compactTimeout,
+			accessServerAdmin,
+		},
+		Route{
+			"Audit",
+			"GET",
+			"/_audit",
+			h.GetAudit,
+			bulkTimeout,
+			accessServerAdmin,
+		},
+		Route{
+			"GetDatabase",
+			"GET",
+			"/{db}",
+			h.GetDatabase,
+			shortTimeout,
+			accessReader,
+		},
+		Route{
+			"PutDatabase",
+			"PUT",
+			"/{db}",
+			h.PutDatabase,
+			shortTimeout,
+			accessServerAdmin,
+		},
+		Route{
+			"PostDatabase",
+			"POST",
+			"/{db}",
+			h.PutDocument,
+			shortTimeout,
+			accessMember,
+		},
+		Route{
+			"DeleteDatabase",
+			"DELETE",
+			"/{db}",
+			h.DeleteDatabase,
+			shortTimeout,
+			accessServerAdmin,
+		},
+		Route{
+			"DatabaseAllDocs",
+			"GET",
+			"/{db}/_all_docs",
+			h.DatabaseAllDocs,
+			viewTimeout,
+			accessReader,
+		},
+		Route{
+			"BulkPutDocuments",
+			"POST",
+			"/{db}/_bulk_docs",
+			h.BulkPutDocuments,
+			bulkTimeout,
+			accessMember,
+		},
+		Route{
+			"BulkGetDocuments",
+			"POST",
+			"/{db}/_bulk_gets",
+			h.BulkGetDocuments,
+			bulkTimeout,
+			accessReader,
+		},
+		Route{
+			"DatabaseChanges",
+			"GET",
+			"/{db}/_changes",
+			h.DatabaseChanges,
+			bulkTimeout,
+			accessReader,
+		},
+		Route{
+			"DatabaseCompact",
+			"POST",
+			"/{db}/_compact",
+			h.DatabaseCompact,
+			compactTimeout,
+			accessDBAdmin,
+		},
+		Route{
+			"DatabaseCopy",
+			"POST",
+			"/{db}/_copy",
+			h.DatabaseCopy,
+			compactTimeout,
+			accessServerAdmin,
+		},
+		Route{
+			"DatabaseBackup",
+			"POST",
+			"/{db}/_backup",
+			h.DatabaseBackup,
+			compactTimeout,
+			accessServerAdmin,
+		},
+		Route{
+			"DatabaseExport",
+			"GET",
+			"/{db}/_export",
+			h.DatabaseExport,
+			compactTimeout,
+			accessReader,
+		},
+		Route{
+			"DatabaseImport",
+			"POST",
+			"/{db}/_import",
+			h.DatabaseImport,
+			compactTimeout,
+			accessMember,
+		},
+		Route{
+			"DatabaseRename",
+			"POST",
+			"/{db}/_rename",
+			h.DatabaseRename,
+			shortTimeout,
+			accessServerAdmin,
+		},
+		Route{
+			"DesignDocumentTest",
+			"POST",
+			"/{db}/_design_test",
+			h.DesignDocumentTest,
+			viewTimeout,
+			accessDBAdmin,
+		},
+		Route{
+			"GetSecurity",
+			"GET",
+			"/{db}/_security",
+			h.GetSecurity,
+			shortTimeout,
+			accessReader,
+		},
+		Route{
+			"PutSecurity",
+			"PUT",
+			"/{db}/_security",
+			h.PutSecurity,
+			shortTimeout,
+			accessDBAdmin,
+		},
+		Route{
+			"GetLocalDocument",
+			"GET",
+			"/{db}/_local/{docid}",
+			h.GetLocalDocument,
+			shortTimeout,
+			accessReader,
+		},
+		Route{
+			"PutLocalDocument",
+			"PUT",
+			"/{db}/_local/{docid}",
+			h.PutLocalDocument,
+			shortTimeout,
+			accessMember,
+		},
+		Route{
+			"GetDocument",
+			"GET",
+			"/{db}/{docid}",
+			h.GetDocument,
+			shortTimeout,
+			accessReader,
+		},
+		Route{
+			"HeadDocument",
+			"HEAD",
+			"/{db}/{docid}",
+			h.HeadDocument,
+			shortTimeout,
+			accessReader,
+		},
+		Route{
+			"PutDocument",
+			"PUT",
+			"/{db}/{docid}",
+			h.PutDocument,
+			shortTimeout,
+			accessMember,
+		},
+		Route{
+			"DeleteDocument",
+			"DELETE",
+			"/{db}/{docid}",
+			h.DeleteDocument,
+			shortTimeout,
+			accessMember,
+		},
+		Route{
+			"GetDDocument",
+			"GET",
+			"/{db}/_design/{docid}",
+			h.GetDDocument,
+			shortTimeout,
+			accessReader,
+		},
+		Route{
+			"PutDDocument",
+			"PUT",
+			"/{db}/_design/{docid}",
+			h.PutDDocument,
+			shortTimeout,
+			accessDBAdmin,
+		},
+		Route{
+			"DeleteDDocument",
+			"DELETE",
+			"/{db}/_design/{docid}",
+			h.DeleteDDocument,
+			shortTimeout,
+			accessDBAdmin,
+		},
+		Route{
+			"SelectView",
+			"GET",
+			"/{db}/_design/{docid}/{view}",
+			h.SelectView,
+			viewTimeout,
+			accessReader,
+		},
+		Route{
+			"SelectView",
+			"GET",
+			"/{db}/_design/{docid}/{view}/{select}",
+			h.SelectView,
+			viewTimeout,
+			accessReader,
+		},
+	}
+}